@@ -0,0 +1,734 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/google/uuid"
+)
+
+// An AuditAction is a change recorded in the audit log.
+type AuditAction string
+
+const (
+	// AuditActionCreateOrganisation records an Organisation being created. After holds the Organisation's name,
+	// and UserID is its owner.
+	AuditActionCreateOrganisation AuditAction = "organisation:create"
+	// AuditActionPutOrganisation records an Organisation being created or renamed. Before and After hold the
+	// Organisation's name.
+	AuditActionPutOrganisation AuditAction = "organisation:put"
+	// AuditActionDeleteOrganisation records an Organisation being deleted. Before holds the Organisation's name.
+	AuditActionDeleteOrganisation AuditAction = "organisation:delete"
+	// AuditActionPutService records a Service being created or renamed. Before and After hold the Service's name.
+	AuditActionPutService AuditAction = "service:put"
+	// AuditActionDeleteService records a Service being deleted. Before holds the Service's name.
+	AuditActionDeleteService AuditAction = "service:delete"
+	// AuditActionPutRole records a role being created or updated. Before and After hold the role.
+	AuditActionPutRole AuditAction = "role:put"
+	// AuditActionDeleteRole records a role being deleted. Before holds the role.
+	AuditActionDeleteRole AuditAction = "role:delete"
+	// AuditActionPutWebhook records a Webhook being created or updated. Before and After hold the Webhook's URL
+	// and events, but not its secret.
+	AuditActionPutWebhook AuditAction = "webhook:put"
+	// AuditActionDeleteWebhook records a Webhook being deleted. Before holds the Webhook's URL and events.
+	AuditActionDeleteWebhook AuditAction = "webhook:delete"
+	// AuditActionTransferOwnership records ownership being transferred to a User. Before and After hold the
+	// Organisation's owners.
+	AuditActionTransferOwnership AuditAction = "organisation:transferOwnership"
+	// AuditActionInvite records a User being invited. After holds the groups that the User was invited to.
+	AuditActionInvite AuditAction = "member:invite"
+	// AuditActionResendInvite records an invitation being sent again with a new token. After holds the new
+	// expiry.
+	AuditActionResendInvite AuditAction = "member:resendInvite"
+	// AuditActionRevokeInvite records an invitation being revoked.
+	AuditActionRevokeInvite AuditAction = "member:revokeInvite"
+	// AuditActionRejectInvite records a User rejecting an invitation. Before holds the groups that the User was
+	// invited to.
+	AuditActionRejectInvite AuditAction = "member:rejectInvite"
+	// AuditActionAcceptInvite records a User accepting an invitation. Before and After hold the User's groups.
+	AuditActionAcceptInvite AuditAction = "member:acceptInvite"
+	// AuditActionAddToGroups records a User being added to groups. Before and After hold the User's groups.
+	AuditActionAddToGroups AuditAction = "member:addToGroups"
+	// AuditActionGrant records a User being granted groups temporarily. After holds the groups granted, and
+	// their expiry.
+	AuditActionGrant AuditAction = "member:grant"
+	// AuditActionRemoveFromGroups records a User being removed from groups. Before and After hold the User's
+	// groups.
+	AuditActionRemoveFromGroups AuditAction = "member:removeFromGroups"
+	// AuditActionExpireGrant records an expired grant being swept. Before holds the group granted, and its
+	// expiry.
+	AuditActionExpireGrant AuditAction = "member:expireGrant"
+	// AuditActionUpdateDetails records a member's details being updated. After holds the names of the details
+	// that changed, but not their values, so that they're only held on the member's records.
+	AuditActionUpdateDetails AuditAction = "member:updateDetails"
+	// AuditActionUpdateProfile records a User's profile being updated. It isn't recorded against an Organisation,
+	// so it's only kept in the audit logs of the User and the actor. After holds the names of the fields that
+	// changed, but not their values.
+	AuditActionUpdateProfile AuditAction = "user:updateProfile"
+	// AuditActionRemoveUser records a User being removed from an Organisation. Before holds the User's groups.
+	AuditActionRemoveUser AuditAction = "member:remove"
+	// AuditActionLeave records a User leaving an Organisation. Before holds the User's groups.
+	AuditActionLeave AuditAction = "member:leave"
+	// AuditActionEraseUser records a User's membership being deleted when the User is deleted. Before holds the
+	// User's groups.
+	AuditActionEraseUser AuditAction = "member:erase"
+)
+
+// An AuditRecord records a change made to an Organisation or one of its members.
+type AuditRecord struct {
+	ID string
+	// OrganisationID is the Organisation that was changed, or empty for changes to a User's profile.
+	OrganisationID string
+	// UserID is the member that was changed, if any.
+	UserID string
+	// ServiceID is the Service that was changed, if any.
+	ServiceID string
+	// Actor is the ID of the User that made the change, or empty if the change wasn't made on behalf of a User.
+	Actor  string
+	Action AuditAction
+	// Before and After are JSON documents of the state before and after the change. They're nil if the state
+	// didn't exist.
+	Before json.RawMessage
+	After  json.RawMessage
+	At     time.Time
+}
+
+// An AuditPage is a page of AuditRecords, in the order that the changes were made.
+type AuditPage struct {
+	Records []AuditRecord
+	// Next is passed to get the next page. It's empty if there are no more records, but may be set when the
+	// page ends with the last record, in which case the next page is empty.
+	Next string
+}
+
+type actorContextKey struct{}
+
+// WithActor returns a context that records the actor as the User making changes to the stores in the audit log.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// actorFromContext returns the actor set by WithActor, or the default.
+func actorFromContext(ctx context.Context, def string) string {
+	if actor, ok := ctx.Value(actorContextKey{}).(string); ok {
+		return actor
+	}
+	return def
+}
+
+// maxAuditAttempts is how many times a change is attempted if the record changes between being read for the audit
+// log and being written.
+const maxAuditAttempts = 3
+
+// auditMembership is the state of a membership recorded in the audit log.
+type auditMembership struct {
+	Groups        []string            `json:"groups,omitempty"`
+	ServiceGroups map[string][]string `json:"serviceGroups,omitempty"`
+	Pending       bool                `json:"pending,omitempty"`
+	ExpiresAt     *time.Time          `json:"expiresAt,omitempty"`
+}
+
+func newAuditMembership(groups []string, serviceIDToGroups map[string][]string) *auditMembership {
+	if len(groups) == 0 && len(serviceIDToGroups) == 0 {
+		return &auditMembership{}
+	}
+	gs := newGroupSet(groups, serviceIDToGroups)
+	am := &auditMembership{
+		Groups:        gs.OrganisationGroups(),
+		ServiceGroups: gs.ServiceGroups(),
+	}
+	sort.Strings(am.Groups)
+	for _, sgs := range am.ServiceGroups {
+		sort.Strings(sgs)
+	}
+	return am
+}
+
+// newAuditMembershipFromRecord returns the state of the membership in an organisationGroupMember record, or nil if
+// the record doesn't exist.
+func newAuditMembershipFromRecord(item map[string]*dynamodb.AttributeValue) (am *auditMembership, version int, err error) {
+	if len(item) == 0 {
+		return
+	}
+	var omr organisationMemberRecord
+	err = dynamodbattribute.UnmarshalMap(item, &omr)
+	if err != nil {
+		err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+		return
+	}
+	am = &auditMembership{}
+	if omr.Groups != nil {
+		am = newAuditMembership(omr.Groups.OrganisationGroups(), omr.Groups.ServiceGroups())
+	}
+	am.Pending = omr.pending()
+	return am, omr.Version, nil
+}
+
+// newAuditGrant returns the groups of the grants, which all expire at the same time.
+func newAuditGrant(grants []Grant) *auditMembership {
+	gs := newGroupSet(nil, nil)
+	for _, g := range grants {
+		if g.ServiceID == "" {
+			gs.AddToGroups(string(g.Group))
+			continue
+		}
+		gs.AddToServiceGroups(g.ServiceID, string(g.Group))
+	}
+	am := newAuditMembership(gs.OrganisationGroups(), gs.ServiceGroups())
+	if len(grants) > 0 {
+		// Grants are stored to the second.
+		expiresAt := grants[0].ExpiresAt.Truncate(time.Second).UTC()
+		am.ExpiresAt = &expiresAt
+	}
+	return am
+}
+
+// add returns the membership with the groups added.
+func (am *auditMembership) add(groups []string, serviceIDToGroups map[string][]string) *auditMembership {
+	var updated *auditMembership
+	if am == nil {
+		updated = newAuditMembership(groups, serviceIDToGroups)
+	} else {
+		gs := newGroupSet(am.Groups, am.ServiceGroups)
+		gs.AddToGroups(groups...)
+		for serviceID, sgs := range serviceIDToGroups {
+			gs.AddToServiceGroups(serviceID, sgs...)
+		}
+		updated = newAuditMembership(gs.OrganisationGroups(), gs.ServiceGroups())
+		updated.Pending = am.Pending
+	}
+	return updated
+}
+
+// remove returns the membership with the groups removed.
+func (am *auditMembership) remove(groups []string, serviceIDToGroups map[string][]string) *auditMembership {
+	var remaining []string
+	for _, g := range am.Groups {
+		if !containsString(groups, g) {
+			remaining = append(remaining, g)
+		}
+	}
+	remainingServiceGroups := make(map[string][]string)
+	for serviceID, sgs := range am.ServiceGroups {
+		for _, g := range sgs {
+			if !containsString(serviceIDToGroups[serviceID], g) {
+				remainingServiceGroups[serviceID] = append(remainingServiceGroups[serviceID], g)
+			}
+		}
+	}
+	updated := newAuditMembership(remaining, remainingServiceGroups)
+	updated.Pending = am.Pending
+	return updated
+}
+
+// auditOrganisation is the state of an Organisation or Service recorded in the audit log.
+type auditOrganisation struct {
+	Name string `json:"name"`
+}
+
+// newAuditOrganisationFromRecord returns the state of an organisation or organisationService record, or nil if
+// the record doesn't exist.
+func newAuditOrganisationFromRecord(item map[string]*dynamodb.AttributeValue) (ao *auditOrganisation, err error) {
+	if len(item) == 0 {
+		return
+	}
+	var r struct {
+		OrganisationName string `json:"organisationName"`
+		ServiceName      string `json:"serviceName"`
+	}
+	err = dynamodbattribute.UnmarshalMap(item, &r)
+	if err != nil {
+		err = fmt.Errorf("failed to convert record: %w", err)
+		return
+	}
+	ao = &auditOrganisation{Name: r.OrganisationName}
+	if r.ServiceName != "" {
+		ao.Name = r.ServiceName
+	}
+	return
+}
+
+// auditRole is the state of a Role recorded in the audit log.
+type auditRole struct {
+	Name        GroupName    `json:"name"`
+	Scope       RoleScope    `json:"scope"`
+	Description string       `json:"description,omitempty"`
+	Permissions []Permission `json:"permissions,omitempty"`
+	Subgroups   []GroupName  `json:"subgroups,omitempty"`
+}
+
+func newAuditRole(role Role) *auditRole {
+	return &auditRole{
+		Name:        role.Name,
+		Scope:       role.Scope,
+		Description: role.Description,
+		Permissions: role.Permissions,
+		Subgroups:   role.Subgroups,
+	}
+}
+
+// newAuditRoleFromRecord returns the state of an organisationRole record, or nil if the record doesn't exist.
+func newAuditRoleFromRecord(item map[string]*dynamodb.AttributeValue) (ar *auditRole, version int, err error) {
+	if len(item) == 0 {
+		return
+	}
+	var orr organisationRoleRecord
+	err = dynamodbattribute.UnmarshalMap(item, &orr)
+	if err != nil {
+		err = fmt.Errorf("failed to convert organisationRoleRecord: %w", err)
+		return
+	}
+	return newAuditRole(newRoleFromRecord(orr)), orr.Version, nil
+}
+
+// auditWebhook is the state of a Webhook recorded in the audit log. The secret is never recorded.
+type auditWebhook struct {
+	ID     string      `json:"id"`
+	URL    string      `json:"url"`
+	Events []EventType `json:"events,omitempty"`
+}
+
+func newAuditWebhook(w Webhook) *auditWebhook {
+	return &auditWebhook{ID: w.ID, URL: w.URL, Events: w.Events}
+}
+
+// newAuditWebhookFromRecord returns the state of an organisationWebhook record, or nil if the record doesn't
+// exist.
+func newAuditWebhookFromRecord(item map[string]*dynamodb.AttributeValue) (aw *auditWebhook, version int, err error) {
+	if len(item) == 0 {
+		return
+	}
+	var owr organisationWebhookRecord
+	err = dynamodbattribute.UnmarshalMap(item, &owr)
+	if err != nil {
+		err = fmt.Errorf("failed to convert organisationWebhookRecord: %w", err)
+		return
+	}
+	return newAuditWebhook(newWebhookFromRecord(owr)), owr.Version, nil
+}
+
+// auditOwners is the state of an Organisation's owners recorded in the audit log.
+type auditOwners struct {
+	Owners []string `json:"owners"`
+}
+
+// auditFields names the fields of a User's details that were changed. The values aren't recorded, so that the
+// details are only held on the User's records.
+type auditFields struct {
+	Fields []string `json:"fields,omitempty"`
+}
+
+func newAuditFields(before, after userRecordFields) *auditFields {
+	var af auditFields
+	if before.FirstName != after.FirstName {
+		af.Fields = append(af.Fields, "firstName")
+	}
+	if before.LastName != after.LastName {
+		af.Fields = append(af.Fields, "lastName")
+	}
+	if before.Phone != after.Phone {
+		af.Fields = append(af.Fields, "phone")
+	}
+	return &af
+}
+
+// auditInvitation is the state of an invitation recorded in the audit log.
+type auditInvitation struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// newAuditRecord creates an AuditRecord, converting before and after to JSON. Nil pointers are recorded as nil.
+func newAuditRecord(ctx context.Context, now time.Time, action AuditAction, organisationID, userID string, before, after interface{}) (ar AuditRecord, err error) {
+	ar = AuditRecord{
+		ID:             uuid.New().String(),
+		OrganisationID: organisationID,
+		UserID:         userID,
+		Actor:          actorFromContext(ctx, ""),
+		Action:         action,
+		At:             now,
+	}
+	ar.Before, err = marshalAuditState(before)
+	if err != nil {
+		return
+	}
+	ar.After, err = marshalAuditState(after)
+	return
+}
+
+func marshalAuditState(v interface{}) (json.RawMessage, error) {
+	if rv := reflect.ValueOf(v); v == nil || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert audit state: %w", err)
+	}
+	return b, nil
+}
+
+// newAuditPuts creates the puts of an AuditRecord to the Organisation's audit log, and the audit logs of the User
+// that the record is about and the actor, if any.
+func newAuditPuts(tableName *string, ar AuditRecord) (puts []*dynamodb.TransactWriteItem, err error) {
+	notExists, err := expression.NewBuilder().WithCondition(versionCondition(0)).Build()
+	if err != nil {
+		err = fmt.Errorf("failed to build audit condition: %v", err)
+		return
+	}
+	for _, r := range newAuditRecordRecords(ar) {
+		item, err := dynamodbattribute.MarshalMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert auditRecord: %w", err)
+		}
+		puts = append(puts, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:                tableName,
+				Item:                     item,
+				ConditionExpression:      notExists.Condition(),
+				ExpressionAttributeNames: notExists.Names(),
+			},
+		})
+	}
+	return
+}
+
+// newErasedUserID creates the ID that replaces an erased User's ID in the audit logs. The same ID is used for all
+// of the User's records, so that they can still be read together, but it can't be traced back to the User.
+func newErasedUserID() string {
+	return "erased/" + uuid.New().String()
+}
+
+// erasedAuditRecordCopy is a copy of an audit record that refers to an erased User, held in an audit log other
+// than the User's own.
+type erasedAuditRecordCopy struct {
+	Key RecordKey
+	// Set holds the new values of the attributes that contain the erased User's ID.
+	Set map[string]string
+}
+
+// newErasedAuditRecordCopies returns the copies of the audit records that refer to an erased User, apart from
+// those in the User's own audit log, and the keys of the records in the User's own audit log. Each record is
+// returned once, however many of the items are copies of it.
+func newErasedAuditRecordCopies(userID, erasedID string, items []map[string]*dynamodb.AttributeValue) (copies []erasedAuditRecordCopy, keys []RecordKey, err error) {
+	userHashKey := newUserAuditRecordHashKey(userID)
+	erased := map[string]bool{}
+	for _, item := range items {
+		var ar auditRecord
+		err = dynamodbattribute.UnmarshalMap(item, &ar)
+		if err != nil {
+			err = fmt.Errorf("failed to convert auditRecord: %w", err)
+			return
+		}
+		if ar.ID == userHashKey {
+			keys = append(keys, RecordKey{ID: ar.ID, Range: ar.Range})
+		}
+		if erased[ar.AuditID] {
+			continue
+		}
+		erased[ar.AuditID] = true
+		set := newErasedAuditRecordSet(ar, userID, erasedID)
+		if len(set) == 0 {
+			continue
+		}
+		for _, r := range newAuditRecordRecords(newAuditRecordFromRecord(ar)) {
+			if r.ID == userHashKey {
+				continue
+			}
+			copies = append(copies, erasedAuditRecordCopy{Key: RecordKey{ID: r.ID, Range: r.Range}, Set: set})
+		}
+	}
+	return
+}
+
+// newErasedAuditRecordSet returns the new values of the audit record's attributes that contain the User's ID,
+// including the states recorded before and after the change, such as lists of owners.
+func newErasedAuditRecordSet(ar auditRecord, userID, erasedID string) (set map[string]string) {
+	set = map[string]string{}
+	if ar.UserID == userID {
+		set["userId"] = erasedID
+	}
+	if ar.Actor == userID {
+		set["actor"] = erasedID
+	}
+	if before, ok := replaceJSONString(ar.Before, userID, erasedID); ok {
+		set["before"] = before
+	}
+	if after, ok := replaceJSONString(ar.After, userID, erasedID); ok {
+		set["after"] = after
+	}
+	return
+}
+
+// replaceJSONString replaces the string values of a JSON document that equal old with new, leaving other strings
+// that contain old alone. It returns false if there are no values to replace.
+func replaceJSONString(doc, old, new string) (replaced string, ok bool) {
+	quoted, _ := json.Marshal(old)
+	newQuoted, _ := json.Marshal(new)
+	if !strings.Contains(doc, string(quoted)) {
+		return doc, false
+	}
+	return strings.Replace(doc, string(quoted), string(newQuoted), -1), true
+}
+
+// eraseAuditLog replaces the User's ID with the erasedID in the audit records that refer to them, and returns the
+// keys of the records in the User's own audit log, which must be deleted afterwards, and the IDs of the
+// Organisations that the records belong to. Records that only refer to the User in their states are found in the
+// audit logs of the given Organisations, and of the Organisations in the User's audit log.
+func eraseAuditLog(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, userID, erasedID string, organisationIDs []string) (keys []RecordKey, auditedOrganisationIDs []string, err error) {
+	items, err := queryPartition(ctx, client, tableName, newUserAuditRecordHashKey(userID), auditRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("failed to query audit log: %w", err)
+		return
+	}
+	auditedOrganisationIDs, err = newAuditedOrganisationIDs(organisationIDs, items)
+	if err != nil {
+		return
+	}
+	for _, organisationID := range auditedOrganisationIDs {
+		var organisationItems []map[string]*dynamodb.AttributeValue
+		organisationItems, err = queryPartition(ctx, client, tableName, newOrganisationAuditRecordHashKey(organisationID), auditRecordName+"/")
+		if err != nil {
+			err = fmt.Errorf("failed to query audit log of organisation %q: %w", organisationID, err)
+			return
+		}
+		items = append(items, organisationItems...)
+	}
+	copies, keys, err := newErasedAuditRecordCopies(userID, erasedID, items)
+	if err != nil {
+		return
+	}
+	for _, c := range copies {
+		update := expression.UpdateBuilder{}
+		for name, value := range c.Set {
+			update = update.Set(expression.Name(name), expression.Value(value))
+		}
+		// The copy may already have been deleted, along with its Organisation or User.
+		exists := expression.AttributeExists(expression.Name("id"))
+		expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(exists).Build()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build audit record update: %v", err)
+		}
+		_, err = client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 tableName,
+			Key:                       idAndRng(c.Key.ID, c.Key.Range),
+			UpdateExpression:          expr.Update(),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+		if err != nil && !isConditionalCheckFailed(err) {
+			return nil, nil, fmt.Errorf("failed to update audit record: %w", err)
+		}
+	}
+	return
+}
+
+// newAuditedOrganisationIDs returns the given Organisation IDs, followed by the IDs of the other Organisations
+// that the audit records belong to.
+func newAuditedOrganisationIDs(organisationIDs []string, items []map[string]*dynamodb.AttributeValue) (ids []string, err error) {
+	seen := map[string]bool{}
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range organisationIDs {
+		add(id)
+	}
+	for _, item := range items {
+		var ar auditRecord
+		err = dynamodbattribute.UnmarshalMap(item, &ar)
+		if err != nil {
+			err = fmt.Errorf("failed to convert auditRecord: %w", err)
+			return
+		}
+		if ar.OrganisationID != "" {
+			add(ar.OrganisationID)
+		}
+	}
+	return
+}
+
+// eraseInvitedBy replaces the User's ID with the erasedID in the invitations that they sent to join the
+// Organisation, on both sides of each membership.
+func eraseInvitedBy(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID, userID, erasedID string) error {
+	items, err := queryPartition(ctx, client, tableName, newOrganisationMemberRecordHashKey(organisationID), organisationMemberRecordName+"/")
+	if err != nil {
+		return fmt.Errorf("failed to query members of organisation %q: %w", organisationID, err)
+	}
+	expr, err := expression.NewBuilder().
+		WithUpdate(incrementVersion(expression.Set(expression.Name("invitedBy"), expression.Value(erasedID)))).
+		WithCondition(expression.Name("invitedBy").Equal(expression.Value(userID))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build invitation update: %v", err)
+	}
+	for _, item := range items {
+		var omr organisationMemberRecord
+		err = dynamodbattribute.UnmarshalMap(item, &omr)
+		if err != nil {
+			return fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+		}
+		if omr.InvitedBy != userID {
+			continue
+		}
+		for _, key := range []RecordKey{
+			{ID: omr.ID, Range: omr.Range},
+			{ID: newUserOrganisationRecordHashKey(omr.Email), Range: newUserOrganisationRecordRangeKey(organisationID)},
+		} {
+			_, err = client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 tableName,
+				Key:                       idAndRng(key.ID, key.Range),
+				UpdateExpression:          expr.Update(),
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			})
+			// The invitation may have been removed, or already erased.
+			if err != nil && !isConditionalCheckFailed(err) {
+				return fmt.Errorf("failed to update invitation: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// queryAuditLog reads a page of an audit log, starting after the start key.
+func queryAuditLog(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, id string, limit int, start string) (page AuditPage, err error) {
+	q := expression.Key("id").Equal(expression.Value(id)).And(expression.Key("rng").BeginsWith(auditRecordName + "/"))
+	expr, err := expression.NewBuilder().WithKeyCondition(q).Build()
+	if err != nil {
+		return
+	}
+	qi := &dynamodb.QueryInput{
+		TableName:                 tableName,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeValues: expr.Values(),
+		ExpressionAttributeNames:  expr.Names(),
+		ConsistentRead:            aws.Bool(true),
+	}
+	if limit > 0 {
+		qi.Limit = aws.Int64(int64(limit))
+	}
+	if start != "" {
+		qi.ExclusiveStartKey = idAndRng(id, start)
+	}
+	qo, err := client.QueryWithContext(ctx, qi)
+	if err != nil {
+		return
+	}
+	page.Records, err = newAuditRecordsFromRecords(qo.Items)
+	if err != nil {
+		return
+	}
+	if rng, ok := qo.LastEvaluatedKey["rng"]; ok && rng.S != nil {
+		page.Next = *rng.S
+	}
+	return
+}
+
+func newAuditRecordsFromRecords(items []map[string]*dynamodb.AttributeValue) (records []AuditRecord, err error) {
+	for _, item := range items {
+		var ar auditRecord
+		err = dynamodbattribute.UnmarshalMap(item, &ar)
+		if err != nil {
+			err = fmt.Errorf("failed to convert auditRecord: %w", err)
+			return
+		}
+		records = append(records, newAuditRecordFromRecord(ar))
+	}
+	return
+}
+
+// audit record. Each AuditRecord is stored in the Organisation's audit log, if it has one, and the audit logs of the
+// User it's about and the User that made the change. The audit logs are kept in their own partitions. When an Organisation
+// is deleted, its audit log is deleted apart from the record of the deletion, but the copies held in Users' audit
+// logs are kept. When a User is erased, their audit log is deleted, and their ID is replaced in the other audit
+// records that refer to them, including in the states recorded before and after each change.
+const auditRecordName = "audit"
+
+func newOrganisationAuditRecordHashKey(organisationID string) string {
+	return auditRecordName + "/" + newOrganisationRecordHashKey(organisationID)
+}
+
+func newUserAuditRecordHashKey(userID string) string {
+	return auditRecordName + "/" + newUserRecordHashKey(userID)
+}
+
+// newAuditRecordRangeKey sorts audit records by time.
+func newAuditRecordRangeKey(at time.Time, id string) string {
+	return auditRecordName + "/" + sortableTime(at) + "/" + id
+}
+
+func newAuditRecordRecords(ar AuditRecord) (records []auditRecord) {
+	var hashKeys []string
+	if ar.OrganisationID != "" {
+		hashKeys = append(hashKeys, newOrganisationAuditRecordHashKey(ar.OrganisationID))
+	}
+	if ar.UserID != "" {
+		hashKeys = append(hashKeys, newUserAuditRecordHashKey(ar.UserID))
+	}
+	if ar.Actor != "" && ar.Actor != ar.UserID {
+		hashKeys = append(hashKeys, newUserAuditRecordHashKey(ar.Actor))
+	}
+	for _, hashKey := range hashKeys {
+		var record auditRecord
+		record.ID = hashKey
+		record.Range = newAuditRecordRangeKey(ar.At, ar.ID)
+		record.RecordType = auditRecordName
+		record.Version = 1
+		record.AuditID = ar.ID
+		record.OrganisationID = ar.OrganisationID
+		record.UserID = ar.UserID
+		record.ServiceID = ar.ServiceID
+		record.Actor = ar.Actor
+		record.Action = string(ar.Action)
+		record.Before = string(ar.Before)
+		record.After = string(ar.After)
+		record.At = ar.At
+		records = append(records, record)
+	}
+	return
+}
+
+func newAuditRecordFromRecord(ar auditRecord) AuditRecord {
+	record := AuditRecord{
+		ID:             ar.AuditID,
+		OrganisationID: ar.OrganisationID,
+		UserID:         ar.UserID,
+		ServiceID:      ar.ServiceID,
+		Actor:          ar.Actor,
+		Action:         AuditAction(ar.Action),
+		At:             ar.At,
+	}
+	if ar.Before != "" {
+		record.Before = json.RawMessage(ar.Before)
+	}
+	if ar.After != "" {
+		record.After = json.RawMessage(ar.After)
+	}
+	return record
+}
+
+type auditRecord struct {
+	record
+	AuditID        string    `json:"auditId"`
+	OrganisationID string    `json:"organisationId"`
+	UserID         string    `json:"userId,omitempty"`
+	ServiceID      string    `json:"serviceId,omitempty"`
+	Actor          string    `json:"actor,omitempty"`
+	Action         string    `json:"action"`
+	Before         string    `json:"before,omitempty"`
+	After          string    `json:"after,omitempty"`
+	At             time.Time `json:"at"`
+}
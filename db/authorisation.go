@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// A Permission allows an action to be carried out on an Organisation or one of its Services.
+type Permission string
+
+const (
+	// PermissionOrganisationRead allows the details of an Organisation and its invitations to be read.
+	PermissionOrganisationRead Permission = "org:read"
+	// PermissionOrganisationRename allows an Organisation to be renamed.
+	PermissionOrganisationRename Permission = "org:rename"
+	// PermissionOrganisationDelete allows an Organisation to be deleted.
+	PermissionOrganisationDelete Permission = "org:delete"
+	// PermissionOrganisationOwners allows Users to be added to, or removed from, the owner group.
+	PermissionOrganisationOwners Permission = "org:owners"
+	// PermissionOrganisationRoles allows the Organisation's roles to be created, changed and deleted.
+	PermissionOrganisationRoles Permission = "org:roles"
+	// PermissionOrganisationWebhooks allows the Organisation's webhooks and their deliveries to be read, created,
+	// changed and deleted.
+	PermissionOrganisationWebhooks Permission = "org:webhooks"
+	// PermissionMemberInvite allows Users to be invited, and invitations to be resent or revoked.
+	PermissionMemberInvite Permission = "member:invite"
+	// PermissionMemberUpdate allows members' groups and details to be changed.
+	PermissionMemberUpdate Permission = "member:update"
+	// PermissionMemberRemove allows members to be removed from an Organisation.
+	PermissionMemberRemove Permission = "member:remove"
+	// PermissionServiceCreate allows Services to be created within an Organisation.
+	PermissionServiceCreate Permission = "service:create"
+	// PermissionServiceUpdate allows a Service to be renamed.
+	PermissionServiceUpdate Permission = "service:update"
+	// PermissionServiceDelete allows a Service to be deleted.
+	PermissionServiceDelete Permission = "service:delete"
+	// PermissionServiceDeploy allows a Service to be deployed.
+	PermissionServiceDeploy Permission = "service:deploy"
+)
+
+const (
+	// ServiceGroupAdmin is the Service group granted full control of a Service by the DefaultPolicy.
+	ServiceGroupAdmin = "admin"
+	// ServiceGroupDeployer is the Service group granted permission to deploy a Service by the DefaultPolicy.
+	ServiceGroupDeployer = "deployer"
+)
+
+// A Policy maps groups to the permissions granted to their members.
+type Policy struct {
+	// Organisation groups grant permissions on the Organisation and all of its Services.
+	Organisation map[GroupName][]Permission
+	// Service groups grant permissions on the Service that the group belongs to.
+	Service map[GroupName][]Permission
+}
+
+// DefaultPolicy grants the permissions of the DefaultRoles: owners have every permission, members can read the
+// Organisation, and Service admins and deployers have control over their Services.
+var DefaultPolicy = NewPolicy(DefaultRoles)
+
+// NewPolicy creates a Policy that grants the permissions of the roles.
+func NewPolicy(roles []Role) Policy {
+	p := Policy{
+		Organisation: make(map[GroupName][]Permission),
+		Service:      make(map[GroupName][]Permission),
+	}
+	for _, r := range roles {
+		p.add(r)
+	}
+	return p
+}
+
+func (p Policy) add(r Role) {
+	switch r.Scope {
+	case RoleScopeOrganisation:
+		p.Organisation[r.Name] = append(p.Organisation[r.Name], r.Permissions...)
+	case RoleScopeService:
+		p.Service[r.Name] = append(p.Service[r.Name], r.Permissions...)
+	}
+}
+
+// withRoles returns a copy of the Policy that also grants the permissions of roles for groups that the Policy
+// doesn't define.
+func (p Policy) withRoles(roles []Role) Policy {
+	merged := NewPolicy(nil)
+	for g, permissions := range p.Organisation {
+		merged.Organisation[g] = permissions
+	}
+	for g, permissions := range p.Service {
+		merged.Service[g] = permissions
+	}
+	for _, r := range roles {
+		if p.defines(r.Scope, r.Name) {
+			continue
+		}
+		merged.add(r)
+	}
+	return merged
+}
+
+func (p Policy) defines(scope RoleScope, g GroupName) bool {
+	if scope == RoleScopeService {
+		_, ok := p.Service[g]
+		return ok
+	}
+	_, ok := p.Organisation[g]
+	return ok
+}
+
+// allows returns true if any of the groups grant the action on the resource.
+func (p Policy) allows(groups []string, serviceIDToGroups map[string][]string, action Permission, resource Resource) bool {
+	for _, g := range groups {
+		if containsPermission(p.Organisation[GroupName(g)], action) {
+			return true
+		}
+	}
+	if resource.ServiceID == "" {
+		return false
+	}
+	for _, g := range serviceIDToGroups[resource.ServiceID] {
+		if containsPermission(p.Service[GroupName(g)], action) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPermission(permissions []Permission, p Permission) bool {
+	for _, v := range permissions {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+// A Resource is an Organisation, or a Service within an Organisation.
+type Resource struct {
+	OrganisationID string
+	// ServiceID is empty if the Resource is the Organisation.
+	ServiceID string
+}
+
+// OrganisationResource returns the Resource of an Organisation.
+func OrganisationResource(organisationID string) Resource {
+	return Resource{OrganisationID: organisationID}
+}
+
+// ServiceResource returns the Resource of a Service within an Organisation.
+func ServiceResource(organisationID, serviceID string) Resource {
+	return Resource{OrganisationID: organisationID, ServiceID: serviceID}
+}
+
+// An Authoriser decides whether Users are allowed to carry out actions, based on the groups they belong to.
+type Authoriser struct {
+	Organisations OrganisationRepository
+	Policy        Policy
+}
+
+// NewAuthoriser creates an Authoriser that reads group memberships from the Organisation repository.
+func NewAuthoriser(organisations OrganisationRepository, policy Policy) Authoriser {
+	return Authoriser{
+		Organisations: organisations,
+		Policy:        policy,
+	}
+}
+
+// Can returns true if the actor is allowed to carry out the action on the resource. Users that aren't members
+// of the resource's Organisation, including those that haven't accepted their invitation, aren't allowed to do
+// anything. Users are granted the permissions of their effective groups, including the groups that contain
+// them, and groups that the Policy doesn't define are granted the permissions of the Organisation's roles.
+func (a Authoriser) Can(ctx context.Context, actor string, action Permission, resource Resource) (bool, error) {
+	groups, serviceIDToGroups, err := a.Organisations.GetUserGroups(ctx, resource.OrganisationID, actor)
+	if errors.Is(err, ErrNotMember) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("authoriser.Can: %w", err)
+	}
+	if a.Policy.allows(groups, serviceIDToGroups, action, resource) {
+		return true, nil
+	}
+	roles, err := a.Organisations.ListRoles(ctx, resource.OrganisationID)
+	if err != nil {
+		return false, fmt.Errorf("authoriser.Can: %w", err)
+	}
+	groups, serviceIDToGroups = resolveGroups(roles, groups, serviceIDToGroups).strings()
+	return a.Policy.withRoles(roles).allows(groups, serviceIDToGroups, action, resource), nil
+}
+
+// authorise returns a *PermissionError if the actor isn't allowed to carry out the action on the resource.
+func (a Authoriser) authorise(ctx context.Context, actor string, action Permission, resource Resource) error {
+	ok, err := a.Can(ctx, actor, action, resource)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &PermissionError{Actor: actor, Action: action, Resource: resource}
+	}
+	return nil
+}
+
+// authoriseGrant returns a *PermissionError if adding a User to the groups would grant a permission that the actor
+// doesn't have, so that actors can't grant more than they hold. The permissions of a group include those of the
+// groups that contain it.
+func (a Authoriser) authoriseGrant(ctx context.Context, actor, organisationID string, groups []string, serviceIDToGroups map[string][]string) error {
+	roles, err := a.Organisations.ListRoles(ctx, organisationID)
+	if err != nil {
+		return fmt.Errorf("authoriser.authoriseGrant: %w", err)
+	}
+	actorGroups, actorServiceIDToGroups, err := a.Organisations.GetUserGroups(ctx, organisationID, actor)
+	if err != nil && !errors.Is(err, ErrNotMember) {
+		return fmt.Errorf("authoriser.authoriseGrant: %w", err)
+	}
+	actorGroups, actorServiceIDToGroups = resolveGroups(roles, actorGroups, actorServiceIDToGroups).strings()
+	policy := a.Policy.withRoles(roles)
+	check := func(permissions []Permission, resource Resource) error {
+		for _, p := range permissions {
+			if !policy.allows(actorGroups, actorServiceIDToGroups, p, resource) {
+				return &PermissionError{Actor: actor, Action: p, Resource: resource}
+			}
+		}
+		return nil
+	}
+	granted := resolveGroups(roles, groups, serviceIDToGroups)
+	for _, g := range granted.Organisation {
+		if err := check(policy.Organisation[g], OrganisationResource(organisationID)); err != nil {
+			return err
+		}
+	}
+	for serviceID, sgs := range granted.Services {
+		for _, g := range sgs {
+			if err := check(policy.Service[g], ServiceResource(organisationID, serviceID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
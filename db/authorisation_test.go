@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	tests := []struct {
+		name              string
+		groups            []string
+		serviceIDToGroups map[string][]string
+		action            Permission
+		resource          Resource
+		expected          bool
+	}{
+		{
+			name:     "owners can rename the organisation",
+			groups:   []string{GroupOwner},
+			action:   PermissionOrganisationRename,
+			resource: OrganisationResource("org"),
+			expected: true,
+		},
+		{
+			name:     "members can't rename the organisation",
+			groups:   []string{GroupMember},
+			action:   PermissionOrganisationRename,
+			resource: OrganisationResource("org"),
+		},
+		{
+			name:     "organisation groups apply to all services",
+			groups:   []string{GroupOwner},
+			action:   PermissionServiceDeploy,
+			resource: ServiceResource("org", "service"),
+			expected: true,
+		},
+		{
+			name:              "service groups apply to their service",
+			groups:            []string{GroupMember},
+			serviceIDToGroups: map[string][]string{"service": {ServiceGroupDeployer}},
+			action:            PermissionServiceDeploy,
+			resource:          ServiceResource("org", "service"),
+			expected:          true,
+		},
+		{
+			name:              "service groups don't apply to other services",
+			serviceIDToGroups: map[string][]string{"service": {ServiceGroupAdmin}},
+			action:            PermissionServiceDeploy,
+			resource:          ServiceResource("org", "other"),
+		},
+		{
+			name:              "service groups don't apply to the organisation",
+			serviceIDToGroups: map[string][]string{"service": {ServiceGroupAdmin}},
+			action:            PermissionServiceCreate,
+			resource:          OrganisationResource("org"),
+		},
+		{
+			name:              "deployers can't delete the service",
+			serviceIDToGroups: map[string][]string{"service": {ServiceGroupDeployer}},
+			action:            PermissionServiceDelete,
+			resource:          ServiceResource("org", "service"),
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			actual := DefaultPolicy.allows(tt.groups, tt.serviceIDToGroups, tt.action, tt.resource)
+			if actual != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func testAuthorisedOrganisationStore(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := NewAuthorisedOrganisationStore(r.users, r.organisations, DefaultPolicy)
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("owner@example.com", "Owner", "Last", "447901234567", createdAt)
+	member := newUser("member@example.com", "Member", "Last", "447901234567", createdAt)
+	invitee := newUser("invitee@example.com", "Invitee", "Last", "447901234567", createdAt)
+	organisationID, err := r.organisations.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+	org := newOrganisation(organisationID, "Organisation Name")
+
+	err = s.AddUserToGroups(ctx, owner.ID, organisationID, member, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("expected the owner to be able to add members, got %v", err)
+	}
+	serviceID, err := s.CreateService(ctx, owner.ID, organisationID, "service")
+	if err != nil {
+		t.Errorf("expected the owner to be able to create services, got %v", err)
+	}
+	err = s.AddUserToGroups(ctx, owner.ID, organisationID, member, nil, map[string][]string{serviceID: {ServiceGroupAdmin}})
+	if err != nil {
+		t.Errorf("expected the owner to be able to add service admins, got %v", err)
+	}
+
+	// Members can read the organisation, and manage the services they're an admin of.
+	_, err = s.GetDetails(ctx, member.ID, organisationID)
+	if err != nil {
+		t.Errorf("expected members to be able to read the organisation, got %v", err)
+	}
+	err = s.PutService(ctx, member.ID, organisationID, serviceID, "renamed", 1)
+	if err != nil {
+		t.Errorf("expected service admins to be able to rename the service, got %v", err)
+	}
+	ok, err := s.Authoriser.Can(ctx, member.ID, PermissionServiceDeploy, ServiceResource(organisationID, serviceID))
+	if err != nil || !ok {
+		t.Errorf("expected service admins to be able to deploy, got %v, %v", ok, err)
+	}
+
+	// But they can't administer the organisation.
+	err = s.Rename(ctx, member.ID, organisationID, "New Name", 1)
+	var pe *PermissionError
+	if !errors.As(err, &pe) || pe.Action != PermissionOrganisationRename || pe.Actor != member.ID {
+		t.Errorf("expected a *PermissionError, got %v", err)
+	}
+	_, err = s.CreateService(ctx, member.ID, organisationID, "other")
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied when creating a service, got %v", err)
+	}
+	err = s.AddUserToGroups(ctx, member.ID, organisationID, member, []string{GroupOwner}, nil)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied when promoting to owner, got %v", err)
+	}
+	_, err = s.Invite(ctx, member.ID, invitee, org, []string{GroupMember}, nil)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied when inviting, got %v", err)
+	}
+	err = s.PutRole(ctx, member.ID, organisationID, Role{Name: "admins", Scope: RoleScopeOrganisation})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied when creating a role, got %v", err)
+	}
+	// Webhook secrets are only visible to those that can manage them.
+	_, err = s.ListWebhooks(ctx, member.ID, organisationID)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied when listing webhooks, got %v", err)
+	}
+	_, err = s.CreateWebhook(ctx, owner.ID, organisationID, Webhook{URL: "https://example.com", Secret: "secret"})
+	if err != nil {
+		t.Errorf("expected the owner to be able to create a webhook, got %v", err)
+	}
+
+	// Users that can update members can only grant the permissions they hold, including those of the groups
+	// that contain the group they're granting.
+	roles := []Role{
+		{Name: "admins", Scope: RoleScopeOrganisation, Permissions: []Permission{PermissionOrganisationRead, PermissionMemberUpdate}},
+		{Name: "helpers", Scope: RoleScopeOrganisation},
+		{Name: "managers", Scope: RoleScopeOrganisation, Permissions: []Permission{PermissionOrganisationDelete}, Subgroups: []GroupName{"helpers"}},
+		{Name: "readers", Scope: RoleScopeOrganisation, Permissions: []Permission{PermissionOrganisationRead}},
+	}
+	for _, role := range roles {
+		err = s.PutRole(ctx, owner.ID, organisationID, role)
+		if err != nil {
+			t.Fatalf("failed to create role %q: %v", role.Name, err)
+		}
+	}
+	err = s.AddUserToGroups(ctx, owner.ID, organisationID, member, []string{"admins"}, nil)
+	if err != nil {
+		t.Fatalf("failed to add admin: %v", err)
+	}
+	err = s.AddUserToGroups(ctx, member.ID, organisationID, member, []string{"managers"}, nil)
+	if !errors.As(err, &pe) || pe.Action != PermissionOrganisationDelete {
+		t.Errorf("expected a *PermissionError for org:delete when granting a more powerful role, got %v", err)
+	}
+	err = s.AddUserToGroups(ctx, member.ID, organisationID, member, []string{"helpers"}, nil)
+	if !errors.As(err, &pe) || pe.Action != PermissionOrganisationDelete {
+		t.Errorf("expected a *PermissionError for org:delete when granting a subgroup of a more powerful role, got %v", err)
+	}
+	err = s.AddUserToGroups(ctx, member.ID, organisationID, invitee, []string{"readers"}, map[string][]string{serviceID: {ServiceGroupAdmin}})
+	if err != nil {
+		t.Errorf("expected admins to be able to grant the permissions they hold, got %v", err)
+	}
+	err = s.RemoveUser(ctx, owner.ID, organisationID, invitee.ID)
+	if err != nil {
+		t.Fatalf("failed to remove user: %v", err)
+	}
+
+	// Invitees have no permissions until they accept.
+	token, err := s.Invite(ctx, owner.ID, invitee, org, []string{GroupOwner}, nil)
+	if err != nil {
+		t.Fatalf("expected the owner to be able to invite owners, got %v", err)
+	}
+	_, err = s.GetDetails(ctx, invitee.ID, organisationID)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied for a pending invitee, got %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, invitee, org, token)
+	if err != nil {
+		t.Fatalf("failed to accept invitation: %v", err)
+	}
+	err = s.Rename(ctx, invitee.ID, organisationID, "New Name", 1)
+	if err != nil {
+		t.Errorf("expected the new owner to be able to rename the organisation, got %v", err)
+	}
+
+	// Users outside the organisation can't do anything.
+	ok, err = s.Authoriser.Can(ctx, "outsider@example.com", PermissionOrganisationRead, OrganisationResource(organisationID))
+	if err != nil || ok {
+		t.Errorf("expected outsiders to be denied, got %v, %v", ok, err)
+	}
+}
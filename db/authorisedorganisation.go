@@ -0,0 +1,264 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// AuthorisedOrganisationStore wraps the Organisation and User repositories, and only carries out operations
+// that the actor is allowed to by the Policy. Operations that the actor isn't allowed to carry out return a
+// *PermissionError. Changes are recorded in the audit log as being made by the actor.
+type AuthorisedOrganisationStore struct {
+	Users         UserRepository
+	Organisations OrganisationRepository
+	Authoriser    Authoriser
+}
+
+// NewAuthorisedOrganisationStore creates an AuthorisedOrganisationStore that applies the policy.
+func NewAuthorisedOrganisationStore(users UserRepository, organisations OrganisationRepository, policy Policy) AuthorisedOrganisationStore {
+	return AuthorisedOrganisationStore{
+		Users:         users,
+		Organisations: organisations,
+		Authoriser:    NewAuthoriser(organisations, policy),
+	}
+}
+
+// GetDetails retrieves all details of an Organisation.
+func (store AuthorisedOrganisationStore) GetDetails(ctx context.Context, actor, id string, opts ...DetailsOption) (org OrganisationDetails, err error) {
+	err = store.Authoriser.authorise(ctx, actor, PermissionOrganisationRead, OrganisationResource(id))
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.GetDetails: %w", err)
+		return
+	}
+	return store.Organisations.GetDetails(ctx, id, opts...)
+}
+
+// Rename an Organisation.
+func (store AuthorisedOrganisationStore) Rename(ctx context.Context, actor, id, name string, version int) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionOrganisationRename, OrganisationResource(id))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.Rename: %w", err)
+	}
+	return store.Organisations.Rename(WithActor(ctx, actor), id, name, version)
+}
+
+// Delete an Organisation and all of its records.
+func (store AuthorisedOrganisationStore) Delete(ctx context.Context, actor, id string, version int, progress func(DeleteProgress)) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionOrganisationDelete, OrganisationResource(id))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.Delete: %w", err)
+	}
+	return store.Organisations.Delete(WithActor(ctx, actor), id, version, progress)
+}
+
+// CreateService creates a new service.
+func (store AuthorisedOrganisationStore) CreateService(ctx context.Context, actor, id, serviceName string) (serviceID string, err error) {
+	err = store.Authoriser.authorise(ctx, actor, PermissionServiceCreate, OrganisationResource(id))
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.CreateService: %w", err)
+		return
+	}
+	return store.Organisations.CreateService(WithActor(ctx, actor), id, serviceName)
+}
+
+// PutService creates a new service (version zero), which requires permission to create services, or updates
+// an existing service's name.
+func (store AuthorisedOrganisationStore) PutService(ctx context.Context, actor, id, serviceID, serviceName string, version int) error {
+	action, resource := PermissionServiceUpdate, ServiceResource(id, serviceID)
+	if version == 0 {
+		action, resource = PermissionServiceCreate, OrganisationResource(id)
+	}
+	err := store.Authoriser.authorise(ctx, actor, action, resource)
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.PutService: %w", err)
+	}
+	return store.Organisations.PutService(WithActor(ctx, actor), id, serviceID, serviceName, version)
+}
+
+// DeleteService deletes a service from the Organisation.
+func (store AuthorisedOrganisationStore) DeleteService(ctx context.Context, actor, id, serviceID string) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionServiceDelete, ServiceResource(id, serviceID))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.DeleteService: %w", err)
+	}
+	return store.Organisations.DeleteService(WithActor(ctx, actor), id, serviceID)
+}
+
+// PutRole creates or updates one of the Organisation's roles.
+func (store AuthorisedOrganisationStore) PutRole(ctx context.Context, actor, id string, role Role) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionOrganisationRoles, OrganisationResource(id))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.PutRole: %w", err)
+	}
+	return store.Organisations.PutRole(WithActor(ctx, actor), id, role)
+}
+
+// DeleteRole deletes one of the Organisation's roles.
+func (store AuthorisedOrganisationStore) DeleteRole(ctx context.Context, actor, id string, scope RoleScope, name GroupName) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionOrganisationRoles, OrganisationResource(id))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.DeleteRole: %w", err)
+	}
+	return store.Organisations.DeleteRole(WithActor(ctx, actor), id, scope, name)
+}
+
+// CreateWebhook creates a new Webhook.
+func (store AuthorisedOrganisationStore) CreateWebhook(ctx context.Context, actor, id string, webhook Webhook) (webhookID string, err error) {
+	err = store.Authoriser.authorise(ctx, actor, PermissionOrganisationWebhooks, OrganisationResource(id))
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.CreateWebhook: %w", err)
+		return
+	}
+	return store.Organisations.CreateWebhook(WithActor(ctx, actor), id, webhook)
+}
+
+// PutWebhook creates or updates one of the Organisation's Webhooks.
+func (store AuthorisedOrganisationStore) PutWebhook(ctx context.Context, actor, id string, webhook Webhook) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionOrganisationWebhooks, OrganisationResource(id))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.PutWebhook: %w", err)
+	}
+	return store.Organisations.PutWebhook(WithActor(ctx, actor), id, webhook)
+}
+
+// DeleteWebhook deletes one of the Organisation's Webhooks.
+func (store AuthorisedOrganisationStore) DeleteWebhook(ctx context.Context, actor, id, webhookID string) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionOrganisationWebhooks, OrganisationResource(id))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.DeleteWebhook: %w", err)
+	}
+	return store.Organisations.DeleteWebhook(WithActor(ctx, actor), id, webhookID)
+}
+
+// ListWebhooks lists the Organisation's Webhooks. Their secrets are included, so listing them requires
+// permission to manage them.
+func (store AuthorisedOrganisationStore) ListWebhooks(ctx context.Context, actor, id string) (webhooks []Webhook, err error) {
+	err = store.Authoriser.authorise(ctx, actor, PermissionOrganisationWebhooks, OrganisationResource(id))
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.ListWebhooks: %w", err)
+		return
+	}
+	return store.Organisations.ListWebhooks(ctx, id)
+}
+
+// ListWebhookDeliveries lists the attempts to deliver Events to one of the Organisation's Webhooks.
+func (store AuthorisedOrganisationStore) ListWebhookDeliveries(ctx context.Context, actor, id, webhookID string) (deliveries []WebhookDelivery, err error) {
+	err = store.Authoriser.authorise(ctx, actor, PermissionOrganisationWebhooks, OrganisationResource(id))
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.ListWebhookDeliveries: %w", err)
+		return
+	}
+	return store.Organisations.ListWebhookDeliveries(ctx, id, webhookID)
+}
+
+// Invite a User to an Organisation on behalf of the actor. Inviting a User to the owner group also requires
+// permission to manage the Organisation's owners, and the actor must hold every permission of the groups.
+func (store AuthorisedOrganisationStore) Invite(ctx context.Context, actor string, u User, org Organisation, groups []string, serviceGroups map[string][]string) (token string, err error) {
+	err = store.authoriseGrant(ctx, actor, PermissionMemberInvite, org.ID, groups, serviceGroups)
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.Invite: %w", err)
+		return
+	}
+	return store.Users.Invite(WithActor(ctx, actor), actor, u, org, groups, serviceGroups)
+}
+
+// ListInvitations lists the pending invitations to the Organisation.
+func (store AuthorisedOrganisationStore) ListInvitations(ctx context.Context, actor, id string) (invitations []Invitation, err error) {
+	err = store.Authoriser.authorise(ctx, actor, PermissionMemberInvite, OrganisationResource(id))
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.ListInvitations: %w", err)
+		return
+	}
+	return store.Organisations.ListInvitations(ctx, id)
+}
+
+// ResendInvite replaces the token and expiry of a pending invitation, and returns the new token.
+func (store AuthorisedOrganisationStore) ResendInvite(ctx context.Context, actor, id, userID string) (token string, err error) {
+	err = store.Authoriser.authorise(ctx, actor, PermissionMemberInvite, OrganisationResource(id))
+	if err != nil {
+		err = fmt.Errorf("authorisedOrganisationStore.ResendInvite: %w", err)
+		return
+	}
+	return store.Organisations.ResendInvite(WithActor(ctx, actor), id, userID)
+}
+
+// RevokeInvite revokes a pending invitation so that it can't be accepted.
+func (store AuthorisedOrganisationStore) RevokeInvite(ctx context.Context, actor, id, userID string) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionMemberInvite, OrganisationResource(id))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.RevokeInvite: %w", err)
+	}
+	return store.Organisations.RevokeInvite(WithActor(ctx, actor), id, userID)
+}
+
+// AddUserToGroups adds a user to Organisation and Service Groups. Adding a User to the owner group also
+// requires permission to manage the Organisation's owners, and the actor must hold every permission of the
+// groups.
+func (store AuthorisedOrganisationStore) AddUserToGroups(ctx context.Context, actor, organisationID string, user User, groups []string, serviceIDToGroups map[string][]string, opts ...GroupOption) error {
+	err := store.authoriseGrant(ctx, actor, PermissionMemberUpdate, organisationID, groups, serviceIDToGroups)
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.AddUserToGroups: %w", err)
+	}
+	return store.Organisations.AddUserToGroups(WithActor(ctx, actor), organisationID, user, groups, serviceIDToGroups, opts...)
+}
+
+// RemoveUserFromGroups removes a user from Organisation and Service groups. Removing a User from the owner
+// group also requires permission to manage the Organisation's owners.
+func (store AuthorisedOrganisationStore) RemoveUserFromGroups(ctx context.Context, actor, organisationID, userID string, groups []string, serviceIDToGroups map[string][]string) error {
+	err := store.authoriseGroups(ctx, actor, PermissionMemberUpdate, organisationID, groups)
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.RemoveUserFromGroups: %w", err)
+	}
+	return store.Organisations.RemoveUserFromGroups(WithActor(ctx, actor), organisationID, userID, groups, serviceIDToGroups)
+}
+
+// RemoveUser from the Organisation.
+func (store AuthorisedOrganisationStore) RemoveUser(ctx context.Context, actor, organisationID, userID string) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionMemberRemove, OrganisationResource(organisationID))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.RemoveUser: %w", err)
+	}
+	return store.Organisations.RemoveUser(WithActor(ctx, actor), organisationID, userID)
+}
+
+// TransferOwnership transfers the actor's ownership of the Organisation to another User.
+func (store AuthorisedOrganisationStore) TransferOwnership(ctx context.Context, actor, organisationID string, to User) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionOrganisationOwners, OrganisationResource(organisationID))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.TransferOwnership: %w", err)
+	}
+	return store.Organisations.TransferOwnership(WithActor(ctx, actor), organisationID, actor, to)
+}
+
+// UpdateUserDetails updates a user's details within the Organisation.
+func (store AuthorisedOrganisationStore) UpdateUserDetails(ctx context.Context, actor, organisationID, userID, firstName, lastName, phone string, version int) error {
+	err := store.Authoriser.authorise(ctx, actor, PermissionMemberUpdate, OrganisationResource(organisationID))
+	if err != nil {
+		return fmt.Errorf("authorisedOrganisationStore.UpdateUserDetails: %w", err)
+	}
+	return store.Organisations.UpdateUserDetails(WithActor(ctx, actor), organisationID, userID, firstName, lastName, phone, version)
+}
+
+// authoriseGroups checks that the actor is allowed to carry out the action, and to manage the Organisation's
+// owners if the groups include the owner group.
+func (store AuthorisedOrganisationStore) authoriseGroups(ctx context.Context, actor string, action Permission, organisationID string, groups []string) error {
+	err := store.Authoriser.authorise(ctx, actor, action, OrganisationResource(organisationID))
+	if err != nil {
+		return err
+	}
+	if containsString(groups, GroupOwner) {
+		return store.Authoriser.authorise(ctx, actor, PermissionOrganisationOwners, OrganisationResource(organisationID))
+	}
+	return nil
+}
+
+// authoriseGrant checks that the actor is allowed to add Users to the groups with the action, and holds every
+// permission that the groups grant, including those of the groups that contain them. Otherwise, anyone allowed
+// to update members could add themselves to a role with more permissions than their own.
+func (store AuthorisedOrganisationStore) authoriseGrant(ctx context.Context, actor string, action Permission, organisationID string, groups []string, serviceIDToGroups map[string][]string) error {
+	err := store.authoriseGroups(ctx, actor, action, organisationID, groups)
+	if err != nil {
+		return err
+	}
+	return store.Authoriser.authoriseGrant(ctx, actor, organisationID, groups, serviceIDToGroups)
+}
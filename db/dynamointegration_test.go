@@ -64,3 +64,51 @@ func deleteLocalTable(t *testing.T, name string) {
 		t.Fatalf("failed to delete table: %v", err)
 	}
 }
+
+func TestDynamoDBRepositoriesIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test")
+	}
+	testRepositories(t, func(t *testing.T) (r repositories, cleanup func()) {
+		name := createLocalTable(t)
+		cleanup = func() { deleteLocalTable(t, name) }
+		us, err := NewUserStore(region, name, WithEndpoint("http://localhost:8000"))
+		if err != nil {
+			t.Fatalf("failed to create user store: %v", err)
+		}
+		orgs, err := NewOrganisationStore(region, name, WithClient(us.Client))
+		if err != nil {
+			t.Fatalf("failed to create organisation store: %v", err)
+		}
+		r.publisher = NewInProcessPublisher()
+		us.Publisher = r.publisher
+		orgs.Publisher = r.publisher
+		r.users = us
+		r.organisations = orgs
+		r.deleteRecord = func(id, rng string) error {
+			_, err := us.Client.DeleteItem(&dynamodb.DeleteItemInput{
+				TableName: aws.String(name),
+				Key:       idAndRng(id, rng),
+			})
+			return err
+		}
+		r.putRecord = func(item map[string]*dynamodb.AttributeValue) error {
+			_, err := us.Client.PutItem(&dynamodb.PutItemInput{
+				TableName: aws.String(name),
+				Item:      item,
+			})
+			return err
+		}
+		r.records = func() (items []map[string]*dynamodb.AttributeValue, err error) {
+			err = us.Client.ScanPages(&dynamodb.ScanInput{
+				TableName:      aws.String(name),
+				ConsistentRead: aws.Bool(true),
+			}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+				items = append(items, page.Items...)
+				return true
+			})
+			return
+		}
+		return
+	})
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// EffectiveGroups are the groups that a User belongs to within an Organisation, either directly, or because
+// they belong to one of the group's subgroups.
+type EffectiveGroups struct {
+	Organisation []GroupName
+	// Services maps Service IDs to the User's groups within the Service.
+	Services map[string][]GroupName
+}
+
+// strings returns the groups in the form stored in the groupSet.
+func (eg EffectiveGroups) strings() (groups []string, serviceIDToGroups map[string][]string) {
+	for _, g := range eg.Organisation {
+		groups = append(groups, string(g))
+	}
+	serviceIDToGroups = make(map[string][]string, len(eg.Services))
+	for serviceID, sgs := range eg.Services {
+		for _, g := range sgs {
+			serviceIDToGroups[serviceID] = append(serviceIDToGroups[serviceID], string(g))
+		}
+	}
+	return
+}
+
+// resolveGroups computes the effective groups of a User that belongs to the groups directly.
+func resolveGroups(roles []Role, groups []string, serviceIDToGroups map[string][]string) (eg EffectiveGroups) {
+	parents := make(map[RoleScope]map[GroupName][]GroupName)
+	for _, r := range roles {
+		if parents[r.Scope] == nil {
+			parents[r.Scope] = make(map[GroupName][]GroupName)
+		}
+		for _, sg := range r.Subgroups {
+			parents[r.Scope][sg] = append(parents[r.Scope][sg], r.Name)
+		}
+	}
+	eg.Organisation = closure(parents[RoleScopeOrganisation], groups)
+	for serviceID, sgs := range serviceIDToGroups {
+		if eg.Services == nil {
+			eg.Services = make(map[string][]GroupName)
+		}
+		eg.Services[serviceID] = closure(parents[RoleScopeService], sgs)
+	}
+	return
+}
+
+// closure returns the groups, and every group that contains them, sorted by name.
+func closure(parents map[GroupName][]GroupName, groups []string) (result []GroupName) {
+	seen := make(map[GroupName]bool)
+	queue := make([]GroupName, len(groups))
+	for i, g := range groups {
+		queue[i] = GroupName(g)
+	}
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if seen[g] {
+			continue
+		}
+		seen[g] = true
+		result = append(result, g)
+		queue = append(queue, parents[g]...)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return
+}
+
+// newSubgroupDescendants returns the roles that are nested within the role, directly or indirectly, once the
+// role has been updated. ErrRoleNotFound is returned if a subgroup isn't defined by a role of the same scope,
+// and ErrGroupCycle if the role would contain itself.
+func newSubgroupDescendants(roles []Role, role Role) (descendants []Role, err error) {
+	byName := make(map[GroupName]Role)
+	for _, r := range roles {
+		if r.Scope == role.Scope {
+			byName[r.Name] = r
+		}
+	}
+	byName[role.Name] = role
+	seen := make(map[GroupName]bool)
+	queue := append([]GroupName(nil), role.Subgroups...)
+	for len(queue) > 0 {
+		g := queue[0]
+		queue = queue[1:]
+		if g == role.Name {
+			err = fmt.Errorf("group %q would contain itself: %w", role.Name, ErrGroupCycle)
+			return
+		}
+		if seen[g] {
+			continue
+		}
+		seen[g] = true
+		r, ok := byName[g]
+		if !ok {
+			err = fmt.Errorf("subgroup %q: %w", g, ErrRoleNotFound)
+			return
+		}
+		descendants = append(descendants, r)
+		queue = append(queue, r.Subgroups...)
+	}
+	return
+}
+
+// getEffectiveGroups reads a User's groups and the Organisation's roles, and resolves the User's effective
+// groups. ErrNotMember is returned if the User isn't a member of the Organisation.
+func getEffectiveGroups(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID, userID string, now time.Time) (eg EffectiveGroups, err error) {
+	groups, serviceIDToGroups, err := getUserGroups(ctx, client, tableName, organisationID, userID, now)
+	if err != nil {
+		return
+	}
+	roles, err := getRoles(ctx, client, tableName, organisationID)
+	if err != nil {
+		return
+	}
+	return resolveGroups(roles, groups, serviceIDToGroups), nil
+}
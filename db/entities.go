@@ -12,6 +12,7 @@ func newUserFromRecord(ur userRecord) User {
 		LastName:  ur.LastName,
 		Phone:     ur.Phone,
 		CreatedAt: ur.CreatedAt,
+		Version:   ur.Version,
 	}
 }
 
@@ -33,6 +34,9 @@ type User struct {
 	LastName  string
 	Phone     string
 	CreatedAt time.Time
+	// Version of the record, used to detect concurrent updates. Within OrganisationDetails, this
+	// is the version of the User's membership of the Organisation.
+	Version int
 }
 
 // UserDetails provides all the details of a User.
@@ -42,9 +46,31 @@ type UserDetails struct {
 	Invitations   []Invitation
 }
 
+// ErasureReport lists the records deleted when a User is erased.
+type ErasureReport struct {
+	UserID string
+	// OrganisationIDs that the User's membership or invitation was removed from.
+	OrganisationIDs []string
+	// Deleted records, in the order that they were deleted.
+	Deleted []RecordKey
+}
+
+// RecordKey is the key of a record in the table.
+type RecordKey struct {
+	ID    string
+	Range string
+}
+
 func newInvitationFromRecord(uor userOrganisationRecord) Invitation {
 	org := newOrganisation(uor.OrganisationID, uor.OrganisationName)
-	return newInvitation(org, uor.InvitedAt, uor.AcceptedAt)
+	invitation := newInvitation(org, uor.InvitedAt, uor.AcceptedAt)
+	invitation.UserID = uor.Email
+	invitation.InvitedBy = uor.InvitedBy
+	if uor.ExpiresAt != 0 {
+		invitation.ExpiresAt = time.Unix(uor.ExpiresAt, 0).UTC()
+	}
+	invitation.RevokedAt = uor.RevokedAt
+	return invitation
 }
 
 func newInvitation(org Organisation, invitedAt time.Time, acceptedAt *time.Time) Invitation {
@@ -55,11 +81,20 @@ func newInvitation(org Organisation, invitedAt time.Time, acceptedAt *time.Time)
 	}
 }
 
-// An Invitation for a user to join an Organisation.
+// Invitation to join an Organisation.
 type Invitation struct {
 	Organisation Organisation
-	InvitedAt    time.Time
-	AcceptedAt   *time.Time
+	// UserID of the invited User.
+	UserID string
+	// InvitedBy is the ID of the User that sent the invitation.
+	InvitedBy  string
+	InvitedAt  time.Time
+	AcceptedAt *time.Time
+	// ExpiresAt is the time after which the invitation can no longer be accepted. It's zero for Users that
+	// created the Organisation.
+	ExpiresAt time.Time
+	// RevokedAt is set if an administrator has revoked the invitation.
+	RevokedAt *time.Time
 }
 
 func newOrganisation(id, name string) Organisation {
@@ -67,13 +102,18 @@ func newOrganisation(id, name string) Organisation {
 }
 
 func newOrganisationFromRecord(or organisationRecord) Organisation {
-	return newOrganisation(or.OrganisationID, or.OrganisationName)
+	org := newOrganisation(or.OrganisationID, or.OrganisationName)
+	org.Version = or.Version
+	return org
 }
 
 // An Organisation that can be joined.
 type Organisation struct {
 	ID   string
 	Name string
+	// Version of the record, used to detect concurrent updates. It's zero when the Organisation is
+	// part of UserDetails.
+	Version int
 }
 
 func newOrganisationDetails(org Organisation, groups map[GroupName][]User, services []Service) OrganisationDetails {
@@ -81,14 +121,97 @@ func newOrganisationDetails(org Organisation, groups map[GroupName][]User, servi
 		Organisation: org,
 		Groups:       groups,
 		Services:     services,
+		Roles:        append([]Role(nil), DefaultRoles...),
 	}
 }
 
 // OrganisationDetails provides all the details of an Organisation.
 type OrganisationDetails struct {
 	Organisation
+	// Groups lists the members of each group. Invited Users are only included when the IncludePending option
+	// is used.
 	Groups   map[GroupName][]User
 	Services []Service
+	// Invitees have been invited to the Organisation, but haven't accepted yet.
+	Invitees []Invitee
+	// Roles define the groups that members can belong to, starting with the DefaultRoles.
+	Roles []Role
+	// Grants are the temporary group memberships that haven't expired. Their Users are included in Groups
+	// until they expire.
+	Grants []Grant
+}
+
+// A Grant is a temporary membership of an Organisation or Service group.
+type Grant struct {
+	UserID string
+	Group  GroupName
+	// ServiceID is empty if the group is an Organisation group.
+	ServiceID string
+	ExpiresAt time.Time
+}
+
+// A Webhook notifies a URL of an Organisation's Events.
+type Webhook struct {
+	ID  string
+	URL string
+	// Secret is used to sign each delivery, so that the receiver can check that it came from us.
+	Secret string
+	// Events are the types of Event that are delivered. If empty, every Event is delivered.
+	Events []EventType
+	// Version of the record, used to detect concurrent updates.
+	Version int
+}
+
+// Accepts returns true if Events of the type are delivered to the Webhook.
+func (w Webhook) Accepts(t EventType) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, et := range w.Events {
+		if et == t {
+			return true
+		}
+	}
+	return false
+}
+
+// A WebhookDelivery is an attempt to deliver an Event to a Webhook.
+type WebhookDelivery struct {
+	WebhookID string
+	EventID   string
+	EventType EventType
+	// Attempt is the number of the attempt, starting from one.
+	Attempt int
+	At      time.Time
+	// StatusCode is the HTTP status code of the response, or zero if there was no response.
+	StatusCode int
+	// Error explains why the attempt failed.
+	Error     string
+	Delivered bool
+}
+
+// A Role defines a group that Users can be added to, and the permissions that it grants.
+type Role struct {
+	Name        GroupName
+	Description string
+	Permissions []Permission
+	Scope       RoleScope
+	// Subgroups are groups of the same scope whose members are also members of this group.
+	Subgroups []GroupName
+	// Version of the record, used to detect concurrent updates. The DefaultRoles have version zero.
+	Version int
+}
+
+// An Invitee has been invited to an Organisation, but hasn't accepted yet.
+type Invitee struct {
+	User
+	InvitedBy string
+	InvitedAt time.Time
+	ExpiresAt time.Time
+	// Groups that the User will join when they accept.
+	Groups []GroupName
+	// ServiceGroups that the User will join when they accept, by Service ID.
+	ServiceGroups map[string][]GroupName
 }
 
 type GroupName string
@@ -98,6 +221,8 @@ type Service struct {
 	ID     string
 	Name   string
 	Groups map[GroupName][]User
+	// Version of the record, used to detect concurrent updates.
+	Version int
 }
 
 const (
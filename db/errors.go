@@ -0,0 +1,181 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	// ErrUserNotFound is returned when a User does not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrOrganisationNotFound is returned when an Organisation does not exist.
+	ErrOrganisationNotFound = errors.New("organisation not found")
+	// ErrServiceNotFound is returned when a Service does not exist within an Organisation.
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrInvitationNotFound is returned when a User has not been invited to an Organisation.
+	ErrInvitationNotFound = errors.New("invitation not found")
+	// ErrInvitationAlreadyAccepted is returned when an invitation has already been accepted.
+	ErrInvitationAlreadyAccepted = errors.New("invitation already accepted")
+	// ErrInvitationExpired is returned when accepting an invitation after it has expired.
+	ErrInvitationExpired = errors.New("invitation expired")
+	// ErrInvitationRevoked is returned when an invitation has been revoked by an administrator.
+	ErrInvitationRevoked = errors.New("invitation revoked")
+	// ErrInvalidInvitationToken is returned when accepting an invitation with the wrong token.
+	ErrInvalidInvitationToken = errors.New("invalid invitation token")
+	// ErrAlreadyExists is returned when creating a record that already exists.
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrVersionConflict is returned when a record has been modified since the caller read it.
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrLastOwner is returned when an operation would leave an Organisation without an owner.
+	ErrLastOwner = errors.New("last owner of organisation")
+	// ErrNotMember is returned when a User doesn't belong to an Organisation.
+	ErrNotMember = errors.New("not a member of organisation")
+	// ErrNotOwner is returned when a User is not an owner of an Organisation.
+	ErrNotOwner = errors.New("not an owner of organisation")
+	// ErrRoleNotFound is returned when a group isn't defined by one of the Organisation's roles.
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrInvalidRole is returned when a role's name or scope is invalid.
+	ErrInvalidRole = errors.New("invalid role")
+	// ErrBuiltInRole is returned when attempting to change one of the DefaultRoles.
+	ErrBuiltInRole = errors.New("built-in roles can't be changed")
+	// ErrInvalidGrant is returned when a temporary group membership can't be granted, e.g. because it has
+	// already expired.
+	ErrInvalidGrant = errors.New("invalid grant")
+	// ErrGroupCycle is returned when a group would contain itself through its subgroups.
+	ErrGroupCycle = errors.New("group contains itself")
+	// ErrWebhookNotFound is returned when a Webhook does not exist within an Organisation.
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrInvalidWebhook is returned when a Webhook's URL, secret or events are invalid.
+	ErrInvalidWebhook = errors.New("invalid webhook")
+	// ErrPermissionDenied is returned when a User isn't allowed to carry out an action.
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// ProfileSyncError is returned when a User's profile has been updated, but the changes couldn't be copied to
+// all of the Organisations that the User belongs to. Calling SyncProfile completes the update.
+type ProfileSyncError struct {
+	UserID string
+	// OrganisationIDs that have not been updated.
+	OrganisationIDs []string
+	Err             error
+}
+
+func (e *ProfileSyncError) Error() string {
+	return fmt.Sprintf("failed to copy profile of user %q to organisations %v: %v", e.UserID, e.OrganisationIDs, e.Err)
+}
+
+func (e *ProfileSyncError) Unwrap() error {
+	return e.Err
+}
+
+// OrganisationNameSyncError is returned when an Organisation has been renamed, but the new name couldn't be
+// copied to all of the Organisation's members and invitees. Calling SyncOrganisationName completes the rename.
+type OrganisationNameSyncError struct {
+	OrganisationID string
+	// UserIDs that have not been updated.
+	UserIDs []string
+	Err     error
+}
+
+func (e *OrganisationNameSyncError) Error() string {
+	return fmt.Sprintf("failed to copy name of organisation %q to users %v: %v", e.OrganisationID, e.UserIDs, e.Err)
+}
+
+func (e *OrganisationNameSyncError) Unwrap() error {
+	return e.Err
+}
+
+// ServiceCleanupError is returned when a Service has been deleted, but its group assignments couldn't be removed
+// from all of the Organisation's members. Calling CleanupService completes the removal.
+type ServiceCleanupError struct {
+	OrganisationID string
+	ServiceID      string
+	// UserIDs that still have assignments to the Service.
+	UserIDs []string
+	Err     error
+}
+
+func (e *ServiceCleanupError) Error() string {
+	return fmt.Sprintf("failed to remove assignments to service %q of organisation %q from users %v: %v", e.ServiceID, e.OrganisationID, e.UserIDs, e.Err)
+}
+
+func (e *ServiceCleanupError) Unwrap() error {
+	return e.Err
+}
+
+// LastOwnerError is returned when a User can't be removed from an Organisation's owners, or erased, because they
+// are the last owner of one or more Organisations. Ownership must be transferred first. It unwraps to
+// ErrLastOwner.
+type LastOwnerError struct {
+	UserID string
+	// OrganisationIDs that the User is the last owner of.
+	OrganisationIDs []string
+}
+
+func (e *LastOwnerError) Error() string {
+	return fmt.Sprintf("user %q is the last owner of organisations %v", e.UserID, e.OrganisationIDs)
+}
+
+func (e *LastOwnerError) Unwrap() error {
+	return ErrLastOwner
+}
+
+// PermissionError is returned when an actor isn't allowed to carry out an action on a resource. It unwraps to
+// ErrPermissionDenied.
+type PermissionError struct {
+	Actor    string
+	Action   Permission
+	Resource Resource
+}
+
+func (e *PermissionError) Error() string {
+	if e.Resource.ServiceID != "" {
+		return fmt.Sprintf("user %q is not allowed to %s service %q of organisation %q", e.Actor, e.Action, e.Resource.ServiceID, e.Resource.OrganisationID)
+	}
+	return fmt.Sprintf("user %q is not allowed to %s organisation %q", e.Actor, e.Action, e.Resource.OrganisationID)
+}
+
+func (e *PermissionError) Unwrap() error {
+	return ErrPermissionDenied
+}
+
+// isConditionalCheckFailed returns true if the error was caused by the condition expression of a single
+// item operation not being met.
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// failedTransactionConditions returns the index of each item in a TransactWriteItems call whose condition
+// expression was not met. If the error was not caused by a failed condition, it returns nil.
+func failedTransactionConditions(err error) (indices []int) {
+	var tce *dynamodb.TransactionCanceledException
+	if !errors.As(err, &tce) {
+		return
+	}
+	for i, reason := range tce.CancellationReasons {
+		if reason != nil && aws.StringValue(reason.Code) == "ConditionalCheckFailed" {
+			indices = append(indices, i)
+		}
+	}
+	return
+}
+
+// failedTransactionCondition returns true if the condition of the item at index i of a TransactWriteItems call
+// was not met. If the item was sent with ReturnValuesOnConditionCheckFailure set to ALL_OLD, the existing item
+// is also returned, and is empty if the item didn't exist.
+func failedTransactionCondition(err error, i int) (failed bool, item map[string]*dynamodb.AttributeValue) {
+	var tce *dynamodb.TransactionCanceledException
+	if !errors.As(err, &tce) || i >= len(tce.CancellationReasons) {
+		return
+	}
+	reason := tce.CancellationReasons[i]
+	if reason == nil || aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+		return
+	}
+	return true, reason.Item
+}
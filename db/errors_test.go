@@ -0,0 +1,66 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestFailedTransactionConditions(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected []int
+	}{
+		{
+			name: "nil error",
+		},
+		{
+			name: "other error",
+			err:  errors.New("other"),
+		},
+		{
+			name: "conditions failed",
+			err: fmt.Errorf("wrapped: %w", &dynamodb.TransactionCanceledException{
+				CancellationReasons: []*dynamodb.CancellationReason{
+					{Code: aws.String("None")},
+					{Code: aws.String("ConditionalCheckFailed")},
+					{Code: aws.String("ConditionalCheckFailed")},
+				},
+			}),
+			expected: []int{1, 2},
+		},
+		{
+			name: "cancelled for another reason",
+			err: &dynamodb.TransactionCanceledException{
+				CancellationReasons: []*dynamodb.CancellationReason{
+					{Code: aws.String("TransactionConflict")},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			actual := failedTransactionConditions(tt.err)
+			if fmt.Sprint(actual) != fmt.Sprint(tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIsConditionalCheckFailed(t *testing.T) {
+	if !isConditionalCheckFailed(&dynamodb.ConditionalCheckFailedException{}) {
+		t.Error("expected ConditionalCheckFailedException to be detected")
+	}
+	if isConditionalCheckFailed(errors.New("other")) {
+		t.Error("expected other errors not to be detected")
+	}
+	if isConditionalCheckFailed(nil) {
+		t.Error("expected nil not to be detected")
+	}
+}
@@ -0,0 +1,242 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An EventType names a kind of Event.
+type EventType string
+
+const (
+	// EventTypeOrganisationCreated is the type of OrganisationCreated events.
+	EventTypeOrganisationCreated EventType = "OrganisationCreated"
+	// EventTypeServiceCreated is the type of ServiceCreated events.
+	EventTypeServiceCreated EventType = "ServiceCreated"
+	// EventTypeServiceDeleted is the type of ServiceDeleted events.
+	EventTypeServiceDeleted EventType = "ServiceDeleted"
+	// EventTypeMemberInvited is the type of MemberInvited events.
+	EventTypeMemberInvited EventType = "MemberInvited"
+	// EventTypeInvitationAccepted is the type of InvitationAccepted events.
+	EventTypeInvitationAccepted EventType = "InvitationAccepted"
+	// EventTypeInvitationRejected is the type of InvitationRejected events.
+	EventTypeInvitationRejected EventType = "InvitationRejected"
+	// EventTypeInvitationRevoked is the type of InvitationRevoked events.
+	EventTypeInvitationRevoked EventType = "InvitationRevoked"
+	// EventTypeMemberRemoved is the type of MemberRemoved events.
+	EventTypeMemberRemoved EventType = "MemberRemoved"
+	// EventTypeGroupsChanged is the type of GroupsChanged events.
+	EventTypeGroupsChanged EventType = "GroupsChanged"
+)
+
+// isEventType returns true if t is the type of one of the Events.
+func isEventType(t EventType) bool {
+	switch t {
+	case EventTypeOrganisationCreated, EventTypeServiceCreated, EventTypeServiceDeleted, EventTypeMemberInvited,
+		EventTypeInvitationAccepted, EventTypeInvitationRejected, EventTypeInvitationRevoked, EventTypeMemberRemoved,
+		EventTypeGroupsChanged:
+		return true
+	}
+	return false
+}
+
+// An Event is a change to an Organisation that downstream systems can react to. The stores publish events
+// through their Publisher. Consumers use a type switch to handle the events they're interested in, e.g.
+// case MemberInvited.
+type Event interface {
+	Type() EventType
+	Metadata() EventMetadata
+}
+
+// EventMetadata is common to every Event.
+type EventMetadata struct {
+	// ID is unique to each event. Events are published at least once, so consumers can use the ID to ignore
+	// events that they've already handled.
+	ID             string
+	OrganisationID string
+	At             time.Time
+}
+
+// Metadata returns the EventMetadata.
+func (em EventMetadata) Metadata() EventMetadata {
+	return em
+}
+
+func newEventMetadata(organisationID string, now time.Time) EventMetadata {
+	return EventMetadata{
+		ID:             uuid.New().String(),
+		OrganisationID: organisationID,
+		At:             now,
+	}
+}
+
+// OrganisationCreated is published when an Organisation is created.
+type OrganisationCreated struct {
+	EventMetadata
+	Name    string
+	OwnerID string
+}
+
+// Type returns EventTypeOrganisationCreated.
+func (OrganisationCreated) Type() EventType {
+	return EventTypeOrganisationCreated
+}
+
+// ServiceCreated is published when a Service is created.
+type ServiceCreated struct {
+	EventMetadata
+	ServiceID string
+	Name      string
+}
+
+// Type returns EventTypeServiceCreated.
+func (ServiceCreated) Type() EventType {
+	return EventTypeServiceCreated
+}
+
+// ServiceDeleted is published when a Service is deleted.
+type ServiceDeleted struct {
+	EventMetadata
+	ServiceID string
+}
+
+// Type returns EventTypeServiceDeleted.
+func (ServiceDeleted) Type() EventType {
+	return EventTypeServiceDeleted
+}
+
+// MemberInvited is published when a User is invited to an Organisation, including when an invitation is
+// replaced by inviting the User again.
+type MemberInvited struct {
+	EventMetadata
+	UserID        string
+	InvitedBy     string
+	Groups        []string
+	ServiceGroups map[string][]string
+}
+
+// Type returns EventTypeMemberInvited.
+func (MemberInvited) Type() EventType {
+	return EventTypeMemberInvited
+}
+
+// InvitationAccepted is published when a User accepts an invitation, and joins the Organisation.
+type InvitationAccepted struct {
+	EventMetadata
+	UserID string
+}
+
+// Type returns EventTypeInvitationAccepted.
+func (InvitationAccepted) Type() EventType {
+	return EventTypeInvitationAccepted
+}
+
+// InvitationRejected is published when a User rejects an invitation.
+type InvitationRejected struct {
+	EventMetadata
+	UserID string
+}
+
+// Type returns EventTypeInvitationRejected.
+func (InvitationRejected) Type() EventType {
+	return EventTypeInvitationRejected
+}
+
+// InvitationRevoked is published when a pending invitation is revoked, so that it can no longer be accepted.
+type InvitationRevoked struct {
+	EventMetadata
+	UserID string
+}
+
+// Type returns EventTypeInvitationRevoked.
+func (InvitationRevoked) Type() EventType {
+	return EventTypeInvitationRevoked
+}
+
+// MemberRemoved is published when a User is removed from, or leaves, an Organisation, including when the User
+// is erased.
+type MemberRemoved struct {
+	EventMetadata
+	UserID string
+}
+
+// Type returns EventTypeMemberRemoved.
+func (MemberRemoved) Type() EventType {
+	return EventTypeMemberRemoved
+}
+
+// GroupsChanged is published when a User is added to or removed from Organisation or Service groups.
+type GroupsChanged struct {
+	EventMetadata
+	UserID               string
+	AddedGroups          []string            `json:",omitempty"`
+	AddedServiceGroups   map[string][]string `json:",omitempty"`
+	RemovedGroups        []string            `json:",omitempty"`
+	RemovedServiceGroups map[string][]string `json:",omitempty"`
+	// ExpiresAt is set when the groups were added temporarily.
+	ExpiresAt *time.Time `json:",omitempty"`
+}
+
+// Type returns EventTypeGroupsChanged.
+func (GroupsChanged) Type() EventType {
+	return EventTypeGroupsChanged
+}
+
+// A Publisher publishes Events to downstream systems. Events are written to an outbox in the same transaction
+// as the change that caused them, and published once the change has been made. If publishing fails, the events
+// stay in the outbox until PublishOutbox is called, so a Publisher may be given the same event more than once.
+// Later events for the same Organisation stay in the outbox with them, so that each Organisation's events are
+// published in order, but events from concurrent changes may be published in either order.
+type Publisher interface {
+	Publish(ctx context.Context, events ...Event) error
+}
+
+// PublisherFunc adapts a function to a Publisher.
+type PublisherFunc func(ctx context.Context, events ...Event) error
+
+// Publish calls f.
+func (f PublisherFunc) Publish(ctx context.Context, events ...Event) error {
+	return f(ctx, events...)
+}
+
+// An EventHandler handles Events published by an InProcessPublisher.
+type EventHandler func(ctx context.Context, e Event) error
+
+// NewInProcessPublisher creates an InProcessPublisher with no subscribers.
+func NewInProcessPublisher() *InProcessPublisher {
+	return &InProcessPublisher{}
+}
+
+// InProcessPublisher publishes Events to handlers in the same process. Handlers are called in turn, in the
+// order they subscribed. If a handler returns an error, publishing stops, and the events are published again
+// to every handler by PublishOutbox.
+type InProcessPublisher struct {
+	m        sync.RWMutex
+	handlers []EventHandler
+}
+
+// Subscribe calls the handler with each Event that's published.
+func (p *InProcessPublisher) Subscribe(handler EventHandler) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.handlers = append(p.handlers, handler)
+}
+
+// Publish calls each handler with each of the events.
+func (p *InProcessPublisher) Publish(ctx context.Context, events ...Event) error {
+	p.m.RLock()
+	handlers := p.handlers
+	p.m.RUnlock()
+	for _, e := range events {
+		for _, h := range handlers {
+			if err := h(ctx, e); err != nil {
+				return fmt.Errorf("inProcessPublisher.Publish: failed to handle %s event %q: %w", e.Type(), e.Metadata().ID, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// ignoreEventMetadata ignores the generated parts of each Event.
+var ignoreEventMetadata = cmp.Options{
+	cmpopts.IgnoreFields(EventMetadata{}, "ID", "At"),
+	cmpopts.EquateEmpty(),
+}
+
+// eventRecorder records the events published to it.
+type eventRecorder struct {
+	m      sync.Mutex
+	events []Event
+	// fail returns an error instead of recording events of the type.
+	fail map[EventType]bool
+}
+
+func (er *eventRecorder) handle(ctx context.Context, e Event) error {
+	er.m.Lock()
+	defer er.m.Unlock()
+	if er.fail[e.Type()] {
+		return errors.New("unavailable")
+	}
+	er.events = append(er.events, e)
+	return nil
+}
+
+func (er *eventRecorder) setFail(types ...EventType) {
+	er.m.Lock()
+	defer er.m.Unlock()
+	er.fail = make(map[EventType]bool)
+	for _, t := range types {
+		er.fail[t] = true
+	}
+}
+
+func (er *eventRecorder) take() (events []Event) {
+	er.m.Lock()
+	defer er.m.Unlock()
+	events, er.events = er.events, nil
+	return
+}
+
+func testOrganisationEvents(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	var recorder eventRecorder
+	r.publisher.Subscribe(recorder.handle)
+
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+	err = s.PutService(ctx, organisationID, "service", "Service Name", 0)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	// Renaming the service isn't published.
+	err = s.PutService(ctx, organisationID, "service", "New Service Name", 1)
+	if err != nil {
+		t.Fatalf("failed to rename service: %v", err)
+	}
+	org, err := s.Get(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	invitee := newUser("invitee@example.com", "Invit", "Ee", "447901234567", createdAt)
+	token, err := r.users.Invite(ctx, owner.ID, invitee, org, []string{GroupMember}, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, invitee, org, token)
+	if err != nil {
+		t.Fatalf("failed to accept invite: %v", err)
+	}
+	err = s.AddUserToServiceGroups(ctx, organisationID, invitee, "service", ServiceGroupDeployer)
+	if err != nil {
+		t.Fatalf("failed to add user to groups: %v", err)
+	}
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	err = s.AddUserToGroups(ctx, organisationID, invitee, []string{GroupMember}, nil, Until(expiresAt))
+	if err != nil {
+		t.Fatalf("failed to grant groups: %v", err)
+	}
+	err = s.RemoveUserFromServiceGroups(ctx, organisationID, invitee.ID, "service", ServiceGroupDeployer)
+	if err != nil {
+		t.Fatalf("failed to remove user from groups: %v", err)
+	}
+	err = s.RemoveUser(ctx, organisationID, invitee.ID)
+	if err != nil {
+		t.Fatalf("failed to remove user: %v", err)
+	}
+	err = s.DeleteService(ctx, organisationID, "service")
+	if err != nil {
+		t.Fatalf("failed to delete service: %v", err)
+	}
+
+	// Members that leave are also removed.
+	leaver := newUser("leaver@example.com", "Lea", "Ver", "447901234567", createdAt)
+	token, err = r.users.Invite(ctx, owner.ID, leaver, org, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, leaver, org, token)
+	if err != nil {
+		t.Fatalf("failed to accept invite: %v", err)
+	}
+	err = r.users.LeaveOrganisation(ctx, leaver.ID, organisationID)
+	if err != nil {
+		t.Fatalf("failed to leave organisation: %v", err)
+	}
+
+	// Invitations that are revoked or rejected.
+	revokee := newUser("revokee@example.com", "Revo", "Kee", "447901234567", createdAt)
+	_, err = r.users.Invite(ctx, owner.ID, revokee, org, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+	err = s.RevokeInvite(ctx, organisationID, revokee.ID)
+	if err != nil {
+		t.Fatalf("failed to revoke invite: %v", err)
+	}
+	rejecter := newUser("rejecter@example.com", "Rejec", "Ter", "447901234567", createdAt)
+	_, err = r.users.Invite(ctx, owner.ID, rejecter, org, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+	err = r.users.RejectInvite(ctx, rejecter, org)
+	if err != nil {
+		t.Fatalf("failed to reject invite: %v", err)
+	}
+
+	// Transferring ownership changes the groups of both users, and the previous owner can then be erased.
+	successor := newUser("successor@example.com", "Succ", "Essor", "447901234567", createdAt)
+	token, err = r.users.Invite(ctx, owner.ID, successor, org, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, successor, org, token)
+	if err != nil {
+		t.Fatalf("failed to accept invite: %v", err)
+	}
+	err = s.TransferOwnership(ctx, organisationID, owner.ID, successor)
+	if err != nil {
+		t.Fatalf("failed to transfer ownership: %v", err)
+	}
+	_, err = r.users.Delete(ctx, owner.ID)
+	if err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	metadata := EventMetadata{OrganisationID: organisationID}
+	expected := []Event{
+		OrganisationCreated{EventMetadata: metadata, Name: "Organisation Name", OwnerID: owner.ID},
+		ServiceCreated{EventMetadata: metadata, ServiceID: "service", Name: "Service Name"},
+		MemberInvited{EventMetadata: metadata, UserID: invitee.ID, InvitedBy: owner.ID, Groups: []string{GroupMember}},
+		InvitationAccepted{EventMetadata: metadata, UserID: invitee.ID},
+		GroupsChanged{EventMetadata: metadata, UserID: invitee.ID, AddedServiceGroups: map[string][]string{"service": {ServiceGroupDeployer}}},
+		GroupsChanged{EventMetadata: metadata, UserID: invitee.ID, AddedGroups: []string{GroupMember}, ExpiresAt: &expiresAt},
+		GroupsChanged{EventMetadata: metadata, UserID: invitee.ID, RemovedServiceGroups: map[string][]string{"service": {ServiceGroupDeployer}}},
+		MemberRemoved{EventMetadata: metadata, UserID: invitee.ID},
+		ServiceDeleted{EventMetadata: metadata, ServiceID: "service"},
+		MemberInvited{EventMetadata: metadata, UserID: leaver.ID, InvitedBy: owner.ID},
+		InvitationAccepted{EventMetadata: metadata, UserID: leaver.ID},
+		MemberRemoved{EventMetadata: metadata, UserID: leaver.ID},
+		MemberInvited{EventMetadata: metadata, UserID: revokee.ID, InvitedBy: owner.ID},
+		InvitationRevoked{EventMetadata: metadata, UserID: revokee.ID},
+		MemberInvited{EventMetadata: metadata, UserID: rejecter.ID, InvitedBy: owner.ID},
+		InvitationRejected{EventMetadata: metadata, UserID: rejecter.ID},
+		MemberInvited{EventMetadata: metadata, UserID: successor.ID, InvitedBy: owner.ID},
+		InvitationAccepted{EventMetadata: metadata, UserID: successor.ID},
+		GroupsChanged{EventMetadata: metadata, UserID: owner.ID, RemovedGroups: []string{GroupOwner}},
+		GroupsChanged{EventMetadata: metadata, UserID: successor.ID, AddedGroups: []string{GroupOwner}},
+		MemberRemoved{EventMetadata: metadata, UserID: owner.ID},
+	}
+	events := recorder.take()
+	if diff := cmp.Diff(expected, events, ignoreEventMetadata); diff != "" {
+		t.Errorf("unexpected events:\n%v", diff)
+	}
+	ids := make(map[string]bool)
+	for _, e := range events {
+		if ids[e.Metadata().ID] {
+			t.Errorf("duplicate event ID %q", e.Metadata().ID)
+		}
+		ids[e.Metadata().ID] = true
+	}
+
+	// Published events are removed from the outbox.
+	published, err := s.PublishOutbox(ctx)
+	if err != nil || published != 0 {
+		t.Errorf("expected the outbox to be empty, got %d, %v", published, err)
+	}
+}
+
+func testOrganisationEventsOutbox(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	var recorder eventRecorder
+	r.publisher.Subscribe(recorder.handle)
+
+	// Changes are made even when their events can't be published.
+	recorder.setFail(EventTypeOrganisationCreated, EventTypeServiceCreated)
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+	err = s.PutService(ctx, organisationID, "service", "Service Name", 0)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	if events := recorder.take(); len(events) != 0 {
+		t.Fatalf("expected no events to be published, got %v", events)
+	}
+
+	// Later events aren't published while earlier events are still in the outbox.
+	recorder.setFail()
+	err = s.PutService(ctx, organisationID, "later", "Later Service", 0)
+	if err != nil {
+		t.Fatalf("failed to create later service: %v", err)
+	}
+	if events := recorder.take(); len(events) != 0 {
+		t.Fatalf("expected the later event to wait for the outbox, got %v", events)
+	}
+
+	// Publishing the outbox stops at the first event that can't be published, so that events stay in order.
+	recorder.setFail(EventTypeServiceCreated)
+	published, err := s.PublishOutbox(ctx)
+	if err == nil {
+		t.Errorf("expected an error publishing the service event")
+	}
+	if published != 1 {
+		t.Errorf("expected 1 event to be published, got %d", published)
+	}
+	metadata := EventMetadata{OrganisationID: organisationID}
+	expected := []Event{
+		OrganisationCreated{EventMetadata: metadata, Name: "Organisation Name", OwnerID: owner.ID},
+	}
+	if diff := cmp.Diff(expected, recorder.take(), ignoreEventMetadata); diff != "" {
+		t.Errorf("unexpected events:\n%v", diff)
+	}
+
+	recorder.setFail()
+	published, err = s.PublishOutbox(ctx)
+	if err != nil || published != 2 {
+		t.Errorf("expected 2 events to be published, got %d, %v", published, err)
+	}
+	expected = []Event{
+		ServiceCreated{EventMetadata: metadata, ServiceID: "service", Name: "Service Name"},
+		ServiceCreated{EventMetadata: metadata, ServiceID: "later", Name: "Later Service"},
+	}
+	if diff := cmp.Diff(expected, recorder.take(), ignoreEventMetadata); diff != "" {
+		t.Errorf("unexpected events:\n%v", diff)
+	}
+	published, err = s.PublishOutbox(ctx)
+	if err != nil || published != 0 {
+		t.Errorf("expected the outbox to be empty, got %d, %v", published, err)
+	}
+}
+
+func TestInProcessPublisher(t *testing.T) {
+	ctx := context.Background()
+	p := NewInProcessPublisher()
+	var first, second eventRecorder
+	p.Subscribe(first.handle)
+	p.Subscribe(second.handle)
+	second.setFail(EventTypeMemberRemoved)
+
+	created := OrganisationCreated{EventMetadata: EventMetadata{ID: "1", OrganisationID: "org"}, Name: "Name"}
+	removed := MemberRemoved{EventMetadata: EventMetadata{ID: "2", OrganisationID: "org"}, UserID: "user"}
+	err := p.Publish(ctx, created, removed)
+	if err == nil {
+		t.Fatalf("expected an error from the second handler")
+	}
+	if diff := cmp.Diff([]Event{created, removed}, first.take()); diff != "" {
+		t.Errorf("unexpected events for the first handler:\n%v", diff)
+	}
+	if diff := cmp.Diff([]Event{created}, second.take()); diff != "" {
+		t.Errorf("unexpected events for the second handler:\n%v", diff)
+	}
+}
+
+func TestOutboxRecords(t *testing.T) {
+	at := time.Date(2000, time.January, 1, 0, 0, 0, 1, time.UTC)
+	expiresAt := at.Add(time.Hour)
+	metadata := func(id string) EventMetadata {
+		return EventMetadata{ID: id, OrganisationID: "org", At: at}
+	}
+	events := []Event{
+		OrganisationCreated{EventMetadata: metadata("1"), Name: "Name", OwnerID: "owner"},
+		ServiceCreated{EventMetadata: metadata("2"), ServiceID: "service", Name: "Service"},
+		ServiceDeleted{EventMetadata: metadata("3"), ServiceID: "service"},
+		MemberInvited{EventMetadata: metadata("4"), UserID: "user", InvitedBy: "owner", Groups: []string{GroupMember}, ServiceGroups: map[string][]string{"service": {ServiceGroupAdmin}}},
+		InvitationAccepted{EventMetadata: metadata("5"), UserID: "user"},
+		MemberRemoved{EventMetadata: metadata("6"), UserID: "user"},
+		GroupsChanged{EventMetadata: metadata("7"), UserID: "user", AddedGroups: []string{"oncall"}, RemovedServiceGroups: map[string][]string{"service": {ServiceGroupAdmin}}, ExpiresAt: &expiresAt},
+		InvitationRejected{EventMetadata: metadata("8"), UserID: "user"},
+		InvitationRevoked{EventMetadata: metadata("9"), UserID: "user"},
+	}
+	var items []map[string]*dynamodb.AttributeValue
+	for _, e := range events {
+		r, err := newOutboxRecord(e)
+		if err != nil {
+			t.Fatalf("failed to create outbox record: %v", err)
+		}
+		item, err := dynamodbattribute.MarshalMap(r)
+		if err != nil {
+			t.Fatalf("failed to marshal outbox record: %v", err)
+		}
+		items = append(items, item)
+	}
+	actual, err := newEventsFromOutboxRecords(items)
+	if err != nil {
+		t.Fatalf("failed to read outbox records: %v", err)
+	}
+	if diff := cmp.Diff(events, actual); diff != "" {
+		t.Errorf("unexpected events:\n%v", diff)
+	}
+}
+
+func TestOutboxShards(t *testing.T) {
+	shards := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		organisationID := fmt.Sprintf("org%d", i)
+		hashKey := newOutboxRecordHashKey(organisationID)
+		if hashKey != newOutboxRecordHashKey(organisationID) {
+			t.Fatalf("expected the events of %q to be written to one shard", organisationID)
+		}
+		shards[hashKey] = true
+	}
+	hashKeys := newOutboxHashKeys()
+	for hashKey := range shards {
+		if !containsString(hashKeys, hashKey) {
+			t.Errorf("expected shard %q to be published", hashKey)
+		}
+	}
+	if len(shards) < 2 {
+		t.Errorf("expected the events to be spread across shards, got %v", shards)
+	}
+}
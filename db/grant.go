@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// maxGrants is the number of groups that can be granted at once. The grants share a transaction with the
+// membership, the copies of the audit record in the Organisation's, User's and actor's audit logs, and the outbox.
+const maxGrants = maxTransactionItems - 5
+
+// newGrantedGroupsChanged creates the GroupsChanged event of granting the groups.
+func newGrantedGroupsChanged(organisationID, userID string, granted *auditMembership, now time.Time) GroupsChanged {
+	return GroupsChanged{
+		EventMetadata:      newEventMetadata(organisationID, now),
+		UserID:             userID,
+		AddedGroups:        granted.Groups,
+		AddedServiceGroups: granted.ServiceGroups,
+		ExpiresAt:          granted.ExpiresAt,
+	}
+}
+
+// validateGrant returns ErrInvalidGrant if the groups can't be granted until expiresAt.
+func validateGrant(groups []string, expiresAt, now time.Time) error {
+	// Grants are stored to the second.
+	if expiresAt.Unix() <= now.Unix() {
+		return fmt.Errorf("expiry %v is not in the future: %w", expiresAt, ErrInvalidGrant)
+	}
+	// Owners are also stored in the organisationOwners record, which can't expire.
+	if containsString(groups, GroupOwner) {
+		return fmt.Errorf("the %q group can't be granted temporarily: %w", GroupOwner, ErrInvalidGrant)
+	}
+	return nil
+}
+
+// newGrants returns a Grant of each of the groups.
+func newGrants(userID string, groups []string, serviceIDToGroups map[string][]string, expiresAt time.Time) (grants []Grant) {
+	for _, g := range groups {
+		grants = append(grants, Grant{UserID: userID, Group: GroupName(g), ExpiresAt: expiresAt})
+	}
+	for serviceID, sgs := range serviceIDToGroups {
+		for _, g := range sgs {
+			grants = append(grants, Grant{UserID: userID, Group: GroupName(g), ServiceID: serviceID, ExpiresAt: expiresAt})
+		}
+	}
+	return
+}
+
+// getUserGroups reads a User's groups, including the groups of their grants that haven't expired. ErrNotMember is
+// returned if the User isn't a member of the Organisation.
+func getUserGroups(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID, userID string, now time.Time) (groups []string, serviceIDToGroups map[string][]string, err error) {
+	gio, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      tableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+	})
+	if err != nil {
+		return
+	}
+	grants, err := queryPartition(ctx, client, tableName, newOrganisationGrantRecordHashKey(organisationID), newOrganisationGrantRecordRangeKeyPrefix(userID))
+	if err != nil {
+		err = fmt.Errorf("failed to query grants: %w", err)
+		return
+	}
+	return newUserGroupsFromRecords(gio.Item, grants, now)
+}
+
+// newUserGroupsFromRecords returns the groups of an organisationGroupMember record, and of the User's grants that
+// haven't expired. ErrNotMember is returned if the record doesn't exist, or is a pending or revoked invitation.
+func newUserGroupsFromRecords(member map[string]*dynamodb.AttributeValue, grants []map[string]*dynamodb.AttributeValue, now time.Time) (groups []string, serviceIDToGroups map[string][]string, err error) {
+	groups, serviceIDToGroups, err = newUserGroupsFromMemberRecord(member)
+	if err != nil || len(grants) == 0 {
+		return
+	}
+	gs := newGroupSet(groups, serviceIDToGroups)
+	for _, item := range grants {
+		var ogr organisationGrantRecord
+		err = dynamodbattribute.UnmarshalMap(item, &ogr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationGrantRecord: %w", err)
+			return
+		}
+		if ogr.expired(now) {
+			continue
+		}
+		ogr.addTo(gs)
+	}
+	return gs.OrganisationGroups(), gs.ServiceGroups(), nil
+}
+
+// newGrantKeys returns the keys of the User's grant records that match the groups. If the groups are nil, all of
+// the User's grants are matched.
+func newGrantKeys(userID string, items []map[string]*dynamodb.AttributeValue, groups *groupSet) (keys []RecordKey, err error) {
+	for _, item := range items {
+		var ogr organisationGrantRecord
+		err = dynamodbattribute.UnmarshalMap(item, &ogr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationGrantRecord: %w", err)
+			return
+		}
+		if ogr.RecordType != organisationGrantRecordName || ogr.Email != userID {
+			continue
+		}
+		if groups != nil && !ogr.in(groups) {
+			continue
+		}
+		keys = append(keys, RecordKey{ID: ogr.ID, Range: ogr.Range})
+	}
+	return
+}
+
+// newDeleteGrants creates deletes of the user's grants of the groups, or of all of their grants if groups is nil.
+func newDeleteGrants(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID, userID string, groups *groupSet) (deletes []*dynamodb.TransactWriteItem, err error) {
+	items, err := queryPartition(ctx, client, tableName, newOrganisationGrantRecordHashKey(organisationID), newOrganisationGrantRecordRangeKeyPrefix(userID))
+	if err != nil {
+		err = fmt.Errorf("failed to query grants: %w", err)
+		return
+	}
+	keys, err := newGrantKeys(userID, items, groups)
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		deletes = append(deletes, &dynamodb.TransactWriteItem{
+			Delete: &dynamodb.Delete{
+				TableName: tableName,
+				Key:       idAndRng(key.ID, key.Range),
+			},
+		})
+	}
+	return
+}
+
+// newExpiredGrantRecords returns the grant records in the items that have expired.
+func newExpiredGrantRecords(items []map[string]*dynamodb.AttributeValue, now time.Time) (expired []organisationGrantRecord, err error) {
+	for _, item := range items {
+		var ogr organisationGrantRecord
+		err = dynamodbattribute.UnmarshalMap(item, &ogr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationGrantRecord: %w", err)
+			return
+		}
+		if ogr.RecordType == organisationGrantRecordName && ogr.expired(now) {
+			expired = append(expired, ogr)
+		}
+	}
+	return
+}
+
+// organisation grant record, a temporary membership of a group.
+const organisationGrantRecordName = "organisationGroupGrant"
+
+func newOrganisationGrantRecordHashKey(organisationID string) string {
+	return newOrganisationRecordHashKey(organisationID)
+}
+
+func newOrganisationGrantRecordRangeKeyPrefix(emailAddress string) string {
+	return organisationGrantRecordName + "/" + emailAddress + "/"
+}
+
+func newOrganisationGrantRecordRangeKey(emailAddress, serviceID string, group GroupName) string {
+	if serviceID == "" {
+		return newOrganisationGrantRecordRangeKeyPrefix(emailAddress) + "organisationGroup/" + string(group)
+	}
+	return newOrganisationGrantRecordRangeKeyPrefix(emailAddress) + "serviceGroup/" + serviceID + "/" + string(group)
+}
+
+func newOrganisationGrantRecord(organisationID string, g Grant) organisationGrantRecord {
+	var record organisationGrantRecord
+	record.ID = newOrganisationGrantRecordHashKey(organisationID)
+	record.Range = newOrganisationGrantRecordRangeKey(g.UserID, g.ServiceID, g.Group)
+	record.RecordType = organisationGrantRecordName
+	record.Version = 1
+	record.OrganisationID = organisationID
+	record.Email = g.UserID
+	record.Group = string(g.Group)
+	record.ServiceID = g.ServiceID
+	record.TTL = g.ExpiresAt.Unix()
+	return record
+}
+
+func newGrantFromRecord(ogr organisationGrantRecord) Grant {
+	return Grant{
+		UserID:    ogr.Email,
+		Group:     GroupName(ogr.Group),
+		ServiceID: ogr.ServiceID,
+		ExpiresAt: time.Unix(ogr.TTL, 0).UTC(),
+	}
+}
+
+type organisationGrantRecord struct {
+	record
+	OrganisationID string `json:"organisationId"`
+	Email          string `json:"email"`
+	Group          string `json:"group"`
+	ServiceID      string `json:"serviceId,omitempty"`
+	// TTL is the Unix time that the grant expires. The table's time to live can be enabled on the ttl
+	// attribute to delete expired grants automatically.
+	TTL int64 `json:"ttl"`
+}
+
+func (ogr organisationGrantRecord) expired(now time.Time) bool {
+	return ogr.TTL <= now.Unix()
+}
+
+func (ogr organisationGrantRecord) addTo(gs *groupSet) {
+	if ogr.ServiceID == "" {
+		gs.AddToGroups(ogr.Group)
+		return
+	}
+	gs.AddToServiceGroups(ogr.ServiceID, ogr.Group)
+}
+
+func (ogr organisationGrantRecord) in(gs *groupSet) (ok bool) {
+	if ogr.ServiceID == "" {
+		_, ok = gs.organisationGroups[ogr.Group]
+		return
+	}
+	_, ok = gs.serviceIDToGroups[ogr.ServiceID][ogr.Group]
+	return
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMemoryOrganisationStoreGrantExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	table := NewMemoryTable()
+	organisations := NewMemoryOrganisationStore(table)
+	organisations.Now = func() time.Time { return now }
+	r := repositories{users: NewMemoryUserStore(table), organisations: organisations}
+	org := createOrganisation(t, r, "A")
+	u := newUser("contractor@example.com", "Contract", "Or", "4476123456789", now)
+	err := organisations.AddUserToGroups(ctx, org.ID, u, []string{GroupMember}, nil)
+	if err != nil {
+		t.Fatalf("failed to add user: %v", err)
+	}
+	err = organisations.AddUserToGroups(ctx, org.ID, u, nil, map[string][]string{"service": {ServiceGroupDeployer}}, Until(now.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("failed to grant group: %v", err)
+	}
+	_, serviceIDToGroups, err := organisations.GetUserGroups(ctx, org.ID, u.ID)
+	if err != nil {
+		t.Fatalf("failed to get groups: %v", err)
+	}
+	if diff := cmp.Diff(map[string][]string{"service": {ServiceGroupDeployer}}, serviceIDToGroups); diff != "" {
+		t.Errorf("expected the grant to apply before it expires:\n%v", diff)
+	}
+
+	now = now.Add(time.Hour)
+	groups, serviceIDToGroups, err := organisations.GetUserGroups(ctx, org.ID, u.ID)
+	if err != nil {
+		t.Fatalf("failed to get groups: %v", err)
+	}
+	if diff := cmp.Diff([]string{GroupMember}, groups); diff != "" {
+		t.Errorf("unexpected groups:\n%v", diff)
+	}
+	if len(serviceIDToGroups) != 0 {
+		t.Errorf("expected the grant to stop applying when it expires, got %v", serviceIDToGroups)
+	}
+	details, err := organisations.GetDetails(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if len(details.Grants) != 0 {
+		t.Errorf("expected expired grants not to be listed, got %v", details.Grants)
+	}
+
+	deleted, err := organisations.SweepExpiredGrants(ctx, org.ID)
+	if err != nil || deleted != 1 {
+		t.Errorf("expected 1 expired grant to be deleted, got %d, %v", deleted, err)
+	}
+	deleted, err = organisations.SweepExpiredGrants(ctx, org.ID)
+	if err != nil || deleted != 0 {
+		t.Errorf("expected nothing to be deleted, got %d, %v", deleted, err)
+	}
+
+	err = organisations.AddUserToGroups(ctx, org.ID, u, []string{"testGroup"}, nil, Until(now))
+	if !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("expected ErrInvalidGrant for a grant that has already expired, got %v", err)
+	}
+}
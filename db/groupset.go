@@ -27,9 +27,9 @@ type groupSet struct {
 	serviceIDToGroups  map[string]map[string]struct{}
 }
 
-// OrganisationGroups gets the list of Organisation level group names.
+// OrganisationGroups gets the list of Organisation level group names. A nil groupSet has no groups.
 func (gs *groupSet) OrganisationGroups() (groups []string) {
-	if gs.organisationGroups == nil {
+	if gs == nil || gs.organisationGroups == nil {
 		return
 	}
 	for g := range gs.organisationGroups {
@@ -39,9 +39,9 @@ func (gs *groupSet) OrganisationGroups() (groups []string) {
 	return
 }
 
-// ServiceGroups gets a map of ServiceIDs and group names.
+// ServiceGroups gets a map of ServiceIDs and group names. A nil groupSet has no groups.
 func (gs *groupSet) ServiceGroups() (groups map[string][]string) {
-	if gs.serviceIDToGroups == nil {
+	if gs == nil || gs.serviceIDToGroups == nil {
 		return
 	}
 	groups = make(map[string][]string)
@@ -114,6 +114,8 @@ func (gs *groupSet) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue)
 	return nil
 }
 
+// MarshalDynamoDBAttributeValue marshals the groups as a string set. DynamoDB doesn't allow empty sets, so an empty
+// groupSet is marshalled as null, and omitted from records.
 func (gs *groupSet) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
 	var ss []string
 	if gs.organisationGroups != nil {
@@ -128,6 +130,42 @@ func (gs *groupSet) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) e
 			}
 		}
 	}
+	if len(ss) == 0 {
+		av.SetNULL(true)
+		return nil
+	}
 	av.SetSS(aws.StringSlice(ss))
 	return nil
 }
+
+// empty returns true if the groupSet has no groups. A nil groupSet is empty.
+func (gs *groupSet) empty() bool {
+	return len(gs.OrganisationGroups()) == 0 && len(gs.ServiceGroups()) == 0
+}
+
+// difference returns the groups that are in gs, but not in other. Either may be nil.
+func (gs *groupSet) difference(other *groupSet) *groupSet {
+	d := &groupSet{}
+	if gs == nil {
+		return d
+	}
+	for g := range gs.organisationGroups {
+		if other != nil {
+			if _, ok := other.organisationGroups[g]; ok {
+				continue
+			}
+		}
+		d.AddToGroups(g)
+	}
+	for serviceID, groups := range gs.serviceIDToGroups {
+		for g := range groups {
+			if other != nil {
+				if _, ok := other.serviceIDToGroups[serviceID][g]; ok {
+					continue
+				}
+			}
+			d.AddToServiceGroups(serviceID, g)
+		}
+	}
+	return d
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// DefaultInvitationTTL is how long an invitation can be accepted for, unless the store is configured otherwise.
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+// newInvitationToken creates a random invitation token, and the hash of it to store.
+func newInvitationToken() (token, tokenHash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		err = fmt.Errorf("failed to create invitation token: %w", err)
+		return
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	tokenHash = hashInvitationToken(token)
+	return
+}
+
+// hashInvitationToken hashes a token, so that tokens aren't stored in the table.
+func hashInvitationToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// invitationRecordFields are stored on the organisationGroupMember record of an invited User.
+type invitationRecordFields struct {
+	InvitedBy string     `json:"invitedBy,omitempty"`
+	InvitedAt *time.Time `json:"invitedAt,omitempty"`
+	// ExpiresAt is stored as Unix seconds, so that it can be compared in condition expressions.
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+	// TokenHash is removed when the invitation is accepted or revoked, so that each token can only be used once.
+	TokenHash  string     `json:"tokenHash,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty"`
+}
+
+func newInvitationRecordFields(invitedBy string, invitedAt, expiresAt time.Time, tokenHash string) invitationRecordFields {
+	return invitationRecordFields{
+		InvitedBy: invitedBy,
+		InvitedAt: &invitedAt,
+		ExpiresAt: expiresAt.Unix(),
+		TokenHash: tokenHash,
+	}
+}
+
+// pending returns true if the invitation can still be accepted, ignoring its expiry.
+func (f invitationRecordFields) pending() bool {
+	return f.TokenHash != ""
+}
+
+// checkInvitation returns an error explaining why the invitation can't be accepted with the token hash at the
+// given time, or nil if it can.
+func checkInvitation(f invitationRecordFields, tokenHash string, now time.Time) error {
+	if f.InvitedAt == nil {
+		return ErrInvitationNotFound
+	}
+	if f.RevokedAt != nil {
+		return ErrInvitationRevoked
+	}
+	if f.AcceptedAt != nil || !f.pending() {
+		return ErrInvitationAlreadyAccepted
+	}
+	if f.ExpiresAt <= now.Unix() {
+		return ErrInvitationExpired
+	}
+	if f.TokenHash != tokenHash {
+		return ErrInvalidInvitationToken
+	}
+	return nil
+}
+
+// isAcceptedMember is met if an organisationGroupMember record exists, and isn't a pending or revoked invitation.
+func isAcceptedMember() expression.ConditionBuilder {
+	return expression.AttributeExists(expression.Name("id")).
+		And(expression.AttributeNotExists(expression.Name("tokenHash"))).
+		And(expression.AttributeNotExists(expression.Name("revokedAt")))
+}
+
+// newInvitationError returns the reason that the condition on an organisationGroupMember record failed, given
+// the item returned by the failed condition.
+func newInvitationError(item map[string]*dynamodb.AttributeValue, tokenHash string, now time.Time) error {
+	if len(item) == 0 {
+		return ErrInvitationNotFound
+	}
+	var omr organisationMemberRecord
+	if err := dynamodbattribute.UnmarshalMap(item, &omr); err != nil {
+		return fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+	}
+	return checkInvitation(omr.invitationRecordFields, tokenHash, now)
+}
+
+// newInvitationsFromOrganisationRecords returns the pending invitations to an Organisation, given the items in
+// the Organisation's partition.
+func newInvitationsFromOrganisationRecords(items []map[string]*dynamodb.AttributeValue) (invitations []Invitation, err error) {
+	var org Organisation
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		switch r.RecordType {
+		case organisationRecordName:
+			var or organisationRecord
+			err = dynamodbattribute.UnmarshalMap(item, &or)
+			if err != nil {
+				err = fmt.Errorf("failed to convert organisationRecord: %w", err)
+				return
+			}
+			org = newOrganisationFromRecord(or)
+			org.Version = 0
+		case organisationMemberRecordName:
+			var omr organisationMemberRecord
+			err = dynamodbattribute.UnmarshalMap(item, &omr)
+			if err != nil {
+				err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+				return
+			}
+			if !omr.pending() {
+				continue
+			}
+			invitations = append(invitations, Invitation{
+				UserID:    omr.Email,
+				InvitedBy: omr.InvitedBy,
+				InvitedAt: *omr.InvitedAt,
+				ExpiresAt: time.Unix(omr.ExpiresAt, 0).UTC(),
+			})
+		}
+	}
+	for i := range invitations {
+		invitations[i].Organisation = org
+	}
+	return
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckInvitation(t *testing.T) {
+	now := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	pending := newInvitationRecordFields("owner@example.com", now, now.Add(time.Hour), hashInvitationToken("token"))
+	revoked := pending
+	revoked.TokenHash = ""
+	revoked.RevokedAt = &now
+	accepted := pending
+	accepted.TokenHash = ""
+	acceptedWithToken := pending
+	acceptedWithToken.AcceptedAt = &now
+
+	tests := []struct {
+		name     string
+		fields   invitationRecordFields
+		token    string
+		at       time.Time
+		expected error
+	}{
+		{name: "valid", fields: pending, token: "token", at: now},
+		{name: "not invited", fields: invitationRecordFields{}, token: "token", at: now, expected: ErrInvitationNotFound},
+		{name: "revoked", fields: revoked, token: "token", at: now, expected: ErrInvitationRevoked},
+		{name: "accepted", fields: accepted, token: "token", at: now, expected: ErrInvitationAlreadyAccepted},
+		{name: "accepted with token", fields: acceptedWithToken, token: "token", at: now, expected: ErrInvitationAlreadyAccepted},
+		{name: "expired", fields: pending, token: "token", at: now.Add(time.Hour), expected: ErrInvitationExpired},
+		{name: "wrong token", fields: pending, token: "wrong", at: now, expected: ErrInvalidInvitationToken},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			err := checkInvitation(test.fields, hashInvitationToken(test.token), test.at)
+			if !errors.Is(err, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestMemoryUserStoreInvitationExpiry(t *testing.T) {
+	now := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	table := NewMemoryTable()
+	users := NewMemoryUserStore(table)
+	users.Now = func() time.Time { return now }
+	users.InvitationTTL = time.Hour
+	r := repositories{users: users, organisations: NewMemoryOrganisationStore(table)}
+	org := createOrganisation(t, r, "A")
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789", now)
+	token, err := users.Invite(context.Background(), "owner@example.com", u, org, []string{GroupMember}, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	err = users.AcceptInvite(context.Background(), u, org, token)
+	if !errors.Is(err, ErrInvitationExpired) {
+		t.Errorf("expected ErrInvitationExpired, got %v", err)
+	}
+}
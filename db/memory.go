@@ -0,0 +1,712 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// memoryTableName is the name of the table used by the in-memory stores. A MemoryTable is a single table, so the
+// name is only used to fill in requests.
+const memoryTableName = "memory"
+
+// NewMemoryUserStore creates a UserStore that stores its records in the table instead of DynamoDB, e.g. for tests
+// that run without DynamoDB Local.
+func NewMemoryUserStore(table *MemoryTable) UserStore {
+	return UserStore{
+		Client:    table,
+		TableName: aws.String(memoryTableName),
+		Now: func() time.Time {
+			return time.Now().UTC()
+		},
+		InvitationTTL: DefaultInvitationTTL,
+	}
+}
+
+// NewMemoryOrganisationStore creates an OrganisationStore that stores its records in the table instead of
+// DynamoDB, e.g. for tests that run without DynamoDB Local.
+func NewMemoryOrganisationStore(table *MemoryTable) OrganisationStore {
+	return OrganisationStore{
+		Client:    table,
+		TableName: aws.String(memoryTableName),
+		Now: func() time.Time {
+			return time.Now().UTC()
+		},
+		InvitationTTL: DefaultInvitationTTL,
+	}
+}
+
+// NewMemoryTable creates an empty MemoryTable.
+func NewMemoryTable() *MemoryTable {
+	return &MemoryTable{
+		partitions: make(map[string]map[string]memoryItem),
+	}
+}
+
+// MemoryTable is an in-memory stand-in for the DynamoDB table, with the id hash key and rng range key. It
+// implements the parts of the DynamoDB API that the stores use, so that the stores run the same code against it
+// as against DynamoDB. Requests are validated in the same way as DynamoDB, e.g. a transaction can't include two
+// operations on the same item, and sets can't be empty. Calling any other DynamoDB API method panics.
+//
+// A MemoryTable can be shared between the stores returned by NewMemoryUserStore and NewMemoryOrganisationStore.
+type MemoryTable struct {
+	dynamodbiface.DynamoDBAPI
+	m          sync.Mutex
+	partitions map[string]map[string]memoryItem
+}
+
+// memoryItem is a single item within a MemoryTable.
+type memoryItem map[string]*dynamodb.AttributeValue
+
+type memoryKey struct {
+	id  string
+	rng string
+}
+
+func (item memoryItem) key() memoryKey {
+	return memoryKey{
+		id:  aws.StringValue(item["id"].S),
+		rng: aws.StringValue(item["rng"].S),
+	}
+}
+
+func (item memoryItem) copy() memoryItem {
+	if item == nil {
+		return nil
+	}
+	c := make(memoryItem, len(item))
+	for k, v := range item {
+		c[k] = copyMemoryAttributeValue(v)
+	}
+	return c
+}
+
+func copyMemoryAttributeValue(av *dynamodb.AttributeValue) *dynamodb.AttributeValue {
+	c := *av
+	if av.B != nil {
+		c.B = append([]byte{}, av.B...)
+	}
+	if av.SS != nil {
+		c.SS = aws.StringSlice(aws.StringValueSlice(av.SS))
+	}
+	if av.NS != nil {
+		c.NS = aws.StringSlice(aws.StringValueSlice(av.NS))
+	}
+	if av.BS != nil {
+		c.BS = make([][]byte, len(av.BS))
+		for i, b := range av.BS {
+			c.BS[i] = append([]byte{}, b...)
+		}
+	}
+	if av.L != nil {
+		c.L = make([]*dynamodb.AttributeValue, len(av.L))
+		for i, v := range av.L {
+			c.L[i] = copyMemoryAttributeValue(v)
+		}
+	}
+	if av.M != nil {
+		c.M = make(map[string]*dynamodb.AttributeValue, len(av.M))
+		for k, v := range av.M {
+			c.M[k] = copyMemoryAttributeValue(v)
+		}
+	}
+	return &c
+}
+
+func newMemoryValidationError(format string, args ...interface{}) error {
+	return awserr.New("ValidationException", fmt.Sprintf(format, args...), nil)
+}
+
+// newMemoryKey validates a key, which must have only the id and rng attributes.
+func newMemoryKey(key map[string]*dynamodb.AttributeValue) (k memoryKey, err error) {
+	if len(key) != 2 {
+		err = newMemoryValidationError("The provided key element does not match the schema")
+		return
+	}
+	return newMemoryItemKey(key)
+}
+
+// newMemoryItemKey returns the key of an item, which must have non-empty string id and rng attributes.
+func newMemoryItemKey(item map[string]*dynamodb.AttributeValue) (k memoryKey, err error) {
+	for _, name := range []string{"id", "rng"} {
+		av, ok := item[name]
+		if !ok {
+			err = newMemoryValidationError("One or more parameter values were invalid: Missing the key %s in the item", name)
+			return
+		}
+		if av == nil || av.S == nil {
+			err = newMemoryValidationError("One or more parameter values were invalid: Type mismatch for key %s expected: S", name)
+			return
+		}
+		if *av.S == "" {
+			err = newMemoryValidationError("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty string value. Key: %s", name)
+			return
+		}
+	}
+	return memoryItem(item).key(), nil
+}
+
+// memoryWrite is a validated write to a single item, as part of a transaction or on its own.
+type memoryWrite struct {
+	key memoryKey
+	// condition is nil if the write is unconditional.
+	condition memoryCondition
+	// apply returns the item after the write, or nil if the item is deleted. It is nil for a condition check.
+	apply func(existing memoryItem) (memoryItem, error)
+	// returnOld is set if the existing item is returned when the condition isn't met.
+	returnOld bool
+}
+
+func newMemoryCondition(expression *string, attrs *memoryAttributes) (memoryCondition, error) {
+	if expression == nil {
+		return nil, nil
+	}
+	p, err := newMemoryParser(*expression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseCondition()
+}
+
+func newMemoryPut(item map[string]*dynamodb.AttributeValue, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (w memoryWrite, err error) {
+	if w.key, err = newMemoryItemKey(item); err != nil {
+		return
+	}
+	for name, av := range item {
+		if err = validateMemoryAttributeValue(av); err != nil {
+			err = newMemoryValidationError("One or more parameter values were invalid: %v for attribute %s", err, name)
+			return
+		}
+	}
+	attrs, err := newMemoryAttributes(names, values)
+	if err != nil {
+		return
+	}
+	if w.condition, err = newMemoryCondition(condition, attrs); err != nil {
+		return
+	}
+	if err = attrs.checkUsed(); err != nil {
+		return
+	}
+	updated := memoryItem(item).copy()
+	w.apply = func(memoryItem) (memoryItem, error) {
+		return updated, nil
+	}
+	return
+}
+
+func newMemoryDelete(key map[string]*dynamodb.AttributeValue, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (w memoryWrite, err error) {
+	if w.key, err = newMemoryKey(key); err != nil {
+		return
+	}
+	attrs, err := newMemoryAttributes(names, values)
+	if err != nil {
+		return
+	}
+	if w.condition, err = newMemoryCondition(condition, attrs); err != nil {
+		return
+	}
+	if err = attrs.checkUsed(); err != nil {
+		return
+	}
+	w.apply = func(memoryItem) (memoryItem, error) {
+		return nil, nil
+	}
+	return
+}
+
+func newMemoryUpdate(key map[string]*dynamodb.AttributeValue, update, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (w memoryWrite, err error) {
+	if w.key, err = newMemoryKey(key); err != nil {
+		return
+	}
+	attrs, err := newMemoryAttributes(names, values)
+	if err != nil {
+		return
+	}
+	if w.condition, err = newMemoryCondition(condition, attrs); err != nil {
+		return
+	}
+	if update == nil {
+		err = newMemoryValidationError("UpdateExpression is required")
+		return
+	}
+	p, err := newMemoryParser(*update, attrs)
+	if err != nil {
+		return
+	}
+	actions, err := p.parseUpdate()
+	if err != nil {
+		return
+	}
+	if err = attrs.checkUsed(); err != nil {
+		return
+	}
+	k := w.key
+	w.apply = func(existing memoryItem) (memoryItem, error) {
+		old := existing
+		if old == nil {
+			// As in DynamoDB, updating an item that doesn't exist creates it.
+			old = memoryItem(idAndRng(k.id, k.rng))
+		}
+		updated := old.copy()
+		for _, action := range actions {
+			if err := action(old, updated); err != nil {
+				return nil, err
+			}
+		}
+		return updated, nil
+	}
+	return
+}
+
+func newMemoryConditionCheck(key map[string]*dynamodb.AttributeValue, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) (w memoryWrite, err error) {
+	if condition == nil {
+		err = newMemoryValidationError("ConditionExpression is required for a ConditionCheck")
+		return
+	}
+	if w.key, err = newMemoryKey(key); err != nil {
+		return
+	}
+	attrs, err := newMemoryAttributes(names, values)
+	if err != nil {
+		return
+	}
+	if w.condition, err = newMemoryCondition(condition, attrs); err != nil {
+		return
+	}
+	err = attrs.checkUsed()
+	return
+}
+
+func newMemoryTransactWrite(ti *dynamodb.TransactWriteItem) (w memoryWrite, err error) {
+	var operations int
+	var returnValues *string
+	if c := ti.ConditionCheck; c != nil {
+		operations++
+		w, err = newMemoryConditionCheck(c.Key, c.ConditionExpression, c.ExpressionAttributeNames, c.ExpressionAttributeValues)
+		returnValues = c.ReturnValuesOnConditionCheckFailure
+	}
+	if p := ti.Put; p != nil && err == nil {
+		operations++
+		w, err = newMemoryPut(p.Item, p.ConditionExpression, p.ExpressionAttributeNames, p.ExpressionAttributeValues)
+		returnValues = p.ReturnValuesOnConditionCheckFailure
+	}
+	if u := ti.Update; u != nil && err == nil {
+		operations++
+		w, err = newMemoryUpdate(u.Key, u.UpdateExpression, u.ConditionExpression, u.ExpressionAttributeNames, u.ExpressionAttributeValues)
+		returnValues = u.ReturnValuesOnConditionCheckFailure
+	}
+	if d := ti.Delete; d != nil && err == nil {
+		operations++
+		w, err = newMemoryDelete(d.Key, d.ConditionExpression, d.ExpressionAttributeNames, d.ExpressionAttributeValues)
+		returnValues = d.ReturnValuesOnConditionCheckFailure
+	}
+	if err != nil {
+		return
+	}
+	if operations != 1 {
+		err = newMemoryValidationError("TransactItems can only contain one of Check, Put, Update or Delete")
+		return
+	}
+	w.returnOld = aws.StringValue(returnValues) == dynamodb.ReturnValuesOnConditionCheckFailureAllOld
+	return
+}
+
+// get returns a copy of an item, or nil if it doesn't exist. The table must be locked.
+func (t *MemoryTable) get(k memoryKey) memoryItem {
+	return t.partitions[k.id][k.rng].copy()
+}
+
+// set replaces an item, or deletes it if item is nil. The table must be locked.
+func (t *MemoryTable) set(k memoryKey, item memoryItem) {
+	partition := t.partitions[k.id]
+	if item == nil {
+		delete(partition, k.rng)
+		if len(partition) == 0 {
+			delete(t.partitions, k.id)
+		}
+		return
+	}
+	if partition == nil {
+		partition = make(map[string]memoryItem)
+		t.partitions[k.id] = partition
+	}
+	partition[k.rng] = item
+}
+
+// write makes a single write, returning ConditionalCheckFailedException if its condition isn't met.
+func (t *MemoryTable) write(w memoryWrite) error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	existing := t.get(w.key)
+	if w.condition != nil && !w.condition(existing) {
+		return &dynamodb.ConditionalCheckFailedException{Message_: aws.String("The conditional request failed")}
+	}
+	updated, err := w.apply(existing)
+	if err != nil {
+		return err
+	}
+	t.set(w.key, updated)
+	return nil
+}
+
+// GetItemWithContext gets a single item.
+func (t *MemoryTable) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if input.ProjectionExpression != nil || input.AttributesToGet != nil {
+		return nil, fmt.Errorf("memoryTable: projections are not supported")
+	}
+	k, err := newMemoryKey(input.Key)
+	if err != nil {
+		return nil, err
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	return &dynamodb.GetItemOutput{Item: t.get(k)}, nil
+}
+
+// PutItemWithContext puts a single item.
+func (t *MemoryTable) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w, err := newMemoryPut(input.Item, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.write(w); err != nil {
+		return nil, err
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// DeleteItemWithContext deletes a single item. Deleting an item that doesn't exist is not an error.
+func (t *MemoryTable) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w, err := newMemoryDelete(input.Key, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.write(w); err != nil {
+		return nil, err
+	}
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+// UpdateItemWithContext updates a single item, creating it if it doesn't exist.
+func (t *MemoryTable) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if input.ReturnValues != nil && aws.StringValue(input.ReturnValues) != dynamodb.ReturnValueNone {
+		return nil, fmt.Errorf("memoryTable: return values are not supported")
+	}
+	w, err := newMemoryUpdate(input.Key, input.UpdateExpression, input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.write(w); err != nil {
+		return nil, err
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// TransactWriteItemsWithContext makes all of the writes, or none of them if any of their conditions aren't met.
+func (t *MemoryTable) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(input.TransactItems) == 0 || len(input.TransactItems) > maxTransactionItems {
+		return nil, newMemoryValidationError("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d", maxTransactionItems)
+	}
+	writes := make([]memoryWrite, len(input.TransactItems))
+	keys := make(map[memoryKey]bool)
+	for i, ti := range input.TransactItems {
+		w, err := newMemoryTransactWrite(ti)
+		if err != nil {
+			return nil, err
+		}
+		if keys[w.key] {
+			return nil, newMemoryValidationError("Transaction request cannot include multiple operations on one item")
+		}
+		keys[w.key] = true
+		writes[i] = w
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	reasons := make([]*dynamodb.CancellationReason, len(writes))
+	codes := make([]string, len(writes))
+	var cancelled bool
+	updated := make([]memoryItem, len(writes))
+	for i, w := range writes {
+		existing := t.get(w.key)
+		reasons[i] = &dynamodb.CancellationReason{Code: aws.String("None")}
+		codes[i] = "None"
+		if w.condition != nil && !w.condition(existing) {
+			cancelled = true
+			reasons[i] = &dynamodb.CancellationReason{
+				Code:    aws.String("ConditionalCheckFailed"),
+				Message: aws.String("The conditional request failed"),
+			}
+			if w.returnOld {
+				reasons[i].Item = existing
+			}
+			codes[i] = "ConditionalCheckFailed"
+			continue
+		}
+		if w.apply == nil {
+			continue
+		}
+		var err error
+		if updated[i], err = w.apply(existing); err != nil {
+			return nil, err
+		}
+	}
+	if cancelled {
+		return nil, &dynamodb.TransactionCanceledException{
+			Message_:            aws.String(fmt.Sprintf("Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(codes, ", "))),
+			CancellationReasons: reasons,
+		}
+	}
+	for i, w := range writes {
+		if w.apply != nil {
+			t.set(w.key, updated[i])
+		}
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// BatchWriteItemWithContext puts and deletes items. All of the items are always processed.
+func (t *MemoryTable) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var writes []memoryWrite
+	keys := make(map[memoryKey]bool)
+	for _, requests := range input.RequestItems {
+		for _, r := range requests {
+			var w memoryWrite
+			var err error
+			switch {
+			case r.PutRequest != nil && r.DeleteRequest == nil:
+				w, err = newMemoryPut(r.PutRequest.Item, nil, nil, nil)
+			case r.DeleteRequest != nil && r.PutRequest == nil:
+				w, err = newMemoryDelete(r.DeleteRequest.Key, nil, nil, nil)
+			default:
+				err = newMemoryValidationError("Supplied WriteRequest must contain exactly one of PutRequest or DeleteRequest")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if keys[w.key] {
+				return nil, newMemoryValidationError("Provided list of item keys contains duplicates")
+			}
+			keys[w.key] = true
+			writes = append(writes, w)
+		}
+	}
+	if len(writes) == 0 || len(writes) > maxBatchWriteItems {
+		return nil, newMemoryValidationError("1 validation error detected: Value at 'requestItems' failed to satisfy constraint: Member must have length less than or equal to %d", maxBatchWriteItems)
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, w := range writes {
+		updated, err := w.apply(nil)
+		if err != nil {
+			return nil, err
+		}
+		t.set(w.key, updated)
+	}
+	return &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{}}, nil
+}
+
+// QueryWithContext queries the items of a partition, in range key order.
+func (t *MemoryTable) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if input.IndexName != nil || input.ProjectionExpression != nil || input.AttributesToGet != nil || input.KeyConditions != nil {
+		return nil, fmt.Errorf("memoryTable: indexes, projections and legacy key conditions are not supported")
+	}
+	attrs, err := newMemoryAttributes(input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return nil, err
+	}
+	if input.KeyConditionExpression == nil {
+		return nil, newMemoryValidationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request")
+	}
+	p, err := newMemoryParser(*input.KeyConditionExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	keyConditions, err := p.parseKeyConditions()
+	if err != nil {
+		return nil, err
+	}
+	var hashKey *dynamodb.AttributeValue
+	var conditions []memoryCondition
+	for _, c := range keyConditions {
+		switch {
+		case c.name == "id" && c.hashKey != nil && hashKey == nil:
+			hashKey = c.hashKey
+		case c.name == "rng" && len(conditions) == 0:
+			conditions = append(conditions, c.condition)
+		default:
+			return nil, newMemoryValidationError("Query key condition not supported")
+		}
+	}
+	if hashKey == nil || hashKey.S == nil {
+		return nil, newMemoryValidationError("Query condition missed key schema element: id")
+	}
+	filter, err := newMemoryCondition(input.FilterExpression, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if err = attrs.checkUsed(); err != nil {
+		return nil, err
+	}
+	var start *memoryKey
+	if input.ExclusiveStartKey != nil {
+		k, err := newMemoryKey(input.ExclusiveStartKey)
+		if err != nil {
+			return nil, err
+		}
+		start = &k
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+	partition := t.partitions[*hashKey.S]
+	ranges := make([]string, 0, len(partition))
+	for rng := range partition {
+		ranges = append(ranges, rng)
+	}
+	forward := input.ScanIndexForward == nil || *input.ScanIndexForward
+	if forward {
+		sort.Strings(ranges)
+	} else {
+		sort.Sort(sort.Reverse(sort.StringSlice(ranges)))
+	}
+	out := &dynamodb.QueryOutput{}
+	var evaluated int64
+	for _, rng := range ranges {
+		if start != nil && ((forward && rng <= start.rng) || (!forward && rng >= start.rng)) {
+			continue
+		}
+		item := partition[rng]
+		if len(conditions) > 0 && !conditions[0](item) {
+			continue
+		}
+		// As in DynamoDB, the limit is the number of items evaluated before the filter is applied, and the last
+		// evaluated key is returned when the limit is reached, even if there are no more items.
+		if input.Limit != nil && evaluated == *input.Limit {
+			break
+		}
+		evaluated++
+		if input.Limit != nil && evaluated == *input.Limit {
+			out.LastEvaluatedKey = idAndRng(*hashKey.S, rng)
+		}
+		if filter != nil && !filter(item) {
+			continue
+		}
+		out.Items = append(out.Items, item.copy())
+	}
+	out.Count = aws.Int64(int64(len(out.Items)))
+	out.ScannedCount = aws.Int64(evaluated)
+	return out, nil
+}
+
+// QueryPagesWithContext queries the items of a partition, calling fn with each page.
+func (t *MemoryTable) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error {
+	in := *input
+	for {
+		out, err := t.QueryWithContext(ctx, &in, opts...)
+		if err != nil {
+			return err
+		}
+		lastPage := len(out.LastEvaluatedKey) == 0
+		if !fn(out, lastPage) || lastPage {
+			return nil
+		}
+		in.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// ScanPagesWithContext reads every item in the table, in key order, calling fn with a single page.
+func (t *MemoryTable) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if input.IndexName != nil || input.ProjectionExpression != nil || input.AttributesToGet != nil || input.ScanFilter != nil ||
+		input.Limit != nil || input.ExclusiveStartKey != nil || input.Segment != nil || input.TotalSegments != nil {
+		return fmt.Errorf("memoryTable: indexes, projections, legacy filters, limits and segments are not supported")
+	}
+	attrs, err := newMemoryAttributes(input.ExpressionAttributeNames, input.ExpressionAttributeValues)
+	if err != nil {
+		return err
+	}
+	filter, err := newMemoryCondition(input.FilterExpression, attrs)
+	if err != nil {
+		return err
+	}
+	if err = attrs.checkUsed(); err != nil {
+		return err
+	}
+
+	t.m.Lock()
+	out := &dynamodb.ScanOutput{}
+	var scanned int64
+	for _, item := range t.sortedItems() {
+		scanned++
+		if filter != nil && !filter(item) {
+			continue
+		}
+		out.Items = append(out.Items, item.copy())
+	}
+	t.m.Unlock()
+	out.Count = aws.Int64(int64(len(out.Items)))
+	out.ScannedCount = aws.Int64(scanned)
+	fn(out, true)
+	return nil
+}
+
+// sortedItems returns every item in the table, sorted by key. The table must be locked.
+func (t *MemoryTable) sortedItems() (items []memoryItem) {
+	for _, partition := range t.partitions {
+		for _, item := range partition {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		ki, kj := items[i].key(), items[j].key()
+		if ki.id != kj.id {
+			return ki.id < kj.id
+		}
+		return ki.rng < kj.rng
+	})
+	return
+}
+
+// items returns a copy of every item in the table.
+func (t *MemoryTable) items() (items []map[string]*dynamodb.AttributeValue) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, partition := range t.partitions {
+		for _, item := range partition {
+			items = append(items, item.copy())
+		}
+	}
+	return
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+func TestMemoryRepositories(t *testing.T) {
+	testRepositories(t, func(t *testing.T) (r repositories, cleanup func()) {
+		table := NewMemoryTable()
+		r.publisher = NewInProcessPublisher()
+		users := NewMemoryUserStore(table)
+		users.Publisher = r.publisher
+		orgs := NewMemoryOrganisationStore(table)
+		orgs.Publisher = r.publisher
+		r.users = users
+		r.organisations = orgs
+		r.deleteRecord = func(id, rng string) error {
+			_, err := table.DeleteItemWithContext(context.Background(), &dynamodb.DeleteItemInput{
+				TableName: aws.String(memoryTableName),
+				Key:       idAndRng(id, rng),
+			})
+			return err
+		}
+		r.putRecord = func(item map[string]*dynamodb.AttributeValue) error {
+			_, err := table.PutItemWithContext(context.Background(), &dynamodb.PutItemInput{
+				TableName: aws.String(memoryTableName),
+				Item:      item,
+			})
+			return err
+		}
+		r.records = func() ([]map[string]*dynamodb.AttributeValue, error) {
+			return table.items(), nil
+		}
+		return r, func() {}
+	})
+}
+
+func TestMemoryTable(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+	put := func(id, rng string, attributes map[string]*dynamodb.AttributeValue) *dynamodb.TransactWriteItem {
+		item := idAndRng(id, rng)
+		for k, v := range attributes {
+			item[k] = v
+		}
+		return &dynamodb.TransactWriteItem{Put: &dynamodb.Put{TableName: aws.String(memoryTableName), Item: item}}
+	}
+	isValidationError := func(err error) bool {
+		var aerr awserr.Error
+		return errors.As(err, &aerr) && aerr.Code() == "ValidationException"
+	}
+
+	t.Run("transactions can't include two operations on one item", func(t *testing.T) {
+		_, err := table.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{put("a", "1", nil), put("a", "1", nil)},
+		})
+		if !isValidationError(err) {
+			t.Errorf("expected a ValidationException, got %v", err)
+		}
+	})
+	t.Run("sets can't be empty", func(t *testing.T) {
+		_, err := table.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{put("a", "1", map[string]*dynamodb.AttributeValue{
+				"set": {SS: []*string{}},
+			})},
+		})
+		if !isValidationError(err) {
+			t.Errorf("expected a ValidationException, got %v", err)
+		}
+	})
+	t.Run("expression attribute values must be used", func(t *testing.T) {
+		_, err := table.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:                 aws.String(memoryTableName),
+			Item:                      idAndRng("a", "1"),
+			ConditionExpression:       aws.String("attribute_not_exists (#0)"),
+			ExpressionAttributeNames:  map[string]*string{"#0": aws.String("id")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":0": {S: aws.String("unused")}},
+		})
+		if !isValidationError(err) {
+			t.Errorf("expected a ValidationException, got %v", err)
+		}
+	})
+	t.Run("failed conditions cancel the transaction", func(t *testing.T) {
+		_, err := table.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{put("b", "1", map[string]*dynamodb.AttributeValue{"v": {N: aws.String("1")}})},
+		})
+		if err != nil {
+			t.Fatalf("failed to put item: %v", err)
+		}
+		expr, err := expression.NewBuilder().WithCondition(expression.Name("v").Equal(expression.Value(2))).Build()
+		if err != nil {
+			t.Fatalf("failed to build condition: %v", err)
+		}
+		_, err = table.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: []*dynamodb.TransactWriteItem{
+				put("b", "2", nil),
+				{
+					ConditionCheck: &dynamodb.ConditionCheck{
+						TableName:                           aws.String(memoryTableName),
+						Key:                                 idAndRng("b", "1"),
+						ConditionExpression:                 expr.Condition(),
+						ExpressionAttributeNames:            expr.Names(),
+						ExpressionAttributeValues:           expr.Values(),
+						ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+					},
+				},
+			},
+		})
+		var tce *dynamodb.TransactionCanceledException
+		if !errors.As(err, &tce) {
+			t.Fatalf("expected the transaction to be cancelled, got %v", err)
+		}
+		if reason := tce.CancellationReasons[1]; aws.StringValue(reason.Code) != "ConditionalCheckFailed" || aws.StringValue(reason.Item["v"].N) != "1" {
+			t.Errorf("expected the condition check to fail and return the item, got %v", reason)
+		}
+		if reason := tce.CancellationReasons[0]; aws.StringValue(reason.Code) != "None" {
+			t.Errorf("expected only the condition check to fail, got %v", reason)
+		}
+		out, err := table.GetItemWithContext(ctx, &dynamodb.GetItemInput{TableName: aws.String(memoryTableName), Key: idAndRng("b", "2")})
+		if err != nil || out.Item != nil {
+			t.Errorf("expected the put to be cancelled, got %v, %v", out, err)
+		}
+	})
+	t.Run("deleting the last value of a set removes the attribute", func(t *testing.T) {
+		for _, update := range []expression.UpdateBuilder{
+			expression.Add(expression.Name("set"), expression.Value(&dynamodb.AttributeValue{SS: aws.StringSlice([]string{"x", "y"})})),
+			expression.Delete(expression.Name("set"), expression.Value(&dynamodb.AttributeValue{SS: aws.StringSlice([]string{"x", "y"})})),
+		} {
+			expr, err := expression.NewBuilder().WithUpdate(update).Build()
+			if err != nil {
+				t.Fatalf("failed to build update: %v", err)
+			}
+			_, err = table.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 aws.String(memoryTableName),
+				Key:                       idAndRng("c", "1"),
+				UpdateExpression:          expr.Update(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			})
+			if err != nil {
+				t.Fatalf("failed to update item: %v", err)
+			}
+		}
+		out, err := table.GetItemWithContext(ctx, &dynamodb.GetItemInput{TableName: aws.String(memoryTableName), Key: idAndRng("c", "1")})
+		if err != nil {
+			t.Fatalf("failed to get item: %v", err)
+		}
+		if len(out.Item) != 2 {
+			t.Errorf("expected only the key to remain, got %v", out.Item)
+		}
+	})
+}
@@ -0,0 +1,1105 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// memoryAttributes holds the expression attribute names and values of a request, and records which of them are
+// used, since DynamoDB rejects requests with unused names or values.
+type memoryAttributes struct {
+	names      map[string]*string
+	values     map[string]*dynamodb.AttributeValue
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newMemoryAttributes(names map[string]*string, values map[string]*dynamodb.AttributeValue) (*memoryAttributes, error) {
+	if names != nil && len(names) == 0 {
+		return nil, newMemoryValidationError("ExpressionAttributeNames must not be empty")
+	}
+	if values != nil && len(values) == 0 {
+		return nil, newMemoryValidationError("ExpressionAttributeValues must not be empty")
+	}
+	for k, v := range values {
+		if err := validateMemoryAttributeValue(v); err != nil {
+			return nil, newMemoryValidationError("ExpressionAttributeValues contains invalid value: %v for key %s", err, k)
+		}
+	}
+	return &memoryAttributes{
+		names:      names,
+		values:     values,
+		usedNames:  make(map[string]bool),
+		usedValues: make(map[string]bool),
+	}, nil
+}
+
+func (a *memoryAttributes) name(token string) (string, error) {
+	name, ok := a.names[token]
+	if !ok {
+		return "", newMemoryValidationError("An expression attribute name used in the document path is not defined; attribute name: %s", token)
+	}
+	a.usedNames[token] = true
+	return aws.StringValue(name), nil
+}
+
+func (a *memoryAttributes) value(token string) (*dynamodb.AttributeValue, error) {
+	v, ok := a.values[token]
+	if !ok {
+		return nil, newMemoryValidationError("An expression attribute value used in expression is not defined; attribute value: %s", token)
+	}
+	a.usedValues[token] = true
+	return v, nil
+}
+
+// checkUsed returns an error if any of the names or values weren't used by the request's expressions.
+func (a *memoryAttributes) checkUsed() error {
+	var unused []string
+	for k := range a.names {
+		if !a.usedNames[k] {
+			unused = append(unused, k)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return newMemoryValidationError("Value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	for k := range a.values {
+		if !a.usedValues[k] {
+			unused = append(unused, k)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return newMemoryValidationError("Value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+// memoryCondition is a parsed condition expression.
+type memoryCondition func(item memoryItem) bool
+
+// memoryOperand is a parsed operand of an expression. It returns nil if it refers to an attribute that the item
+// doesn't have.
+type memoryOperand func(item memoryItem) *dynamodb.AttributeValue
+
+// memoryUpdate is a parsed update expression. Its actions read the item as it was before the update, and write to
+// the updated item.
+type memoryUpdate []func(old, updated memoryItem) error
+
+// memoryKeyCondition is one of the conditions of a key condition expression.
+type memoryKeyCondition struct {
+	name      string
+	condition memoryCondition
+	// hashKey is set if the condition is an equality condition that selects a partition.
+	hashKey *dynamodb.AttributeValue
+}
+
+// memoryParser parses the subset of DynamoDB's expression syntax produced by the expression package: top-level
+// attribute names, comparisons, BETWEEN, IN, AND, OR, NOT, the condition functions, and the SET, REMOVE, ADD and
+// DELETE update actions.
+type memoryParser struct {
+	tokens []string
+	pos    int
+	attrs  *memoryAttributes
+}
+
+func newMemoryParser(expression string, attrs *memoryAttributes) (*memoryParser, error) {
+	tokens, err := tokeniseMemoryExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, newMemoryValidationError("Invalid expression: The expression can not be empty")
+	}
+	return &memoryParser{tokens: tokens, attrs: attrs}, nil
+}
+
+func tokeniseMemoryExpression(s string) (tokens []string, err error) {
+	isWordByte := func(c byte) bool {
+		return c == '_' || c == '#' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.IndexByte("(),=+-[].", c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		case c == '<' || c == '>':
+			if i+1 < len(s) && (s[i+1] == '=' || (c == '<' && s[i+1] == '>')) {
+				tokens = append(tokens, s[i:i+2])
+				i += 2
+				continue
+			}
+			tokens = append(tokens, string(c))
+			i++
+		case isWordByte(c):
+			j := i + 1
+			for j < len(s) && isWordByte(s[j]) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, newMemoryValidationError("Invalid expression: Syntax error; token: %q", string(c))
+		}
+	}
+	return
+}
+
+func (p *memoryParser) peek(offset int) string {
+	if p.pos+offset >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *memoryParser) next() string {
+	t := p.peek(0)
+	p.pos++
+	return t
+}
+
+func (p *memoryParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *memoryParser) expect(token string) error {
+	if t := p.next(); t != token {
+		return p.syntaxError(t)
+	}
+	return nil
+}
+
+// keyword consumes the next token if it's the keyword, which is case insensitive.
+func (p *memoryParser) keyword(k string) bool {
+	if strings.EqualFold(p.peek(0), k) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// function returns true if the next tokens are a call to the function.
+func (p *memoryParser) function(name string) bool {
+	return strings.EqualFold(p.peek(0), name) && p.peek(1) == "("
+}
+
+func (p *memoryParser) syntaxError(token string) error {
+	if token == "" {
+		return newMemoryValidationError("Invalid expression: Syntax error; token: <EOF>")
+	}
+	return newMemoryValidationError("Invalid expression: Syntax error; token: %q", token)
+}
+
+// parseCondition parses a whole condition expression.
+func (p *memoryParser) parseCondition() (memoryCondition, error) {
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.syntaxError(p.peek(0))
+	}
+	return c, nil
+}
+
+func (p *memoryParser) parseOr() (memoryCondition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item memoryItem) bool { return l(item) || right(item) }
+	}
+	return left, nil
+}
+
+func (p *memoryParser) parseAnd() (memoryCondition, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item memoryItem) bool { return l(item) && right(item) }
+	}
+	return left, nil
+}
+
+func (p *memoryParser) parseNot() (memoryCondition, error) {
+	if p.keyword("NOT") {
+		c, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) bool { return !c(item) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *memoryParser) parsePrimary() (memoryCondition, error) {
+	if p.peek(0) == "(" {
+		p.next()
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	switch {
+	case p.function("attribute_exists"), p.function("attribute_not_exists"):
+		exists := strings.EqualFold(p.next(), "attribute_exists")
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		name, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) bool { _, ok := item[name]; return ok == exists }, nil
+	case p.function("attribute_type"):
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		name, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+		t, err := p.attrs.value(p.next())
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) bool {
+			av, ok := item[name]
+			return ok && t.S != nil && memoryAttributeType(av) == *t.S
+		}, nil
+	case p.function("begins_with"):
+		p.next()
+		args, err := p.parseArguments(2)
+		if err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) bool {
+			a, b := args[0](item), args[1](item)
+			switch {
+			case a == nil || b == nil:
+				return false
+			case a.S != nil && b.S != nil:
+				return strings.HasPrefix(*a.S, *b.S)
+			case a.B != nil && b.B != nil:
+				return bytes.HasPrefix(a.B, b.B)
+			}
+			return false
+		}, nil
+	case p.function("contains"):
+		p.next()
+		args, err := p.parseArguments(2)
+		if err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) bool { return memoryContains(args[0](item), args[1](item)) }, nil
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("BETWEEN") {
+		low, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("AND") {
+			return nil, p.syntaxError(p.peek(0))
+		}
+		high, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) bool {
+			v := left(item)
+			c1, ok1 := compareMemoryAttributeValues(low(item), v)
+			c2, ok2 := compareMemoryAttributeValues(v, high(item))
+			return ok1 && ok2 && c1 <= 0 && c2 <= 0
+		}, nil
+	}
+	if p.keyword("IN") {
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		var candidates []memoryOperand
+		for {
+			o, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, o)
+			if p.peek(0) != "," {
+				break
+			}
+			p.next()
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) bool {
+			v := left(item)
+			for _, c := range candidates {
+				if equalMemoryAttributeValues(v, c(item)) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	op := p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryComparison(op, left, right)
+}
+
+func newMemoryComparison(op string, left, right memoryOperand) (memoryCondition, error) {
+	switch op {
+	case "=":
+		return func(item memoryItem) bool { return equalMemoryAttributeValues(left(item), right(item)) }, nil
+	case "<>":
+		return func(item memoryItem) bool {
+			l, r := left(item), right(item)
+			return l != nil && r != nil && !equalMemoryAttributeValues(l, r)
+		}, nil
+	case "<", "<=", ">", ">=":
+		return func(item memoryItem) bool {
+			c, ok := compareMemoryAttributeValues(left(item), right(item))
+			if !ok {
+				return false
+			}
+			switch op {
+			case "<":
+				return c < 0
+			case "<=":
+				return c <= 0
+			case ">":
+				return c > 0
+			}
+			return c >= 0
+		}, nil
+	}
+	return nil, newMemoryValidationError("Invalid expression: Syntax error; token: %q", op)
+}
+
+// parseArguments parses the parenthesised operands of a function.
+func (p *memoryParser) parseArguments(n int) (args []memoryOperand, err error) {
+	if err = p.expect("("); err != nil {
+		return
+	}
+	for {
+		var o memoryOperand
+		o, err = p.parseOperand()
+		if err != nil {
+			return
+		}
+		args = append(args, o)
+		if p.peek(0) != "," {
+			break
+		}
+		p.next()
+	}
+	if err = p.expect(")"); err != nil {
+		return
+	}
+	if len(args) != n {
+		err = newMemoryValidationError("Invalid expression: Incorrect number of operands for operator or function; number of operands: %d", len(args))
+	}
+	return
+}
+
+// parseOperand parses an attribute name, a value, or a call to the size function.
+func (p *memoryParser) parseOperand() (memoryOperand, error) {
+	t := p.peek(0)
+	switch {
+	case strings.HasPrefix(t, ":"):
+		p.next()
+		v, err := p.attrs.value(t)
+		if err != nil {
+			return nil, err
+		}
+		return func(memoryItem) *dynamodb.AttributeValue { return v }, nil
+	case p.function("size"):
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		name, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(item memoryItem) *dynamodb.AttributeValue {
+			n, ok := memoryAttributeSize(item[name])
+			if !ok {
+				return nil
+			}
+			return &dynamodb.AttributeValue{N: aws.String(fmt.Sprint(n))}
+		}, nil
+	}
+	name, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	return func(item memoryItem) *dynamodb.AttributeValue { return item[name] }, nil
+}
+
+// parsePath parses a top-level attribute name. Nested paths aren't used by the stores, so they aren't supported.
+func (p *memoryParser) parsePath() (name string, err error) {
+	t := p.next()
+	switch {
+	case strings.HasPrefix(t, "#"):
+		name, err = p.attrs.name(t)
+	case t == "" || strings.HasPrefix(t, ":") || strings.IndexByte("(),=+-[].<>", t[0]) >= 0:
+		err = p.syntaxError(t)
+	default:
+		name = t
+	}
+	if err != nil {
+		return
+	}
+	if next := p.peek(0); next == "." || next == "[" {
+		err = fmt.Errorf("memoryTable: nested attribute paths are not supported")
+	}
+	return
+}
+
+// parseUpdate parses a whole update expression.
+func (p *memoryParser) parseUpdate() (update memoryUpdate, err error) {
+	sections := make(map[string]bool)
+	paths := make(map[string]bool)
+	claim := func(name string) error {
+		if name == "id" || name == "rng" {
+			return newMemoryValidationError("Cannot update attribute %s. This attribute is part of the key", name)
+		}
+		if paths[name] {
+			return newMemoryValidationError("Invalid UpdateExpression: Two document paths overlap with each other; must remove or rewrite one of these paths; path one: [%s], path two: [%s]", name, name)
+		}
+		paths[name] = true
+		return nil
+	}
+	for !p.done() {
+		section := strings.ToUpper(p.next())
+		if sections[section] {
+			return nil, newMemoryValidationError("Invalid UpdateExpression: The %q section can only be used once in an update expression", section)
+		}
+		sections[section] = true
+		for {
+			name, err := p.parsePath()
+			if err != nil {
+				return nil, err
+			}
+			if err = claim(name); err != nil {
+				return nil, err
+			}
+			var action func(old, updated memoryItem) error
+			switch section {
+			case "SET":
+				if err = p.expect("="); err != nil {
+					return nil, err
+				}
+				value, err := p.parseSetValue()
+				if err != nil {
+					return nil, err
+				}
+				action = func(old, updated memoryItem) error {
+					av, err := value(old)
+					if err != nil {
+						return err
+					}
+					updated[name] = av
+					return nil
+				}
+			case "REMOVE":
+				action = func(old, updated memoryItem) error {
+					delete(updated, name)
+					return nil
+				}
+			case "ADD", "DELETE":
+				t := p.next()
+				if !strings.HasPrefix(t, ":") {
+					return nil, p.syntaxError(t)
+				}
+				value, err := p.attrs.value(t)
+				if err != nil {
+					return nil, err
+				}
+				if section == "ADD" {
+					action = func(old, updated memoryItem) (err error) {
+						updated[name], err = addMemoryAttributeValues(old[name], value)
+						return
+					}
+					break
+				}
+				action = func(old, updated memoryItem) error {
+					av, err := deleteMemoryAttributeValues(old[name], value)
+					if err != nil {
+						return err
+					}
+					if av == nil {
+						delete(updated, name)
+						return nil
+					}
+					updated[name] = av
+					return nil
+				}
+			default:
+				return nil, p.syntaxError(section)
+			}
+			update = append(update, action)
+			if p.peek(0) != "," {
+				break
+			}
+			p.next()
+		}
+	}
+	return
+}
+
+// parseSetValue parses the value of a SET action.
+func (p *memoryParser) parseSetValue() (func(old memoryItem) (*dynamodb.AttributeValue, error), error) {
+	left, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek(0)
+	if op != "+" && op != "-" {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseSetOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(old memoryItem) (*dynamodb.AttributeValue, error) {
+		l, err := left(old)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(old)
+		if err != nil {
+			return nil, err
+		}
+		if l.N == nil || r.N == nil {
+			return nil, newMemoryValidationError("An operand in the update expression has an incorrect data type")
+		}
+		a, b := parseMemoryNumber(*l.N), parseMemoryNumber(*r.N)
+		if op == "+" {
+			return newMemoryNumber(a.Add(a, b)), nil
+		}
+		return newMemoryNumber(a.Sub(a, b)), nil
+	}, nil
+}
+
+func (p *memoryParser) parseSetOperand() (func(old memoryItem) (*dynamodb.AttributeValue, error), error) {
+	switch {
+	case p.function("if_not_exists"):
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		name, err := p.parsePath()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+		value, err := p.parseSetOperand()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		return func(old memoryItem) (*dynamodb.AttributeValue, error) {
+			if av, ok := old[name]; ok {
+				return av, nil
+			}
+			return value(old)
+		}, nil
+	case p.function("list_append"):
+		p.next()
+		args, err := p.parseArguments(2)
+		if err != nil {
+			return nil, err
+		}
+		return func(old memoryItem) (*dynamodb.AttributeValue, error) {
+			a, b := args[0](old), args[1](old)
+			if a == nil || b == nil {
+				return nil, newMemoryValidationError("The provided expression refers to an attribute that does not exist in the item")
+			}
+			if a.L == nil || b.L == nil {
+				return nil, newMemoryValidationError("An operand in the update expression has an incorrect data type")
+			}
+			l := append(append([]*dynamodb.AttributeValue{}, a.L...), b.L...)
+			return &dynamodb.AttributeValue{L: l}, nil
+		}, nil
+	}
+	o, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return func(old memoryItem) (*dynamodb.AttributeValue, error) {
+		av := o(old)
+		if av == nil {
+			return nil, newMemoryValidationError("The provided expression refers to an attribute that does not exist in the item")
+		}
+		return av, nil
+	}, nil
+}
+
+// parseKeyConditions parses a key condition expression, which is one or two conditions joined by AND.
+func (p *memoryParser) parseKeyConditions() (conditions []memoryKeyCondition, err error) {
+	for {
+		var c memoryKeyCondition
+		c, err = p.parseKeyCondition()
+		if err != nil {
+			return
+		}
+		conditions = append(conditions, c)
+		if !p.keyword("AND") {
+			break
+		}
+	}
+	if !p.done() {
+		err = p.syntaxError(p.peek(0))
+	}
+	return
+}
+
+func (p *memoryParser) parseKeyCondition() (c memoryKeyCondition, err error) {
+	if p.peek(0) == "(" {
+		p.next()
+		c, err = p.parseKeyCondition()
+		if err != nil {
+			return
+		}
+		err = p.expect(")")
+		return
+	}
+	if p.function("begins_with") {
+		p.next()
+		if err = p.expect("("); err != nil {
+			return
+		}
+		if c.name, err = p.parsePath(); err != nil {
+			return
+		}
+		if err = p.expect(","); err != nil {
+			return
+		}
+		var prefix *dynamodb.AttributeValue
+		if prefix, err = p.attrs.value(p.next()); err != nil {
+			return
+		}
+		if err = p.expect(")"); err != nil {
+			return
+		}
+		name := c.name
+		c.condition = func(item memoryItem) bool {
+			v := item[name]
+			return v != nil && v.S != nil && prefix.S != nil && strings.HasPrefix(*v.S, *prefix.S)
+		}
+		return
+	}
+	if c.name, err = p.parsePath(); err != nil {
+		return
+	}
+	name := c.name
+	left := func(item memoryItem) *dynamodb.AttributeValue { return item[name] }
+	if p.keyword("BETWEEN") {
+		var low, high *dynamodb.AttributeValue
+		if low, err = p.attrs.value(p.next()); err != nil {
+			return
+		}
+		if !p.keyword("AND") {
+			err = p.syntaxError(p.peek(0))
+			return
+		}
+		if high, err = p.attrs.value(p.next()); err != nil {
+			return
+		}
+		c.condition = func(item memoryItem) bool {
+			c1, ok1 := compareMemoryAttributeValues(low, item[name])
+			c2, ok2 := compareMemoryAttributeValues(item[name], high)
+			return ok1 && ok2 && c1 <= 0 && c2 <= 0
+		}
+		return
+	}
+	op := p.next()
+	var value *dynamodb.AttributeValue
+	if value, err = p.attrs.value(p.next()); err != nil {
+		return
+	}
+	if op == "=" {
+		c.hashKey = value
+	}
+	c.condition, err = newMemoryComparison(op, left, func(memoryItem) *dynamodb.AttributeValue { return value })
+	if op == "<>" {
+		err = newMemoryValidationError("Invalid KeyConditionExpression: Invalid operator used in KeyConditionExpression: <>")
+	}
+	return
+}
+
+// memoryAttributeType returns the DynamoDB type of an attribute value, e.g. "S" or "SS".
+func memoryAttributeType(av *dynamodb.AttributeValue) string {
+	switch {
+	case av.S != nil:
+		return dynamodb.ScalarAttributeTypeS
+	case av.N != nil:
+		return dynamodb.ScalarAttributeTypeN
+	case av.B != nil:
+		return dynamodb.ScalarAttributeTypeB
+	case av.SS != nil:
+		return "SS"
+	case av.NS != nil:
+		return "NS"
+	case av.BS != nil:
+		return "BS"
+	case av.M != nil:
+		return "M"
+	case av.L != nil:
+		return "L"
+	case av.NULL != nil:
+		return "NULL"
+	case av.BOOL != nil:
+		return "BOOL"
+	}
+	return ""
+}
+
+// validateMemoryAttributeValue returns an error if DynamoDB would reject the attribute value.
+func validateMemoryAttributeValue(av *dynamodb.AttributeValue) error {
+	if av == nil {
+		return newMemoryValidationError("Supplied AttributeValue is empty, must contain exactly one of the supported datatypes")
+	}
+	var types int
+	for _, set := range []bool{av.S != nil, av.N != nil, av.B != nil, av.SS != nil, av.NS != nil, av.BS != nil, av.M != nil, av.L != nil, av.NULL != nil, av.BOOL != nil} {
+		if set {
+			types++
+		}
+	}
+	if types != 1 {
+		return newMemoryValidationError("Supplied AttributeValue has more than one datatypes set, must contain exactly one of the supported datatypes")
+	}
+	switch {
+	case av.N != nil:
+		if _, ok := new(big.Rat).SetString(*av.N); !ok {
+			return newMemoryValidationError("A value provided cannot be converted into a number")
+		}
+	case av.SS != nil, av.NS != nil:
+		values := av.SS
+		if av.NS != nil {
+			values = av.NS
+		}
+		if len(values) == 0 {
+			kind := "string"
+			if av.NS != nil {
+				kind = "number"
+			}
+			return newMemoryValidationError("One or more parameter values were invalid: An %s set may not be empty", kind)
+		}
+		seen := make(map[string]bool)
+		for _, v := range values {
+			s := aws.StringValue(v)
+			if av.NS != nil {
+				n, ok := new(big.Rat).SetString(s)
+				if !ok {
+					return newMemoryValidationError("A value provided cannot be converted into a number")
+				}
+				s = n.String()
+			}
+			if seen[s] {
+				return newMemoryValidationError("One or more parameter values were invalid: Input collection contains duplicates")
+			}
+			seen[s] = true
+		}
+	case av.BS != nil:
+		if len(av.BS) == 0 {
+			return newMemoryValidationError("One or more parameter values were invalid: An binary set may not be empty")
+		}
+		seen := make(map[string]bool)
+		for _, b := range av.BS {
+			if seen[string(b)] {
+				return newMemoryValidationError("One or more parameter values were invalid: Input collection contains duplicates")
+			}
+			seen[string(b)] = true
+		}
+	case av.M != nil:
+		for _, v := range av.M {
+			if err := validateMemoryAttributeValue(v); err != nil {
+				return err
+			}
+		}
+	case av.L != nil:
+		for _, v := range av.L {
+			if err := validateMemoryAttributeValue(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseMemoryNumber(s string) *big.Rat {
+	n, _ := new(big.Rat).SetString(s)
+	return n
+}
+
+func newMemoryNumber(n *big.Rat) *dynamodb.AttributeValue {
+	if n.IsInt() {
+		return &dynamodb.AttributeValue{N: aws.String(n.Num().String())}
+	}
+	return &dynamodb.AttributeValue{N: aws.String(strings.TrimRight(n.FloatString(38), "0"))}
+}
+
+// memorySetValues returns the values of a set attribute as strings, normalising numbers.
+func memorySetValues(av *dynamodb.AttributeValue) (values []string) {
+	switch {
+	case av.SS != nil:
+		return aws.StringValueSlice(av.SS)
+	case av.NS != nil:
+		for _, n := range av.NS {
+			values = append(values, parseMemoryNumber(aws.StringValue(n)).String())
+		}
+	case av.BS != nil:
+		for _, b := range av.BS {
+			values = append(values, string(b))
+		}
+	}
+	return
+}
+
+// newMemorySet creates a set attribute of the same type as av from the values. It returns nil if the set is empty,
+// since DynamoDB removes attributes when the last value is deleted from a set.
+func newMemorySet(av *dynamodb.AttributeValue, values map[string]bool) *dynamodb.AttributeValue {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := make([]string, 0, len(values))
+	for v := range values {
+		sorted = append(sorted, v)
+	}
+	sort.Strings(sorted)
+	switch {
+	case av.SS != nil:
+		return &dynamodb.AttributeValue{SS: aws.StringSlice(sorted)}
+	case av.NS != nil:
+		set := &dynamodb.AttributeValue{}
+		for _, v := range sorted {
+			set.NS = append(set.NS, newMemoryNumber(parseMemoryNumber(v)).N)
+		}
+		return set
+	}
+	set := &dynamodb.AttributeValue{}
+	for _, v := range sorted {
+		set.BS = append(set.BS, []byte(v))
+	}
+	return set
+}
+
+func isMemorySet(av *dynamodb.AttributeValue) bool {
+	return av.SS != nil || av.NS != nil || av.BS != nil
+}
+
+// addMemoryAttributeValues is the ADD action of an update expression.
+func addMemoryAttributeValues(existing, value *dynamodb.AttributeValue) (*dynamodb.AttributeValue, error) {
+	if !isMemorySet(value) && value.N == nil {
+		return nil, newMemoryValidationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: ADD, operand type: %s", memoryAttributeType(value))
+	}
+	if existing == nil {
+		return value, nil
+	}
+	if memoryAttributeType(existing) != memoryAttributeType(value) {
+		return nil, newMemoryValidationError("An operand in the update expression has an incorrect data type")
+	}
+	if value.N != nil {
+		a, b := parseMemoryNumber(*existing.N), parseMemoryNumber(*value.N)
+		return newMemoryNumber(a.Add(a, b)), nil
+	}
+	values := make(map[string]bool)
+	for _, v := range memorySetValues(existing) {
+		values[v] = true
+	}
+	for _, v := range memorySetValues(value) {
+		values[v] = true
+	}
+	return newMemorySet(value, values), nil
+}
+
+// deleteMemoryAttributeValues is the DELETE action of an update expression. It returns nil if the set is empty.
+func deleteMemoryAttributeValues(existing, value *dynamodb.AttributeValue) (*dynamodb.AttributeValue, error) {
+	if !isMemorySet(value) {
+		return nil, newMemoryValidationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: DELETE, operand type: %s", memoryAttributeType(value))
+	}
+	if existing == nil {
+		return nil, nil
+	}
+	if memoryAttributeType(existing) != memoryAttributeType(value) {
+		return nil, newMemoryValidationError("An operand in the update expression has an incorrect data type")
+	}
+	values := make(map[string]bool)
+	for _, v := range memorySetValues(existing) {
+		values[v] = true
+	}
+	for _, v := range memorySetValues(value) {
+		delete(values, v)
+	}
+	return newMemorySet(existing, values), nil
+}
+
+// memoryContains is the contains function of a condition expression.
+func memoryContains(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	switch {
+	case a.S != nil && b.S != nil:
+		return strings.Contains(*a.S, *b.S)
+	case a.B != nil && b.B != nil:
+		return bytes.Contains(a.B, b.B)
+	case a.SS != nil && b.S != nil, a.NS != nil && b.N != nil, a.BS != nil && b.B != nil:
+		element := aws.StringValue(b.S)
+		if b.N != nil {
+			element = parseMemoryNumber(*b.N).String()
+		}
+		if b.B != nil {
+			element = string(b.B)
+		}
+		for _, v := range memorySetValues(a) {
+			if v == element {
+				return true
+			}
+		}
+	case a.L != nil:
+		for _, v := range a.L {
+			if equalMemoryAttributeValues(v, b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memoryAttributeSize is the size function of a condition expression.
+func memoryAttributeSize(av *dynamodb.AttributeValue) (int, bool) {
+	switch {
+	case av == nil:
+		return 0, false
+	case av.S != nil:
+		return len(*av.S), true
+	case av.B != nil:
+		return len(av.B), true
+	case isMemorySet(av):
+		return len(memorySetValues(av)), true
+	case av.L != nil:
+		return len(av.L), true
+	case av.M != nil:
+		return len(av.M), true
+	}
+	return 0, false
+}
+
+// compareMemoryAttributeValues orders two scalar values of the same type. It returns false if they can't be
+// compared, in which case DynamoDB treats the comparison as false.
+func compareMemoryAttributeValues(a, b *dynamodb.AttributeValue) (int, bool) {
+	switch {
+	case a == nil || b == nil:
+		return 0, false
+	case a.S != nil && b.S != nil:
+		return strings.Compare(*a.S, *b.S), true
+	case a.N != nil && b.N != nil:
+		return parseMemoryNumber(*a.N).Cmp(parseMemoryNumber(*b.N)), true
+	case a.B != nil && b.B != nil:
+		return bytes.Compare(a.B, b.B), true
+	}
+	return 0, false
+}
+
+// equalMemoryAttributeValues compares values in the same way as the = operator of a condition expression.
+func equalMemoryAttributeValues(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil || memoryAttributeType(a) != memoryAttributeType(b) {
+		return false
+	}
+	switch {
+	case a.S != nil, a.N != nil, a.B != nil:
+		c, _ := compareMemoryAttributeValues(a, b)
+		return c == 0
+	case isMemorySet(a):
+		av, bv := memorySetValues(a), memorySetValues(b)
+		if len(av) != len(bv) {
+			return false
+		}
+		sort.Strings(av)
+		sort.Strings(bv)
+		for i := range av {
+			if av[i] != bv[i] {
+				return false
+			}
+		}
+		return true
+	case a.BOOL != nil:
+		return *a.BOOL == *b.BOOL
+	case a.NULL != nil:
+		return true
+	case a.L != nil:
+		if len(a.L) != len(b.L) {
+			return false
+		}
+		for i := range a.L {
+			if !equalMemoryAttributeValues(a.L[i], b.L[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if len(a.M) != len(b.M) {
+		return false
+	}
+	for k, v := range a.M {
+		if !equalMemoryAttributeValues(v, b.M[k]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// MigrateMemberships updates the membership records written by versions of the stores that didn't record
+// invitations against the Organisation, returning the number of memberships updated. Until it has run, those
+// versions' pending invitations are treated as accepted memberships, and the members they added directly can't
+// be found when they're erased.
+//
+// Each pending invitation is migrated as an expired invitation, because earlier versions didn't issue tokens, so
+// it must be resent with ResendInvite before it can be accepted. Accepted invitations keep the time that they were
+// accepted, and direct members are recorded as accepting when they're migrated. Records that have already been
+// migrated are left unchanged, so MigrateMemberships can be run again if it fails part way through.
+func (store UserStore) MigrateMemberships(ctx context.Context) (migrated int, err error) {
+	filter := expression.Name("typ").In(expression.Value(userOrgnisationRecordName), expression.Value(organisationMemberRecordName))
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		err = fmt.Errorf("userStore.MigrateMemberships: failed to build filter: %v", err)
+		return
+	}
+	var pageErr error
+	err = store.Client.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:                 store.TableName,
+		ConsistentRead:            aws.Bool(true),
+		FilterExpression:          expr.Filter(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var ok bool
+			ok, pageErr = store.migrateMembership(ctx, item)
+			if pageErr != nil {
+				return false
+			}
+			if ok {
+				migrated++
+			}
+		}
+		return true
+	})
+	if err == nil {
+		err = pageErr
+	}
+	if err != nil {
+		err = fmt.Errorf("userStore.MigrateMemberships: %w", err)
+	}
+	return
+}
+
+// migrateMembership migrates the membership that a userOrganisation or organisationGroupMember record belongs to,
+// returning true if it was updated.
+func (store UserStore) migrateMembership(ctx context.Context, item map[string]*dynamodb.AttributeValue) (migrated bool, err error) {
+	var r record
+	err = dynamodbattribute.UnmarshalMap(item, &r)
+	if err != nil {
+		err = fmt.Errorf("failed to convert record: %w", err)
+		return
+	}
+	var items []*dynamodb.TransactWriteItem
+	switch r.RecordType {
+	case userOrgnisationRecordName:
+		var uor userOrganisationRecord
+		err = dynamodbattribute.UnmarshalMap(item, &uor)
+		if err != nil {
+			err = fmt.Errorf("failed to convert userOrganisationRecord: %w", err)
+			return
+		}
+		items, err = store.newInvitationMigration(uor)
+	case organisationMemberRecordName:
+		var omr organisationMemberRecord
+		err = dynamodbattribute.UnmarshalMap(item, &omr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+			return
+		}
+		items, err = store.newDirectMemberMigration(ctx, omr)
+	}
+	if err != nil || len(items) == 0 {
+		return
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if len(failedTransactionConditions(err)) > 0 {
+		// The membership has already been migrated, or was written by this version.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newInvitationMigration creates the updates that record an invitation against the Organisation, given the
+// invitee's userOrganisation record.
+func (store UserStore) newInvitationMigration(uor userOrganisationRecord) (items []*dynamodb.TransactWriteItem, err error) {
+	set := expression.Set(expression.Name("invitedAt"), expression.Value(uor.InvitedAt))
+	if uor.AcceptedAt != nil {
+		set = set.Set(expression.Name("acceptedAt"), expression.Value(uor.AcceptedAt))
+		update, err := store.newMemberMigrationUpdate(uor.OrganisationID, uor.Email, set)
+		if err != nil {
+			return nil, err
+		}
+		return []*dynamodb.TransactWriteItem{{Update: update}}, nil
+	}
+	// The token is discarded, so the invitation must be resent.
+	_, tokenHash, err := newInvitationToken()
+	if err != nil {
+		return
+	}
+	expiresAt := store.Now().Unix()
+	set = set.Set(expression.Name("tokenHash"), expression.Value(tokenHash)).
+		Set(expression.Name("expiresAt"), expression.Value(expiresAt))
+	update, err := store.newMemberMigrationUpdate(uor.OrganisationID, uor.Email, set)
+	if err != nil {
+		return
+	}
+	expr, err := expression.NewBuilder().
+		WithUpdate(incrementVersion(expression.Set(expression.Name("expiresAt"), expression.Value(expiresAt)))).
+		WithCondition(expression.AttributeExists(expression.Name("id"))).
+		Build()
+	if err != nil {
+		err = fmt.Errorf("failed to build userOrganisation update: %v", err)
+		return
+	}
+	items = append(items,
+		&dynamodb.TransactWriteItem{Update: update},
+		&dynamodb.TransactWriteItem{
+			Update: &dynamodb.Update{
+				TableName:                 store.TableName,
+				Key:                       idAndRng(uor.ID, uor.Range),
+				UpdateExpression:          expr.Update(),
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
+		})
+	return
+}
+
+// newDirectMemberMigration creates the writes that record a member's membership against the User, if the member
+// was added directly and has no userOrganisation record.
+func (store UserStore) newDirectMemberMigration(ctx context.Context, omr organisationMemberRecord) (items []*dynamodb.TransactWriteItem, err error) {
+	if omr.InvitedAt != nil || omr.AcceptedAt != nil {
+		return
+	}
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationRecordHashKey(omr.OrganisationID), newOrganisationRecordRangeKey()),
+	})
+	if err != nil || len(gio.Item) == 0 {
+		// Members left behind by a deleted Organisation are skipped.
+		return
+	}
+	var or organisationRecord
+	err = dynamodbattribute.UnmarshalMap(gio.Item, &or)
+	if err != nil {
+		err = fmt.Errorf("failed to convert organisationRecord: %w", err)
+		return
+	}
+	now := store.Now()
+	uorItem, err := dynamodbattribute.MarshalMap(newUserOrganisationRecord(User{ID: omr.Email}, newOrganisationFromRecord(or), now, &now))
+	if err != nil {
+		err = fmt.Errorf("failed to convert userOrganisationRecord: %w", err)
+		return
+	}
+	notExists, err := expression.NewBuilder().WithCondition(expression.AttributeNotExists(expression.Name("id"))).Build()
+	if err != nil {
+		err = fmt.Errorf("failed to build userOrganisation condition: %v", err)
+		return
+	}
+	update, err := store.newMemberMigrationUpdate(omr.OrganisationID, omr.Email, expression.Set(expression.Name("acceptedAt"), expression.Value(now)))
+	if err != nil {
+		return
+	}
+	items = append(items,
+		&dynamodb.TransactWriteItem{Update: update},
+		&dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:                store.TableName,
+				Item:                     uorItem,
+				ConditionExpression:      notExists.Condition(),
+				ExpressionAttributeNames: notExists.Names(),
+			},
+		})
+	return
+}
+
+// newMemberMigrationUpdate creates an update of an organisationGroupMember record, which is only made if the
+// record doesn't already record how the User joined.
+func (store UserStore) newMemberMigrationUpdate(organisationID, userID string, set expression.UpdateBuilder) (*dynamodb.Update, error) {
+	notMigrated := expression.And(
+		expression.AttributeExists(expression.Name("id")),
+		expression.AttributeNotExists(expression.Name("invitedAt")),
+		expression.AttributeNotExists(expression.Name("acceptedAt")),
+		expression.AttributeNotExists(expression.Name("tokenHash")),
+		expression.AttributeNotExists(expression.Name("revokedAt")))
+	expr, err := expression.NewBuilder().
+		WithUpdate(incrementVersion(set)).
+		WithCondition(notMigrated).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build member update: %v", err)
+	}
+	return &dynamodb.Update{
+		TableName:                 store.TableName,
+		Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, nil
+}
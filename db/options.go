@@ -0,0 +1,130 @@
+package db
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// An Option configures the DynamoDB client used by NewUserStore and NewOrganisationStore.
+type Option func(o *options)
+
+type options struct {
+	client  dynamodbiface.DynamoDBAPI
+	session *session.Session
+	config  aws.Config
+}
+
+// WithClient uses an existing DynamoDB client, e.g. one shared between stores, or wrapped with
+// instrumentation. When a client is provided, all other options are ignored.
+func WithClient(client dynamodbiface.DynamoDBAPI) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithSession creates the DynamoDB client from an existing session instead of creating a new one.
+func WithSession(sess *session.Session) Option {
+	return func(o *options) {
+		o.session = sess
+	}
+}
+
+// WithEndpoint sets the DynamoDB endpoint, e.g. "http://localhost:8000" for DynamoDB Local.
+func WithEndpoint(endpoint string) Option {
+	return func(o *options) {
+		o.config.Endpoint = aws.String(endpoint)
+	}
+}
+
+// WithCredentials sets the credentials used to sign requests.
+func WithCredentials(creds *credentials.Credentials) Option {
+	return func(o *options) {
+		o.config.Credentials = creds
+	}
+}
+
+// WithRetryer sets the strategy used to retry failed requests.
+func WithRetryer(retryer request.Retryer) Option {
+	return func(o *options) {
+		request.WithRetryer(&o.config, retryer)
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to make requests.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.config.HTTPClient = client
+	}
+}
+
+// newClient creates a DynamoDB client for the region from the options.
+func newClient(region string, opts []Option) (client dynamodbiface.DynamoDBAPI, err error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.client != nil {
+		return o.client, nil
+	}
+	o.config.Region = aws.String(region)
+	sess := o.session
+	if sess == nil {
+		sess, err = session.NewSession()
+		if err != nil {
+			return
+		}
+	}
+	client = dynamodb.New(sess, &o.config)
+	return
+}
+
+// A DetailsOption configures the OrganisationDetails returned by GetDetails.
+type DetailsOption func(o *detailsOptions)
+
+type detailsOptions struct {
+	includePending bool
+}
+
+// IncludePending lists Users that have been invited but haven't accepted yet in the Organisation and Service
+// groups, as well as in Invitees.
+func IncludePending() DetailsOption {
+	return func(o *detailsOptions) {
+		o.includePending = true
+	}
+}
+
+func newDetailsOptions(opts []DetailsOption) (o detailsOptions) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return
+}
+
+// A GroupOption configures how AddUserToGroups adds a User to groups.
+type GroupOption func(o *groupOptions)
+
+type groupOptions struct {
+	expiresAt time.Time
+}
+
+// Until grants membership of the groups until the expiry time, after which the groups stop applying to the
+// User. The owner group can't be granted temporarily.
+func Until(expiresAt time.Time) GroupOption {
+	return func(o *groupOptions) {
+		o.expiresAt = expiresAt
+	}
+}
+
+func newGroupOptions(opts []GroupOption) (o groupOptions) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type countingClient struct {
+	dynamodbiface.DynamoDBAPI
+	getItemCalls int
+}
+
+func (c *countingClient) GetItemWithContext(ctx context.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	c.getItemCalls++
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func TestWithClient(t *testing.T) {
+	client := &countingClient{}
+	s, err := NewUserStore(region, "table", WithClient(client))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	_, err = s.Get(context.Background(), "test@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound from the empty response, got %v", err)
+	}
+	if client.getItemCalls != 1 {
+		t.Errorf("expected the injected client to be called once, but was called %d times", client.getItemCalls)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	httpClient := &http.Client{}
+	creds := credentials.NewStaticCredentials("id", "secret", "")
+	s, err := NewOrganisationStore(region, "table",
+		WithEndpoint("http://localhost:8000"),
+		WithHTTPClient(httpClient),
+		WithCredentials(creds))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	client, ok := s.Client.(*dynamodb.DynamoDB)
+	if !ok {
+		t.Fatalf("expected a *dynamodb.DynamoDB client, got %T", s.Client)
+	}
+	if client.Endpoint != "http://localhost:8000" {
+		t.Errorf("expected endpoint to be set, got %q", client.Endpoint)
+	}
+	if client.Config.HTTPClient != httpClient {
+		t.Error("expected the HTTP client to be set")
+	}
+	if client.Config.Credentials != creds {
+		t.Error("expected the credentials to be set")
+	}
+	if *client.Config.Region != region {
+		t.Errorf("expected region %q, got %q", region, *client.Config.Region)
+	}
+}
@@ -1,40 +1,47 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"github.com/google/uuid"
 )
 
 // NewOrganisationStore creates a new OrganisationStore.
-func NewOrganisationStore(region, tableName string) (us OrganisationStore, err error) {
-	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+func NewOrganisationStore(region, tableName string, opts ...Option) (us OrganisationStore, err error) {
+	us.Client, err = newClient(region, opts)
 	if err != nil {
 		return
 	}
-	us.Client = dynamodb.New(sess)
 	us.TableName = aws.String(tableName)
 	us.Now = func() time.Time {
 		return time.Now().UTC()
 	}
+	us.InvitationTTL = DefaultInvitationTTL
 	return
 }
 
 // OrganisationStore stores Organisation records in DynamoDB.
 type OrganisationStore struct {
-	Client    *dynamodb.DynamoDB
+	Client    dynamodbiface.DynamoDBAPI
 	TableName *string
 	Now       func() time.Time
+	// InvitationTTL is how long a resent invitation can be accepted for.
+	InvitationTTL time.Duration
+	// Publisher publishes the Events caused by changes. If it's nil, no events are recorded.
+	Publisher Publisher
 }
 
 // Create a new organisation.
-func (store OrganisationStore) Create(owner User, name string) (id string, err error) {
+func (store OrganisationStore) Create(ctx context.Context, owner User, name string) (id string, err error) {
 	// Create the Organisation.
 	id = uuid.New().String()
 	now := store.Now()
@@ -51,9 +58,10 @@ func (store OrganisationStore) Create(owner User, name string) (id string, err e
 		return
 	}
 	putNewOrganisation := &dynamodb.Put{
-		TableName:           store.TableName,
-		Item:                orItem,
-		ConditionExpression: notOverwriteExpr.KeyCondition(),
+		TableName:                store.TableName,
+		Item:                     orItem,
+		ConditionExpression:      notOverwriteExpr.Condition(),
+		ExpressionAttributeNames: notOverwriteExpr.Names(),
 	}
 
 	// Assign ownership.
@@ -72,7 +80,7 @@ func (store OrganisationStore) Create(owner User, name string) (id string, err e
 	userOrganisationRecord := newUserOrganisationRecord(owner, org, now, &now)
 	userOrganisationItem, err := dynamodbattribute.MarshalMap(userOrganisationRecord)
 	if err != nil {
-		err = fmt.Errorf("userStore.Invite: failed to convert userOrganisationRecord: %w", err)
+		err = fmt.Errorf("organisationStore.Create: failed to convert userOrganisationRecord: %w", err)
 		return
 	}
 	putUserOrganisation := &dynamodb.Put{
@@ -80,301 +88,2358 @@ func (store OrganisationStore) Create(owner User, name string) (id string, err e
 		Item:      userOrganisationItem,
 	}
 
-	_, err = store.Client.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
-		TransactItems: []*dynamodb.TransactWriteItem{
-			{Put: putNewOrganisation},
-			{Put: putOrganisationGroupMember},
-			{Put: putUserOrganisation},
+	// Record the owner, so that the Organisation can't be left without one.
+	oor := newOrganisationOwnersRecord(id, []string{owner.ID})
+	oorItem, err := dynamodbattribute.MarshalMap(oor)
+	if err != nil {
+		return
+	}
+	putOrganisationOwners := &dynamodb.Put{
+		TableName: store.TableName,
+		Item:      oorItem,
+	}
+
+	ar, err := newAuditRecord(ctx, now, AuditActionCreateOrganisation, id, owner.ID, nil, &auditOrganisation{Name: name})
+	if err != nil {
+		err = fmt.Errorf("organisationStore.Create: %w", err)
+		return
+	}
+	ar.Actor = actorFromContext(ctx, owner.ID)
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.Create: %w", err)
+		return
+	}
+	created := OrganisationCreated{EventMetadata: newEventMetadata(id, now), Name: name, OwnerID: owner.ID}
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, created)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.Create: %w", err)
+		return
+	}
+
+	items := append([]*dynamodb.TransactWriteItem{
+		{Put: putNewOrganisation},
+		{Put: putOrganisationGroupMember},
+		{Put: putUserOrganisation},
+		{Put: putOrganisationOwners},
+	}, audit...)
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append(items, outbox...),
+	})
+	if len(failedTransactionConditions(err)) > 0 {
+		err = fmt.Errorf("organisationStore.Create: %w", ErrAlreadyExists)
+	}
+	if err != nil {
+		return
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, created)
+	return
+}
+
+// Put an Organisation. The Organisation's Version must match the stored version, otherwise
+// ErrVersionConflict is returned.
+func (store OrganisationStore) Put(ctx context.Context, org Organisation) error {
+	audit, err := store.newPutOrganisationAudit(ctx, org)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Put: %w", err)
+	}
+	ur := newOrganisationRecord(org)
+	item, err := dynamodbattribute.MarshalMap(ur)
+	if err != nil {
+		return err
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(org.Version)).Build()
+	if err != nil {
+		return fmt.Errorf("organisationStore.Put: failed to build condition: %v", err)
+	}
+	items := append([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                 store.TableName,
+				Item:                      item,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
+		},
+	}, audit...)
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.Put: %w", ErrVersionConflict)
+	}
+	return err
+}
+
+// newPutOrganisationAudit creates the audit record of putting the Organisation. The Organisation is read to record
+// its name before the change, so the put must be conditional on its version.
+func (store OrganisationStore) newPutOrganisationAudit(ctx context.Context, org Organisation) (puts []*dynamodb.TransactWriteItem, err error) {
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationRecordHashKey(org.ID), newOrganisationRecordRangeKey()),
+	})
+	if err != nil {
+		return
+	}
+	before, err := newAuditOrganisationFromRecord(gio.Item)
+	if err != nil {
+		return
+	}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionPutOrganisation, org.ID, "", before, &auditOrganisation{Name: org.Name})
+	if err != nil {
+		return
+	}
+	return newAuditPuts(store.TableName, ar)
+}
+
+// Rename an Organisation, and copy the new name to the Organisation's members and invitees. The version must
+// match the stored version of the Organisation, otherwise ErrVersionConflict is returned.
+//
+// When the Organisation has few enough members, the rename is a single transaction. Otherwise, the
+// Organisation is renamed first, and the new name is then copied to each member in turn. If copying fails, an
+// *OrganisationNameSyncError is returned, and SyncOrganisationName can be used to complete the rename.
+func (store OrganisationStore) Rename(ctx context.Context, id, name string, version int) error {
+	userIDs, err := store.getMemberIDs(ctx, id)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Rename: %w", err)
+	}
+	org := newOrganisation(id, name)
+	org.Version = version
+	// The organisation record, the copies of its audit record in the Organisation's and actor's audit logs, and
+	// each member's record.
+	if len(userIDs)+3 <= maxTransactionItems {
+		err = store.renameTransaction(ctx, org, userIDs)
+		if !errors.Is(err, errMembershipNotFound) {
+			return err
+		}
+		// One of the members no longer has a userOrganisation record to update, so the transaction
+		// can't succeed. Fall back to updating each member in turn.
+	}
+	err = store.Put(ctx, org)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Rename: %w", err)
+	}
+	return store.syncOrganisationName(ctx, org, userIDs)
+}
+
+func (store OrganisationStore) renameTransaction(ctx context.Context, org Organisation, userIDs []string) error {
+	item, err := dynamodbattribute.MarshalMap(newOrganisationRecord(org))
+	if err != nil {
+		return fmt.Errorf("organisationStore.Rename: failed to convert organisationRecord: %w", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(org.Version)).Build()
+	if err != nil {
+		return fmt.Errorf("organisationStore.Rename: failed to build condition: %v", err)
+	}
+	audit, err := store.newPutOrganisationAudit(ctx, org)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Rename: %w", err)
+	}
+	items := append([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                 store.TableName,
+				Item:                      item,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
+		},
+	}, audit...)
+	for _, userID := range userIDs {
+		update, err := store.newUserOrganisationNameUpdate(org, userID)
+		if err != nil {
+			return fmt.Errorf("organisationStore.Rename: %w", err)
+		}
+		items = append(items, &dynamodb.TransactWriteItem{Update: update})
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.Rename: %w", ErrVersionConflict)
+	}
+	if len(failedTransactionConditions(err)) > 0 {
+		return errMembershipNotFound
+	}
+	return err
+}
+
+// SyncOrganisationName copies an Organisation's name to each of its members and invitees. It completes a Rename
+// that returned an *OrganisationNameSyncError.
+func (store OrganisationStore) SyncOrganisationName(ctx context.Context, id string) error {
+	org, err := store.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("organisationStore.SyncOrganisationName: %w", err)
+	}
+	userIDs, err := store.getMemberIDs(ctx, id)
+	if err != nil {
+		return fmt.Errorf("organisationStore.SyncOrganisationName: %w", err)
+	}
+	return store.syncOrganisationName(ctx, org, userIDs)
+}
+
+func (store OrganisationStore) syncOrganisationName(ctx context.Context, org Organisation, userIDs []string) error {
+	syncErr := &OrganisationNameSyncError{
+		OrganisationID: org.ID,
+	}
+	for _, userID := range userIDs {
+		update, err := store.newUserOrganisationNameUpdate(org, userID)
+		if err != nil {
+			return fmt.Errorf("organisationStore.syncOrganisationName: %w", err)
+		}
+		_, err = store.Client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 update.TableName,
+			Key:                       update.Key,
+			UpdateExpression:          update.UpdateExpression,
+			ConditionExpression:       update.ConditionExpression,
+			ExpressionAttributeNames:  update.ExpressionAttributeNames,
+			ExpressionAttributeValues: update.ExpressionAttributeValues,
+		})
+		if isConditionalCheckFailed(err) {
+			// The User no longer belongs to the Organisation.
+			continue
+		}
+		if err != nil {
+			syncErr.UserIDs = append(syncErr.UserIDs, userID)
+			if syncErr.Err == nil {
+				syncErr.Err = err
+			}
+		}
+	}
+	if len(syncErr.UserIDs) > 0 {
+		return syncErr
+	}
+	return nil
+}
+
+// newUserOrganisationNameUpdate creates an update that copies the Organisation's name to the User's side of
+// their membership, if they are a member.
+func (store OrganisationStore) newUserOrganisationNameUpdate(org Organisation, userID string) (update *dynamodb.Update, err error) {
+	set := incrementVersion(expression.Set(expression.Name("organisationName"), expression.Value(org.Name)))
+	isMember := expression.AttributeExists(expression.Name("id"))
+	expr, err := expression.NewBuilder().
+		WithUpdate(set).
+		WithCondition(isMember).
+		Build()
+	if err != nil {
+		err = fmt.Errorf("failed to build userOrganisation update: %v", err)
+		return
+	}
+	update = &dynamodb.Update{
+		TableName:                 store.TableName,
+		Key:                       idAndRng(newUserOrganisationRecordHashKey(userID), newUserOrganisationRecordRangeKey(org.ID)),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	return
+}
+
+// getMemberIDs gets the IDs of all of the members and invitees of the Organisation.
+func (store OrganisationStore) getMemberIDs(ctx context.Context, id string) (userIDs []string, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newOrganisationMemberRecordHashKey(id), organisationMemberRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("failed to query members: %v", err)
+		return
+	}
+	return newUserIDsFromOrganisationMemberRecords(items)
+}
+
+func newUserIDsFromOrganisationMemberRecords(items []map[string]*dynamodb.AttributeValue) (userIDs []string, err error) {
+	for _, item := range items {
+		var omr organisationMemberRecord
+		err = dynamodbattribute.UnmarshalMap(item, &omr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+			return
+		}
+		userIDs = append(userIDs, omr.Email)
+	}
+	return
+}
+
+// Get an Organisation.
+func (store OrganisationStore) Get(ctx context.Context, id string) (org Organisation, err error) {
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationRecordHashKey(id), newOrganisationRecordRangeKey()),
+	})
+	if err != nil {
+		return
+	}
+	if len(gio.Item) == 0 {
+		err = fmt.Errorf("organisationStore.Get: %w", ErrOrganisationNotFound)
+		return
+	}
+	var record organisationRecord
+	err = dynamodbattribute.UnmarshalMap(gio.Item, &record)
+	org = newOrganisationFromRecord(record)
+	return
+}
+
+// DeleteProgress reports the progress of deleting an Organisation.
+type DeleteProgress struct {
+	// Deleted is the number of records deleted so far.
+	Deleted int
+	// Total is the number of records to delete.
+	Total int
+}
+
+// Delete an Organisation, its members, services, invitations, Webhooks and their deliveries, and each member's
+// record of belonging to the Organisation. The Organisation's audit log is deleted, apart from the record of
+// deleting the Organisation. The version must match the stored version of the Organisation, otherwise
+// ErrVersionConflict is returned and nothing is deleted. If progress is not nil, it's called after each batch
+// of records is deleted.
+//
+// The deliveries of Webhooks that were deleted before the Organisation aren't found, so they're only removed
+// once they expire, if the table's time to live is enabled on the ttl attribute.
+//
+// The Organisation record is deleted first, along with the version check, so that the Organisation can't be
+// changed once its other records start to be deleted. If Delete fails after that, it can be called again to
+// delete the remaining records, whatever the version.
+func (store OrganisationStore) Delete(ctx context.Context, id string, version int, progress func(DeleteProgress)) error {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newOrganisationRecordHashKey(id), "")
+	if err != nil {
+		return fmt.Errorf("organisationStore.Delete: failed to query records: %w", err)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("organisationStore.Delete: %w", ErrOrganisationNotFound)
+	}
+	keys, err := newOrganisationDeleteKeys(id, items)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Delete: %w", err)
+	}
+	hashKeys, err := newOrganisationDeletePartitions(id, items)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Delete: %w", err)
+	}
+	org, found, err := newOrganisationFromRecords(items)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Delete: %w", err)
+	}
+	// Include the Organisation record, unless an earlier call deleted it.
+	var deleted int
+	if found {
+		err = store.deleteOrganisationRecord(ctx, org, version)
+		if err != nil {
+			return fmt.Errorf("organisationStore.Delete: %w", err)
+		}
+		deleted = 1
+	}
+	for _, hashKey := range hashKeys {
+		partition, err := queryPartition(ctx, store.Client, store.TableName, hashKey, "")
+		if err != nil {
+			return fmt.Errorf("organisationStore.Delete: failed to query records: %w", err)
+		}
+		partitionKeys, err := newOrganisationDeletePartitionKeys(partition)
+		if err != nil {
+			return fmt.Errorf("organisationStore.Delete: %w", err)
+		}
+		keys = append(keys, partitionKeys...)
+	}
+	total := deleted + len(keys)
+	report := func(n int) {
+		if progress != nil {
+			progress(DeleteProgress{Deleted: deleted + n, Total: total})
+		}
+	}
+	if found {
+		report(0)
+	}
+	err = batchDelete(ctx, store.Client, store.TableName, keys, report)
+	if err != nil {
+		return fmt.Errorf("organisationStore.Delete: failed to delete records: %w", err)
+	}
+	return nil
+}
+
+// deleteOrganisationRecord deletes the Organisation record, and records the deletion in the audit log. If the
+// version doesn't match the stored version, ErrVersionConflict is returned.
+func (store OrganisationStore) deleteOrganisationRecord(ctx context.Context, org Organisation, version int) error {
+	if org.Version != version {
+		return ErrVersionConflict
+	}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionDeleteOrganisation, org.ID, "", &auditOrganisation{Name: org.Name}, nil)
+	if err != nil {
+		return err
+	}
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return err
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(version)).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build condition: %v", err)
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName:                 store.TableName,
+					Key:                       idAndRng(newOrganisationRecordHashKey(org.ID), newOrganisationRecordRangeKey()),
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+				},
+			},
+		}, audit...),
+	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+// newOrganisationFromRecords returns the Organisation stored in the Organisation's partition, and whether it was
+// found.
+func newOrganisationFromRecords(items []map[string]*dynamodb.AttributeValue) (org Organisation, found bool, err error) {
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		if r.RecordType != organisationRecordName {
+			continue
+		}
+		var or organisationRecord
+		err = dynamodbattribute.UnmarshalMap(item, &or)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationRecord: %w", err)
+			return
+		}
+		return newOrganisationFromRecord(or), true, nil
+	}
+	return
+}
+
+// newOrganisationDeleteKeys returns the keys of the records to delete along with an Organisation, given the
+// items in the Organisation's partition. The key of the Organisation record itself is not included.
+func newOrganisationDeleteKeys(id string, items []map[string]*dynamodb.AttributeValue) (keys []map[string]*dynamodb.AttributeValue, err error) {
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		switch r.RecordType {
+		case organisationRecordName:
+			continue
+		case organisationMemberRecordName:
+			var omr organisationMemberRecord
+			err = dynamodbattribute.UnmarshalMap(item, &omr)
+			if err != nil {
+				err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+				return
+			}
+			keys = append(keys, idAndRng(newUserOrganisationRecordHashKey(omr.Email), newUserOrganisationRecordRangeKey(id)))
+		}
+		keys = append(keys, idAndRng(r.ID, r.Range))
+	}
+	return
+}
+
+// newOrganisationDeletePartitions returns the hash keys of the partitions, other than the Organisation's own, to
+// delete along with it, given the items in the Organisation's partition: the deliveries of each of its Webhooks,
+// and its audit log.
+func newOrganisationDeletePartitions(id string, items []map[string]*dynamodb.AttributeValue) (hashKeys []string, err error) {
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		if r.RecordType != organisationWebhookRecordName {
+			continue
+		}
+		var owr organisationWebhookRecord
+		err = dynamodbattribute.UnmarshalMap(item, &owr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationWebhookRecord: %w", err)
+			return
+		}
+		hashKeys = append(hashKeys, newWebhookDeliveryRecordHashKey(id, owr.WebhookID))
+	}
+	hashKeys = append(hashKeys, newOrganisationAuditRecordHashKey(id))
+	return
+}
+
+// newOrganisationDeletePartitionKeys returns the keys of the records to delete from one of the other partitions
+// of an Organisation, keeping the record of the Organisation's deletion in its audit log.
+func newOrganisationDeletePartitionKeys(items []map[string]*dynamodb.AttributeValue) (keys []map[string]*dynamodb.AttributeValue, err error) {
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		if r.RecordType == auditRecordName {
+			var ar auditRecord
+			err = dynamodbattribute.UnmarshalMap(item, &ar)
+			if err != nil {
+				err = fmt.Errorf("failed to convert auditRecord: %w", err)
+				return
+			}
+			if AuditAction(ar.Action) == AuditActionDeleteOrganisation {
+				continue
+			}
+		}
+		keys = append(keys, idAndRng(r.ID, r.Range))
+	}
+	return
+}
+
+// newOrganisationExistsCheck creates a check that the Organisation exists, so that records can't be written to
+// Organisations that have been deleted, or never existed.
+func newOrganisationExistsCheck(tableName *string, id string) (*dynamodb.ConditionCheck, error) {
+	exists := expression.AttributeExists(expression.Name("id"))
+	expr, err := expression.NewBuilder().WithCondition(exists).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build organisation condition: %v", err)
+	}
+	return &dynamodb.ConditionCheck{
+		TableName:                tableName,
+		Key:                      idAndRng(newOrganisationRecordHashKey(id), newOrganisationRecordRangeKey()),
+		ConditionExpression:      expr.Condition(),
+		ExpressionAttributeNames: expr.Names(),
+	}, nil
+}
+
+// CreateService creates a new service. If the Organisation doesn't exist, ErrOrganisationNotFound is returned.
+func (store OrganisationStore) CreateService(ctx context.Context, id string, serviceName string) (serviceID string, err error) {
+	serviceID = uuid.New().String()
+	err = store.PutService(ctx, id, serviceID, serviceName, 0)
+	return
+}
+
+// PutService creates a new service or updates an existing service's name. The version must match the stored
+// version of the service, or be zero if the service is new, otherwise ErrVersionConflict is returned. If the
+// Organisation doesn't exist, ErrOrganisationNotFound is returned.
+func (store OrganisationStore) PutService(ctx context.Context, id string, serviceID, serviceName string, version int) (err error) {
+	organisationServiceRecord := newOrganisationServiceRecord(id, serviceID, serviceName)
+	organisationServiceRecord.Version = version + 1
+	item, err := dynamodbattribute.MarshalMap(organisationServiceRecord)
+	if err != nil {
+		return
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(version)).Build()
+	if err != nil {
+		return
+	}
+	var before *auditOrganisation
+	if version > 0 {
+		// If the version doesn't match, the put fails, so the name read is the name being replaced.
+		gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:      store.TableName,
+			ConsistentRead: aws.Bool(true),
+			Key:            idAndRng(newOrganisationServiceRecordHashKey(id), newOrganisationServiceRecordRangeKey(serviceID)),
+		})
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutService: %w", err)
+		}
+		before, err = newAuditOrganisationFromRecord(gio.Item)
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutService: %w", err)
+		}
+	}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionPutService, id, "", before, &auditOrganisation{Name: serviceName})
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutService: %w", err)
+	}
+	ar.ServiceID = serviceID
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutService: %w", err)
+	}
+	var events []Event
+	if version == 0 {
+		events = append(events, ServiceCreated{EventMetadata: newEventMetadata(id, ar.At), ServiceID: serviceID, Name: serviceName})
+	}
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, events...)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutService: %w", err)
+	}
+	checkOrganisationExists, err := newOrganisationExistsCheck(store.TableName, id)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutService: %w", err)
+	}
+	items := append([]*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                 store.TableName,
+				Item:                      item,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
+		},
+		{ConditionCheck: checkOrganisationExists},
+	}, audit...)
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append(items, outbox...),
+	})
+	if failed, _ := failedTransactionCondition(err, 1); failed {
+		return fmt.Errorf("organisationStore.PutService: %w", ErrOrganisationNotFound)
+	}
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.PutService: %w", ErrVersionConflict)
+	}
+	if err != nil {
+		return
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, events...)
+	return
+}
+
+// DeleteService deletes a service from the Organisation, and removes the Organisation's members from the
+// service's groups. If the service doesn't exist, ErrServiceNotFound is returned. If the service changes while
+// it's being deleted, ErrVersionConflict is returned.
+//
+// When few enough members are assigned to the service's groups, the deletion is a single transaction.
+// Otherwise, the service is deleted first, and then each member is removed from its groups in turn. If that
+// fails, a *ServiceCleanupError is returned, and CleanupService can be used to complete the removal.
+func (store OrganisationStore) DeleteService(ctx context.Context, id, serviceID string) (err error) {
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationServiceRecordHashKey(id), newOrganisationServiceRecordRangeKey(serviceID)),
+	})
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteService: %w", err)
+	}
+	if len(gio.Item) == 0 {
+		return fmt.Errorf("organisationStore.DeleteService: %w", ErrServiceNotFound)
+	}
+	var osr organisationServiceRecord
+	err = dynamodbattribute.UnmarshalMap(gio.Item, &osr)
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteService: failed to convert organisationServiceRecord: %w", err)
+	}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionDeleteService, id, "", &auditOrganisation{Name: osr.ServiceName}, nil)
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteService: %w", err)
+	}
+	ar.ServiceID = serviceID
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteService: %w", err)
+	}
+	deleted := ServiceDeleted{EventMetadata: newEventMetadata(id, ar.At), ServiceID: serviceID}
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, deleted)
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteService: %w", err)
+	}
+	assignments, err := store.getServiceAssignments(ctx, id, serviceID)
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteService: %w", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(osr.Version)).Build()
+	if err != nil {
+		return
+	}
+	deleteService := &dynamodb.Delete{
+		TableName:                 store.TableName,
+		Key:                       idAndRng(newOrganisationServiceRecordHashKey(id), newOrganisationServiceRecordRangeKey(serviceID)),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	items := append([]*dynamodb.TransactWriteItem{{Delete: deleteService}}, audit...)
+	items = append(items, outbox...)
+	deleteOnly := len(items)
+	if len(assignments)+len(items) <= maxTransactionItems {
+		for userID, groups := range assignments {
+			update, err := store.newRemoveFromServiceGroupsUpdate(id, userID, serviceID, groups)
+			if err != nil {
+				return fmt.Errorf("organisationStore.DeleteService: %w", err)
+			}
+			items = append(items, &dynamodb.TransactWriteItem{Update: update})
+		}
+		_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		if failed, _ := failedTransactionCondition(err, 0); failed {
+			return fmt.Errorf("organisationStore.DeleteService: %w", ErrVersionConflict)
+		}
+		if len(failedTransactionConditions(err)) == 0 {
+			if err != nil {
+				return err
+			}
+			publishEvents(ctx, store.Client, store.TableName, store.Publisher, deleted)
+			return nil
+		}
+		// A member was removed from the Organisation after the assignments were read, so the transaction
+		// can't succeed. Fall back to removing the assignments one at a time.
+		items = items[:deleteOnly]
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.DeleteService: %w", ErrVersionConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteService: %w", err)
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, deleted)
+	return store.cleanupService(ctx, id, serviceID, assignments)
+}
+
+// CleanupService removes the Organisation's members from the groups of a deleted service. It completes a
+// DeleteService that returned a *ServiceCleanupError.
+func (store OrganisationStore) CleanupService(ctx context.Context, id, serviceID string) error {
+	assignments, err := store.getServiceAssignments(ctx, id, serviceID)
+	if err != nil {
+		return fmt.Errorf("organisationStore.CleanupService: %w", err)
+	}
+	return store.cleanupService(ctx, id, serviceID, assignments)
+}
+
+func (store OrganisationStore) cleanupService(ctx context.Context, id, serviceID string, assignments map[string][]string) error {
+	cleanupErr := &ServiceCleanupError{
+		OrganisationID: id,
+		ServiceID:      serviceID,
+	}
+	for userID, groups := range assignments {
+		update, err := store.newRemoveFromServiceGroupsUpdate(id, userID, serviceID, groups)
+		if err != nil {
+			return fmt.Errorf("organisationStore.cleanupService: %w", err)
+		}
+		_, err = store.Client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 update.TableName,
+			Key:                       update.Key,
+			UpdateExpression:          update.UpdateExpression,
+			ConditionExpression:       update.ConditionExpression,
+			ExpressionAttributeNames:  update.ExpressionAttributeNames,
+			ExpressionAttributeValues: update.ExpressionAttributeValues,
+		})
+		if isConditionalCheckFailed(err) {
+			// The User has been removed from the Organisation.
+			continue
+		}
+		if err != nil {
+			cleanupErr.UserIDs = append(cleanupErr.UserIDs, userID)
+			if cleanupErr.Err == nil {
+				cleanupErr.Err = err
+			}
+		}
+	}
+	if len(cleanupErr.UserIDs) > 0 {
+		sort.Strings(cleanupErr.UserIDs)
+		return cleanupErr
+	}
+	return nil
+}
+
+// newRemoveFromServiceGroupsUpdate creates an update that removes a member from the groups of a service.
+func (store OrganisationStore) newRemoveFromServiceGroupsUpdate(id, userID, serviceID string, groups []string) (update *dynamodb.Update, err error) {
+	gs := newGroupSet(nil, map[string][]string{serviceID: groups})
+	remove := incrementVersion(expression.Delete(expression.Name("groups"), expression.Value(gs)))
+	isMember := expression.AttributeExists(expression.Name("id"))
+	expr, err := expression.NewBuilder().
+		WithUpdate(remove).
+		WithCondition(isMember).
+		Build()
+	if err != nil {
+		err = fmt.Errorf("failed to build service group update: %v", err)
+		return
+	}
+	update = &dynamodb.Update{
+		TableName:                 store.TableName,
+		Key:                       idAndRng(newOrganisationMemberRecordHashKey(id), newOrganisationMemberRecordRangeKey(userID)),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	return
+}
+
+// getServiceAssignments gets a map of the IDs of the Organisation's members that are in the service's groups,
+// to the names of the groups.
+func (store OrganisationStore) getServiceAssignments(ctx context.Context, id, serviceID string) (userIDToGroups map[string][]string, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newOrganisationMemberRecordHashKey(id), organisationMemberRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("failed to query members: %v", err)
+		return
+	}
+	return newServiceAssignmentsFromOrganisationMemberRecords(items, serviceID)
+}
+
+func newServiceAssignmentsFromOrganisationMemberRecords(items []map[string]*dynamodb.AttributeValue, serviceID string) (userIDToGroups map[string][]string, err error) {
+	userIDToGroups = make(map[string][]string)
+	for _, item := range items {
+		var omr organisationMemberRecord
+		err = dynamodbattribute.UnmarshalMap(item, &omr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+			return
+		}
+		if omr.Groups == nil {
+			continue
+		}
+		if groups := omr.Groups.ServiceGroups()[serviceID]; len(groups) > 0 {
+			userIDToGroups[omr.Email] = groups
+		}
+	}
+	return
+}
+
+// GetDetails retrieves all details of an Organisation. Users that have been invited but haven't accepted are
+// listed in Invitees, and are only included in groups if the IncludePending option is used.
+func (store OrganisationStore) GetDetails(ctx context.Context, id string, opts ...DetailsOption) (org OrganisationDetails, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newOrganisationRecordHashKey(id), "")
+	if err != nil {
+		err = fmt.Errorf("organisationStore.GetDetails: failed to query pages: %v", err)
+		return
+	}
+
+	org, err = newOrganisationDetailsFromRecords(items, newDetailsOptions(opts), store.Now())
+	if err != nil {
+		err = fmt.Errorf("organisationStore.GetDetails: failed to create OrganisationDetails: %w", err)
+		return
+	}
+	if org.ID == "" {
+		err = fmt.Errorf("organisationStore.GetDetails: %w", ErrOrganisationNotFound)
+	}
+	return
+}
+
+func newOrganisationDetailsFromRecords(items []map[string]*dynamodb.AttributeValue, opts detailsOptions, now time.Time) (org OrganisationDetails, err error) {
+	serviceIDToService := make(map[string]Service)
+	var serviceIDs []string
+	roles := append([]Role(nil), DefaultRoles...)
+	userIDToUser := make(map[string]User)
+	userIDToGroups := make(map[string]*groupSet)
+	var userIDs []string
+	var grants []organisationGrantRecord
+
+	for _, item := range items {
+		recordType, ok := item["typ"]
+		if !ok || recordType.S == nil {
+			continue
+		}
+		switch *recordType.S {
+		case organisationRecordName:
+			var or organisationRecord
+			err = dynamodbattribute.UnmarshalMap(item, &or)
+			if err != nil {
+				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationRecord: %w", err)
+				return
+			}
+			org.Organisation = newOrganisationFromRecord(or)
+		case organisationMemberRecordName:
+			// Extract the member record details.
+			var omr organisationMemberRecord
+			err = dynamodbattribute.UnmarshalMap(item, &omr)
+			if err != nil {
+				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationMember: %w", err)
+				return
+			}
+			if omr.RevokedAt != nil {
+				// The invitation was revoked, so the user never joined.
+				continue
+			}
+
+			// Extract the user details.
+			var ur userRecord
+			err = dynamodbattribute.UnmarshalMap(item, &ur)
+			if err != nil {
+				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationServiceGroupMemberRecord: %w", err)
+				return
+			}
+			u := newUserFromRecord(ur)
+			if omr.pending() {
+				org.Invitees = append(org.Invitees, newInviteeFromRecord(u, omr))
+				if !opts.includePending {
+					continue
+				}
+			}
+			userIDToUser[u.ID] = u
+
+			// Collate the user groups.
+			userIDToGroups[omr.Email] = omr.Groups
+			userIDs = append(userIDs, omr.Email)
+		case organisationRoleRecordName:
+			var orr organisationRoleRecord
+			err = dynamodbattribute.UnmarshalMap(item, &orr)
+			if err != nil {
+				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationRoleRecord: %w", err)
+				return
+			}
+			roles = append(roles, newRoleFromRecord(orr))
+		case organisationGrantRecordName:
+			var ogr organisationGrantRecord
+			err = dynamodbattribute.UnmarshalMap(item, &ogr)
+			if err != nil {
+				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationGrantRecord: %w", err)
+				return
+			}
+			if ogr.expired(now) {
+				continue
+			}
+			grants = append(grants, ogr)
+		case organisationServiceRecordName:
+			var osr organisationServiceRecord
+			err = dynamodbattribute.UnmarshalMap(item, &osr)
+			if err != nil {
+				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationServiceRecord: %w", err)
+				return
+			}
+			service := serviceIDToService[osr.ServiceID]
+			service.ID = osr.ServiceID
+			service.Name = osr.ServiceName
+			service.Version = osr.Version
+			serviceIDToService[osr.ServiceID] = service
+			serviceIDs = append(serviceIDs, osr.ServiceID)
+		}
+	}
+	// Grants are sorted before the member records, so they can only be matched to their Users once all of the
+	// records have been read.
+	for _, ogr := range grants {
+		if _, ok := userIDToUser[ogr.Email]; !ok {
+			continue
+		}
+		if userIDToGroups[ogr.Email] == nil {
+			userIDToGroups[ogr.Email] = &groupSet{}
+		}
+		ogr.addTo(userIDToGroups[ogr.Email])
+		org.Grants = append(org.Grants, newGrantFromRecord(ogr))
+	}
+	// Now that all of the records have been read, populate the organisation groups and the services.
+	// The records are sorted by range key, so iterating in record order keeps the output stable.
+	for _, userID := range userIDs {
+		user := userIDToUser[userID]
+		groups := userIDToGroups[userID]
+		if groups == nil {
+			// DynamoDB removes the set when the user is removed from their last group.
+			continue
+		}
+		for _, g := range groups.OrganisationGroups() {
+			if org.Groups == nil {
+				org.Groups = make(map[GroupName][]User)
+			}
+			org.Groups[GroupName(g)] = append(org.Groups[GroupName(g)], user)
+		}
+
+		for serviceID, groups := range groups.ServiceGroups() {
+			service, ok := serviceIDToService[serviceID]
+			if !ok {
+				// The service has been deleted, but the assignment hasn't been cleaned up yet.
+				continue
+			}
+			if service.Groups == nil {
+				service.Groups = make(map[GroupName][]User)
+			}
+			for _, g := range groups {
+				service.Groups[GroupName(g)] = append(service.Groups[GroupName(g)], user)
+			}
+			serviceIDToService[serviceID] = service
+		}
+	}
+	// Now copy the services to the organisation.
+	for _, serviceID := range serviceIDs {
+		org.Services = append(org.Services, serviceIDToService[serviceID])
+	}
+	org.Roles = roles
+	return
+}
+
+func newInviteeFromRecord(u User, omr organisationMemberRecord) Invitee {
+	invitee := Invitee{
+		User:      u,
+		InvitedBy: omr.InvitedBy,
+		ExpiresAt: time.Unix(omr.ExpiresAt, 0).UTC(),
+	}
+	if omr.InvitedAt != nil {
+		invitee.InvitedAt = *omr.InvitedAt
+	}
+	if omr.Groups == nil {
+		return invitee
+	}
+	for _, g := range omr.Groups.OrganisationGroups() {
+		invitee.Groups = append(invitee.Groups, GroupName(g))
+	}
+	for serviceID, groups := range omr.Groups.ServiceGroups() {
+		if invitee.ServiceGroups == nil {
+			invitee.ServiceGroups = make(map[string][]GroupName)
+		}
+		for _, g := range groups {
+			invitee.ServiceGroups[serviceID] = append(invitee.ServiceGroups[serviceID], GroupName(g))
+		}
+	}
+	return invitee
+}
+
+// AddUserToOrganisationGroups puts a user into groups within the Organisation. If they already exist, the user is added to the group.
+func (store OrganisationStore) AddUserToOrganisationGroups(ctx context.Context, organisationID string, user User, groups ...string) error {
+	return store.AddUserToGroups(ctx, organisationID, user, groups, nil)
+}
+
+// AddUserToServiceGroups puts a user into groups within an Organisation Service.
+func (store OrganisationStore) AddUserToServiceGroups(ctx context.Context, organisationID string, user User, serviceID string, groups ...string) error {
+	return store.AddUserToGroups(ctx, organisationID, user, nil, map[string][]string{
+		serviceID: groups,
+	})
+}
+
+// AddUserToGroups adds a user to Organisation and Service Groups. Each group must be defined by one of the
+// Organisation's roles, otherwise ErrRoleNotFound is returned. If the Until option is used, the groups are
+// granted temporarily, and ErrInvalidGrant is returned if the grant can't be made. Users who aren't already
+// members become members without an invitation, and the membership is recorded against the User too. Pending
+// invitees can't be added to the owner group until they accept, so ErrNotMember is returned.
+func (store OrganisationStore) AddUserToGroups(ctx context.Context, organisationID string, user User, groups []string, serviceIDToGroups map[string][]string, opts ...GroupOption) error {
+	o := newGroupOptions(opts)
+	if !o.expiresAt.IsZero() {
+		err := validateGrant(groups, o.expiresAt, store.Now())
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+		}
+	}
+	err := checkGroups(ctx, store.Client, store.TableName, organisationID, groups, serviceIDToGroups)
+	if err != nil {
+		return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+	}
+	if !o.expiresAt.IsZero() {
+		return store.grant(ctx, organisationID, user, newGrants(user.ID, groups, serviceIDToGroups, o.expiresAt))
+	}
+	if containsString(groups, GroupOwner) {
+		err = ensureOwners(ctx, store.Client, store.TableName, organisationID)
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+		}
+	}
+	for i := 0; i < maxAuditAttempts; i++ {
+		before, version, err := getMembership(ctx, store.Client, store.TableName, organisationID, user.ID)
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+		}
+		var putMembership *dynamodb.Put
+		if before == nil {
+			putMembership, err = store.newDirectMembershipPut(ctx, organisationID, user)
+			if err != nil {
+				return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+			}
+		}
+		ar, err := newAuditRecord(ctx, store.Now(), AuditActionAddToGroups, organisationID, user.ID, before, before.add(groups, serviceIDToGroups))
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+		}
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+		}
+		changed := GroupsChanged{EventMetadata: newEventMetadata(organisationID, ar.At), UserID: user.ID, AddedGroups: groups, AddedServiceGroups: serviceIDToGroups}
+		outbox, err := newOutboxPuts(store.TableName, store.Publisher, changed)
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+		}
+		condition := versionCondition(version)
+		if containsString(groups, GroupOwner) {
+			// Invitees only become owners when they accept, so that they can't be left as an Organisation's
+			// only owners.
+			condition = condition.And(expression.AttributeNotExists(expression.Name("id")).Or(isAcceptedMember()))
+		}
+		update, err := store.newAddToGroupsUpdate(organisationID, user, newGroupSet(groups, serviceIDToGroups), condition)
+		if err != nil {
+			return err
+		}
+		update.ReturnValuesOnConditionCheckFailure = aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld)
+		items := append([]*dynamodb.TransactWriteItem{{Update: update}}, audit...)
+		items = append(items, outbox...)
+		if containsString(groups, GroupOwner) {
+			addOwner, err := newAddOwnerUpdate(store.TableName, organisationID, user.ID)
+			if err != nil {
+				return err
+			}
+			items = append(items, &dynamodb.TransactWriteItem{Update: addOwner})
+		}
+		if putMembership != nil {
+			items = append(items, &dynamodb.TransactWriteItem{Put: putMembership})
+		}
+		_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: items,
+		})
+		if failed, item := failedTransactionCondition(err, 0); failed {
+			var omr organisationMemberRecord
+			if err = dynamodbattribute.UnmarshalMap(item, &omr); err != nil {
+				return fmt.Errorf("organisationStore.AddUserToGroups: failed to convert organisationMemberRecord: %w", err)
+			}
+			if containsString(groups, GroupOwner) && (omr.pending() || omr.RevokedAt != nil) {
+				return fmt.Errorf("organisationStore.AddUserToGroups: %w", ErrNotMember)
+			}
+			// The membership changed since it was read for the audit log.
+			continue
+		}
+		if failed, _ := failedTransactionCondition(err, len(items)-1); failed && putMembership != nil {
+			// The User was invited since the membership was read.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		publishEvents(ctx, store.Client, store.TableName, store.Publisher, changed)
+		return nil
+	}
+	return fmt.Errorf("organisationStore.AddUserToGroups: %w", ErrVersionConflict)
+}
+
+// grant gives a user temporary membership of groups, creating their membership if required.
+func (store OrganisationStore) grant(ctx context.Context, organisationID string, user User, grants []Grant) error {
+	if len(grants) > maxGrants {
+		return fmt.Errorf("organisationStore.AddUserToGroups: can't grant more than %d groups at once: %w", maxGrants, ErrInvalidGrant)
+	}
+	before, _, err := getMembership(ctx, store.Client, store.TableName, organisationID, user.ID)
+	if err != nil {
+		return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+	}
+	var putMembership *dynamodb.Put
+	if before == nil {
+		putMembership, err = store.newDirectMembershipPut(ctx, organisationID, user)
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+		}
+	}
+	granted := newAuditGrant(grants)
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionGrant, organisationID, user.ID, nil, granted)
+	if err != nil {
+		return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+	}
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+	}
+	changed := newGrantedGroupsChanged(organisationID, user.ID, granted, ar.At)
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, changed)
+	if err != nil {
+		return fmt.Errorf("organisationStore.AddUserToGroups: %w", err)
+	}
+	update, err := store.newAddToGroupsUpdate(organisationID, user, nil)
+	if err != nil {
+		return err
+	}
+	items := append([]*dynamodb.TransactWriteItem{{Update: update}}, audit...)
+	items = append(items, outbox...)
+	for _, g := range grants {
+		item, err := dynamodbattribute.MarshalMap(newOrganisationGrantRecord(organisationID, g))
+		if err != nil {
+			return fmt.Errorf("organisationStore.AddUserToGroups: failed to convert organisationGrantRecord: %w", err)
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: store.TableName,
+				Item:      item,
+			},
+		})
+	}
+	if putMembership != nil {
+		items = append(items, &dynamodb.TransactWriteItem{Put: putMembership})
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if failed, _ := failedTransactionCondition(err, len(items)-1); failed && putMembership != nil {
+		// The User was invited since the membership was read.
+		return fmt.Errorf("organisationStore.AddUserToGroups: %w", ErrVersionConflict)
+	}
+	if err != nil {
+		return err
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, changed)
+	return nil
+}
+
+// newDirectMembershipPut creates the put of the record of a User belonging to the Organisation, for Users who are
+// added to its groups without being invited, so that the membership is found from the User like any other. The
+// put fails if the User has been invited since. If the Organisation doesn't exist, ErrOrganisationNotFound is
+// returned.
+func (store OrganisationStore) newDirectMembershipPut(ctx context.Context, organisationID string, user User) (*dynamodb.Put, error) {
+	org, err := store.Get(ctx, organisationID)
+	if err != nil {
+		return nil, err
+	}
+	now := store.Now()
+	item, err := dynamodbattribute.MarshalMap(newUserOrganisationRecord(user, org, now, &now))
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert userOrganisationRecord: %w", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(expression.AttributeNotExists(expression.Name("id"))).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build userOrganisation condition: %v", err)
+	}
+	return &dynamodb.Put{
+		TableName:                store.TableName,
+		Item:                     item,
+		ConditionExpression:      expr.Condition(),
+		ExpressionAttributeNames: expr.Names(),
+	}, nil
+}
+
+// getMembership reads the state of a user's membership for the audit log, and the version of their
+// organisationGroupMember record. If the user isn't a member, the membership is nil and the version is zero.
+func getMembership(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID, userID string) (am *auditMembership, version int, err error) {
+	gio, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      tableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+	})
+	if err != nil {
+		return
+	}
+	return newAuditMembershipFromRecord(gio.Item)
+}
+
+// newAddToGroupsUpdate creates an update that adds a user to groups, creating their membership if required. If
+// gs is empty, only the membership is created. If a condition is passed, the update is conditional on it.
+func (store OrganisationStore) newAddToGroupsUpdate(organisationID string, user User, gs *groupSet, conditions ...expression.ConditionBuilder) (*dynamodb.Update, error) {
+	update := expression.
+		Set(expression.Name("typ"), expression.Value(organisationMemberRecordName)).
+		Add(expression.Name("v"), expression.Value(1)).
+		Set(expression.Name("organisationId"), expression.Value(organisationID)).
+		Set(expression.Name("email"), expression.Value(user.ID)).
+		Set(expression.Name("firstName"), expression.Value(user.FirstName)).
+		Set(expression.Name("lastName"), expression.Value(user.LastName)).
+		Set(expression.Name("phone"), expression.Value(user.Phone)).
+		Set(expression.Name("createdAt"), expression.Value(user.CreatedAt))
+	if !gs.empty() {
+		update = update.Add(expression.Name("groups"), expression.Value(gs))
+	}
+	builder := expression.NewBuilder().WithUpdate(update)
+	for _, condition := range conditions {
+		builder = builder.WithCondition(condition)
+	}
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &dynamodb.Update{
+		TableName:                 store.TableName,
+		Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(user.ID)),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		UpdateExpression:          expr.Update(),
+	}, nil
+}
+
+// RemoveUserFromOrganisationGroups removes a user from a set of Organisation level groups.
+func (store OrganisationStore) RemoveUserFromOrganisationGroups(ctx context.Context, organisationID, userID string, groups ...string) error {
+	return store.RemoveUserFromGroups(ctx, organisationID, userID, groups, nil)
+}
+
+// RemoveUserFromServiceGroups removes a user from a set of Service-level groups.
+func (store OrganisationStore) RemoveUserFromServiceGroups(ctx context.Context, organisationID, userID, serviceID string, groups ...string) error {
+	return store.RemoveUserFromGroups(ctx, organisationID, userID, nil, map[string][]string{
+		serviceID: groups,
+	})
+}
+
+// RemoveUserFromGroups removes a user from Organisation and Service groups, including temporary grants of the
+// groups. Removing a user that isn't a member of the Organisation has no effect. If the user is the Organisation's last owner and would be removed from the
+// owner group, a *LastOwnerError is returned, and no groups are changed. If the membership keeps changing while
+// it's being updated, ErrVersionConflict is returned.
+func (store OrganisationStore) RemoveUserFromGroups(ctx context.Context, organisationID, userID string, groups []string, serviceIDToGroups map[string][]string) error {
+	gs := newGroupSet(groups, serviceIDToGroups)
+	if gs.empty() {
+		return nil
+	}
+	for i := 0; i < maxAuditAttempts; i++ {
+		before, version, err := getMembership(ctx, store.Client, store.TableName, organisationID, userID)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUserFromGroups: %w", err)
+		}
+		if before == nil {
+			return nil
+		}
+		ar, err := newAuditRecord(ctx, store.Now(), AuditActionRemoveFromGroups, organisationID, userID, before, before.remove(groups, serviceIDToGroups))
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUserFromGroups: %w", err)
+		}
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUserFromGroups: %w", err)
+		}
+		changed := GroupsChanged{EventMetadata: newEventMetadata(organisationID, ar.At), UserID: userID, RemovedGroups: groups, RemovedServiceGroups: serviceIDToGroups}
+		outbox, err := newOutboxPuts(store.TableName, store.Publisher, changed)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUserFromGroups: %w", err)
+		}
+		update := incrementVersion(expression.Delete(expression.Name("groups"), expression.Value(gs)))
+		expr, err := expression.NewBuilder().
+			WithUpdate(update).
+			WithCondition(versionCondition(version)).
+			Build()
+		if err != nil {
+			return err
+		}
+		removeFromGroups := &dynamodb.Update{
+			TableName:                 store.TableName,
+			Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			UpdateExpression:          expr.Update(),
+		}
+		deleteGrants, err := newDeleteGrants(ctx, store.Client, store.TableName, organisationID, userID, gs)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUserFromGroups: %w", err)
+		}
+		items := append([]*dynamodb.TransactWriteItem{{Update: removeFromGroups}}, deleteGrants...)
+		items = append(items, audit...)
+		items = append(items, outbox...)
+		if !containsString(groups, GroupOwner) {
+			_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+				TransactItems: items,
+			})
+			if failed, _ := failedTransactionCondition(err, 0); failed {
+				// The membership changed since it was read for the audit log.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			publishEvents(ctx, store.Client, store.TableName, store.Publisher, changed)
+			return nil
+		}
+		err = store.removeOwner(ctx, organisationID, userID, items...)
+		if errors.Is(err, errMembershipNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUserFromGroups: %w", err)
+		}
+		publishEvents(ctx, store.Client, store.TableName, store.Publisher, changed)
+		return nil
+	}
+	return fmt.Errorf("organisationStore.RemoveUserFromGroups: %w", ErrVersionConflict)
+}
+
+// RemoveUser from the Organisation. The user's membership, grants and their record of belonging to the
+// Organisation are deleted in a single transaction. If the user is the Organisation's last owner, a *LastOwnerError is
+// returned.
+func (store OrganisationStore) RemoveUser(ctx context.Context, organisationID string, userID string) error {
+	removeUserOrganisation := &dynamodb.Delete{
+		TableName: store.TableName,
+		Key:       idAndRng(newUserOrganisationRecordHashKey(userID), newUserOrganisationRecordRangeKey(organisationID)),
+	}
+	for i := 0; i < maxAuditAttempts; i++ {
+		before, version, err := getMembership(ctx, store.Client, store.TableName, organisationID, userID)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUser: %w", err)
+		}
+		if before == nil {
+			// Remove any record of belonging to the Organisation left behind by an earlier removal.
+			_, err = store.Client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+				TableName: removeUserOrganisation.TableName,
+				Key:       removeUserOrganisation.Key,
+			})
+			if err != nil {
+				return fmt.Errorf("organisationStore.RemoveUser: %w", err)
+			}
+			return nil
+		}
+		ar, err := newAuditRecord(ctx, store.Now(), AuditActionRemoveUser, organisationID, userID, before, nil)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUser: %w", err)
+		}
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUser: %w", err)
+		}
+		removed := MemberRemoved{EventMetadata: newEventMetadata(organisationID, ar.At), UserID: userID}
+		outbox, err := newOutboxPuts(store.TableName, store.Publisher, removed)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUser: %w", err)
+		}
+		expr, err := expression.NewBuilder().WithCondition(versionCondition(version)).Build()
+		if err != nil {
+			return err
+		}
+		removeMember := &dynamodb.Delete{
+			TableName:                 store.TableName,
+			Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}
+		deleteGrants, err := newDeleteGrants(ctx, store.Client, store.TableName, organisationID, userID, nil)
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUser: %w", err)
+		}
+		items := append([]*dynamodb.TransactWriteItem{
+			{Delete: removeMember},
+			{Delete: removeUserOrganisation},
+		}, deleteGrants...)
+		items = append(items, audit...)
+		items = append(items, outbox...)
+		err = store.removeOwner(ctx, organisationID, userID, items...)
+		if errors.Is(err, errMembershipNotFound) {
+			// The membership changed since it was read for the audit log.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("organisationStore.RemoveUser: %w", err)
+		}
+		publishEvents(ctx, store.Client, store.TableName, store.Publisher, removed)
+		return nil
+	}
+	return fmt.Errorf("organisationStore.RemoveUser: %w", ErrVersionConflict)
+}
+
+// SweepExpiredGrants deletes the Organisation's grants that have expired, and returns how many were deleted.
+// Expired grants stop applying as soon as they expire, so sweeping is only needed to remove the records from tables
+// that don't have time to live enabled on the ttl attribute.
+//
+// Each grant is deleted in a transaction with its audit record. Grants deleted by the table's time to live aren't
+// recorded in the audit log.
+func (store OrganisationStore) SweepExpiredGrants(ctx context.Context, organisationID string) (deleted int, err error) {
+	now := store.Now()
+	items, err := queryPartition(ctx, store.Client, store.TableName, newOrganisationGrantRecordHashKey(organisationID), organisationGrantRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("organisationStore.SweepExpiredGrants: failed to query grants: %w", err)
+		return
+	}
+	expired, err := newExpiredGrantRecords(items, now)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.SweepExpiredGrants: %w", err)
+		return
+	}
+	for _, ogr := range expired {
+		// The grant may have been renewed since it was read.
+		stillExpired := expression.Name("ttl").LessThanEqual(expression.Value(now.Unix()))
+		expr, err := expression.NewBuilder().WithCondition(stillExpired).Build()
+		if err != nil {
+			return deleted, fmt.Errorf("organisationStore.SweepExpiredGrants: failed to build condition: %v", err)
+		}
+		ar, err := newAuditRecord(ctx, now, AuditActionExpireGrant, organisationID, ogr.Email, newAuditGrant([]Grant{newGrantFromRecord(ogr)}), nil)
+		if err != nil {
+			return deleted, fmt.Errorf("organisationStore.SweepExpiredGrants: %w", err)
+		}
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return deleted, fmt.Errorf("organisationStore.SweepExpiredGrants: %w", err)
+		}
+		_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]*dynamodb.TransactWriteItem{
+				{
+					Delete: &dynamodb.Delete{
+						TableName:                 store.TableName,
+						Key:                       idAndRng(ogr.ID, ogr.Range),
+						ConditionExpression:       expr.Condition(),
+						ExpressionAttributeNames:  expr.Names(),
+						ExpressionAttributeValues: expr.Values(),
+					},
+				},
+			}, audit...),
+		})
+		if failed, _ := failedTransactionCondition(err, 0); failed {
+			continue
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("organisationStore.SweepExpiredGrants: %w", err)
+		}
+		deleted++
+	}
+	return
+}
+
+// removeOwner removes a user from the Organisation's owners in the same transaction as the changes to their
+// membership. The first change must be conditional on the membership existing. If it doesn't exist, nothing is
+// changed, and errMembershipNotFound is returned. If the user is the last owner, a *LastOwnerError is returned.
+func (store OrganisationStore) removeOwner(ctx context.Context, organisationID, userID string, membershipChanges ...*dynamodb.TransactWriteItem) error {
+	err := ensureOwners(ctx, store.Client, store.TableName, organisationID)
+	if err != nil {
+		return err
+	}
+	removeOwner, err := newRemoveOwnerUpdate(store.TableName, organisationID, userID)
+	if err != nil {
+		return err
+	}
+	items := append(membershipChanges, &dynamodb.TransactWriteItem{Update: removeOwner})
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return errMembershipNotFound
+	}
+	if failed, _ := failedTransactionCondition(err, len(items)-1); failed {
+		return &LastOwnerError{UserID: userID, OrganisationIDs: []string{organisationID}}
+	}
+	return err
+}
+
+// TransferOwnership makes a user an owner of the Organisation, and removes the current owner from the owner
+// group, in a single transaction. If from is not an owner, ErrNotOwner is returned. If to is not a member, or
+// hasn't accepted their invitation, ErrNotMember is returned. If the Organisation's owners change during the
+// transfer, ErrVersionConflict is returned.
+func (store OrganisationStore) TransferOwnership(ctx context.Context, organisationID, from string, to User) error {
+	if from == to.ID {
+		return nil
+	}
+	err := ensureOwners(ctx, store.Client, store.TableName, organisationID)
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", err)
+	}
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationOwnersRecordHashKey(organisationID), newOrganisationOwnersRecordRangeKey()),
+	})
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", err)
+	}
+	var oor organisationOwnersRecord
+	err = dynamodbattribute.UnmarshalMap(gio.Item, &oor)
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: failed to convert organisationOwnersRecord: %w", err)
+	}
+	owners, ok := transferOwnership(oor.Owners, from, to.ID)
+	if !ok {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", ErrNotOwner)
+	}
+
+	setOwners := incrementVersion(expression.Set(expression.Name("owners"), expression.Value(stringSet(owners))))
+	ownersExpr, err := expression.NewBuilder().
+		WithUpdate(setOwners).
+		WithCondition(versionCondition(oor.Version)).
+		Build()
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: failed to build owners update: %v", err)
+	}
+	addToOwnerGroup, err := store.newAddToGroupsUpdate(organisationID, to, newGroupSet([]string{GroupOwner}, nil), isAcceptedMember())
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: failed to build owner group update: %v", err)
+	}
+	removeFromOwnerGroup := incrementVersion(expression.Delete(expression.Name("groups"), expression.Value(newGroupSet([]string{GroupOwner}, nil))))
+	removeExpr, err := expression.NewBuilder().
+		WithUpdate(removeFromOwnerGroup).
+		WithCondition(expression.AttributeExists(expression.Name("id"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: failed to build owner group update: %v", err)
+	}
+	before := &auditOwners{Owners: append([]string{}, oor.Owners...)}
+	sort.Strings(before.Owners)
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionTransferOwnership, organisationID, to.ID, before, &auditOwners{Owners: owners})
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", err)
+	}
+	ar.Actor = actorFromContext(ctx, from)
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", err)
+	}
+	changed := newTransferOwnershipGroupsChanged(organisationID, from, to.ID, before.Owners, ar.At)
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, changed...)
+	if err != nil {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", err)
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName:                 store.TableName,
+					Key:                       idAndRng(newOrganisationOwnersRecordHashKey(organisationID), newOrganisationOwnersRecordRangeKey()),
+					UpdateExpression:          ownersExpr.Update(),
+					ConditionExpression:       ownersExpr.Condition(),
+					ExpressionAttributeNames:  ownersExpr.Names(),
+					ExpressionAttributeValues: ownersExpr.Values(),
+				},
+			},
+			{Update: addToOwnerGroup},
+			{
+				Update: &dynamodb.Update{
+					TableName:                 store.TableName,
+					Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(from)),
+					UpdateExpression:          removeExpr.Update(),
+					ConditionExpression:       removeExpr.Condition(),
+					ExpressionAttributeNames:  removeExpr.Names(),
+					ExpressionAttributeValues: removeExpr.Values(),
+				},
+			},
+		}, append(audit, outbox...)...),
+	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", ErrVersionConflict)
+	}
+	if failed, _ := failedTransactionCondition(err, 1); failed {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", ErrNotMember)
+	}
+	if failed, _ := failedTransactionCondition(err, 2); failed {
+		return fmt.Errorf("organisationStore.TransferOwnership: %w", ErrNotOwner)
+	}
+	if err != nil {
+		return err
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, changed...)
+	return nil
+}
+
+// newTransferOwnershipGroupsChanged creates the GroupsChanged events of transferring ownership from one user to
+// another. If to is already an owner, their groups don't change.
+func newTransferOwnershipGroupsChanged(organisationID, from, to string, owners []string, now time.Time) (events []Event) {
+	events = append(events, GroupsChanged{
+		EventMetadata: newEventMetadata(organisationID, now),
+		UserID:        from,
+		RemovedGroups: []string{GroupOwner},
+	})
+	if !containsString(owners, to) {
+		events = append(events, GroupsChanged{
+			EventMetadata: newEventMetadata(organisationID, now),
+			UserID:        to,
+			AddedGroups:   []string{GroupOwner},
+		})
+	}
+	return
+}
+
+// transferOwnership returns the owners after ownership is transferred, or false if from is not an owner.
+func transferOwnership(owners []string, from, to string) (transferred []string, ok bool) {
+	for _, o := range owners {
+		if o == from {
+			ok = true
+			continue
+		}
+		if o != to {
+			transferred = append(transferred, o)
+		}
+	}
+	transferred = append(transferred, to)
+	sort.Strings(transferred)
+	return
+}
+
+// ensureOwners creates the Organisation's owners record from the accepted members of the owner group, if it doesn't
+// exist. Organisations created before owners were recorded don't have one, and the updates that remove owners fail
+// until it's created. If the Organisation doesn't exist, nothing is created.
+func ensureOwners(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID string) error {
+	gio, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      tableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationOwnersRecordHashKey(organisationID), newOrganisationOwnersRecordRangeKey()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get owners: %w", err)
+	}
+	if len(gio.Item) > 0 {
+		return nil
+	}
+	items, err := queryPartition(ctx, client, tableName, newOrganisationRecordHashKey(organisationID), "")
+	if err != nil {
+		return fmt.Errorf("failed to query organisation: %w", err)
+	}
+	exists, owners, err := newOwnersFromOrganisationRecords(items)
+	if err != nil || !exists {
+		return err
+	}
+	item, err := dynamodbattribute.MarshalMap(newOrganisationOwnersRecord(organisationID, owners))
+	if err != nil {
+		return fmt.Errorf("failed to convert organisationOwnersRecord: %w", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(0)).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build owners condition: %v", err)
+	}
+	_, err = client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 tableName,
+		Item:                      item,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if isConditionalCheckFailed(err) {
+		// The owners were created concurrently.
+		return nil
+	}
+	return err
+}
+
+// newOwnersFromOrganisationRecords returns whether the Organisation exists, and the accepted members of its owner
+// group, given the items in the Organisation's partition.
+func newOwnersFromOrganisationRecords(items []map[string]*dynamodb.AttributeValue) (exists bool, owners []string, err error) {
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		switch r.RecordType {
+		case organisationRecordName:
+			exists = true
+		case organisationMemberRecordName:
+			var groups []string
+			groups, _, err = newUserGroupsFromMemberRecord(item)
+			if errors.Is(err, ErrNotMember) {
+				err = nil
+				continue
+			}
+			if err != nil {
+				return
+			}
+			if containsString(groups, GroupOwner) {
+				var omr organisationMemberRecord
+				err = dynamodbattribute.UnmarshalMap(item, &omr)
+				if err != nil {
+					err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+					return
+				}
+				owners = append(owners, omr.Email)
+			}
+		}
+	}
+	sort.Strings(owners)
+	return
+}
+
+// newAddOwnerUpdate creates an update that adds a user to the Organisation's owners.
+func newAddOwnerUpdate(tableName *string, organisationID, userID string) (*dynamodb.Update, error) {
+	update := incrementVersion(expression.
+		Set(expression.Name("typ"), expression.Value(organisationOwnersRecordName)).
+		Set(expression.Name("organisationId"), expression.Value(organisationID)).
+		Add(expression.Name("owners"), expression.Value(stringSet{userID})))
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build owners update: %v", err)
+	}
+	return &dynamodb.Update{
+		TableName:                 tableName,
+		Key:                       idAndRng(newOrganisationOwnersRecordHashKey(organisationID), newOrganisationOwnersRecordRangeKey()),
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, nil
+}
+
+// newRemoveOwnerUpdate creates an update that removes a user from the Organisation's owners. Its condition fails
+// if the user is the last owner, or if the owners record doesn't exist, so call ensureOwners first.
+func newRemoveOwnerUpdate(tableName *string, organisationID, userID string) (*dynamodb.Update, error) {
+	update := incrementVersion(expression.
+		Set(expression.Name("typ"), expression.Value(organisationOwnersRecordName)).
+		Set(expression.Name("organisationId"), expression.Value(organisationID)).
+		Delete(expression.Name("owners"), expression.Value(stringSet{userID})))
+	notLastOwner := expression.AttributeExists(expression.Name("id")).And(expression.Or(
+		expression.Not(expression.Name("owners").Contains(userID)),
+		expression.Name("owners").Size().GreaterThan(expression.Value(1))))
+	expr, err := expression.NewBuilder().
+		WithUpdate(update).
+		WithCondition(notLastOwner).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build owners update: %v", err)
+	}
+	return &dynamodb.Update{
+		TableName:                 tableName,
+		Key:                       idAndRng(newOrganisationOwnersRecordHashKey(organisationID), newOrganisationOwnersRecordRangeKey()),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PutRole creates a role (version zero) or updates an existing role's description, permissions and subgroups.
+// Returns ErrOrganisationNotFound if the Organisation doesn't exist, ErrVersionConflict if the version is stale,
+// ErrBuiltInRole if the role is one of the DefaultRoles, ErrInvalidRole if the name or scope is invalid,
+// ErrRoleNotFound if a subgroup isn't defined, or ErrGroupCycle if the role would contain itself.
+//
+// The versions of the nested roles are checked in the same transaction, so that concurrent updates can't
+// create a cycle.
+func (store OrganisationStore) PutRole(ctx context.Context, id string, role Role) error {
+	err := validateRole(role)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutRole: %w", err)
+	}
+	var descendants []Role
+	if len(role.Subgroups) > 0 {
+		roles, err := getRoles(ctx, store.Client, store.TableName, id)
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutRole: %w", err)
+		}
+		descendants, err = newSubgroupDescendants(roles, role)
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutRole: %w", err)
+		}
+	}
+	organisationRoleRecord := newOrganisationRoleRecord(id, role)
+	organisationRoleRecord.Version = role.Version + 1
+	item, err := dynamodbattribute.MarshalMap(organisationRoleRecord)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutRole: failed to convert organisationRoleRecord: %w", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(role.Version)).Build()
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutRole: failed to build condition: %v", err)
+	}
+	var before *auditRole
+	if role.Version > 0 {
+		// If the version doesn't match, the put fails, so the role read is the role being replaced.
+		gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:      store.TableName,
+			ConsistentRead: aws.Bool(true),
+			Key:            idAndRng(newOrganisationRoleRecordHashKey(id), newOrganisationRoleRecordRangeKey(role.Scope, role.Name)),
+		})
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutRole: %w", err)
+		}
+		before, _, err = newAuditRoleFromRecord(gio.Item)
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutRole: %w", err)
+		}
+	}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionPutRole, id, "", before, newAuditRole(role))
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutRole: %w", err)
+	}
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutRole: %w", err)
+	}
+	checkOrganisationExists, err := newOrganisationExistsCheck(store.TableName, id)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutRole: %w", err)
+	}
+	items := append([]*dynamodb.TransactWriteItem{
+		{ConditionCheck: checkOrganisationExists},
+		{
+			Put: &dynamodb.Put{
+				TableName:                 store.TableName,
+				Item:                      item,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			},
 		},
+	}, audit...)
+	for _, d := range descendants {
+		if d.Version == 0 {
+			// The DefaultRoles can't change.
+			continue
+		}
+		if len(items) == maxTransactionItems {
+			return fmt.Errorf("organisationStore.PutRole: more than %d nested groups: %w", maxTransactionItems-len(audit)-2, ErrInvalidRole)
+		}
+		unchanged, err := expression.NewBuilder().WithCondition(versionCondition(d.Version)).Build()
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutRole: failed to build subgroup condition: %v", err)
+		}
+		items = append(items, &dynamodb.TransactWriteItem{
+			ConditionCheck: &dynamodb.ConditionCheck{
+				TableName:                 store.TableName,
+				Key:                       idAndRng(newOrganisationRoleRecordHashKey(id), newOrganisationRoleRecordRangeKey(d.Scope, d.Name)),
+				ConditionExpression:       unchanged.Condition(),
+				ExpressionAttributeNames:  unchanged.Names(),
+				ExpressionAttributeValues: unchanged.Values(),
+			},
+		})
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.PutRole: %w", ErrOrganisationNotFound)
+	}
+	if len(failedTransactionConditions(err)) > 0 {
+		return fmt.Errorf("organisationStore.PutRole: %w", ErrVersionConflict)
+	}
+	return err
+}
+
+// DeleteRole deletes one of the Organisation's roles, or returns ErrRoleNotFound. Members of the role's group
+// keep their membership, but no more Users can be added to it. The DefaultRoles can't be deleted.
+func (store OrganisationStore) DeleteRole(ctx context.Context, id string, scope RoleScope, name GroupName) error {
+	if isDefaultRole(scope, name) {
+		return fmt.Errorf("organisationStore.DeleteRole: %w", ErrBuiltInRole)
+	}
+	key := idAndRng(newOrganisationRoleRecordHashKey(id), newOrganisationRoleRecordRangeKey(scope, name))
+	err := store.deleteAudited(ctx, key, ErrRoleNotFound, func(item map[string]*dynamodb.AttributeValue) (ar AuditRecord, version int, err error) {
+		before, version, err := newAuditRoleFromRecord(item)
+		if err != nil {
+			return
+		}
+		ar, err = newAuditRecord(ctx, store.Now(), AuditActionDeleteRole, id, "", before, nil)
+		return
 	})
+	if err != nil {
+		return fmt.Errorf("organisationStore.DeleteRole: %w", err)
+	}
+	return nil
+}
+
+// deleteAudited deletes a record in a transaction with the audit record of deleting it, created from the record by
+// newAuditRecord. The delete is conditional on the version of the record that was read, and is retried if the
+// record changes in between. If the record doesn't exist, notFound is returned.
+func (store OrganisationStore) deleteAudited(ctx context.Context, key map[string]*dynamodb.AttributeValue, notFound error, newAuditRecord func(item map[string]*dynamodb.AttributeValue) (ar AuditRecord, version int, err error)) error {
+	for i := 0; i < maxAuditAttempts; i++ {
+		gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:      store.TableName,
+			ConsistentRead: aws.Bool(true),
+			Key:            key,
+		})
+		if err != nil {
+			return err
+		}
+		if len(gio.Item) == 0 {
+			return notFound
+		}
+		ar, version, err := newAuditRecord(gio.Item)
+		if err != nil {
+			return err
+		}
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return err
+		}
+		expr, err := expression.NewBuilder().WithCondition(versionCondition(version)).Build()
+		if err != nil {
+			return fmt.Errorf("failed to build condition: %v", err)
+		}
+		_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]*dynamodb.TransactWriteItem{
+				{
+					Delete: &dynamodb.Delete{
+						TableName:                 store.TableName,
+						Key:                       key,
+						ConditionExpression:       expr.Condition(),
+						ExpressionAttributeNames:  expr.Names(),
+						ExpressionAttributeValues: expr.Values(),
+					},
+				},
+			}, audit...),
+		})
+		if failed, _ := failedTransactionCondition(err, 0); failed {
+			// The record changed since it was read for the audit log.
+			continue
+		}
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// ListRoles lists the DefaultRoles, followed by the Organisation's own roles.
+func (store OrganisationStore) ListRoles(ctx context.Context, id string) (roles []Role, err error) {
+	roles, err = getRoles(ctx, store.Client, store.TableName, id)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ListRoles: %w", err)
+	}
 	return
 }
 
-// Put an Organisation.
-func (store OrganisationStore) Put(org Organisation) error {
-	ur := newOrganisationRecord(org)
-	item, err := dynamodbattribute.MarshalMap(ur)
+// CreateWebhook creates a new Webhook, or returns ErrOrganisationNotFound or ErrInvalidWebhook. The Webhook's ID
+// and version are ignored.
+func (store OrganisationStore) CreateWebhook(ctx context.Context, id string, webhook Webhook) (webhookID string, err error) {
+	webhook.ID = uuid.New().String()
+	webhook.Version = 0
+	err = store.PutWebhook(ctx, id, webhook)
 	if err != nil {
-		return err
+		return "", err
 	}
-	_, err = store.Client.PutItem(&dynamodb.PutItemInput{
-		TableName: store.TableName,
-		Item:      item,
+	return webhook.ID, nil
+}
+
+// PutWebhook creates a Webhook (version zero) or updates an existing Webhook's URL, secret and events. Returns
+// ErrOrganisationNotFound if the Organisation doesn't exist, ErrVersionConflict if the version is stale, or
+// ErrInvalidWebhook.
+func (store OrganisationStore) PutWebhook(ctx context.Context, id string, webhook Webhook) error {
+	if err := validateWebhook(webhook); err != nil {
+		return fmt.Errorf("organisationStore.PutWebhook: %w", err)
+	}
+	organisationWebhookRecord := newOrganisationWebhookRecord(id, webhook)
+	organisationWebhookRecord.Version = webhook.Version + 1
+	item, err := dynamodbattribute.MarshalMap(organisationWebhookRecord)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutWebhook: failed to convert organisationWebhookRecord: %w", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(webhook.Version)).Build()
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutWebhook: failed to build condition: %v", err)
+	}
+	var before *auditWebhook
+	if webhook.Version > 0 {
+		// If the version doesn't match, the put fails, so the Webhook read is the Webhook being replaced.
+		gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:      store.TableName,
+			ConsistentRead: aws.Bool(true),
+			Key:            idAndRng(newOrganisationWebhookRecordHashKey(id), newOrganisationWebhookRecordRangeKey(webhook.ID)),
+		})
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutWebhook: %w", err)
+		}
+		before, _, err = newAuditWebhookFromRecord(gio.Item)
+		if err != nil {
+			return fmt.Errorf("organisationStore.PutWebhook: %w", err)
+		}
+	}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionPutWebhook, id, "", before, newAuditWebhook(webhook))
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutWebhook: %w", err)
+	}
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutWebhook: %w", err)
+	}
+	checkOrganisationExists, err := newOrganisationExistsCheck(store.TableName, id)
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutWebhook: %w", err)
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]*dynamodb.TransactWriteItem{
+			{ConditionCheck: checkOrganisationExists},
+			{
+				Put: &dynamodb.Put{
+					TableName:                 store.TableName,
+					Item:                      item,
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+				},
+			},
+		}, audit...),
 	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.PutWebhook: %w", ErrOrganisationNotFound)
+	}
+	if failed, _ := failedTransactionCondition(err, 1); failed {
+		return fmt.Errorf("organisationStore.PutWebhook: %w", ErrVersionConflict)
+	}
 	return err
 }
 
-// Get an Organisation.
-func (store OrganisationStore) Get(id string) (org Organisation, err error) {
-	gio, err := store.Client.GetItem(&dynamodb.GetItemInput{
-		TableName:      store.TableName,
-		ConsistentRead: aws.Bool(true),
-		Key:            idAndRng(newOrganisationRecordHashKey(id), newOrganisationRecordRangeKey()),
+// DeleteWebhook deletes one of the Organisation's Webhooks, or returns ErrWebhookNotFound. Its deliveries are kept
+// until they expire.
+func (store OrganisationStore) DeleteWebhook(ctx context.Context, id, webhookID string) error {
+	key := idAndRng(newOrganisationWebhookRecordHashKey(id), newOrganisationWebhookRecordRangeKey(webhookID))
+	err := store.deleteAudited(ctx, key, ErrWebhookNotFound, func(item map[string]*dynamodb.AttributeValue) (ar AuditRecord, version int, err error) {
+		before, version, err := newAuditWebhookFromRecord(item)
+		if err != nil {
+			return
+		}
+		ar, err = newAuditRecord(ctx, store.Now(), AuditActionDeleteWebhook, id, "", before, nil)
+		return
 	})
 	if err != nil {
-		return
+		return fmt.Errorf("organisationStore.DeleteWebhook: %w", err)
 	}
-	var record organisationRecord
-	err = dynamodbattribute.UnmarshalMap(gio.Item, &record)
-	org = newOrganisationFromRecord(record)
-	return
+	return nil
 }
 
-// CreateService creates a new service.
-func (store OrganisationStore) CreateService(id string, serviceName string) (serviceID string, err error) {
-	serviceID = uuid.New().String()
-	err = store.PutService(id, serviceID, serviceName)
+// ListWebhooks lists the Organisation's Webhooks.
+func (store OrganisationStore) ListWebhooks(ctx context.Context, id string) (webhooks []Webhook, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newOrganisationWebhookRecordHashKey(id), organisationWebhookRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ListWebhooks: failed to query webhooks: %w", err)
+		return
+	}
+	webhooks, err = newWebhooksFromRecords(items)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ListWebhooks: %w", err)
+	}
 	return
 }
 
-// PutService creates a new service or updates an existing service's name.
-func (store OrganisationStore) PutService(id string, serviceID, serviceName string) (err error) {
-	organisationServiceRecord := newOrganisationServiceRecord(id, serviceID, serviceName)
-	item, err := dynamodbattribute.MarshalMap(organisationServiceRecord)
+// PutWebhookDelivery records an attempt to deliver an Event to one of the Organisation's Webhooks.
+func (store OrganisationStore) PutWebhookDelivery(ctx context.Context, id string, delivery WebhookDelivery) error {
+	item, err := dynamodbattribute.MarshalMap(newWebhookDeliveryRecord(id, delivery))
 	if err != nil {
-		return
+		return fmt.Errorf("organisationStore.PutWebhookDelivery: failed to convert webhookDeliveryRecord: %w", err)
 	}
-	_, err = store.Client.PutItem(&dynamodb.PutItemInput{
+	_, err = store.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		TableName: store.TableName,
 		Item:      item,
 	})
-	return
+	if err != nil {
+		return fmt.Errorf("organisationStore.PutWebhookDelivery: %w", err)
+	}
+	return nil
 }
 
-// DeleteService deletes a service from the Organisation. It does not remove assignments to the deleted service.
-// These could be removed by a separate process if required.
-func (store OrganisationStore) DeleteService(id, serviceID string) (err error) {
-	key := idAndRng(newOrganisationServiceRecordHashKey(id), newOrganisationServiceRecordRangeKey(serviceID))
-	_, err = store.Client.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: store.TableName,
-		Key:       key,
-	})
+// ListWebhookDeliveries lists the attempts to deliver Events to one of the Organisation's Webhooks, oldest first.
+func (store OrganisationStore) ListWebhookDeliveries(ctx context.Context, id, webhookID string) (deliveries []WebhookDelivery, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newWebhookDeliveryRecordHashKey(id, webhookID), webhookDeliveryRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ListWebhookDeliveries: failed to query deliveries: %w", err)
+		return
+	}
+	deliveries, err = newWebhookDeliveriesFromRecords(items)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ListWebhookDeliveries: %w", err)
+	}
 	return
 }
 
-// GetDetails retrieves all details of an Organisation.
-func (store OrganisationStore) GetDetails(id string) (org OrganisationDetails, err error) {
-	q := expression.Key("id").Equal(expression.Value(newOrganisationRecordHashKey(id)))
-	expr, err := expression.NewBuilder().
-		WithKeyCondition(q).
-		Build()
+// EffectiveGroups gets the groups that a User belongs to, including the groups that contain them. ErrNotMember is
+// returned if the User isn't a member of the Organisation.
+func (store OrganisationStore) EffectiveGroups(ctx context.Context, organisationID, userID string) (eg EffectiveGroups, err error) {
+	eg, err = getEffectiveGroups(ctx, store.Client, store.TableName, organisationID, userID, store.Now())
 	if err != nil {
-		err = fmt.Errorf("organisationStore.GetDetails: failed to build query: %v", err)
-		return
+		err = fmt.Errorf("organisationStore.EffectiveGroups: %w", err)
 	}
-	qi := &dynamodb.QueryInput{
-		TableName:                 store.TableName,
-		KeyConditionExpression:    expr.KeyCondition(),
-		ExpressionAttributeValues: expr.Values(),
-		FilterExpression:          expr.Filter(),
-		ExpressionAttributeNames:  expr.Names(),
-		ConsistentRead:            aws.Bool(true),
+	return
+}
+
+// GetUserGroups gets the Organisation and Service groups that a User belongs to, including the groups of grants
+// that haven't expired. ErrNotMember is returned if the User isn't a member of the Organisation, or hasn't
+// accepted their invitation yet.
+func (store OrganisationStore) GetUserGroups(ctx context.Context, organisationID, userID string) (groups []string, serviceIDToGroups map[string][]string, err error) {
+	groups, serviceIDToGroups, err = getUserGroups(ctx, store.Client, store.TableName, organisationID, userID, store.Now())
+	if err != nil {
+		err = fmt.Errorf("organisationStore.GetUserGroups: %w", err)
 	}
+	return
+}
 
-	var items []map[string]*dynamodb.AttributeValue
-	page := func(page *dynamodb.QueryOutput, lastPage bool) bool {
-		items = append(items, page.Items...)
-		return true
+// newUserGroupsFromMemberRecord returns the groups of an organisationGroupMember record, or ErrNotMember if the
+// record doesn't exist, or is a pending or revoked invitation.
+func newUserGroupsFromMemberRecord(item map[string]*dynamodb.AttributeValue) (groups []string, serviceIDToGroups map[string][]string, err error) {
+	if len(item) == 0 {
+		err = ErrNotMember
+		return
 	}
-	err = store.Client.QueryPages(qi, page)
+	var omr organisationMemberRecord
+	err = dynamodbattribute.UnmarshalMap(item, &omr)
 	if err != nil {
-		err = fmt.Errorf("organisationStore.GetDetails: failed to query pages: %v", err)
+		err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+		return
+	}
+	if omr.pending() || omr.RevokedAt != nil {
+		err = ErrNotMember
+		return
+	}
+	if omr.Groups == nil {
 		return
 	}
+	return omr.Groups.OrganisationGroups(), omr.Groups.ServiceGroups(), nil
+}
 
-	org, err = newOrganisationDetailsFromRecords(items)
+// AuditLog reads a page of the changes made to the Organisation and its members, oldest first. At most limit
+// records are returned, or all of them if limit is zero. To read the next page, pass the returned page's Next
+// as start.
+func (store OrganisationStore) AuditLog(ctx context.Context, id string, limit int, start string) (page AuditPage, err error) {
+	page, err = queryAuditLog(ctx, store.Client, store.TableName, newOrganisationAuditRecordHashKey(id), limit, start)
 	if err != nil {
-		err = fmt.Errorf("organisationStore.GetDetails: failed to create OrganisationDetails: %w", err)
+		err = fmt.Errorf("organisationStore.AuditLog: %w", err)
 	}
 	return
 }
 
-func newOrganisationDetailsFromRecords(items []map[string]*dynamodb.AttributeValue) (org OrganisationDetails, err error) {
-	serviceIDToService := make(map[string]Service)
-	userIDToUser := make(map[string]User)
-	userIDToGroups := make(map[string]*groupSet)
-
-	for _, item := range items {
-		recordType, ok := item["typ"]
-		if !ok || recordType.S == nil {
-			continue
-		}
-		switch *recordType.S {
-		case organisationRecordName:
-			var or organisationRecord
-			err = dynamodbattribute.UnmarshalMap(item, &or)
-			if err != nil {
-				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationRecord: %w", err)
-				return
-			}
-			org.ID = or.OrganisationID
-			org.Name = or.OrganisationName
-		case organisationMemberRecordName:
-			// Extract the member record details.
-			var omr organisationMemberRecord
-			err = dynamodbattribute.UnmarshalMap(item, &omr)
-			if err != nil {
-				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationMember: %w", err)
-				return
-			}
-
-			// Extract the user details.
-			var ur userRecord
-			err = dynamodbattribute.UnmarshalMap(item, &ur)
-			if err != nil {
-				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationServiceGroupMemberRecord: %w", err)
-				return
-			}
-			u := newUserFromRecord(ur)
-			userIDToUser[u.ID] = u
-
-			// Collate the user groups.
-			userIDToGroups[omr.Email] = omr.Groups
-		case organisationServiceRecordName:
-			var osr organisationServiceRecord
-			err = dynamodbattribute.UnmarshalMap(item, &osr)
-			if err != nil {
-				err = fmt.Errorf("newOrganisationDetailsFromRecords: failed to convert organisationServiceRecord: %w", err)
-				return
-			}
-			service := serviceIDToService[osr.ServiceID]
-			service.ID = osr.ServiceID
-			service.Name = osr.ServiceName
-			serviceIDToService[osr.ServiceID] = service
-		}
+// PublishOutbox publishes the events that are still in the outbox, because the Publisher failed when the changes
+// that caused them were made, or the process stopped before they could be published. Each Organisation's events
+// are published in the order they happened, and publishing an Organisation's events stops at the first event that
+// can't be published. It returns the number of events that were published.
+func (store OrganisationStore) PublishOutbox(ctx context.Context) (published int, err error) {
+	published, err = publishOutbox(ctx, store.Client, store.TableName, store.Publisher)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.PublishOutbox: %w", err)
 	}
-	// Now that all of the records have been read, populate the organisation groups and the services.
-	for userID, groups := range userIDToGroups {
-		user := userIDToUser[userID]
-		for _, g := range groups.OrganisationGroups() {
-			if org.Groups == nil {
-				org.Groups = make(map[GroupName][]User)
-			}
-			org.Groups[GroupName(g)] = append(org.Groups[GroupName(g)], user)
-		}
+	return
+}
 
-		for serviceID, groups := range groups.ServiceGroups() {
-			service := serviceIDToService[serviceID]
-			if service.Groups == nil {
-				service.Groups = make(map[GroupName][]User)
-			}
-			for _, g := range groups {
-				service.Groups[GroupName(g)] = append(service.Groups[GroupName(g)], user)
-			}
-			serviceIDToService[serviceID] = service
-		}
+// ListInvitations lists the pending invitations to the Organisation, including expired invitations that can
+// be resent.
+func (store OrganisationStore) ListInvitations(ctx context.Context, id string) (invitations []Invitation, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newOrganisationRecordHashKey(id), "")
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ListInvitations: failed to query records: %w", err)
+		return
 	}
-	// Now copy the services to the organisation.
-	for serviceID := range serviceIDToService {
-		org.Services = append(org.Services, serviceIDToService[serviceID])
+	invitations, err = newInvitationsFromOrganisationRecords(items)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ListInvitations: %w", err)
 	}
 	return
 }
 
-// AddUserToOrganisationGroups puts a user into groups within the Organisation. If they already exist, the user is added to the group.
-func (store OrganisationStore) AddUserToOrganisationGroups(organisationID string, user User, groups ...string) error {
-	return store.AddUserToGroups(organisationID, user, groups, nil)
+// ResendInvite replaces the token of a pending invitation and extends its expiry by the InvitationTTL, so
+// that the previous token can no longer be used. If there's no pending invitation, ErrInvitationNotFound,
+// ErrInvitationAlreadyAccepted or ErrInvitationRevoked is returned.
+func (store OrganisationStore) ResendInvite(ctx context.Context, id, userID string) (token string, err error) {
+	token, tokenHash, err := newInvitationToken()
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ResendInvite: %w", err)
+		return
+	}
+	now := store.Now()
+	expiresAt := now.Add(store.InvitationTTL).Unix()
+	ar, err := newAuditRecord(ctx, now, AuditActionResendInvite, id, userID, nil, &auditInvitation{ExpiresAt: time.Unix(expiresAt, 0).UTC()})
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ResendInvite: %w", err)
+		return
+	}
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		err = fmt.Errorf("organisationStore.ResendInvite: %w", err)
+		return
+	}
+	err = store.updateInvitation(ctx, id, userID,
+		incrementVersion(expression.
+			Set(expression.Name("tokenHash"), expression.Value(tokenHash)).
+			Set(expression.Name("expiresAt"), expression.Value(expiresAt))),
+		incrementVersion(expression.Set(expression.Name("expiresAt"), expression.Value(expiresAt))),
+		audit...)
+	if err != nil {
+		return "", fmt.Errorf("organisationStore.ResendInvite: %w", err)
+	}
+	return
 }
 
-// AddUserToServiceGroups puts a user into groups within an Organisation Service.
-func (store OrganisationStore) AddUserToServiceGroups(organisationID string, user User, serviceID string, groups ...string) error {
-	return store.AddUserToGroups(organisationID, user, nil, map[string][]string{
-		serviceID: groups,
-	})
+// RevokeInvite revokes a pending invitation, so that it can't be accepted. The invitee is no longer listed in
+// the Organisation's groups. If there's no pending invitation, ErrInvitationNotFound,
+// ErrInvitationAlreadyAccepted or ErrInvitationRevoked is returned.
+func (store OrganisationStore) RevokeInvite(ctx context.Context, id, userID string) error {
+	now := store.Now()
+	ar, err := newAuditRecord(ctx, now, AuditActionRevokeInvite, id, userID, nil, nil)
+	if err != nil {
+		return fmt.Errorf("organisationStore.RevokeInvite: %w", err)
+	}
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.RevokeInvite: %w", err)
+	}
+	revoked := InvitationRevoked{EventMetadata: newEventMetadata(id, now), UserID: userID}
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, revoked)
+	if err != nil {
+		return fmt.Errorf("organisationStore.RevokeInvite: %w", err)
+	}
+	err = store.updateInvitation(ctx, id, userID,
+		incrementVersion(expression.
+			Set(expression.Name("revokedAt"), expression.Value(now)).
+			Remove(expression.Name("tokenHash"))),
+		incrementVersion(expression.Set(expression.Name("revokedAt"), expression.Value(now))),
+		append(audit, outbox...)...)
+	if err != nil {
+		return fmt.Errorf("organisationStore.RevokeInvite: %w", err)
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, revoked)
+	return nil
 }
 
-// AddUserToGroups adds a user to Organisation and Service Groups.
-func (store OrganisationStore) AddUserToGroups(organisationID string, user User, groups []string, serviceIDToGroups map[string][]string) error {
-	gs := newGroupSet(groups, serviceIDToGroups)
-	update := expression.
-		Set(expression.Name("typ"), expression.Value(organisationMemberRecordName)).
-		Set(expression.Name("v"), expression.Value(0)).
-		Set(expression.Name("organisationId"), expression.Value(organisationID)).
-		Add(expression.Name("groups"), expression.Value(gs)).
-		Set(expression.Name("email"), expression.Value(user.ID)).
-		Set(expression.Name("firstName"), expression.Value(user.FirstName)).
-		Set(expression.Name("lastName"), expression.Value(user.LastName)).
-		Set(expression.Name("phone"), expression.Value(user.Phone)).
-		Set(expression.Name("createdAt"), expression.Value(user.CreatedAt))
-	expr, err := expression.NewBuilder().
-		WithUpdate(update).
+// updateInvitation updates both sides of a pending invitation in a single transaction with the items, its audit
+// record and events.
+func (store OrganisationStore) updateInvitation(ctx context.Context, id, userID string, memberUpdate, userOrganisationUpdate expression.UpdateBuilder, items ...*dynamodb.TransactWriteItem) error {
+	pending := expression.AttributeExists(expression.Name("tokenHash"))
+	memberExpr, err := expression.NewBuilder().
+		WithUpdate(memberUpdate).
+		WithCondition(pending).
 		Build()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to build member update: %v", err)
 	}
-	_, err = store.Client.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName:                 store.TableName,
-		Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(user.ID)),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		UpdateExpression:          expr.Update(),
-	})
-	return err
-}
-
-// RemoveUserFromOrganisationGroups removes a user from a set of Organisation level groups.
-func (store OrganisationStore) RemoveUserFromOrganisationGroups(organisationID, userID string, groups ...string) error {
-	return store.RemoveUserFromGroups(organisationID, userID, groups, nil)
-}
-
-// RemoveUserFromServiceGroups removes a user from a set of Service-level groups.
-func (store OrganisationStore) RemoveUserFromServiceGroups(organisationID, userID, serviceID string, groups ...string) error {
-	return store.RemoveUserFromGroups(organisationID, userID, nil, map[string][]string{
-		serviceID: groups,
-	})
-}
-
-// RemoveUserFromGroups removes a user from Organisation and Service groups.
-func (store OrganisationStore) RemoveUserFromGroups(organisationID, userID string, groups []string, serviceIDToGroups map[string][]string) error {
-	gs := newGroupSet(groups, serviceIDToGroups)
-	update := expression.Delete(expression.Name("groups"), expression.Value(gs))
-	expr, err := expression.NewBuilder().
-		WithUpdate(update).
+	invited := expression.AttributeExists(expression.Name("id"))
+	userOrganisationExpr, err := expression.NewBuilder().
+		WithUpdate(userOrganisationUpdate).
+		WithCondition(invited).
 		Build()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to build userOrganisation update: %v", err)
 	}
-	_, err = store.Client.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName:                 store.TableName,
-		Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		UpdateExpression:          expr.Update(),
-	})
-	return err
-}
-
-// RemoveUser from the Organisation.
-func (store OrganisationStore) RemoveUser(organisationID string, userID string) error {
-	key := idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID))
-	_, err := store.Client.DeleteItem(&dynamodb.DeleteItemInput{
-		TableName: store.TableName,
-		Key:       key,
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName:                           store.TableName,
+					Key:                                 idAndRng(newOrganisationMemberRecordHashKey(id), newOrganisationMemberRecordRangeKey(userID)),
+					UpdateExpression:                    memberExpr.Update(),
+					ConditionExpression:                 memberExpr.Condition(),
+					ExpressionAttributeNames:            memberExpr.Names(),
+					ExpressionAttributeValues:           memberExpr.Values(),
+					ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+				},
+			},
+			{
+				Update: &dynamodb.Update{
+					TableName:                 store.TableName,
+					Key:                       idAndRng(newUserOrganisationRecordHashKey(userID), newUserOrganisationRecordRangeKey(id)),
+					UpdateExpression:          userOrganisationExpr.Update(),
+					ConditionExpression:       userOrganisationExpr.Condition(),
+					ExpressionAttributeNames:  userOrganisationExpr.Names(),
+					ExpressionAttributeValues: userOrganisationExpr.Values(),
+				},
+			},
+		}, items...),
 	})
+	if failed, item := failedTransactionCondition(err, 0); failed {
+		// The token and expiry don't matter, because the invitation isn't pending.
+		return newInvitationError(item, "", time.Time{})
+	}
+	if failed, _ := failedTransactionCondition(err, 1); failed {
+		return ErrInvitationNotFound
+	}
 	return err
 }
 
-// UpdateUserDetails updates a user's details within the Organisation.
-func (store OrganisationStore) UpdateUserDetails(organisationID, userID, firstName, lastName, phone string) error {
-	update := expression.
+// UpdateUserDetails updates a user's details within the Organisation. The version is the version of the user's
+// membership, as returned by GetDetails. If the membership has been modified since, ErrVersionConflict is returned.
+func (store OrganisationStore) UpdateUserDetails(ctx context.Context, organisationID, userID, firstName, lastName, phone string, version int) error {
+	update := incrementVersion(expression.
 		Set(expression.Name("firstName"), expression.Value(firstName)).
 		Set(expression.Name("lastName"), expression.Value(lastName)).
-		Set(expression.Name("phone"), expression.Value(phone))
+		Set(expression.Name("phone"), expression.Value(phone)))
+	isVersion := expression.Name("v").Equal(expression.Value(version))
 	expr, err := expression.NewBuilder().
 		WithUpdate(update).
+		WithCondition(isVersion).
 		Build()
 	if err != nil {
 		return err
 	}
-	_, err = store.Client.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName:                 store.TableName,
-		Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		UpdateExpression:          expr.Update(),
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+	})
+	if err != nil {
+		return fmt.Errorf("organisationStore.UpdateUserDetails: failed to get member: %w", err)
+	}
+	var before organisationMemberRecord
+	err = dynamodbattribute.UnmarshalMap(gio.Item, &before)
+	if err != nil {
+		return fmt.Errorf("organisationStore.UpdateUserDetails: failed to convert organisationMemberRecord: %w", err)
+	}
+	after := userRecordFields{FirstName: firstName, LastName: lastName, Phone: phone}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionUpdateDetails, organisationID, userID, nil, newAuditFields(before.userRecordFields, after))
+	if err != nil {
+		return fmt.Errorf("organisationStore.UpdateUserDetails: %w", err)
+	}
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("organisationStore.UpdateUserDetails: %w", err)
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append([]*dynamodb.TransactWriteItem{
+			{
+				Update: &dynamodb.Update{
+					TableName:                 store.TableName,
+					Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+					ConditionExpression:       expr.Condition(),
+					ExpressionAttributeNames:  expr.Names(),
+					ExpressionAttributeValues: expr.Values(),
+					UpdateExpression:          expr.Update(),
+				},
+			},
+		}, audit...),
 	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("organisationStore.UpdateUserDetails: %w", ErrVersionConflict)
+	}
 	return err
-
 }
 
 // organisation record.
@@ -393,7 +2458,7 @@ func newOrganisationRecord(org Organisation) organisationRecord {
 	record.ID = newOrganisationRecordHashKey(org.ID)
 	record.Range = newOrganisationRecordRangeKey()
 	record.RecordType = organisationRecordName
-	record.Version = 0
+	record.Version = org.Version + 1
 	record.OrganisationID = org.ID
 	record.OrganisationName = org.Name
 	return record
@@ -425,7 +2490,7 @@ func newOrganisationMemberRecord(org Organisation, groups []string, serviceGroup
 	record.ID = newOrganisationMemberRecordHashKey(org.ID)
 	record.Range = newOrganisationMemberRecordRangeKey(u.ID)
 	record.RecordType = organisationMemberRecordName
-	record.Version = 0
+	record.Version = 1
 
 	record.OrganisationID = org.ID
 
@@ -443,8 +2508,37 @@ func newOrganisationMemberRecord(org Organisation, groups []string, serviceGroup
 type organisationMemberRecord struct {
 	record
 	OrganisationID string    `json:"organisationId"`
-	Groups         *groupSet `json:"groups"`
+	Groups         *groupSet `json:"groups" dynamodbav:"groups,omitempty"`
 	userRecordFields
+	invitationRecordFields
+}
+
+// organisation owners record, used to ensure that an Organisation always has an owner.
+const organisationOwnersRecordName = "organisationOwners"
+
+func newOrganisationOwnersRecordHashKey(organisationID string) string {
+	return newOrganisationRecordHashKey(organisationID)
+}
+
+func newOrganisationOwnersRecordRangeKey() string {
+	return organisationOwnersRecordName
+}
+
+func newOrganisationOwnersRecord(organisationID string, owners []string) organisationOwnersRecord {
+	var record organisationOwnersRecord
+	record.ID = newOrganisationOwnersRecordHashKey(organisationID)
+	record.Range = newOrganisationOwnersRecordRangeKey()
+	record.RecordType = organisationOwnersRecordName
+	record.Version = 1
+	record.OrganisationID = organisationID
+	record.Owners = owners
+	return record
+}
+
+type organisationOwnersRecord struct {
+	record
+	OrganisationID string   `json:"organisationId"`
+	Owners         []string `json:"owners" dynamodbav:"owners,stringset,omitempty"`
 }
 
 // organisation service record.
@@ -463,7 +2557,7 @@ func newOrganisationServiceRecord(organisationID, serviceID, name string) organi
 	record.ID = newOrganisationServiceRecordHashKey(organisationID)
 	record.Range = newOrganisationServiceRecordRangeKey(serviceID)
 	record.RecordType = organisationServiceRecordName
-	record.Version = 0
+	record.Version = 1
 	record.ServiceID = serviceID
 	record.ServiceName = name
 	return record
@@ -1,40 +1,43 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
-func TestOrganisationPutIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testOrganisationPut(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
 
 	// Update it.
 	expected := newOrganisation(organisationID, "New Organisation Name")
-	err = s.Put(expected)
+	expected.Version = 1
+	err = s.Put(ctx, expected)
 	if err != nil {
 		t.Errorf("failed to put organisation: %v", err)
 	}
+	expected.Version = 2
 
 	// Get the updated one.
-	actual, err := s.Get(organisationID)
+	actual, err := s.Get(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
@@ -43,28 +46,21 @@ func TestOrganisationPutIntegration(t *testing.T) {
 	}
 }
 
-func TestOrganisationGetIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testOrganisationGet(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
 
 	// Now get it back.
 	expected := newOrganisation(organisationID, "Organisation Name")
-	actual, err := s.Get(organisationID)
+	expected.Version = 1
+	actual, err := s.Get(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
@@ -73,21 +69,13 @@ func TestOrganisationGetIntegration(t *testing.T) {
 	}
 }
 
-func TestOrganisationGetDetailsIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testOrganisationGetDetails(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
@@ -99,49 +87,42 @@ func TestOrganisationGetDetailsIntegration(t *testing.T) {
 	}
 	var services []Service
 	expected := newOrganisationDetails(org, groups, services)
-	actual, err := s.GetDetails(organisationID)
+	actual, err := s.GetDetails(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
-	if diff := cmp.Diff(expected, actual); diff != "" {
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
 		t.Error(diff)
 	}
 }
 
-func TestOrganisationGroupIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testOrganisationGroup(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
 
 	// Add the owner to some groups.
-	err = s.AddUserToOrganisationGroups(organisationID, owner, "hipsters", "gin_fans", "tricycle_riders")
+	roles := putRoles(t, r, organisationID, RoleScopeOrganisation, "gin_fans", "hipsters", "tricycle_riders")
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, owner, "hipsters", "gin_fans", "tricycle_riders")
 	if err != nil {
 		t.Errorf("failed to add owner to Organisation group: %v", err)
 	}
 
 	// Add a user that doesn't exist to some groups.
 	other := newUser("other@example.com", "Other F", "Other L", "1567", createdAt)
-	err = s.AddUserToOrganisationGroups(organisationID, other, "hipsters", "tricycle_riders")
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, other, "hipsters", "tricycle_riders")
 	if err != nil {
 		t.Errorf("failed to add other to Organisation group: %v", err)
 	}
 
 	// Remove the owner from the "gin_fans" group.
-	err = s.RemoveUserFromOrganisationGroups(organisationID, "test@example.com", "tricycle_riders", "gin_fans")
+	err = s.RemoveUserFromOrganisationGroups(ctx, organisationID, "test@example.com", "tricycle_riders", "gin_fans")
 	if err != nil {
 		t.Errorf("failed to remove owner from groups: %v", err)
 	}
@@ -155,57 +136,51 @@ func TestOrganisationGroupIntegration(t *testing.T) {
 	}
 	var services []Service
 	expected := newOrganisationDetails(org, groups, services)
-	actual, err := s.GetDetails(organisationID)
+	expected.Roles = append(expected.Roles, roles...)
+	actual, err := s.GetDetails(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
 	if hipsterCount := len(actual.Groups[GroupName("hipsters")]); hipsterCount != 2 {
 		t.Errorf("expected two users in hipster group, got %v", hipsterCount)
 	}
-	if diff := cmp.Diff(expected, actual); diff != "" {
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
 		t.Error(diff)
 	}
 }
 
-func TestServiceGroupsIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testServiceGroups(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
 
 	// Create a service.
-	serviceID, err := s.CreateService(organisationID, "old_service_name")
+	serviceID, err := s.CreateService(ctx, organisationID, "old_service_name")
 	if err != nil {
 		t.Errorf("failed to create a service: %v", err)
 	}
 
 	// Rename it.
-	err = s.PutService(organisationID, serviceID, "new_service_name")
+	err = s.PutService(ctx, organisationID, serviceID, "new_service_name", 1)
 	if err != nil {
 		t.Errorf("failed to rename service: %v", err)
 	}
 
 	// Add the owner to service groups.
-	err = s.AddUserToServiceGroups(organisationID, owner, serviceID, "service_group_1", "service_group_2", "service_group_3")
+	roles := putRoles(t, r, organisationID, RoleScopeService, "service_group_1", "service_group_2", "service_group_3")
+	err = s.AddUserToServiceGroups(ctx, organisationID, owner, serviceID, "service_group_1", "service_group_2", "service_group_3")
 	if err != nil {
 		t.Errorf("failed to add user to service: %v", err)
 	}
 
 	// Delete the owner from one of the groups.
-	err = s.RemoveUserFromServiceGroups(organisationID, owner.ID, serviceID, "service_group_2", "non-existent-group")
+	err = s.RemoveUserFromServiceGroups(ctx, organisationID, owner.ID, serviceID, "service_group_2", "non-existent-group")
 	if err != nil {
 		t.Errorf("failed to remove user from service: %v", err)
 	}
@@ -226,42 +201,35 @@ func TestServiceGroupsIntegration(t *testing.T) {
 		},
 	}
 	expected := newOrganisationDetails(org, groups, services)
-	actual, err := s.GetDetails(organisationID)
+	expected.Roles = append(expected.Roles, roles...)
+	actual, err := s.GetDetails(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
-	if diff := cmp.Diff(expected, actual); diff != "" {
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
 		t.Error(diff)
 	}
 }
 
-func TestServiceGroupDeleteIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testServiceGroupDelete(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
 
 	// Create a service.
-	serviceID, err := s.CreateService(organisationID, "old_service_name")
+	serviceID, err := s.CreateService(ctx, organisationID, "old_service_name")
 	if err != nil {
 		t.Errorf("failed to create a service: %v", err)
 	}
 
 	// Create a new service and delete it.
-	err = s.DeleteService(organisationID, serviceID)
+	err = s.DeleteService(ctx, organisationID, serviceID)
 	if err != nil {
 		t.Errorf("failed to delete service: %v", err)
 	}
@@ -272,37 +240,30 @@ func TestServiceGroupDeleteIntegration(t *testing.T) {
 		GroupOwner: {owner},
 	}
 	expected := newOrganisationDetails(org, groups, nil)
-	actual, err := s.GetDetails(organisationID)
+	actual, err := s.GetDetails(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
-	if diff := cmp.Diff(expected, actual); diff != "" {
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
 		t.Error(diff)
 	}
 }
 
-func TestOrganisationUpdateUserIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testOrganisationUpdateUser(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
 
 	// Add another user to organisation groups.
+	roles := putRoles(t, r, organisationID, RoleScopeOrganisation, "hipsters")
 	other := newUser("other@example.com", "Other F", "Other L", "1567", createdAt)
-	err = s.AddUserToOrganisationGroups(organisationID, other, "hipsters")
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, other, "hipsters")
 	if err != nil {
 		t.Errorf("failed to add other to Organisation group: %v", err)
 	}
@@ -311,7 +272,7 @@ func TestOrganisationUpdateUserIntegration(t *testing.T) {
 	other.FirstName = "updated_firstname"
 	other.LastName = "updated_lastname"
 	other.Phone = "updated_phone"
-	err = s.UpdateUserDetails(organisationID, other.ID, other.FirstName, other.LastName, other.Phone)
+	err = s.UpdateUserDetails(ctx, organisationID, other.ID, other.FirstName, other.LastName, other.Phone, 1)
 	if err != nil {
 		t.Errorf("failed to update user: %v", err)
 	}
@@ -323,43 +284,37 @@ func TestOrganisationUpdateUserIntegration(t *testing.T) {
 		GroupName("hipsters"): {other},
 	}
 	expected := newOrganisationDetails(org, groups, nil)
-	actual, err := s.GetDetails(organisationID)
+	expected.Roles = append(expected.Roles, roles...)
+	actual, err := s.GetDetails(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
-	if diff := cmp.Diff(expected, actual); diff != "" {
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
 		t.Error(diff)
 	}
 }
 
-func TestOrganisationDeleteUserIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewOrganisationStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testOrganisationDeleteUser(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
 	// Create an organisation.
 	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
 	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
-	organisationID, err := s.Create(owner, "Organisation Name")
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
 	if err != nil {
 		t.Errorf("failed to create organisation: %v", err)
 	}
 
 	// Add another user to organisation groups.
+	roles := putRoles(t, r, organisationID, RoleScopeOrganisation, "hipsters")
 	other := newUser("other@example.com", "Other F", "Other L", "1567", createdAt)
-	err = s.AddUserToOrganisationGroups(organisationID, other, "hipsters")
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, other, "hipsters")
 	if err != nil {
 		t.Errorf("failed to add other to Organisation group: %v", err)
 	}
 
 	// Delete the user.
-	err = s.RemoveUser(organisationID, other.ID)
+	err = s.RemoveUser(ctx, organisationID, other.ID)
 	if err != nil {
 		t.Errorf("failed to delete user: %v", err)
 	}
@@ -370,11 +325,1591 @@ func TestOrganisationDeleteUserIntegration(t *testing.T) {
 		GroupOwner: {owner},
 	}
 	expected := newOrganisationDetails(org, groups, nil)
-	actual, err := s.GetDetails(organisationID)
+	expected.Roles = append(expected.Roles, roles...)
+	actual, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func testOrganisationGetNotFound(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	_, err := s.Get(ctx, "missing")
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound, got %v", err)
+	}
+	_, err = s.GetDetails(ctx, "missing")
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound from GetDetails, got %v", err)
+	}
+	_, err = s.CreateService(ctx, "missing", "Service")
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound from CreateService, got %v", err)
+	}
+	err = s.PutService(ctx, "missing", "service", "Service", 0)
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound from PutService, got %v", err)
+	}
+}
+
+func testServiceDeleteNotFound(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	err = s.DeleteService(ctx, organisationID, "missing")
+	if !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("expected ErrServiceNotFound, got %v", err)
+	}
+}
+
+func testServiceDeleteAssigned(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	serviceID, err := s.CreateService(ctx, organisationID, "service_name")
+	if err != nil {
+		t.Errorf("failed to create a service: %v", err)
+	}
+	roles := putRoles(t, r, organisationID, RoleScopeService, "service_group_1", "service_group_2")
+	err = s.AddUserToServiceGroups(ctx, organisationID, owner, serviceID, "service_group_1", "service_group_2")
+	if err != nil {
+		t.Errorf("failed to add user to service: %v", err)
+	}
+
+	err = s.DeleteService(ctx, organisationID, serviceID)
+	if err != nil {
+		t.Errorf("failed to delete service: %v", err)
+	}
+
+	// The deleted service must not appear in the details.
+	org := newOrganisation(organisationID, "Organisation Name")
+	groups := map[GroupName][]User{
+		GroupOwner: {owner},
+	}
+	expected := newOrganisationDetails(org, groups, nil)
+	expected.Roles = append(expected.Roles, roles...)
+	actual, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
+		t.Error(diff)
+	}
+
+	// Recreating a service with the same ID must not restore the old assignments.
+	err = s.PutService(ctx, organisationID, serviceID, "service_name", 0)
+	if err != nil {
+		t.Errorf("failed to recreate service: %v", err)
+	}
+	expected = newOrganisationDetails(org, groups, []Service{{ID: serviceID, Name: "service_name"}})
+	expected.Roles = append(expected.Roles, roles...)
+	actual, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func testServiceDeleteManyAssigned(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	serviceID, err := s.CreateService(ctx, organisationID, "service_name")
+	if err != nil {
+		t.Errorf("failed to create a service: %v", err)
+	}
+	putRoles(t, r, organisationID, RoleScopeService, "service_group")
+	// More assigned members than fit into a single transaction.
+	for i := 0; i < maxTransactionItems+5; i++ {
+		u := newUser(fmt.Sprintf("user%d@example.com", i), "First", "Last", "447901234567", createdAt)
+		err = s.AddUserToGroups(ctx, organisationID, u, []string{GroupMember}, map[string][]string{serviceID: {"service_group"}})
+		if err != nil {
+			t.Fatalf("failed to add user to groups: %v", err)
+		}
+	}
+
+	err = s.DeleteService(ctx, organisationID, serviceID)
+	if err != nil {
+		t.Errorf("failed to delete service: %v", err)
+	}
+	err = s.PutService(ctx, organisationID, serviceID, "service_name", 0)
+	if err != nil {
+		t.Errorf("failed to recreate service: %v", err)
+	}
+	actual, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if len(actual.Services) != 1 || len(actual.Services[0].Groups) != 0 {
+		t.Errorf("expected the recreated service to have no groups, got %+v", actual.Services)
+	}
+	if n := len(actual.Groups[GroupMember]); n != maxTransactionItems+5 {
+		t.Errorf("expected organisation groups to be unaffected, got %d members", n)
+	}
+}
+
+func testOrganisationPutVersionConflict(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+
+	// Two admins edit the name at the same time.
+	a, err := s.Get(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	b := a
+	a.Name = "A"
+	b.Name = "B"
+	err = s.Put(ctx, a)
+	if err != nil {
+		t.Errorf("failed to put organisation: %v", err)
+	}
+	err = s.Put(ctx, b)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for the stale update, got %v", err)
+	}
+
+	actual, err := s.Get(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	expected := newOrganisation(organisationID, "A")
+	expected.Version = 2
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func testServicePutVersionConflict(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	serviceID, err := s.CreateService(ctx, organisationID, "service_name")
+	if err != nil {
+		t.Errorf("failed to create a service: %v", err)
+	}
+
+	// Creating the same service again must fail.
+	err = s.PutService(ctx, organisationID, serviceID, "other_service_name", 0)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict when recreating the service, got %v", err)
+	}
+
+	// The version is exposed by GetDetails.
+	details, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if len(details.Services) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(details.Services))
+	}
+	version := details.Services[0].Version
+	if version != 1 {
+		t.Errorf("expected version 1, got %d", version)
+	}
+	err = s.PutService(ctx, organisationID, serviceID, "new_service_name", version)
+	if err != nil {
+		t.Errorf("failed to rename service: %v", err)
+	}
+	err = s.PutService(ctx, organisationID, serviceID, "stale_service_name", version)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for the stale update, got %v", err)
+	}
+}
+
+func testOrganisationUpdateUserVersionConflict(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+
+	// Read the owner's membership version.
+	details, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	version := details.Groups[GroupOwner][0].Version
+
+	err = s.UpdateUserDetails(ctx, organisationID, owner.ID, "A", "Last", "447901234567", version)
+	if err != nil {
+		t.Errorf("failed to update user: %v", err)
+	}
+	err = s.UpdateUserDetails(ctx, organisationID, owner.ID, "B", "Last", "447901234567", version)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for the stale update, got %v", err)
+	}
+	err = s.UpdateUserDetails(ctx, organisationID, "missing@example.com", "B", "Last", "447901234567", 1)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for a user that isn't a member, got %v", err)
+	}
+
+	details, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if actual := details.Groups[GroupOwner][0]; actual.FirstName != "A" || actual.Version != version+1 {
+		t.Errorf("expected first name %q at version %d, got %q at version %d", "A", version+1, actual.FirstName, actual.Version)
+	}
+
+	// The audit log names the details that changed, but not their values.
+	page, err := s.AuditLog(ctx, organisationID, 0, "")
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	last := page.Records[len(page.Records)-1]
+	if last.Action != AuditActionUpdateDetails || last.UserID != owner.ID || string(last.After) != `{"fields":["firstName"]}` {
+		t.Errorf("expected the update to record the changed field, got %+v", last)
+	}
+}
+
+func testOrganisationRename(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	invitee := newUser("invitee@example.com", "Invitee F", "Invitee L", "1567", createdAt)
+	_, err = r.users.Invite(ctx, "owner@example.com", invitee, newOrganisation(organisationID, "Organisation Name"), []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+
+	err = s.Rename(ctx, organisationID, "New Organisation Name", 1)
+	if err != nil {
+		t.Errorf("failed to rename organisation: %v", err)
+	}
+	err = s.Rename(ctx, organisationID, "Stale Organisation Name", 1)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for a stale rename, got %v", err)
+	}
+
+	expected := newOrganisation(organisationID, "New Organisation Name")
+	expected.Version = 2
+	actual, err := s.Get(ctx, organisationID)
 	if err != nil {
 		t.Errorf("failed to get organisation: %v", err)
 	}
 	if diff := cmp.Diff(expected, actual); diff != "" {
 		t.Error(diff)
 	}
+
+	ownerDetails, err := r.users.GetDetails(ctx, owner.ID)
+	if err != nil {
+		t.Errorf("failed to get owner details: %v", err)
+	}
+	if diff := cmp.Diff([]Organisation{expected}, ownerDetails.Organisations, ignoreVersions); diff != "" {
+		t.Errorf("owner's organisations not renamed:\n%v", diff)
+	}
+	inviteeDetails, err := r.users.GetDetails(ctx, invitee.ID)
+	if err != nil {
+		t.Errorf("failed to get invitee details: %v", err)
+	}
+	if len(inviteeDetails.Invitations) != 1 {
+		t.Fatalf("expected 1 invitation, got %d", len(inviteeDetails.Invitations))
+	}
+	if diff := cmp.Diff(expected, inviteeDetails.Invitations[0].Organisation, ignoreVersions); diff != "" {
+		t.Errorf("invitation not renamed:\n%v", diff)
+	}
+}
+
+func testOrganisationRenameManyMembers(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	// More members than fit into a single transaction.
+	var userIDs []string
+	for i := 0; i < maxTransactionItems+5; i++ {
+		u := newUser(fmt.Sprintf("user%d@example.com", i), "First", "Last", "447901234567", createdAt)
+		_, err = r.users.Invite(ctx, "owner@example.com", u, newOrganisation(organisationID, "Organisation Name"), []string{GroupMember}, nil)
+		if err != nil {
+			t.Fatalf("failed to invite user: %v", err)
+		}
+		userIDs = append(userIDs, u.ID)
+	}
+	// A member with no userOrganisation record, as earlier versions added directly.
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, newUser("direct@example.com", "First", "Last", "447901234567", createdAt), GroupMember)
+	if err != nil {
+		t.Errorf("failed to add user to group: %v", err)
+	}
+	err = r.deleteRecord(newUserOrganisationRecordHashKey("direct@example.com"), newUserOrganisationRecordRangeKey(organisationID))
+	if err != nil {
+		t.Fatalf("failed to delete userOrganisation record: %v", err)
+	}
+
+	err = s.Rename(ctx, organisationID, "New Organisation Name", 1)
+	if err != nil {
+		t.Errorf("failed to rename organisation: %v", err)
+	}
+	for _, userID := range userIDs {
+		details, err := r.users.GetDetails(ctx, userID)
+		if err != nil {
+			t.Fatalf("failed to get user details: %v", err)
+		}
+		if name := details.Invitations[0].Organisation.Name; name != "New Organisation Name" {
+			t.Errorf("invitation of %q not renamed, got %q", userID, name)
+		}
+	}
+	_, err = r.users.GetDetails(ctx, "direct@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected the rename not to create records for users without them, got %v", err)
+	}
+}
+
+func testOrganisationSyncName(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	// Put doesn't copy the name to members.
+	err = s.Put(ctx, Organisation{ID: organisationID, Name: "New Organisation Name", Version: 1})
+	if err != nil {
+		t.Errorf("failed to put organisation: %v", err)
+	}
+	err = s.SyncOrganisationName(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to sync organisation name: %v", err)
+	}
+	details, err := r.users.GetDetails(ctx, owner.ID)
+	if err != nil {
+		t.Errorf("failed to get owner details: %v", err)
+	}
+	if name := details.Organisations[0].Name; name != "New Organisation Name" {
+		t.Errorf("expected the name to be synchronised, got %q", name)
+	}
+	err = s.SyncOrganisationName(ctx, "missing")
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound, got %v", err)
+	}
+}
+
+func testOrganisationDelete(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	serviceID, err := s.CreateService(ctx, organisationID, "service_name")
+	if err != nil {
+		t.Errorf("failed to create a service: %v", err)
+	}
+	putRoles(t, r, organisationID, RoleScopeService, "service_group")
+	err = s.AddUserToServiceGroups(ctx, organisationID, owner, serviceID, "service_group")
+	if err != nil {
+		t.Errorf("failed to add user to service: %v", err)
+	}
+	invitee := newUser("invitee@example.com", "First", "Last", "447901234567", createdAt)
+	err = r.users.Put(ctx, invitee)
+	if err != nil {
+		t.Errorf("failed to put user: %v", err)
+	}
+	_, err = r.users.Invite(ctx, "owner@example.com", invitee, newOrganisation(organisationID, "Organisation Name"), []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	webhookID, err := s.CreateWebhook(ctx, organisationID, Webhook{URL: "https://example.com", Secret: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	err = s.PutWebhookDelivery(ctx, organisationID, WebhookDelivery{WebhookID: webhookID, EventID: "a", EventType: EventTypeMemberRemoved, Attempt: 1, At: createdAt, StatusCode: 200, Delivered: true})
+	if err != nil {
+		t.Fatalf("failed to record delivery: %v", err)
+	}
+
+	var progress []DeleteProgress
+	err = s.Delete(ctx, organisationID, 1, func(p DeleteProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("failed to delete organisation: %v", err)
+	}
+	if len(progress) == 0 {
+		t.Fatalf("expected progress to be reported")
+	}
+	if last := progress[len(progress)-1]; last.Deleted != last.Total {
+		t.Errorf("expected the final progress to be complete, got %+v", last)
+	}
+
+	_, err = s.GetDetails(ctx, organisationID)
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound, got %v", err)
+	}
+	details, err := r.users.GetDetails(ctx, invitee.ID)
+	if err != nil {
+		t.Fatalf("failed to get user details: %v", err)
+	}
+	if len(details.Organisations) != 0 || len(details.Invitations) != 0 {
+		t.Errorf("expected the invitation to be deleted, got %+v", details)
+	}
+	_, err = r.users.GetDetails(ctx, owner.ID)
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected the owner's membership to be deleted, got %v", err)
+	}
+	deliveries, err := s.ListWebhookDeliveries(ctx, organisationID, webhookID)
+	if err != nil || len(deliveries) != 0 {
+		t.Errorf("expected the webhook's deliveries to be deleted, got %v, %v", deliveries, err)
+	}
+	page, err := s.AuditLog(ctx, organisationID, 0, "")
+	if err != nil {
+		t.Fatalf("failed to get audit log: %v", err)
+	}
+	if len(page.Records) != 1 || page.Records[0].Action != AuditActionDeleteOrganisation {
+		t.Errorf("expected only the record of the deletion to be kept, got %+v", page.Records)
+	}
+
+	// Records can't be added to the deleted Organisation.
+	_, err = s.CreateWebhook(ctx, organisationID, Webhook{URL: "https://example.com", Secret: "secret"})
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound creating a webhook, got %v", err)
+	}
+	err = s.PutRole(ctx, organisationID, Role{Name: "group", Description: "group", Scope: RoleScopeOrganisation})
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound creating a role, got %v", err)
+	}
+}
+
+func testOrganisationDeleteVersionConflict(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	err = s.Rename(ctx, organisationID, "New Organisation Name", 1)
+	if err != nil {
+		t.Errorf("failed to rename organisation: %v", err)
+	}
+
+	err = s.Delete(ctx, organisationID, 1, nil)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for a stale version, got %v", err)
+	}
+	details, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("expected the organisation not to be deleted, got %v", err)
+	}
+	if len(details.Groups[GroupOwner]) != 1 {
+		t.Errorf("expected the owner not to be deleted, got %+v", details.Groups)
+	}
+
+	err = s.Delete(ctx, "missing", 1, nil)
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound, got %v", err)
+	}
+}
+
+func testOrganisationDeleteManyMembers(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	// More records than fit into a single batch.
+	var userIDs []string
+	for i := 0; i < maxBatchWriteItems+5; i++ {
+		u := newUser(fmt.Sprintf("user%d@example.com", i), "First", "Last", "447901234567", createdAt)
+		_, err = r.users.Invite(ctx, "owner@example.com", u, newOrganisation(organisationID, "Organisation Name"), []string{GroupMember}, nil)
+		if err != nil {
+			t.Fatalf("failed to invite user: %v", err)
+		}
+		userIDs = append(userIDs, u.ID)
+	}
+
+	err = s.Delete(ctx, organisationID, 1, nil)
+	if err != nil {
+		t.Fatalf("failed to delete organisation: %v", err)
+	}
+	for _, userID := range userIDs {
+		_, err = r.users.GetDetails(ctx, userID)
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected the invitation of %q to be deleted, got %v", userID, err)
+		}
+	}
+}
+
+func testOrganisationRemoveLastOwner(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+
+	err = s.RemoveUser(ctx, organisationID, owner.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when removing the last owner, got %v", err)
+	}
+	err = s.RemoveUserFromOrganisationGroups(ctx, organisationID, owner.ID, GroupOwner)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when removing the last owner from the owner group, got %v", err)
+	}
+	var lastOwnerErr *LastOwnerError
+	if !errors.As(err, &lastOwnerErr) || lastOwnerErr.UserID != owner.ID {
+		t.Errorf("expected a *LastOwnerError for the owner, got %v", err)
+	}
+	expected := newOrganisationDetails(newOrganisation(organisationID, "Organisation Name"), map[GroupName][]User{
+		GroupOwner: {owner},
+	}, nil)
+	actual, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
+		t.Error(diff)
+	}
+
+	// Invitees can't be made owners until they accept, otherwise revoking the invitation would leave no owners.
+	invitee := newUser("invitee@example.com", "Invited", "User", "447901234567", createdAt)
+	_, err = r.users.Invite(ctx, owner.ID, invitee, newOrganisation(organisationID, "Organisation Name"), []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, invitee, GroupOwner)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember when adding an invitee to the owner group, got %v", err)
+	}
+	err = s.RemoveUserFromOrganisationGroups(ctx, organisationID, owner.ID, GroupOwner)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when the only other owner is an invitee, got %v", err)
+	}
+	err = s.RevokeInvite(ctx, organisationID, invitee.ID)
+	if err != nil {
+		t.Errorf("failed to revoke invitation: %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, invitee, GroupOwner)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember when adding a revoked invitee to the owner group, got %v", err)
+	}
+	actual, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
+		t.Errorf("expected the owner to remain:\n%v", diff)
+	}
+
+	// Once there's a second owner, the first can be removed, but not the second.
+	second := newUser("second@example.com", "Second", "Owner", "447901234567", createdAt)
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, second, GroupOwner)
+	if err != nil {
+		t.Errorf("failed to add owner: %v", err)
+	}
+	err = s.RemoveUserFromOrganisationGroups(ctx, organisationID, owner.ID, GroupOwner)
+	if err != nil {
+		t.Errorf("failed to remove owner from the owner group: %v", err)
+	}
+	err = s.RemoveUser(ctx, organisationID, second.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when removing the remaining owner, got %v", err)
+	}
+	err = s.RemoveUser(ctx, organisationID, owner.ID)
+	if err != nil {
+		t.Errorf("failed to remove former owner: %v", err)
+	}
+}
+
+func testOrganisationTransferOwnership(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, owner, GroupMember)
+	if err != nil {
+		t.Errorf("failed to add owner to member group: %v", err)
+	}
+	newOwner := newUser("new@example.com", "New", "Owner", "447901234567", createdAt)
+
+	// Ownership can only be transferred to a member that has accepted their invitation.
+	err = s.TransferOwnership(ctx, organisationID, owner.ID, newOwner)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember when transferring to a user that isn't a member, got %v", err)
+	}
+	_, err = r.users.Invite(ctx, owner.ID, newOwner, newOrganisation(organisationID, "Organisation Name"), []string{GroupMember}, nil)
+	if err != nil {
+		t.Fatalf("failed to invite new owner: %v", err)
+	}
+	err = s.TransferOwnership(ctx, organisationID, owner.ID, newOwner)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember when transferring to a pending invitee, got %v", err)
+	}
+	err = s.RemoveUser(ctx, organisationID, newOwner.ID)
+	if err != nil {
+		t.Fatalf("failed to remove pending invitee: %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, newOwner)
+	if err != nil {
+		t.Fatalf("failed to add new owner: %v", err)
+	}
+
+	err = s.TransferOwnership(ctx, organisationID, newOwner.ID, owner)
+	if !errors.Is(err, ErrNotOwner) {
+		t.Errorf("expected ErrNotOwner when transferring from a user that isn't an owner, got %v", err)
+	}
+	err = s.TransferOwnership(ctx, organisationID, owner.ID, newOwner)
+	if err != nil {
+		t.Fatalf("failed to transfer ownership: %v", err)
+	}
+
+	expected := newOrganisationDetails(newOrganisation(organisationID, "Organisation Name"), map[GroupName][]User{
+		GroupOwner:  {newOwner},
+		GroupMember: {owner},
+	}, nil)
+	actual, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expected, actual, ignoreVersions); diff != "" {
+		t.Error(diff)
+	}
+
+	// The new owner is now the last owner.
+	err = s.RemoveUser(ctx, organisationID, newOwner.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner, got %v", err)
+	}
+	err = s.RemoveUser(ctx, organisationID, owner.ID)
+	if err != nil {
+		t.Errorf("failed to remove former owner: %v", err)
+	}
+}
+
+func testOrganisationOwnersBackfill(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	member := newUser("member@example.com", "Member", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, member, GroupMember)
+	if err != nil {
+		t.Fatalf("failed to add member: %v", err)
+	}
+
+	// Organisations created before owners were recorded don't have an owners record.
+	deleteOwners := func() {
+		err := r.deleteRecord(newOrganisationOwnersRecordHashKey(organisationID), newOrganisationOwnersRecordRangeKey())
+		if err != nil {
+			t.Fatalf("failed to delete owners: %v", err)
+		}
+	}
+	deleteOwners()
+	err = s.RemoveUser(ctx, organisationID, owner.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when removing the last owner, got %v", err)
+	}
+	deleteOwners()
+	err = s.TransferOwnership(ctx, organisationID, owner.ID, member)
+	if err != nil {
+		t.Fatalf("failed to transfer ownership: %v", err)
+	}
+	err = s.RemoveUser(ctx, organisationID, member.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when removing the new owner, got %v", err)
+	}
+	err = s.RemoveUser(ctx, organisationID, owner.ID)
+	if err != nil {
+		t.Errorf("failed to remove former owner: %v", err)
+	}
+}
+
+func testOrganisationRemoveUserMembership(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	org := newOrganisation(organisationID, "Organisation Name")
+	member := newUser("member@example.com", "First", "Last", "447901234567", createdAt)
+	err = r.users.Put(ctx, member)
+	if err != nil {
+		t.Errorf("failed to put user: %v", err)
+	}
+	token, err := r.users.Invite(ctx, "owner@example.com", member, org, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, member, org, token)
+	if err != nil {
+		t.Errorf("failed to accept invite: %v", err)
+	}
+
+	err = s.RemoveUser(ctx, organisationID, member.ID)
+	if err != nil {
+		t.Errorf("failed to remove user: %v", err)
+	}
+	details, err := r.users.GetDetails(ctx, member.ID)
+	if err != nil {
+		t.Fatalf("failed to get user details: %v", err)
+	}
+	if len(details.Organisations) != 0 || len(details.Invitations) != 0 {
+		t.Errorf("expected the user's record of the organisation to be deleted, got %+v", details)
+	}
+}
+
+func testOrganisationInvitations(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	org := newOrganisation(organisationID, "Organisation Name")
+	a := newUser("a@example.com", "A", "Last", "447901234567", createdAt)
+	b := newUser("b@example.com", "B", "Last", "447901234567", createdAt)
+	oldToken, err := r.users.Invite(ctx, owner.ID, a, org, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	bToken, err := r.users.Invite(ctx, owner.ID, b, org, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+
+	invitations, err := s.ListInvitations(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to list invitations: %v", err)
+	}
+	if len(invitations) != 2 || invitations[0].UserID != a.ID || invitations[1].UserID != b.ID {
+		t.Fatalf("expected invitations for both users, got %+v", invitations)
+	}
+	if invitations[0].InvitedBy != owner.ID || invitations[0].Organisation.Name != "Organisation Name" {
+		t.Errorf("unexpected invitation: %+v", invitations[0])
+	}
+
+	// Resending the invitation replaces the token.
+	newToken, err := s.ResendInvite(ctx, organisationID, a.ID)
+	if err != nil {
+		t.Errorf("failed to resend invitation: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, a, org, oldToken)
+	if !errors.Is(err, ErrInvalidInvitationToken) {
+		t.Errorf("expected the old token to be rejected, got %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, a, org, newToken)
+	if err != nil {
+		t.Errorf("failed to accept invitation: %v", err)
+	}
+	_, err = s.ResendInvite(ctx, organisationID, a.ID)
+	if !errors.Is(err, ErrInvitationAlreadyAccepted) {
+		t.Errorf("expected ErrInvitationAlreadyAccepted when resending an accepted invitation, got %v", err)
+	}
+
+	// A revoked invitation can't be accepted.
+	err = s.RevokeInvite(ctx, organisationID, b.ID)
+	if err != nil {
+		t.Errorf("failed to revoke invitation: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, b, org, bToken)
+	if !errors.Is(err, ErrInvitationRevoked) {
+		t.Errorf("expected ErrInvitationRevoked, got %v", err)
+	}
+	err = s.RevokeInvite(ctx, organisationID, b.ID)
+	if !errors.Is(err, ErrInvitationRevoked) {
+		t.Errorf("expected ErrInvitationRevoked when revoking again, got %v", err)
+	}
+	err = s.RevokeInvite(ctx, organisationID, "missing@example.com")
+	if !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("expected ErrInvitationNotFound, got %v", err)
+	}
+	details, err := r.users.GetDetails(ctx, b.ID)
+	if err != nil {
+		t.Fatalf("failed to get user details: %v", err)
+	}
+	if len(details.Invitations) != 1 || details.Invitations[0].RevokedAt == nil {
+		t.Errorf("expected the invitation to be shown as revoked, got %+v", details.Invitations)
+	}
+
+	invitations, err = s.ListInvitations(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to list invitations: %v", err)
+	}
+	if len(invitations) != 0 {
+		t.Errorf("expected no pending invitations, got %+v", invitations)
+	}
+	orgDetails, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	for _, member := range orgDetails.Groups[GroupMember] {
+		if member.ID == b.ID {
+			t.Errorf("expected the revoked invitee not to be listed as a member")
+		}
+	}
+}
+
+func testOrganisationInvitees(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	serviceID, err := s.CreateService(ctx, organisationID, "service")
+	if err != nil {
+		t.Errorf("failed to create service: %v", err)
+	}
+	org := newOrganisation(organisationID, "Organisation Name")
+	invitee := newUser("invitee@example.com", "Invited", "Last", "447901234567", createdAt)
+	token, err := r.users.Invite(ctx, owner.ID, invitee, org, []string{GroupOwner},
+		map[string][]string{serviceID: {"admin"}})
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+
+	// The invitee isn't a member until they accept.
+	details, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff([]User{owner}, details.Groups[GroupOwner], ignoreVersions); diff != "" {
+		t.Errorf("expected only the owner to be listed:\n%v", diff)
+	}
+	if len(details.Services) != 1 || len(details.Services[0].Groups) != 0 {
+		t.Errorf("expected the invitee not to be listed in service groups, got %+v", details.Services)
+	}
+	if len(details.Invitees) != 1 {
+		t.Fatalf("expected 1 invitee, got %+v", details.Invitees)
+	}
+	actual := details.Invitees[0]
+	if actual.ID != invitee.ID || actual.InvitedBy != owner.ID || actual.InvitedAt.IsZero() {
+		t.Errorf("unexpected invitee: %+v", actual)
+	}
+	if diff := cmp.Diff([]GroupName{GroupOwner}, actual.Groups); diff != "" {
+		t.Errorf("unexpected invitee groups:\n%v", diff)
+	}
+	if diff := cmp.Diff(map[string][]GroupName{serviceID: {"admin"}}, actual.ServiceGroups); diff != "" {
+		t.Errorf("unexpected invitee service groups:\n%v", diff)
+	}
+
+	// Pending invitees can be listed in groups on request.
+	details, err = s.GetDetails(ctx, organisationID, IncludePending())
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if n := len(details.Groups[GroupOwner]); n != 2 {
+		t.Errorf("expected the invitee to be listed as a pending owner, got %d owners", n)
+	}
+	if n := len(details.Services[0].Groups["admin"]); n != 1 {
+		t.Errorf("expected the invitee to be listed as a pending service admin, got %d", n)
+	}
+
+	// Pending owners can't take over from the last owner.
+	err = s.RemoveUserFromOrganisationGroups(ctx, organisationID, owner.ID, GroupOwner)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner while the new owner's invitation is pending, got %v", err)
+	}
+
+	err = r.users.AcceptInvite(ctx, invitee, org, token)
+	if err != nil {
+		t.Fatalf("failed to accept invitation: %v", err)
+	}
+	details, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if len(details.Invitees) != 0 || len(details.Groups[GroupOwner]) != 2 {
+		t.Errorf("expected the invitee to become an owner, got %+v", details)
+	}
+	err = s.RemoveUserFromOrganisationGroups(ctx, organisationID, owner.ID, GroupOwner)
+	if err != nil {
+		t.Errorf("expected the original owner to be removable once the invitation is accepted, got %v", err)
+	}
+}
+
+func testOrganisationGetUserGroups(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	err = s.AddUserToGroups(ctx, organisationID, owner, nil, map[string][]string{"service": {"admin"}})
+	if err != nil {
+		t.Errorf("failed to add owner to service group: %v", err)
+	}
+	groups, serviceIDToGroups, err := s.GetUserGroups(ctx, organisationID, owner.ID)
+	if err != nil {
+		t.Fatalf("failed to get groups: %v", err)
+	}
+	if diff := cmp.Diff([]string{GroupOwner}, groups); diff != "" {
+		t.Errorf("unexpected groups:\n%v", diff)
+	}
+	if diff := cmp.Diff(map[string][]string{"service": {"admin"}}, serviceIDToGroups); diff != "" {
+		t.Errorf("unexpected service groups:\n%v", diff)
+	}
+
+	invitee := newUser("invitee@example.com", "Invited", "Last", "447901234567", createdAt)
+	_, err = r.users.Invite(ctx, owner.ID, invitee, newOrganisation(organisationID, "Organisation Name"), []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	_, _, err = s.GetUserGroups(ctx, organisationID, invitee.ID)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember for a pending invitee, got %v", err)
+	}
+	_, _, err = s.GetUserGroups(ctx, organisationID, "missing@example.com")
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+}
+
+func testOrganisationRoles(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	billing := Role{
+		Name:        "billing",
+		Description: "Can view invoices.",
+		Permissions: []Permission{"billing:read"},
+		Scope:       RoleScopeOrganisation,
+	}
+	err = s.PutRole(ctx, organisationID, billing)
+	if err != nil {
+		t.Errorf("failed to create role: %v", err)
+	}
+	err = s.PutRole(ctx, organisationID, billing)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict when creating the role again, got %v", err)
+	}
+	billing.Permissions = append(billing.Permissions, "billing:pay")
+	billing.Version = 1
+	err = s.PutRole(ctx, organisationID, billing)
+	if err != nil {
+		t.Errorf("failed to update role: %v", err)
+	}
+	for _, invalid := range []struct {
+		role     Role
+		expected error
+	}{
+		{role: Role{Name: GroupOwner, Scope: RoleScopeOrganisation}, expected: ErrBuiltInRole},
+		{role: Role{Name: "a/b", Scope: RoleScopeOrganisation}, expected: ErrInvalidRole},
+		{role: Role{Name: "billing", Scope: "team"}, expected: ErrInvalidRole},
+	} {
+		err = s.PutRole(ctx, organisationID, invalid.role)
+		if !errors.Is(err, invalid.expected) {
+			t.Errorf("expected %v for role %+v, got %v", invalid.expected, invalid.role, err)
+		}
+	}
+
+	roles, err := s.ListRoles(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to list roles: %v", err)
+	}
+	expected := append(append([]Role(nil), DefaultRoles...), billing)
+	if diff := cmp.Diff(expected, roles, ignoreVersions); diff != "" {
+		t.Errorf("unexpected roles:\n%v", diff)
+	}
+
+	// Groups must be defined by a role of the right scope.
+	member := newUser("member@example.com", "Member", "Last", "447901234567", createdAt)
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, member, "ownr")
+	if !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound for a misspelled group, got %v", err)
+	}
+	err = s.AddUserToServiceGroups(ctx, organisationID, member, "service", "billing")
+	if !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound for an organisation role used as a service group, got %v", err)
+	}
+	_, err = r.users.Invite(ctx, owner.ID, member, newOrganisation(organisationID, "Organisation Name"), []string{"ownr"}, nil)
+	if !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound when inviting to a misspelled group, got %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, member, GroupMember, "billing")
+	if err != nil {
+		t.Errorf("failed to add user to groups: %v", err)
+	}
+
+	// Custom roles grant their permissions.
+	authoriser := NewAuthoriser(s, DefaultPolicy)
+	ok, err := authoriser.Can(ctx, member.ID, "billing:pay", OrganisationResource(organisationID))
+	if err != nil || !ok {
+		t.Errorf("expected the billing role to grant billing:pay, got %v, %v", ok, err)
+	}
+	ok, err = authoriser.Can(ctx, member.ID, PermissionOrganisationRename, OrganisationResource(organisationID))
+	if err != nil || ok {
+		t.Errorf("expected the billing role not to grant %s, got %v, %v", PermissionOrganisationRename, ok, err)
+	}
+
+	details, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expected, details.Roles, ignoreVersions); diff != "" {
+		t.Errorf("unexpected roles in details:\n%v", diff)
+	}
+
+	// Deleting a role stops Users from being added to its group, but doesn't remove existing members.
+	err = s.DeleteRole(ctx, organisationID, RoleScopeOrganisation, "billing")
+	if err != nil {
+		t.Errorf("failed to delete role: %v", err)
+	}
+	err = s.DeleteRole(ctx, organisationID, RoleScopeOrganisation, "billing")
+	if !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound when deleting the role again, got %v", err)
+	}
+	err = s.DeleteRole(ctx, organisationID, RoleScopeOrganisation, GroupOwner)
+	if !errors.Is(err, ErrBuiltInRole) {
+		t.Errorf("expected ErrBuiltInRole, got %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, owner, "billing")
+	if !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("expected ErrRoleNotFound after deleting the role, got %v", err)
+	}
+	details, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if len(details.Groups["billing"]) != 1 {
+		t.Errorf("expected the member to remain in the billing group, got %v", details.Groups)
+	}
+}
+
+func testOrganisationNestedGroups(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	putRoles(t, r, organisationID, RoleScopeOrganisation, "dba", "sre")
+	platform := Role{
+		Name:        "platform",
+		Description: "Can view the infrastructure.",
+		Permissions: []Permission{"infra:read"},
+		Scope:       RoleScopeOrganisation,
+		Subgroups:   []GroupName{"dba", "sre"},
+	}
+	err = s.PutRole(ctx, organisationID, platform)
+	if err != nil {
+		t.Errorf("failed to create role with subgroups: %v", err)
+	}
+	ops := Role{
+		Name:        "ops",
+		Permissions: []Permission{"service:restart"},
+		Scope:       RoleScopeService,
+		Subgroups:   []GroupName{ServiceGroupDeployer},
+	}
+	err = s.PutRole(ctx, organisationID, ops)
+	if err != nil {
+		t.Errorf("failed to create service role with subgroups: %v", err)
+	}
+
+	// Groups can't contain themselves, or groups that don't exist.
+	for _, invalid := range []struct {
+		role     Role
+		expected error
+	}{
+		{role: Role{Name: "sre", Scope: RoleScopeOrganisation, Subgroups: []GroupName{"platform"}, Version: 1}, expected: ErrGroupCycle},
+		{role: Role{Name: "sre", Scope: RoleScopeOrganisation, Subgroups: []GroupName{"sre"}, Version: 1}, expected: ErrGroupCycle},
+		{role: Role{Name: "sre", Scope: RoleScopeOrganisation, Subgroups: []GroupName{"missing"}, Version: 1}, expected: ErrRoleNotFound},
+		{role: Role{Name: "infra", Scope: RoleScopeOrganisation, Subgroups: []GroupName{"ops"}}, expected: ErrRoleNotFound},
+	} {
+		err = s.PutRole(ctx, organisationID, invalid.role)
+		if !errors.Is(err, invalid.expected) {
+			t.Errorf("expected %v for role %+v, got %v", invalid.expected, invalid.role, err)
+		}
+	}
+
+	member := newUser("member@example.com", "Member", "Last", "447901234567", createdAt)
+	org := newOrganisation(organisationID, "Organisation Name")
+	token, err := r.users.Invite(ctx, owner.ID, member, org, []string{GroupMember, "sre"}, map[string][]string{"service": {ServiceGroupDeployer}})
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, member, org, token)
+	if err != nil {
+		t.Errorf("failed to accept invite: %v", err)
+	}
+	expected := EffectiveGroups{
+		Organisation: []GroupName{GroupMember, "platform", "sre"},
+		Services:     map[string][]GroupName{"service": {ServiceGroupDeployer, "ops"}},
+	}
+	eg, err := s.EffectiveGroups(ctx, organisationID, member.ID)
+	if err != nil {
+		t.Fatalf("failed to get effective groups: %v", err)
+	}
+	if diff := cmp.Diff(expected, eg); diff != "" {
+		t.Errorf("unexpected effective groups:\n%v", diff)
+	}
+	organisationIDToGroups, err := r.users.EffectiveGroups(ctx, member.ID)
+	if err != nil {
+		t.Fatalf("failed to get the user's effective groups: %v", err)
+	}
+	if diff := cmp.Diff(map[string]EffectiveGroups{organisationID: expected}, organisationIDToGroups); diff != "" {
+		t.Errorf("unexpected effective groups of the user:\n%v", diff)
+	}
+	_, err = s.EffectiveGroups(ctx, organisationID, "missing@example.com")
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember, got %v", err)
+	}
+	_, err = r.users.EffectiveGroups(ctx, "missing@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+
+	// Members of a subgroup are granted the permissions of the groups that contain it.
+	authoriser := NewAuthoriser(s, DefaultPolicy)
+	ok, err := authoriser.Can(ctx, member.ID, "infra:read", OrganisationResource(organisationID))
+	if err != nil || !ok {
+		t.Errorf("expected sre members to be granted the permissions of platform, got %v, %v", ok, err)
+	}
+	ok, err = authoriser.Can(ctx, member.ID, "service:restart", ServiceResource(organisationID, "service"))
+	if err != nil || !ok {
+		t.Errorf("expected deployers to be granted the permissions of ops, got %v, %v", ok, err)
+	}
+	ok, err = authoriser.Can(ctx, member.ID, "service:restart", ServiceResource(organisationID, "other"))
+	if err != nil || ok {
+		t.Errorf("expected service groups not to apply to other services, got %v, %v", ok, err)
+	}
+
+	// Removing the subgroup removes the inherited groups.
+	platform.Subgroups = []GroupName{"dba"}
+	platform.Version = 1
+	err = s.PutRole(ctx, organisationID, platform)
+	if err != nil {
+		t.Errorf("failed to update role: %v", err)
+	}
+	eg, err = s.EffectiveGroups(ctx, organisationID, member.ID)
+	if err != nil {
+		t.Fatalf("failed to get effective groups: %v", err)
+	}
+	if diff := cmp.Diff([]GroupName{GroupMember, "sre"}, eg.Organisation); diff != "" {
+		t.Errorf("unexpected effective groups after removing the subgroup:\n%v", diff)
+	}
+}
+
+func testOrganisationGrants(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	err = s.PutService(ctx, organisationID, "service", "Service Name", 0)
+	if err != nil {
+		t.Errorf("failed to create service: %v", err)
+	}
+	putRoles(t, r, organisationID, RoleScopeOrganisation, "oncall")
+
+	// Grants are stored to the second.
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	contractor := newUser("contractor@example.com", "Contract", "Or", "447901234567", createdAt)
+	err = s.AddUserToGroups(ctx, organisationID, contractor, []string{"oncall"}, map[string][]string{"service": {ServiceGroupDeployer}}, Until(expiresAt))
+	if err != nil {
+		t.Fatalf("failed to grant groups: %v", err)
+	}
+	for _, invalid := range []struct {
+		groups    []string
+		expiresAt time.Time
+	}{
+		{groups: []string{GroupMember}, expiresAt: time.Now().Add(-time.Minute)},
+		{groups: []string{GroupOwner}, expiresAt: expiresAt},
+	} {
+		err = s.AddUserToGroups(ctx, organisationID, contractor, invalid.groups, nil, Until(invalid.expiresAt))
+		if !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("expected ErrInvalidGrant for %v until %v, got %v", invalid.groups, invalid.expiresAt, err)
+		}
+	}
+
+	groups, serviceIDToGroups, err := s.GetUserGroups(ctx, organisationID, contractor.ID)
+	if err != nil {
+		t.Fatalf("failed to get groups: %v", err)
+	}
+	if diff := cmp.Diff([]string{"oncall"}, groups); diff != "" {
+		t.Errorf("unexpected groups:\n%v", diff)
+	}
+	if diff := cmp.Diff(map[string][]string{"service": {ServiceGroupDeployer}}, serviceIDToGroups); diff != "" {
+		t.Errorf("unexpected service groups:\n%v", diff)
+	}
+
+	details, err := s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	expectedGrants := []Grant{
+		{UserID: contractor.ID, Group: "oncall", ExpiresAt: expiresAt},
+		{UserID: contractor.ID, Group: ServiceGroupDeployer, ServiceID: "service", ExpiresAt: expiresAt},
+	}
+	if diff := cmp.Diff(expectedGrants, details.Grants); diff != "" {
+		t.Errorf("unexpected grants:\n%v", diff)
+	}
+	if diff := cmp.Diff([]User{contractor}, details.Groups["oncall"], ignoreVersions); diff != "" {
+		t.Errorf("expected the contractor to be in the oncall group:\n%v", diff)
+	}
+	if len(details.Services) != 1 {
+		t.Fatalf("expected 1 service, got %d", len(details.Services))
+	}
+	if diff := cmp.Diff([]User{contractor}, details.Services[0].Groups[ServiceGroupDeployer], ignoreVersions); diff != "" {
+		t.Errorf("expected the contractor to be a deployer:\n%v", diff)
+	}
+
+	// Nothing has expired yet.
+	deleted, err := s.SweepExpiredGrants(ctx, organisationID)
+	if err != nil || deleted != 0 {
+		t.Errorf("expected nothing to be swept, got %d, %v", deleted, err)
+	}
+
+	// Removing the User from a group removes the grant.
+	err = s.RemoveUserFromGroups(ctx, organisationID, contractor.ID, []string{"oncall"}, nil)
+	if err != nil {
+		t.Errorf("failed to remove user from groups: %v", err)
+	}
+	details, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if diff := cmp.Diff(expectedGrants[1:], details.Grants); diff != "" {
+		t.Errorf("unexpected grants after removing the user from the group:\n%v", diff)
+	}
+
+	// Removing the User from the Organisation removes their grants, so they don't return if the User rejoins.
+	err = s.RemoveUser(ctx, organisationID, contractor.ID)
+	if err != nil {
+		t.Errorf("failed to remove user: %v", err)
+	}
+	err = s.AddUserToOrganisationGroups(ctx, organisationID, contractor, GroupMember)
+	if err != nil {
+		t.Errorf("failed to add user: %v", err)
+	}
+	details, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+	if len(details.Grants) != 0 {
+		t.Errorf("expected the grants to be removed with the user, got %v", details.Grants)
+	}
+
+	// The same applies to Users that leave the Organisation.
+	org := newOrganisation(organisationID, "Organisation Name")
+	leaver := newUser("leaver@example.com", "Lea", "Ver", "447901234567", createdAt)
+	joinAs := func(groups ...string) {
+		token, err := r.users.Invite(ctx, owner.ID, leaver, org, groups, nil)
+		if err != nil {
+			t.Fatalf("failed to invite user: %v", err)
+		}
+		err = r.users.AcceptInvite(ctx, leaver, org, token)
+		if err != nil {
+			t.Fatalf("failed to accept invitation: %v", err)
+		}
+	}
+	joinAs(GroupMember)
+	err = s.AddUserToGroups(ctx, organisationID, leaver, []string{"oncall"}, nil, Until(expiresAt))
+	if err != nil {
+		t.Fatalf("failed to grant groups: %v", err)
+	}
+	err = r.users.LeaveOrganisation(ctx, leaver.ID, organisationID)
+	if err != nil {
+		t.Fatalf("failed to leave organisation: %v", err)
+	}
+	joinAs()
+	groups, _, err = s.GetUserGroups(ctx, organisationID, leaver.ID)
+	if err != nil {
+		t.Fatalf("failed to get groups: %v", err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("expected the grants to be removed when the user left, got %v", groups)
+	}
+}
+
+func testAuditLog(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+	err = s.PutService(ctx, organisationID, "service", "Service Name", 0)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	org, err := s.Get(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to get organisation: %v", err)
+	}
+
+	// Invitations are made by the inviter, and accepted by the invitee, unless another actor is set.
+	invitee := newUser("invitee@example.com", "Invit", "Ee", "447901234567", createdAt)
+	token, err := r.users.Invite(ctx, owner.ID, invitee, org, []string{GroupMember}, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+	err = r.users.AcceptInvite(ctx, invitee, org, token)
+	if err != nil {
+		t.Fatalf("failed to accept invite: %v", err)
+	}
+	actx := WithActor(ctx, "admin@example.com")
+	err = s.AddUserToServiceGroups(actx, organisationID, invitee, "service", ServiceGroupDeployer)
+	if err != nil {
+		t.Fatalf("failed to add user to groups: %v", err)
+	}
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	err = s.AddUserToGroups(actx, organisationID, invitee, []string{GroupMember}, nil, Until(expiresAt))
+	if err != nil {
+		t.Fatalf("failed to grant groups: %v", err)
+	}
+	err = s.RemoveUserFromServiceGroups(actx, organisationID, invitee.ID, "service", ServiceGroupDeployer)
+	if err != nil {
+		t.Fatalf("failed to remove user from groups: %v", err)
+	}
+	err = s.RemoveUser(actx, organisationID, invitee.ID)
+	if err != nil {
+		t.Fatalf("failed to remove user: %v", err)
+	}
+	// Removing a user that isn't a member changes nothing, so isn't recorded.
+	err = s.RemoveUser(actx, organisationID, invitee.ID)
+	if err != nil {
+		t.Fatalf("failed to remove user again: %v", err)
+	}
+	err = s.DeleteService(actx, organisationID, "service")
+	if err != nil {
+		t.Fatalf("failed to delete service: %v", err)
+	}
+	err = s.Rename(actx, organisationID, "New Name", org.Version)
+	if err != nil {
+		t.Fatalf("failed to rename organisation: %v", err)
+	}
+	err = s.PutRole(actx, organisationID, Role{Name: "readers", Scope: RoleScopeOrganisation, Permissions: []Permission{PermissionOrganisationRead}})
+	if err != nil {
+		t.Fatalf("failed to create role: %v", err)
+	}
+	err = s.DeleteRole(actx, organisationID, RoleScopeOrganisation, "readers")
+	if err != nil {
+		t.Fatalf("failed to delete role: %v", err)
+	}
+	// Webhook secrets aren't recorded.
+	webhookID, err := s.CreateWebhook(actx, organisationID, Webhook{URL: "https://example.com", Secret: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	member := `{"groups":["` + GroupMember + `"]}`
+	deployer := `{"groups":["` + GroupMember + `"],"serviceGroups":{"service":["` + ServiceGroupDeployer + `"]}}`
+	expiry, err := json.Marshal(expiresAt)
+	if err != nil {
+		t.Fatalf("failed to marshal expiry: %v", err)
+	}
+	expectedUserLog := []AuditRecord{
+		{OrganisationID: organisationID, UserID: invitee.ID, Actor: owner.ID, Action: AuditActionInvite,
+			After: json.RawMessage(`{"groups":["` + GroupMember + `"],"pending":true}`)},
+		{OrganisationID: organisationID, UserID: invitee.ID, Actor: invitee.ID, Action: AuditActionAcceptInvite,
+			Before: json.RawMessage(`{"groups":["` + GroupMember + `"],"pending":true}`), After: json.RawMessage(member)},
+		{OrganisationID: organisationID, UserID: invitee.ID, Actor: "admin@example.com", Action: AuditActionAddToGroups,
+			Before: json.RawMessage(member), After: json.RawMessage(deployer)},
+		{OrganisationID: organisationID, UserID: invitee.ID, Actor: "admin@example.com", Action: AuditActionGrant,
+			After: json.RawMessage(`{"groups":["` + GroupMember + `"],"expiresAt":` + string(expiry) + `}`)},
+		{OrganisationID: organisationID, UserID: invitee.ID, Actor: "admin@example.com", Action: AuditActionRemoveFromGroups,
+			Before: json.RawMessage(deployer), After: json.RawMessage(member)},
+		{OrganisationID: organisationID, UserID: invitee.ID, Actor: "admin@example.com", Action: AuditActionRemoveUser,
+			Before: json.RawMessage(member)},
+	}
+	readers := `{"name":"readers","scope":"` + string(RoleScopeOrganisation) + `","permissions":["` + string(PermissionOrganisationRead) + `"]}`
+	expectedOrganisationLog := []AuditRecord{
+		{OrganisationID: organisationID, UserID: owner.ID, Actor: owner.ID, Action: AuditActionCreateOrganisation,
+			After: json.RawMessage(`{"name":"Organisation Name"}`)},
+		{OrganisationID: organisationID, ServiceID: "service", Action: AuditActionPutService,
+			After: json.RawMessage(`{"name":"Service Name"}`)},
+	}
+	expectedOrganisationLog = append(expectedOrganisationLog, expectedUserLog...)
+	expectedOrganisationLog = append(expectedOrganisationLog,
+		AuditRecord{OrganisationID: organisationID, ServiceID: "service", Actor: "admin@example.com", Action: AuditActionDeleteService,
+			Before: json.RawMessage(`{"name":"Service Name"}`)},
+		AuditRecord{OrganisationID: organisationID, Actor: "admin@example.com", Action: AuditActionPutOrganisation,
+			Before: json.RawMessage(`{"name":"Organisation Name"}`), After: json.RawMessage(`{"name":"New Name"}`)},
+		AuditRecord{OrganisationID: organisationID, Actor: "admin@example.com", Action: AuditActionPutRole,
+			After: json.RawMessage(readers)},
+		AuditRecord{OrganisationID: organisationID, Actor: "admin@example.com", Action: AuditActionDeleteRole,
+			Before: json.RawMessage(readers)},
+		AuditRecord{OrganisationID: organisationID, Actor: "admin@example.com", Action: AuditActionPutWebhook,
+			After: json.RawMessage(`{"id":"` + webhookID + `","url":"https://example.com"}`)},
+	)
+	ignoreGenerated := cmpopts.IgnoreFields(AuditRecord{}, "ID", "At")
+
+	// Read the logs a few records at a time.
+	readAll := func(auditLog func(ctx context.Context, id string, limit int, start string) (AuditPage, error), id string) (records []AuditRecord) {
+		var start string
+		for i := 0; i < 10; i++ {
+			page, err := auditLog(ctx, id, 3, start)
+			if err != nil {
+				t.Fatalf("failed to read audit log: %v", err)
+			}
+			if len(page.Records) > 3 {
+				t.Errorf("expected at most 3 records, got %d", len(page.Records))
+			}
+			records = append(records, page.Records...)
+			if page.Next == "" {
+				return
+			}
+			start = page.Next
+		}
+		t.Fatalf("audit log didn't end")
+		return
+	}
+	organisationLog := readAll(s.AuditLog, organisationID)
+	if diff := cmp.Diff(expectedOrganisationLog, organisationLog, ignoreGenerated); diff != "" {
+		t.Errorf("unexpected organisation audit log:\n%v", diff)
+	}
+	for i := 1; i < len(organisationLog); i++ {
+		if organisationLog[i].At.Before(organisationLog[i-1].At) {
+			t.Errorf("expected the audit log to be in time order, but record %d is before record %d", i, i-1)
+		}
+	}
+	userLog := readAll(r.users.AuditLog, invitee.ID)
+	if diff := cmp.Diff(expectedUserLog, userLog, ignoreGenerated); diff != "" {
+		t.Errorf("unexpected user audit log:\n%v", diff)
+	}
+
+	// The whole log can be read at once.
+	page, err := s.AuditLog(ctx, organisationID, 0, "")
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if diff := cmp.Diff(organisationLog, page.Records); diff != "" {
+		t.Errorf("unexpected audit log:\n%v", diff)
+	}
+	if page.Next != "" {
+		t.Errorf("expected no next page, got %q", page.Next)
+	}
+}
+
+// failingBatchClient fails BatchWriteItem calls until failures reaches zero.
+type failingBatchClient struct {
+	dynamodbiface.DynamoDBAPI
+	failures int
+}
+
+func (c *failingBatchClient) BatchWriteItemWithContext(ctx context.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	if c.failures > 0 {
+		c.failures--
+		return nil, errors.New("throttled")
+	}
+	return c.DynamoDBAPI.BatchWriteItemWithContext(ctx, input, opts...)
+}
+
+func TestOrganisationStoreDeleteRetry(t *testing.T) {
+	ctx := context.Background()
+	table := NewMemoryTable()
+	client := &failingBatchClient{DynamoDBAPI: table, failures: 1}
+	s := NewMemoryOrganisationStore(table)
+	s.Client = client
+	owner := newUser("test@example.com", "First", "Last", "447901234567", time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+
+	err = s.Delete(ctx, organisationID, 1, nil)
+	if err == nil {
+		t.Fatalf("expected the deletion to fail")
+	}
+	_, err = s.Get(ctx, organisationID)
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected the organisation record to be deleted first, got %v", err)
+	}
+
+	// Calling Delete again deletes the remaining records, whatever the version.
+	err = s.Delete(ctx, organisationID, 2, nil)
+	if err != nil {
+		t.Fatalf("failed to complete the deletion: %v", err)
+	}
+	for _, item := range table.items() {
+		id := aws.StringValue(item["id"].S)
+		if id != newUserRecordHashKey(owner.ID) && id != newUserAuditRecordHashKey(owner.ID) && id != newOrganisationAuditRecordHashKey(organisationID) {
+			t.Errorf("unexpected record remaining: %v", item)
+		}
+	}
+	page, err := s.AuditLog(ctx, organisationID, 10, "")
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	if len(page.Records) != 1 || page.Records[0].Action != AuditActionDeleteOrganisation {
+		t.Errorf("expected only the record of the deletion to remain, got %+v", page.Records)
+	}
+	err = s.Delete(ctx, organisationID, 2, nil)
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound once the deletion is complete, got %v", err)
+	}
 }
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+// newOutboxPuts creates the puts of the events to the outbox. If there's no Publisher, the events aren't
+// recorded.
+func newOutboxPuts(tableName *string, publisher Publisher, events ...Event) (puts []*dynamodb.TransactWriteItem, err error) {
+	if publisher == nil {
+		return
+	}
+	for _, e := range events {
+		r, err := newOutboxRecord(e)
+		if err != nil {
+			return nil, err
+		}
+		item, err := dynamodbattribute.MarshalMap(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert outboxRecord: %w", err)
+		}
+		puts = append(puts, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName: tableName,
+				Item:      item,
+			},
+		})
+	}
+	return
+}
+
+// publishEvents publishes events once the change that wrote them to the outbox has been made, and then removes
+// them from the outbox. Events are only published if there are no earlier events in their shard of the outbox, so
+// that they aren't published before the events that couldn't be published earlier. The change has already been
+// made, so errors aren't returned. Events that aren't published stay in the outbox, to be published by
+// PublishOutbox.
+func publishEvents(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, publisher Publisher, events ...Event) {
+	if publisher == nil || len(events) == 0 {
+		return
+	}
+	hashKeyToFirst := make(map[string]string)
+	for _, e := range events {
+		hashKey, rangeKey := newOutboxRecordHashKey(e.Metadata().OrganisationID), newOutboxRecordRangeKey(e)
+		if first, ok := hashKeyToFirst[hashKey]; !ok || rangeKey < first {
+			hashKeyToFirst[hashKey] = rangeKey
+		}
+	}
+	waiting := make(map[string]bool)
+	for hashKey, first := range hashKeyToFirst {
+		earlier, err := hasOutboxRecordsBefore(ctx, client, tableName, hashKey, first)
+		waiting[hashKey] = earlier || err != nil
+	}
+	var publish []Event
+	var keys []map[string]*dynamodb.AttributeValue
+	for _, e := range events {
+		hashKey := newOutboxRecordHashKey(e.Metadata().OrganisationID)
+		if waiting[hashKey] {
+			continue
+		}
+		publish = append(publish, e)
+		keys = append(keys, idAndRng(hashKey, newOutboxRecordRangeKey(e)))
+	}
+	if len(publish) == 0 {
+		return
+	}
+	if err := publisher.Publish(ctx, publish...); err != nil {
+		return
+	}
+	_ = batchDelete(ctx, client, tableName, keys, nil)
+}
+
+// hasOutboxRecordsBefore returns true if the shard of the outbox holds events that sort before the range key.
+func hasOutboxRecordsBefore(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, hashKey, rangeKey string) (ok bool, err error) {
+	q := expression.Key("id").Equal(expression.Value(hashKey)).And(expression.Key("rng").LessThan(expression.Value(rangeKey)))
+	expr, err := expression.NewBuilder().WithKeyCondition(q).Build()
+	if err != nil {
+		return
+	}
+	qo, err := client.QueryWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                 tableName,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConsistentRead:            aws.Bool(true),
+		Limit:                     aws.Int64(1),
+	})
+	if err != nil {
+		return
+	}
+	return len(qo.Items) > 0, nil
+}
+
+// publishOutbox publishes the events in each shard of the outbox in the order they happened, removing each event
+// once it's been published. Each shard stops at the first event that can't be published, so that the events of
+// an Organisation stay in order, but the other shards are still published. The first error is returned.
+func publishOutbox(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, publisher Publisher) (published int, err error) {
+	if publisher == nil {
+		return
+	}
+	for _, hashKey := range newOutboxHashKeys() {
+		n, shardErr := publishOutboxShard(ctx, client, tableName, publisher, hashKey)
+		published += n
+		if shardErr != nil && err == nil {
+			err = shardErr
+		}
+	}
+	return
+}
+
+// publishOutboxShard publishes the events in one shard of the outbox, stopping at the first event that can't be
+// published.
+func publishOutboxShard(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, publisher Publisher, hashKey string) (published int, err error) {
+	items, err := queryPartition(ctx, client, tableName, hashKey, outboxRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("failed to query outbox: %w", err)
+		return
+	}
+	events, err := newEventsFromOutboxRecords(items)
+	if err != nil {
+		return
+	}
+	for _, e := range events {
+		err = publisher.Publish(ctx, e)
+		if err != nil {
+			return
+		}
+		_, err = client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			TableName: tableName,
+			Key:       idAndRng(hashKey, newOutboxRecordRangeKey(e)),
+		})
+		if err != nil {
+			return
+		}
+		published++
+	}
+	return
+}
+
+// eraseOutbox replaces the User's ID with the erasedID in the events that are waiting in the outbox.
+func eraseOutbox(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, userID, erasedID string) error {
+	for _, hashKey := range newOutboxHashKeys() {
+		items, err := queryPartition(ctx, client, tableName, hashKey, outboxRecordName+"/")
+		if err != nil {
+			return fmt.Errorf("failed to query outbox: %w", err)
+		}
+		for _, item := range items {
+			var or outboxRecord
+			err = dynamodbattribute.UnmarshalMap(item, &or)
+			if err != nil {
+				return fmt.Errorf("failed to convert outboxRecord: %w", err)
+			}
+			payload, ok := replaceJSONString(or.Payload, userID, erasedID)
+			if !ok {
+				continue
+			}
+			// The event may have been published since the outbox was read.
+			expr, err := expression.NewBuilder().
+				WithUpdate(expression.Set(expression.Name("payload"), expression.Value(payload))).
+				WithCondition(expression.AttributeExists(expression.Name("id"))).
+				Build()
+			if err != nil {
+				return fmt.Errorf("failed to build outbox update: %v", err)
+			}
+			_, err = client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+				TableName:                 tableName,
+				Key:                       idAndRng(or.ID, or.Range),
+				UpdateExpression:          expr.Update(),
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
+			})
+			if err != nil && !isConditionalCheckFailed(err) {
+				return fmt.Errorf("failed to update outbox: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func newEventsFromOutboxRecords(items []map[string]*dynamodb.AttributeValue) (events []Event, err error) {
+	for _, item := range items {
+		var or outboxRecord
+		err = dynamodbattribute.UnmarshalMap(item, &or)
+		if err != nil {
+			err = fmt.Errorf("failed to convert outboxRecord: %w", err)
+			return
+		}
+		e, err := newEventFromOutboxRecord(or)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return
+}
+
+func newEventFromOutboxRecord(or outboxRecord) (e Event, err error) {
+	payload := []byte(or.Payload)
+	switch EventType(or.EventType) {
+	case EventTypeOrganisationCreated:
+		var oc OrganisationCreated
+		err = json.Unmarshal(payload, &oc)
+		e = oc
+	case EventTypeServiceCreated:
+		var sc ServiceCreated
+		err = json.Unmarshal(payload, &sc)
+		e = sc
+	case EventTypeServiceDeleted:
+		var sd ServiceDeleted
+		err = json.Unmarshal(payload, &sd)
+		e = sd
+	case EventTypeMemberInvited:
+		var mi MemberInvited
+		err = json.Unmarshal(payload, &mi)
+		e = mi
+	case EventTypeInvitationAccepted:
+		var ia InvitationAccepted
+		err = json.Unmarshal(payload, &ia)
+		e = ia
+	case EventTypeInvitationRejected:
+		var ir InvitationRejected
+		err = json.Unmarshal(payload, &ir)
+		e = ir
+	case EventTypeInvitationRevoked:
+		var ir InvitationRevoked
+		err = json.Unmarshal(payload, &ir)
+		e = ir
+	case EventTypeMemberRemoved:
+		var mr MemberRemoved
+		err = json.Unmarshal(payload, &mr)
+		e = mr
+	case EventTypeGroupsChanged:
+		var gc GroupsChanged
+		err = json.Unmarshal(payload, &gc)
+		e = gc
+	default:
+		return nil, fmt.Errorf("unknown event type %q", or.EventType)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %s event: %w", or.EventType, err)
+	}
+	return
+}
+
+// outbox record. Events are written to the outbox in the same transaction as the change that caused them, so
+// that they're published even if the process stops before publishing them. The outbox is split into shards, so
+// that busy Organisations don't exceed the throughput of a single partition. Each Organisation's events are kept
+// in one shard, sorted by time, and the shards are kept small by removing events once they're published.
+const outboxRecordName = "outbox"
+
+// outboxShards is the number of partitions that the outbox is split into.
+const outboxShards = 16
+
+// newOutboxShard returns the shard of the outbox that the Organisation's events are written to.
+func newOutboxShard(organisationID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(organisationID))
+	return int(h.Sum32() % outboxShards)
+}
+
+func newOutboxShardHashKey(shard int) string {
+	return outboxRecordName + "/" + strconv.Itoa(shard)
+}
+
+func newOutboxRecordHashKey(organisationID string) string {
+	return newOutboxShardHashKey(newOutboxShard(organisationID))
+}
+
+// newOutboxHashKeys returns the hash keys of every shard of the outbox.
+func newOutboxHashKeys() (hashKeys []string) {
+	for shard := 0; shard < outboxShards; shard++ {
+		hashKeys = append(hashKeys, newOutboxShardHashKey(shard))
+	}
+	return
+}
+
+func newOutboxRecordRangeKey(e Event) string {
+	m := e.Metadata()
+	return outboxRecordName + "/" + sortableTime(m.At) + "/" + m.ID
+}
+
+func newOutboxRecord(e Event) (record outboxRecord, err error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		err = fmt.Errorf("failed to convert %s event: %w", e.Type(), err)
+		return
+	}
+	m := e.Metadata()
+	record.ID = newOutboxRecordHashKey(m.OrganisationID)
+	record.Range = newOutboxRecordRangeKey(e)
+	record.RecordType = outboxRecordName
+	record.Version = 1
+	record.EventID = m.ID
+	record.EventType = string(e.Type())
+	record.OrganisationID = m.OrganisationID
+	record.Payload = string(payload)
+	record.At = m.At
+	return
+}
+
+type outboxRecord struct {
+	record
+	EventID        string    `json:"eventId"`
+	EventType      string    `json:"eventType"`
+	OrganisationID string    `json:"organisationId"`
+	Payload        string    `json:"payload"`
+	At             time.Time `json:"at"`
+}
@@ -1,10 +1,28 @@
 package db
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
+// maxTransactionItems is the maximum number of items that DynamoDB allows in a single transaction.
+const maxTransactionItems = 25
+
+// maxBatchWriteItems is the maximum number of items that DynamoDB allows in a single BatchWriteItem call.
+const maxBatchWriteItems = 25
+
+// batchWriteAttempts is the number of times that batchDelete tries to write a batch before giving up.
+const batchWriteAttempts = 8
+
+// batchWriteBackoff is the delay before the first retry of unprocessed items. It doubles after each attempt.
+var batchWriteBackoff = 50 * time.Millisecond
+
 // record default fields.
 type record struct {
 	ID         string `json:"id"`
@@ -13,6 +31,11 @@ type record struct {
 	Version    int    `json:"v"`
 }
 
+// sortableTime formats a time with a fixed width, so that range keys that contain it sort in time order.
+func sortableTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
 // idAndRng creates a DynamoDB key.
 func idAndRng(id, rng string) map[string]*dynamodb.AttributeValue {
 	return map[string]*dynamodb.AttributeValue{
@@ -20,3 +43,105 @@ func idAndRng(id, rng string) map[string]*dynamodb.AttributeValue {
 		"rng": {S: aws.String(rng)},
 	}
 }
+
+// stringSet is marshalled as a DynamoDB string set, e.g. for use in ADD and DELETE update actions.
+type stringSet []string
+
+func (ss stringSet) MarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	av.SS = aws.StringSlice(ss)
+	return nil
+}
+
+// versionCondition is met if the record is at the expected version. Version zero means that the record
+// must not already exist.
+func versionCondition(version int) expression.ConditionBuilder {
+	if version == 0 {
+		return expression.AttributeNotExists(expression.Name("id"))
+	}
+	return expression.Name("v").Equal(expression.Value(version))
+}
+
+// incrementVersion adds one to the record version.
+func incrementVersion(update expression.UpdateBuilder) expression.UpdateBuilder {
+	return update.Add(expression.Name("v"), expression.Value(1))
+}
+
+// queryPartition returns all of the items with the hash key id, sorted by range key. If rangePrefix is not empty,
+// only items whose range key begins with the prefix are returned.
+func queryPartition(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, id, rangePrefix string) (items []map[string]*dynamodb.AttributeValue, err error) {
+	q := expression.Key("id").Equal(expression.Value(id))
+	if rangePrefix != "" {
+		q = q.And(expression.Key("rng").BeginsWith(rangePrefix))
+	}
+	expr, err := expression.NewBuilder().
+		WithKeyCondition(q).
+		Build()
+	if err != nil {
+		return
+	}
+	qi := &dynamodb.QueryInput{
+		TableName:                 tableName,
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeValues: expr.Values(),
+		ExpressionAttributeNames:  expr.Names(),
+		ConsistentRead:            aws.Bool(true),
+	}
+	page := func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	}
+	err = client.QueryPagesWithContext(ctx, qi, page)
+	return
+}
+
+// batchDelete deletes the items with the given keys, in batches. Unprocessed items are retried with exponential
+// backoff. After each batch is complete, deleted is called with the number of items deleted so far.
+func batchDelete(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, keys []map[string]*dynamodb.AttributeValue, deleted func(n int)) error {
+	var n int
+	for start := 0; start < len(keys); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(keys) {
+			end = len(keys)
+		}
+		requests := make([]*dynamodb.WriteRequest, end-start)
+		for i, key := range keys[start:end] {
+			requests[i] = &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: key}}
+		}
+		if err := batchWrite(ctx, client, tableName, requests); err != nil {
+			return err
+		}
+		n += len(requests)
+		if deleted != nil {
+			deleted(n)
+		}
+	}
+	return nil
+}
+
+// batchWrite writes a single batch, retrying unprocessed items until they have all been written.
+func batchWrite(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, requests []*dynamodb.WriteRequest) error {
+	backoff := batchWriteBackoff
+	for attempt := 1; ; attempt++ {
+		out, err := client.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{
+				aws.StringValue(tableName): requests,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		requests = out.UnprocessedItems[aws.StringValue(tableName)]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == batchWriteAttempts {
+			return fmt.Errorf("%d items still unprocessed after %d attempts", len(requests), attempt)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// unprocessedClient leaves the last item of each BatchWriteItem call unprocessed, until it has done so a
+// number of times.
+type unprocessedClient struct {
+	dynamodbiface.DynamoDBAPI
+	unprocessed int
+	calls       int
+	deleted     int
+}
+
+func (c *unprocessedClient) BatchWriteItemWithContext(ctx context.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	c.calls++
+	out := &dynamodb.BatchWriteItemOutput{}
+	for table, requests := range input.RequestItems {
+		if len(requests) > maxBatchWriteItems {
+			return nil, fmt.Errorf("too many items in batch: %d", len(requests))
+		}
+		if c.unprocessed > 0 {
+			c.unprocessed--
+			out.UnprocessedItems = map[string][]*dynamodb.WriteRequest{
+				table: requests[len(requests)-1:],
+			}
+			requests = requests[:len(requests)-1]
+		}
+		c.deleted += len(requests)
+	}
+	return out, nil
+}
+
+func TestBatchDelete(t *testing.T) {
+	defer func(d time.Duration) { batchWriteBackoff = d }(batchWriteBackoff)
+	batchWriteBackoff = 0
+
+	var keys []map[string]*dynamodb.AttributeValue
+	for i := 0; i < 60; i++ {
+		keys = append(keys, idAndRng(fmt.Sprintf("id%d", i), "rng"))
+	}
+
+	t.Run("unprocessed items are retried", func(t *testing.T) {
+		client := &unprocessedClient{unprocessed: 2}
+		var progress []int
+		err := batchDelete(context.Background(), client, aws.String("table"), keys, func(n int) {
+			progress = append(progress, n)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if client.deleted != len(keys) {
+			t.Errorf("expected %d items to be deleted, got %d", len(keys), client.deleted)
+		}
+		if client.calls != 5 {
+			t.Errorf("expected 3 batches and 2 retries, got %d calls", client.calls)
+		}
+		if fmt.Sprint(progress) != "[25 50 60]" {
+			t.Errorf("unexpected progress: %v", progress)
+		}
+	})
+	t.Run("gives up after too many attempts", func(t *testing.T) {
+		client := &unprocessedClient{unprocessed: batchWriteAttempts}
+		err := batchDelete(context.Background(), client, aws.String("table"), keys, nil)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if client.calls != batchWriteAttempts {
+			t.Errorf("expected %d attempts, got %d", batchWriteAttempts, client.calls)
+		}
+	})
+}
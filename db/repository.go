@@ -0,0 +1,155 @@
+package db
+
+import "context"
+
+// UserRepository stores Users and their Organisation memberships.
+type UserRepository interface {
+	// Put a User, or return ErrVersionConflict if the User's Version is stale.
+	Put(ctx context.Context, user User) error
+	// Get a User, or ErrUserNotFound.
+	Get(ctx context.Context, id string) (User, error)
+	// GetDetails gets the full details of a User, or ErrUserNotFound.
+	GetDetails(ctx context.Context, id string) (UserDetails, error)
+	// EffectiveGroups gets the groups that a User belongs to in each of their Organisations, including the
+	// groups that contain them, keyed by Organisation ID, or returns ErrUserNotFound.
+	EffectiveGroups(ctx context.Context, id string) (organisationIDToGroups map[string]EffectiveGroups, err error)
+	// AuditLog reads a page of the changes made to the User's profile and memberships, and the changes made by
+	// the User, oldest first. Pass the page's Next as start to read the next page.
+	AuditLog(ctx context.Context, id string, limit int, start string) (page AuditPage, err error)
+	// UpdateProfile updates a User, and copies their name and phone number to each Organisation they belong to.
+	// Returns ErrVersionConflict if the User's Version is stale.
+	UpdateProfile(ctx context.Context, user User) error
+	// SyncProfile copies a User's name and phone number to each Organisation they belong to.
+	SyncProfile(ctx context.Context, id string) error
+	// Delete erases a User, their memberships of Organisations, their invitations and their audit log, and
+	// replaces their ID in the other records that refer to them, returning a report of the records deleted.
+	// ErrUserNotFound is returned if the User doesn't exist, and a *LastOwnerError if the User is the last owner
+	// of an Organisation.
+	Delete(ctx context.Context, id string) (report ErasureReport, err error)
+	// Invite a User to an Organisation, optionally inviting to Organisation and Service groups, and returns the
+	// token required to accept the invitation. Returns ErrOrganisationNotFound, ErrRoleNotFound or
+	// ErrInvitationAlreadyAccepted if the invitation can't be made.
+	Invite(ctx context.Context, invitedBy string, u User, org Organisation, groups []string, serviceGroups map[string][]string) (token string, err error)
+	// AcceptInvite accepts an invitation to join an Organisation using its token, or returns
+	// ErrInvitationNotFound, ErrInvitationAlreadyAccepted, ErrInvitationRevoked, ErrInvitationExpired or
+	// ErrInvalidInvitationToken.
+	AcceptInvite(ctx context.Context, u User, org Organisation, token string) error
+	// LeaveOrganisation removes a User from an Organisation, or returns ErrNotMember if they don't belong to it,
+	// or a *LastOwnerError if they are its last owner.
+	LeaveOrganisation(ctx context.Context, id, organisationID string) error
+	// RejectInvite rejects an invitation to join an Organisation, or returns ErrInvitationNotFound or
+	// ErrInvitationAlreadyAccepted.
+	RejectInvite(ctx context.Context, u User, org Organisation) error
+	// MigrateMemberships updates the memberships and invitations written by earlier versions, returning the
+	// number updated. Pending invitations become expired invitations, which must be resent.
+	MigrateMemberships(ctx context.Context) (migrated int, err error)
+}
+
+// OrganisationRepository stores Organisations, their Services and group memberships.
+type OrganisationRepository interface {
+	// Create a new organisation. Returns ErrAlreadyExists if the Organisation ID is already in use.
+	Create(ctx context.Context, owner User, name string) (id string, err error)
+	// Put an Organisation, or return ErrVersionConflict if the Organisation's Version is stale.
+	Put(ctx context.Context, org Organisation) error
+	// Rename an Organisation, and copy the new name to its members and invitees. Returns ErrVersionConflict if
+	// the version is stale.
+	Rename(ctx context.Context, id, name string, version int) error
+	// SyncOrganisationName copies an Organisation's name to its members and invitees.
+	SyncOrganisationName(ctx context.Context, id string) error
+	// Get an Organisation, or ErrOrganisationNotFound.
+	Get(ctx context.Context, id string) (Organisation, error)
+	// GetDetails retrieves all details of an Organisation, or ErrOrganisationNotFound. Pending invitees are
+	// only included in groups if the IncludePending option is used.
+	GetDetails(ctx context.Context, id string, opts ...DetailsOption) (OrganisationDetails, error)
+	// Delete an Organisation and all of its records, including each member's record of belonging to it, its
+	// Webhooks' deliveries, and its audit log apart from the record of the deletion. The version must match the
+	// stored version, otherwise ErrVersionConflict is returned and nothing is deleted. If Delete fails part way
+	// through, calling it again deletes the remaining records. If progress is not nil, it's called as records
+	// are deleted.
+	Delete(ctx context.Context, id string, version int, progress func(DeleteProgress)) error
+	// CreateService creates a new service, or returns ErrOrganisationNotFound.
+	CreateService(ctx context.Context, id string, serviceName string) (serviceID string, err error)
+	// PutService creates a new service (version zero) or updates an existing service's name, or returns
+	// ErrVersionConflict if the version is stale, or ErrOrganisationNotFound.
+	PutService(ctx context.Context, id string, serviceID, serviceName string, version int) error
+	// DeleteService deletes a service from the Organisation and removes its members from the service's
+	// groups, or returns ErrServiceNotFound. If the service was deleted but some members couldn't be removed
+	// from its groups, a *ServiceCleanupError is returned.
+	DeleteService(ctx context.Context, id, serviceID string) error
+	// CleanupService removes the Organisation's members from the groups of a deleted service, completing a
+	// DeleteService that returned a *ServiceCleanupError.
+	CleanupService(ctx context.Context, id, serviceID string) error
+	// AddUserToOrganisationGroups puts a user into groups within the Organisation.
+	AddUserToOrganisationGroups(ctx context.Context, organisationID string, user User, groups ...string) error
+	// AddUserToServiceGroups puts a user into groups within an Organisation Service.
+	AddUserToServiceGroups(ctx context.Context, organisationID string, user User, serviceID string, groups ...string) error
+	// AddUserToGroups adds a user to Organisation and Service Groups, or returns ErrRoleNotFound if a group isn't
+	// defined by one of the Organisation's roles. The Until option grants the groups temporarily, or returns
+	// ErrInvalidGrant if they can't be granted. Invitees can't be added to the owner group until they accept, so
+	// ErrNotMember is returned.
+	AddUserToGroups(ctx context.Context, organisationID string, user User, groups []string, serviceIDToGroups map[string][]string, opts ...GroupOption) error
+	// RemoveUserFromOrganisationGroups removes a user from a set of Organisation level groups.
+	RemoveUserFromOrganisationGroups(ctx context.Context, organisationID, userID string, groups ...string) error
+	// RemoveUserFromServiceGroups removes a user from a set of Service-level groups.
+	RemoveUserFromServiceGroups(ctx context.Context, organisationID, userID, serviceID string, groups ...string) error
+	// RemoveUserFromGroups removes a user from Organisation and Service groups. Returns a *LastOwnerError if
+	// the Organisation would be left without an owner.
+	RemoveUserFromGroups(ctx context.Context, organisationID, userID string, groups []string, serviceIDToGroups map[string][]string) error
+	// RemoveUser from the Organisation, deleting both sides of the membership, or returns a *LastOwnerError if
+	// the user is its last owner.
+	RemoveUser(ctx context.Context, organisationID string, userID string) error
+	// SweepExpiredGrants deletes the Organisation's expired grants, and returns how many were deleted.
+	SweepExpiredGrants(ctx context.Context, organisationID string) (deleted int, err error)
+	// TransferOwnership makes a user an owner of the Organisation and removes the current owner from the owner
+	// group in a single transaction, or returns ErrNotOwner if the current owner isn't an owner.
+	TransferOwnership(ctx context.Context, organisationID, from string, to User) error
+	// PutRole creates a role (version zero) or updates an existing role, or returns ErrOrganisationNotFound,
+	// ErrVersionConflict if the version is stale, ErrBuiltInRole for the DefaultRoles, ErrInvalidRole,
+	// ErrRoleNotFound for an undefined subgroup, or ErrGroupCycle.
+	PutRole(ctx context.Context, id string, role Role) error
+	// DeleteRole deletes one of the Organisation's roles, or returns ErrRoleNotFound or ErrBuiltInRole.
+	DeleteRole(ctx context.Context, id string, scope RoleScope, name GroupName) error
+	// ListRoles lists the DefaultRoles, followed by the Organisation's own roles.
+	ListRoles(ctx context.Context, id string) (roles []Role, err error)
+	// CreateWebhook creates a new Webhook, or returns ErrOrganisationNotFound or ErrInvalidWebhook.
+	CreateWebhook(ctx context.Context, id string, webhook Webhook) (webhookID string, err error)
+	// PutWebhook creates a Webhook (version zero) or updates an existing Webhook, or returns
+	// ErrOrganisationNotFound, ErrVersionConflict if the version is stale, or ErrInvalidWebhook.
+	PutWebhook(ctx context.Context, id string, webhook Webhook) error
+	// DeleteWebhook deletes one of the Organisation's Webhooks, or returns ErrWebhookNotFound.
+	DeleteWebhook(ctx context.Context, id, webhookID string) error
+	// ListWebhooks lists the Organisation's Webhooks.
+	ListWebhooks(ctx context.Context, id string) (webhooks []Webhook, err error)
+	// PutWebhookDelivery records an attempt to deliver an Event to one of the Organisation's Webhooks.
+	PutWebhookDelivery(ctx context.Context, id string, delivery WebhookDelivery) error
+	// ListWebhookDeliveries lists the attempts to deliver Events to one of the Organisation's Webhooks, oldest
+	// first.
+	ListWebhookDeliveries(ctx context.Context, id, webhookID string) (deliveries []WebhookDelivery, err error)
+	// EffectiveGroups gets the groups that a User belongs to, including the groups that contain them, or
+	// returns ErrNotMember.
+	EffectiveGroups(ctx context.Context, organisationID, userID string) (eg EffectiveGroups, err error)
+	// GetUserGroups gets the Organisation and Service groups that a User belongs to, or ErrNotMember if the User
+	// isn't a member, or hasn't accepted their invitation.
+	GetUserGroups(ctx context.Context, organisationID, userID string) (groups []string, serviceIDToGroups map[string][]string, err error)
+	// AuditLog reads a page of the changes made to the Organisation and its members, oldest first. Pass the
+	// page's Next as start to read the next page.
+	AuditLog(ctx context.Context, id string, limit int, start string) (page AuditPage, err error)
+	// PublishOutbox publishes the events left in the outbox by changes whose events couldn't be published, and
+	// returns how many were published.
+	PublishOutbox(ctx context.Context) (published int, err error)
+	// ListInvitations lists the pending invitations to the Organisation, including expired invitations that can
+	// be resent.
+	ListInvitations(ctx context.Context, id string) (invitations []Invitation, err error)
+	// ResendInvite replaces the token and expiry of a pending invitation, and returns the new token. Returns
+	// ErrInvitationNotFound, ErrInvitationAlreadyAccepted or ErrInvitationRevoked if there's no pending invitation.
+	ResendInvite(ctx context.Context, id, userID string) (token string, err error)
+	// RevokeInvite revokes a pending invitation so that it can't be accepted. Returns ErrInvitationNotFound,
+	// ErrInvitationAlreadyAccepted or ErrInvitationRevoked if there's no pending invitation.
+	RevokeInvite(ctx context.Context, id, userID string) error
+	// UpdateUserDetails updates a user's details within the Organisation, or returns ErrVersionConflict if the
+	// version of the membership is stale.
+	UpdateUserDetails(ctx context.Context, organisationID, userID, firstName, lastName, phone string, version int) error
+}
+
+var _ UserRepository = UserStore{}
+var _ OrganisationRepository = OrganisationStore{}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// ignoreVersions ignores record versions when comparing results in tests that aren't about concurrency.
+var ignoreVersions = cmp.Options{
+	cmpopts.IgnoreFields(User{}, "Version"),
+	cmpopts.IgnoreFields(Organisation{}, "Version"),
+	cmpopts.IgnoreFields(Service{}, "Version"),
+	cmpopts.IgnoreFields(Role{}, "Version"),
+}
+
+// repositories under test. Both repositories must share the same underlying storage, and publish their events
+// to the publisher.
+type repositories struct {
+	users         UserRepository
+	organisations OrganisationRepository
+	publisher     *InProcessPublisher
+	// deleteRecord deletes a record from the underlying storage, to recreate data written by earlier versions.
+	deleteRecord func(id, rng string) error
+	// putRecord puts a record into the underlying storage, to recreate data written by earlier versions.
+	putRecord func(item map[string]*dynamodb.AttributeValue) error
+	// records reads every record from the underlying storage.
+	records func() ([]map[string]*dynamodb.AttributeValue, error)
+}
+
+// repositoriesFactory creates a set of empty repositories, and a function to clean them up afterwards.
+type repositoriesFactory func(t *testing.T) (r repositories, cleanup func())
+
+type repositoryTest struct {
+	name string
+	test func(t *testing.T, r repositories)
+}
+
+// repositoryTests is the conformance suite that every UserRepository and OrganisationRepository
+// implementation must pass.
+var repositoryTests = []repositoryTest{
+	{name: "UserPut", test: testUserPut},
+	{name: "UserGet", test: testUserGet},
+	{name: "UserInviteIgnore", test: testUserInviteIgnore},
+	{name: "UserInviteAccept", test: testUserInviteAccept},
+	{name: "UserInviteReject", test: testUserInviteReject},
+	{name: "UserContextCancelled", test: testUserContextCancelled},
+	{name: "UserGetNotFound", test: testUserGetNotFound},
+	{name: "UserAcceptInviteNotFound", test: testUserAcceptInviteNotFound},
+	{name: "UserPutVersionConflict", test: testUserPutVersionConflict},
+	{name: "UserInviteOrganisationNotFound", test: testUserInviteOrganisationNotFound},
+	{name: "UserInviteAlreadyAccepted", test: testUserInviteAlreadyAccepted},
+	{name: "UserRejectInviteNotFound", test: testUserRejectInviteNotFound},
+	{name: "UserUpdateProfile", test: testUserUpdateProfile},
+	{name: "UserUpdateProfileManyOrganisations", test: testUserUpdateProfileManyOrganisations},
+	{name: "UserUpdateProfileRemovedMember", test: testUserUpdateProfileRemovedMember},
+	{name: "UserSyncProfile", test: testUserSyncProfile},
+	{name: "UserDelete", test: testUserDelete},
+	{name: "UserDeleteLastOwner", test: testUserDeleteLastOwner},
+	{name: "UserDeleteNotFound", test: testUserDeleteNotFound},
+	{name: "UserLeaveOrganisation", test: testUserLeaveOrganisation},
+	{name: "UserAcceptInviteToken", test: testUserAcceptInviteToken},
+	{name: "UserMigrateMemberships", test: testUserMigrateMemberships},
+	{name: "OrganisationPut", test: testOrganisationPut},
+	{name: "OrganisationGet", test: testOrganisationGet},
+	{name: "OrganisationGetDetails", test: testOrganisationGetDetails},
+	{name: "OrganisationGroup", test: testOrganisationGroup},
+	{name: "ServiceGroups", test: testServiceGroups},
+	{name: "ServiceGroupDelete", test: testServiceGroupDelete},
+	{name: "OrganisationUpdateUser", test: testOrganisationUpdateUser},
+	{name: "OrganisationDeleteUser", test: testOrganisationDeleteUser},
+	{name: "OrganisationGetNotFound", test: testOrganisationGetNotFound},
+	{name: "ServiceDeleteNotFound", test: testServiceDeleteNotFound},
+	{name: "ServiceDeleteAssigned", test: testServiceDeleteAssigned},
+	{name: "ServiceDeleteManyAssigned", test: testServiceDeleteManyAssigned},
+	{name: "OrganisationPutVersionConflict", test: testOrganisationPutVersionConflict},
+	{name: "ServicePutVersionConflict", test: testServicePutVersionConflict},
+	{name: "OrganisationUpdateUserVersionConflict", test: testOrganisationUpdateUserVersionConflict},
+	{name: "OrganisationRename", test: testOrganisationRename},
+	{name: "OrganisationRenameManyMembers", test: testOrganisationRenameManyMembers},
+	{name: "OrganisationSyncName", test: testOrganisationSyncName},
+	{name: "OrganisationDelete", test: testOrganisationDelete},
+	{name: "OrganisationDeleteVersionConflict", test: testOrganisationDeleteVersionConflict},
+	{name: "OrganisationDeleteManyMembers", test: testOrganisationDeleteManyMembers},
+	{name: "OrganisationRemoveLastOwner", test: testOrganisationRemoveLastOwner},
+	{name: "OrganisationTransferOwnership", test: testOrganisationTransferOwnership},
+	{name: "OrganisationOwnersBackfill", test: testOrganisationOwnersBackfill},
+	{name: "OrganisationRemoveUserMembership", test: testOrganisationRemoveUserMembership},
+	{name: "OrganisationInvitations", test: testOrganisationInvitations},
+	{name: "OrganisationInvitees", test: testOrganisationInvitees},
+	{name: "OrganisationGetUserGroups", test: testOrganisationGetUserGroups},
+	{name: "OrganisationRoles", test: testOrganisationRoles},
+	{name: "OrganisationNestedGroups", test: testOrganisationNestedGroups},
+	{name: "OrganisationGrants", test: testOrganisationGrants},
+	{name: "AuditLog", test: testAuditLog},
+	{name: "OrganisationEvents", test: testOrganisationEvents},
+	{name: "OrganisationEventsOutbox", test: testOrganisationEventsOutbox},
+	{name: "OrganisationWebhooks", test: testOrganisationWebhooks},
+	{name: "AuthorisedOrganisationStore", test: testAuthorisedOrganisationStore},
+}
+
+// testRepositories runs the conformance suite against the repositories created by newRepositories.
+func testRepositories(t *testing.T, newRepositories repositoriesFactory) {
+	for _, rt := range repositoryTests {
+		rt := rt
+		t.Run(rt.name, func(t *testing.T) {
+			r, cleanup := newRepositories(t)
+			defer cleanup()
+			rt.test(t, r)
+		})
+	}
+}
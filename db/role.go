@@ -0,0 +1,232 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// RoleScope is the level at which a Role's group exists.
+type RoleScope string
+
+const (
+	// RoleScopeOrganisation roles are Organisation groups.
+	RoleScopeOrganisation RoleScope = "organisation"
+	// RoleScopeService roles are groups within each of an Organisation's Services.
+	RoleScopeService RoleScope = "service"
+)
+
+// DefaultRoles are built in to every Organisation, and can't be changed.
+var DefaultRoles = []Role{
+	{
+		Name:        GroupOwner,
+		Description: "Full control of the Organisation and its Services.",
+		Scope:       RoleScopeOrganisation,
+		Permissions: []Permission{
+			PermissionOrganisationRead,
+			PermissionOrganisationRename,
+			PermissionOrganisationDelete,
+			PermissionOrganisationOwners,
+			PermissionOrganisationRoles,
+			PermissionOrganisationWebhooks,
+			PermissionMemberInvite,
+			PermissionMemberUpdate,
+			PermissionMemberRemove,
+			PermissionServiceCreate,
+			PermissionServiceUpdate,
+			PermissionServiceDelete,
+			PermissionServiceDeploy,
+		},
+	},
+	{
+		Name:        GroupMember,
+		Description: "Can view the Organisation.",
+		Scope:       RoleScopeOrganisation,
+		Permissions: []Permission{PermissionOrganisationRead},
+	},
+	{
+		Name:        ServiceGroupAdmin,
+		Description: "Full control of the Service.",
+		Scope:       RoleScopeService,
+		Permissions: []Permission{PermissionServiceUpdate, PermissionServiceDelete, PermissionServiceDeploy},
+	},
+	{
+		Name:        ServiceGroupDeployer,
+		Description: "Can deploy the Service.",
+		Scope:       RoleScopeService,
+		Permissions: []Permission{PermissionServiceDeploy},
+	},
+}
+
+func isDefaultRole(scope RoleScope, name GroupName) bool {
+	for _, r := range DefaultRoles {
+		if r.Scope == scope && r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRole returns ErrBuiltInRole if the role is one of the DefaultRoles, or ErrInvalidRole if it can't be
+// stored.
+func validateRole(role Role) error {
+	if role.Scope != RoleScopeOrganisation && role.Scope != RoleScopeService {
+		return fmt.Errorf("unknown scope %q: %w", role.Scope, ErrInvalidRole)
+	}
+	// Group names are stored in the groupSet separated by slashes.
+	if role.Name == "" || strings.Contains(string(role.Name), "/") {
+		return fmt.Errorf("name %q must be non-empty and not contain '/': %w", role.Name, ErrInvalidRole)
+	}
+	if isDefaultRole(role.Scope, role.Name) {
+		return ErrBuiltInRole
+	}
+	return nil
+}
+
+// defaultGroups returns true if all of the groups are DefaultRoles, so that the role catalogue doesn't need to
+// be read to validate them.
+func defaultGroups(groups []string, serviceIDToGroups map[string][]string) bool {
+	for _, g := range groups {
+		if !isDefaultRole(RoleScopeOrganisation, GroupName(g)) {
+			return false
+		}
+	}
+	for _, sgs := range serviceIDToGroups {
+		for _, g := range sgs {
+			if !isDefaultRole(RoleScopeService, GroupName(g)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateGroups returns ErrRoleNotFound if any of the groups aren't defined by a role of the matching scope.
+func validateGroups(roles []Role, groups []string, serviceIDToGroups map[string][]string) error {
+	defined := make(map[RoleScope]map[GroupName]bool)
+	for _, r := range roles {
+		if defined[r.Scope] == nil {
+			defined[r.Scope] = make(map[GroupName]bool)
+		}
+		defined[r.Scope][r.Name] = true
+	}
+	for _, g := range groups {
+		if !defined[RoleScopeOrganisation][GroupName(g)] {
+			return fmt.Errorf("organisation group %q: %w", g, ErrRoleNotFound)
+		}
+	}
+	for serviceID, sgs := range serviceIDToGroups {
+		for _, g := range sgs {
+			if !defined[RoleScopeService][GroupName(g)] {
+				return fmt.Errorf("group %q of service %q: %w", g, serviceID, ErrRoleNotFound)
+			}
+		}
+	}
+	return nil
+}
+
+// checkGroups validates the groups against the Organisation's role catalogue.
+func checkGroups(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID string, groups []string, serviceIDToGroups map[string][]string) error {
+	if defaultGroups(groups, serviceIDToGroups) {
+		return nil
+	}
+	roles, err := getRoles(ctx, client, tableName, organisationID)
+	if err != nil {
+		return err
+	}
+	return validateGroups(roles, groups, serviceIDToGroups)
+}
+
+// getRoles reads the DefaultRoles and the custom roles of an Organisation.
+func getRoles(ctx context.Context, client dynamodbiface.DynamoDBAPI, tableName *string, organisationID string) (roles []Role, err error) {
+	items, err := queryPartition(ctx, client, tableName, newOrganisationRoleRecordHashKey(organisationID), organisationRoleRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("failed to query roles: %w", err)
+		return
+	}
+	return newRolesFromRecords(items)
+}
+
+// newRolesFromRecords returns the DefaultRoles, followed by the custom roles in the items.
+func newRolesFromRecords(items []map[string]*dynamodb.AttributeValue) (roles []Role, err error) {
+	roles = append(roles, DefaultRoles...)
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		if r.RecordType != organisationRoleRecordName {
+			continue
+		}
+		var orr organisationRoleRecord
+		err = dynamodbattribute.UnmarshalMap(item, &orr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationRoleRecord: %w", err)
+			return
+		}
+		roles = append(roles, newRoleFromRecord(orr))
+	}
+	return
+}
+
+func newRoleFromRecord(orr organisationRoleRecord) Role {
+	role := Role{
+		Name:        GroupName(orr.Name),
+		Description: orr.Description,
+		Scope:       RoleScope(orr.Scope),
+		Version:     orr.Version,
+	}
+	for _, p := range orr.Permissions {
+		role.Permissions = append(role.Permissions, Permission(p))
+	}
+	for _, g := range orr.Subgroups {
+		role.Subgroups = append(role.Subgroups, GroupName(g))
+	}
+	return role
+}
+
+// organisation role record.
+const organisationRoleRecordName = "organisationRole"
+
+func newOrganisationRoleRecordHashKey(organisationID string) string {
+	return newOrganisationRecordHashKey(organisationID)
+}
+
+func newOrganisationRoleRecordRangeKey(scope RoleScope, name GroupName) string {
+	return organisationRoleRecordName + "/" + string(scope) + "/" + string(name)
+}
+
+func newOrganisationRoleRecord(organisationID string, role Role) organisationRoleRecord {
+	var record organisationRoleRecord
+	record.ID = newOrganisationRoleRecordHashKey(organisationID)
+	record.Range = newOrganisationRoleRecordRangeKey(role.Scope, role.Name)
+	record.RecordType = organisationRoleRecordName
+	record.Version = 1
+	record.OrganisationID = organisationID
+	record.Name = string(role.Name)
+	record.Description = role.Description
+	record.Scope = string(role.Scope)
+	for _, p := range role.Permissions {
+		record.Permissions = append(record.Permissions, string(p))
+	}
+	for _, g := range role.Subgroups {
+		record.Subgroups = append(record.Subgroups, string(g))
+	}
+	return record
+}
+
+type organisationRoleRecord struct {
+	record
+	OrganisationID string   `json:"organisationId"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Scope          string   `json:"scope"`
+	Permissions    []string `json:"permissions"`
+	Subgroups      []string `json:"subgroups,omitempty"`
+}
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// ErrStreamImagesMissing is returned when a stream record doesn't include the images of the item that are needed
+// to decode it. The table's stream view type must be NEW_AND_OLD_IMAGES.
+var ErrStreamImagesMissing = errors.New("stream record images missing")
+
+// NewEventsFromStreamRecord decodes a change to an item in the table, as recorded by DynamoDB Streams, into the
+// Events that it represents. Changes to records that Events aren't published for, such as the audit log, result in
+// no Events. The user side of each membership, the userOrganisation record, changes in the same transaction as the
+// organisationGroupMember record, so only the organisationGroupMember record results in Events.
+//
+// The changes are decoded from the records alone, so they differ from the Events published to a Publisher:
+// OrganisationCreated doesn't include the owner, who is added to the owner group by a separate GroupsChanged,
+// members that are removed by deleting an Organisation result in MemberRemoved, and pending invitations that are
+// deleted, including those that are rejected, result in no Events.
+//
+// Each Event's ID is derived from the stream record's event ID, so that Events read from the stream more than once
+// have the same ID.
+func NewEventsFromStreamRecord(sr *dynamodbstreams.Record) (events []Event, err error) {
+	eventID := aws.StringValue(sr.EventID)
+	if sr.Dynamodb == nil {
+		return nil, fmt.Errorf("stream record %q: %w", eventID, ErrStreamImagesMissing)
+	}
+	old, new := sr.Dynamodb.OldImage, sr.Dynamodb.NewImage
+	image := new
+	if image == nil {
+		image = old
+	}
+	if image == nil || (aws.StringValue(sr.EventName) == dynamodbstreams.OperationTypeModify && old == nil) {
+		return nil, fmt.Errorf("stream record %q: %w", eventID, ErrStreamImagesMissing)
+	}
+	var r record
+	err = dynamodbattribute.UnmarshalMap(image, &r)
+	if err != nil {
+		return nil, fmt.Errorf("stream record %q: failed to convert record: %w", eventID, err)
+	}
+	var n int
+	metadata := func(organisationID string) EventMetadata {
+		m := EventMetadata{
+			ID:             fmt.Sprintf("%s/%d", eventID, n),
+			OrganisationID: organisationID,
+			At:             aws.TimeValue(sr.Dynamodb.ApproximateCreationDateTime),
+		}
+		n++
+		return m
+	}
+	switch r.RecordType {
+	case organisationRecordName:
+		events, err = newOrganisationStreamEvents(metadata, old, new)
+	case organisationServiceRecordName:
+		events, err = newOrganisationServiceStreamEvents(metadata, old, new)
+	case organisationMemberRecordName:
+		events, err = newOrganisationMemberStreamEvents(metadata, old, new)
+	case organisationGrantRecordName:
+		events, err = newOrganisationGrantStreamEvents(metadata, old, new)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("stream record %q: failed to convert %s: %w", eventID, r.RecordType, err)
+	}
+	return
+}
+
+// streamMetadata creates the metadata of each of the Events of a stream record in turn.
+type streamMetadata func(organisationID string) EventMetadata
+
+// unmarshalStreamImages unmarshals the old and new images into old and new, leaving either unset if its image is
+// missing. It returns whether each was set.
+func unmarshalStreamImages(oldImage, newImage map[string]*dynamodb.AttributeValue, old, new interface{}) (hasOld, hasNew bool, err error) {
+	if oldImage != nil {
+		if err = dynamodbattribute.UnmarshalMap(oldImage, old); err != nil {
+			return
+		}
+		hasOld = true
+	}
+	if newImage != nil {
+		if err = dynamodbattribute.UnmarshalMap(newImage, new); err != nil {
+			return
+		}
+		hasNew = true
+	}
+	return
+}
+
+func newOrganisationStreamEvents(metadata streamMetadata, oldImage, newImage map[string]*dynamodb.AttributeValue) (events []Event, err error) {
+	var old, new organisationRecord
+	hasOld, hasNew, err := unmarshalStreamImages(oldImage, newImage, &old, &new)
+	if err != nil || hasOld || !hasNew {
+		return
+	}
+	events = append(events, OrganisationCreated{
+		EventMetadata: metadata(new.OrganisationID),
+		Name:          new.OrganisationName,
+	})
+	return
+}
+
+func newOrganisationServiceStreamEvents(metadata streamMetadata, oldImage, newImage map[string]*dynamodb.AttributeValue) (events []Event, err error) {
+	var old, new organisationServiceRecord
+	hasOld, hasNew, err := unmarshalStreamImages(oldImage, newImage, &old, &new)
+	if err != nil {
+		return
+	}
+	// The service record doesn't include the Organisation ID, so it's taken from the key.
+	switch {
+	case !hasOld && hasNew:
+		events = append(events, ServiceCreated{
+			EventMetadata: metadata(organisationIDFromHashKey(new.ID)),
+			ServiceID:     new.ServiceID,
+			Name:          new.ServiceName,
+		})
+	case hasOld && !hasNew:
+		events = append(events, ServiceDeleted{
+			EventMetadata: metadata(organisationIDFromHashKey(old.ID)),
+			ServiceID:     old.ServiceID,
+		})
+	}
+	return
+}
+
+// organisationIDFromHashKey returns the Organisation ID from the hash key of the Organisation's records.
+func organisationIDFromHashKey(id string) string {
+	return id[len(newOrganisationRecordHashKey("")):]
+}
+
+func newOrganisationMemberStreamEvents(metadata streamMetadata, oldImage, newImage map[string]*dynamodb.AttributeValue) (events []Event, err error) {
+	var old, new organisationMemberRecord
+	hasOld, hasNew, err := unmarshalStreamImages(oldImage, newImage, &old, &new)
+	if err != nil {
+		return
+	}
+	// Invitations that are revoked or awaiting acceptance aren't memberships.
+	joined := func(omr organisationMemberRecord) bool {
+		return !omr.pending() && omr.RevokedAt == nil
+	}
+	switch {
+	case !hasOld && hasNew:
+		if new.pending() {
+			events = append(events, newMemberInvitedStreamEvent(metadata, new))
+			return
+		}
+		if joined(new) {
+			events = appendGroupsChanged(events, metadata, new, nil, new.Groups)
+		}
+	case hasOld && !hasNew:
+		if joined(old) {
+			events = append(events, MemberRemoved{EventMetadata: metadata(old.OrganisationID), UserID: old.Email})
+		}
+	case hasOld && hasNew:
+		if new.pending() {
+			// Inviting the User again replaces the token.
+			if old.TokenHash != new.TokenHash {
+				events = append(events, newMemberInvitedStreamEvent(metadata, new))
+			}
+			return
+		}
+		if old.pending() && new.RevokedAt != nil {
+			events = append(events, InvitationRevoked{EventMetadata: metadata(new.OrganisationID), UserID: new.Email})
+			return
+		}
+		if !joined(new) {
+			return
+		}
+		if !joined(old) {
+			events = append(events, InvitationAccepted{EventMetadata: metadata(new.OrganisationID), UserID: new.Email})
+		}
+		events = appendGroupsChanged(events, metadata, new, old.Groups, new.Groups)
+	}
+	return
+}
+
+func newMemberInvitedStreamEvent(metadata streamMetadata, omr organisationMemberRecord) MemberInvited {
+	groups := newAuditMembership(nil, nil)
+	if omr.Groups != nil {
+		groups = newAuditMembership(omr.Groups.OrganisationGroups(), omr.Groups.ServiceGroups())
+	}
+	return MemberInvited{
+		EventMetadata: metadata(omr.OrganisationID),
+		UserID:        omr.Email,
+		InvitedBy:     omr.InvitedBy,
+		Groups:        groups.Groups,
+		ServiceGroups: groups.ServiceGroups,
+	}
+}
+
+// appendGroupsChanged appends a GroupsChanged event if the member's groups have changed from old to new.
+func appendGroupsChanged(events []Event, metadata streamMetadata, omr organisationMemberRecord, old, new *groupSet) []Event {
+	addedSet, removedSet := new.difference(old), old.difference(new)
+	added := newAuditMembership(addedSet.OrganisationGroups(), addedSet.ServiceGroups())
+	removed := newAuditMembership(removedSet.OrganisationGroups(), removedSet.ServiceGroups())
+	if len(added.Groups)+len(added.ServiceGroups)+len(removed.Groups)+len(removed.ServiceGroups) == 0 {
+		return events
+	}
+	return append(events, GroupsChanged{
+		EventMetadata:        metadata(omr.OrganisationID),
+		UserID:               omr.Email,
+		AddedGroups:          added.Groups,
+		AddedServiceGroups:   added.ServiceGroups,
+		RemovedGroups:        removed.Groups,
+		RemovedServiceGroups: removed.ServiceGroups,
+	})
+}
+
+func newOrganisationGrantStreamEvents(metadata streamMetadata, oldImage, newImage map[string]*dynamodb.AttributeValue) (events []Event, err error) {
+	var old, new organisationGrantRecord
+	hasOld, hasNew, err := unmarshalStreamImages(oldImage, newImage, &old, &new)
+	if err != nil {
+		return
+	}
+	switch {
+	case hasNew && (!hasOld || old.TTL != new.TTL):
+		// The grant is new, or has been granted again with a different expiry.
+		gs := &groupSet{}
+		new.addTo(gs)
+		expiresAt := time.Unix(new.TTL, 0).UTC()
+		events = append(events, GroupsChanged{
+			EventMetadata:      metadata(new.OrganisationID),
+			UserID:             new.Email,
+			AddedGroups:        gs.OrganisationGroups(),
+			AddedServiceGroups: gs.ServiceGroups(),
+			ExpiresAt:          &expiresAt,
+		})
+	case hasOld && !hasNew:
+		// The grant has expired, or been revoked.
+		gs := &groupSet{}
+		old.addTo(gs)
+		events = append(events, GroupsChanged{
+			EventMetadata:        metadata(old.OrganisationID),
+			UserID:               old.Email,
+			RemovedGroups:        gs.OrganisationGroups(),
+			RemovedServiceGroups: gs.ServiceGroups(),
+		})
+	}
+	return
+}
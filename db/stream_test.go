@@ -0,0 +1,208 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewEventsFromStreamRecord(t *testing.T) {
+	at := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := at.Add(time.Hour)
+	org := newOrganisation("org", "Organisation Name")
+	renamed := newOrganisation("org", "New Organisation Name")
+	owner := newUser("owner@example.com", "Own", "Er", "447901234567", at)
+	user := newUser("user@example.com", "Us", "Er", "447901234567", at)
+
+	owned := newOrganisationMemberRecord(org, []string{GroupOwner}, nil, owner)
+	invited := newOrganisationMemberRecord(org, []string{GroupMember}, map[string][]string{"service": {ServiceGroupDeployer}}, user)
+	invited.invitationRecordFields = newInvitationRecordFields(owner.ID, at, expiresAt, "hash")
+	reinvited := invited
+	reinvited.TokenHash = "new hash"
+	accepted := invited
+	accepted.TokenHash = ""
+	accepted.AcceptedAt = &at
+	revoked := invited
+	revoked.TokenHash = ""
+	revoked.RevokedAt = &at
+	regrouped := newOrganisationMemberRecord(org, []string{GroupMember, "oncall"}, nil, user)
+	regrouped.invitationRecordFields = accepted.invitationRecordFields
+	profile := accepted
+	profile.FirstName = "New"
+
+	grant := newOrganisationGrantRecord(org.ID, Grant{UserID: user.ID, Group: ServiceGroupAdmin, ServiceID: "service", ExpiresAt: expiresAt})
+	regrant := newOrganisationGrantRecord(org.ID, Grant{UserID: user.ID, Group: ServiceGroupAdmin, ServiceID: "service", ExpiresAt: expiresAt.Add(time.Hour)})
+	regrantExpiresAt := expiresAt.Add(time.Hour)
+
+	metadata := EventMetadata{ID: "event/0", OrganisationID: org.ID, At: at}
+	tests := []struct {
+		name      string
+		eventName string
+		old, new  interface{}
+		expected  []Event
+	}{
+		{
+			name:      "creating an organisation",
+			eventName: dynamodbstreams.OperationTypeInsert,
+			new:       newOrganisationRecord(org),
+			expected:  []Event{OrganisationCreated{EventMetadata: metadata, Name: org.Name}},
+		},
+		{
+			name:      "renaming an organisation",
+			eventName: dynamodbstreams.OperationTypeModify,
+			old:       newOrganisationRecord(org),
+			new:       newOrganisationRecord(renamed),
+		},
+		{
+			name:      "creating a service",
+			eventName: dynamodbstreams.OperationTypeInsert,
+			new:       newOrganisationServiceRecord(org.ID, "service", "Service Name"),
+			expected:  []Event{ServiceCreated{EventMetadata: metadata, ServiceID: "service", Name: "Service Name"}},
+		},
+		{
+			name:      "deleting a service",
+			eventName: dynamodbstreams.OperationTypeRemove,
+			old:       newOrganisationServiceRecord(org.ID, "service", "Service Name"),
+			expected:  []Event{ServiceDeleted{EventMetadata: metadata, ServiceID: "service"}},
+		},
+		{
+			name:      "adding the owner",
+			eventName: dynamodbstreams.OperationTypeInsert,
+			new:       owned,
+			expected:  []Event{GroupsChanged{EventMetadata: metadata, UserID: owner.ID, AddedGroups: []string{GroupOwner}}},
+		},
+		{
+			name:      "inviting a user",
+			eventName: dynamodbstreams.OperationTypeInsert,
+			new:       invited,
+			expected: []Event{MemberInvited{EventMetadata: metadata, UserID: user.ID, InvitedBy: owner.ID,
+				Groups: []string{GroupMember}, ServiceGroups: map[string][]string{"service": {ServiceGroupDeployer}}}},
+		},
+		{
+			name:      "inviting a user again",
+			eventName: dynamodbstreams.OperationTypeModify,
+			old:       invited,
+			new:       reinvited,
+			expected: []Event{MemberInvited{EventMetadata: metadata, UserID: user.ID, InvitedBy: owner.ID,
+				Groups: []string{GroupMember}, ServiceGroups: map[string][]string{"service": {ServiceGroupDeployer}}}},
+		},
+		{
+			name:      "revoking an invitation",
+			eventName: dynamodbstreams.OperationTypeModify,
+			old:       invited,
+			new:       revoked,
+			expected:  []Event{InvitationRevoked{EventMetadata: metadata, UserID: user.ID}},
+		},
+		{
+			name:      "removing an invitation",
+			eventName: dynamodbstreams.OperationTypeRemove,
+			old:       invited,
+		},
+		{
+			name:      "accepting an invitation",
+			eventName: dynamodbstreams.OperationTypeModify,
+			old:       invited,
+			new:       accepted,
+			expected:  []Event{InvitationAccepted{EventMetadata: metadata, UserID: user.ID}},
+		},
+		{
+			name:      "changing groups",
+			eventName: dynamodbstreams.OperationTypeModify,
+			old:       accepted,
+			new:       regrouped,
+			expected: []Event{GroupsChanged{EventMetadata: metadata, UserID: user.ID, AddedGroups: []string{"oncall"},
+				RemovedServiceGroups: map[string][]string{"service": {ServiceGroupDeployer}}}},
+		},
+		{
+			name:      "updating a member's profile",
+			eventName: dynamodbstreams.OperationTypeModify,
+			old:       accepted,
+			new:       profile,
+		},
+		{
+			name:      "removing a member",
+			eventName: dynamodbstreams.OperationTypeRemove,
+			old:       accepted,
+			expected:  []Event{MemberRemoved{EventMetadata: metadata, UserID: user.ID}},
+		},
+		{
+			name:      "granting a group",
+			eventName: dynamodbstreams.OperationTypeInsert,
+			new:       grant,
+			expected: []Event{GroupsChanged{EventMetadata: metadata, UserID: user.ID,
+				AddedServiceGroups: map[string][]string{"service": {ServiceGroupAdmin}}, ExpiresAt: &expiresAt}},
+		},
+		{
+			name:      "granting a group again",
+			eventName: dynamodbstreams.OperationTypeModify,
+			old:       grant,
+			new:       regrant,
+			expected: []Event{GroupsChanged{EventMetadata: metadata, UserID: user.ID,
+				AddedServiceGroups: map[string][]string{"service": {ServiceGroupAdmin}}, ExpiresAt: &regrantExpiresAt}},
+		},
+		{
+			name:      "a grant expiring",
+			eventName: dynamodbstreams.OperationTypeRemove,
+			old:       grant,
+			expected: []Event{GroupsChanged{EventMetadata: metadata, UserID: user.ID,
+				RemovedServiceGroups: map[string][]string{"service": {ServiceGroupAdmin}}}},
+		},
+		{
+			name:      "the user side of a membership",
+			eventName: dynamodbstreams.OperationTypeInsert,
+			new:       newUserOrganisationRecord(user, org, at, &at),
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			sr := &dynamodbstreams.Record{
+				EventID:   aws.String("event"),
+				EventName: aws.String(test.eventName),
+				Dynamodb: &dynamodbstreams.StreamRecord{
+					ApproximateCreationDateTime: aws.Time(at),
+					OldImage:                    streamImage(t, test.old),
+					NewImage:                    streamImage(t, test.new),
+				},
+			}
+			events, err := NewEventsFromStreamRecord(sr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(test.expected, events); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestNewEventsFromStreamRecordWithoutImages(t *testing.T) {
+	sr := &dynamodbstreams.Record{
+		EventID:   aws.String("event"),
+		EventName: aws.String(dynamodbstreams.OperationTypeModify),
+		Dynamodb: &dynamodbstreams.StreamRecord{
+			NewImage: streamImage(t, newOrganisationServiceRecord("org", "service", "Service Name")),
+		},
+	}
+	_, err := NewEventsFromStreamRecord(sr)
+	if !errors.Is(err, ErrStreamImagesMissing) {
+		t.Errorf("expected ErrStreamImagesMissing, got %v", err)
+	}
+}
+
+func streamImage(t *testing.T, r interface{}) map[string]*dynamodb.AttributeValue {
+	if r == nil {
+		return nil
+	}
+	item, err := dynamodbattribute.MarshalMap(r)
+	if err != nil {
+		t.Fatalf("failed to marshal record: %v", err)
+	}
+	return item
+}
@@ -1,54 +1,71 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 )
 
 // NewUserStore creates a new UserStore.
-func NewUserStore(region, tableName string) (us UserStore, err error) {
-	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+func NewUserStore(region, tableName string, opts ...Option) (us UserStore, err error) {
+	us.Client, err = newClient(region, opts)
 	if err != nil {
 		return
 	}
-	us.Client = dynamodb.New(sess)
 	us.TableName = aws.String(tableName)
 	us.Now = func() time.Time {
 		return time.Now().UTC()
 	}
+	us.InvitationTTL = DefaultInvitationTTL
 	return
 }
 
 // UserStore stores User records in DynamoDB.
 type UserStore struct {
-	Client    *dynamodb.DynamoDB
+	Client    dynamodbiface.DynamoDBAPI
 	TableName *string
 	Now       func() time.Time
+	// InvitationTTL is how long an invitation can be accepted for.
+	InvitationTTL time.Duration
+	// Publisher publishes the Events caused by changes. If it's nil, no events are recorded.
+	Publisher Publisher
 }
 
-// Put a User.
-func (store UserStore) Put(user User) error {
+// Put a User. The User's Version must match the stored version, or be zero if the User is new, otherwise
+// ErrVersionConflict is returned.
+func (store UserStore) Put(ctx context.Context, user User) error {
 	ur := newUserRecord(user)
 	item, err := dynamodbattribute.MarshalMap(ur)
 	if err != nil {
 		return err
 	}
-	_, err = store.Client.PutItem(&dynamodb.PutItemInput{
-		TableName: store.TableName,
-		Item:      item,
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(user.Version)).Build()
+	if err != nil {
+		return fmt.Errorf("userStore.Put: failed to build condition: %v", err)
+	}
+	_, err = store.Client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName:                 store.TableName,
+		Item:                      item,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
 	})
+	if isConditionalCheckFailed(err) {
+		return fmt.Errorf("userStore.Put: %w", ErrVersionConflict)
+	}
 	return err
 }
 
 // Get a User.
-func (store UserStore) Get(id string) (user User, err error) {
-	gio, err := store.Client.GetItem(&dynamodb.GetItemInput{
+func (store UserStore) Get(ctx context.Context, id string) (user User, err error) {
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
 		TableName:      store.TableName,
 		ConsistentRead: aws.Bool(true),
 		Key:            idAndRng(newUserRecordHashKey(id), newUserRecordRangeKey()),
@@ -56,6 +73,10 @@ func (store UserStore) Get(id string) (user User, err error) {
 	if err != nil {
 		return
 	}
+	if len(gio.Item) == 0 {
+		err = fmt.Errorf("userStore.Get: %w", ErrUserNotFound)
+		return
+	}
 	var record userRecord
 	err = dynamodbattribute.UnmarshalMap(gio.Item, &record)
 	user = newUserFromRecord(record)
@@ -63,33 +84,14 @@ func (store UserStore) Get(id string) (user User, err error) {
 }
 
 // GetDetails gets the full details of a User.
-func (store UserStore) GetDetails(id string) (user UserDetails, err error) {
-	q := expression.Key("id").Equal(expression.Value(newUserRecordHashKey(id)))
-	expr, err := expression.NewBuilder().
-		WithKeyCondition(q).
-		Build()
+func (store UserStore) GetDetails(ctx context.Context, id string) (user UserDetails, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newUserRecordHashKey(id), "")
 	if err != nil {
-		err = fmt.Errorf("userStore.GetDetails: failed to build query: %v", err)
+		err = fmt.Errorf("userStore.GetDetails: failed to query pages: %v", err)
 		return
 	}
-
-	qi := &dynamodb.QueryInput{
-		TableName:                 store.TableName,
-		KeyConditionExpression:    expr.KeyCondition(),
-		ExpressionAttributeValues: expr.Values(),
-		FilterExpression:          expr.Filter(),
-		ExpressionAttributeNames:  expr.Names(),
-		ConsistentRead:            aws.Bool(true),
-	}
-
-	var items []map[string]*dynamodb.AttributeValue
-	page := func(page *dynamodb.QueryOutput, lastPage bool) bool {
-		items = append(items, page.Items...)
-		return true
-	}
-	err = store.Client.QueryPages(qi, page)
-	if err != nil {
-		err = fmt.Errorf("userStore.GetDetails: failed to query pages: %v", err)
+	if len(items) == 0 {
+		err = fmt.Errorf("userStore.GetDetails: %w", ErrUserNotFound)
 		return
 	}
 
@@ -100,6 +102,29 @@ func (store UserStore) GetDetails(id string) (user UserDetails, err error) {
 	return
 }
 
+// EffectiveGroups gets the groups that a User belongs to in each of their Organisations, including the groups
+// that contain them, keyed by Organisation ID.
+func (store UserStore) EffectiveGroups(ctx context.Context, id string) (organisationIDToGroups map[string]EffectiveGroups, err error) {
+	user, err := store.GetDetails(ctx, id)
+	if err != nil {
+		err = fmt.Errorf("userStore.EffectiveGroups: %w", err)
+		return
+	}
+	organisationIDToGroups = make(map[string]EffectiveGroups)
+	for _, org := range user.Organisations {
+		eg, err := getEffectiveGroups(ctx, store.Client, store.TableName, org.ID, id, store.Now())
+		if errors.Is(err, ErrNotMember) {
+			// The Organisation has been removed since the User joined.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("userStore.EffectiveGroups: %w", err)
+		}
+		organisationIDToGroups[org.ID] = eg
+	}
+	return
+}
+
 func newUserDetailsFromRecords(items []map[string]*dynamodb.AttributeValue) (user UserDetails, err error) {
 	for _, item := range items {
 		recordType, ok := item["typ"]
@@ -108,12 +133,13 @@ func newUserDetailsFromRecords(items []map[string]*dynamodb.AttributeValue) (use
 		}
 		switch *recordType.S {
 		case userRecordName:
-			err = dynamodbattribute.UnmarshalMap(item, &user)
+			var ur userRecord
+			err = dynamodbattribute.UnmarshalMap(item, &ur)
 			if err != nil {
 				err = fmt.Errorf("newUserDetailsFromRecords: failed to convert userRecord: %w", err)
 				return
 			}
-			user.ID = *item["email"].S
+			user.User = newUserFromRecord(ur)
 			break
 		case userOrgnisationRecordName:
 			var uor userOrganisationRecord
@@ -133,81 +159,861 @@ func newUserDetailsFromRecords(items []map[string]*dynamodb.AttributeValue) (use
 	return
 }
 
-// Invite a User to an Organisation, optionally inviting to Organisation and Service groups.
-func (store UserStore) Invite(u User, org Organisation, groups []string, serviceGroups map[string][]string) error {
-	now := store.Now()
-	organisationMemberRecord := newOrganisationMemberRecord(org, groups, serviceGroups, u)
-	organisationGroupMemberItem, err := dynamodbattribute.ConvertToMap(organisationMemberRecord)
+// AuditLog reads a page of the changes made to the User's profile and memberships, and the changes made by the
+// User, oldest first. At most limit records are returned, or all of them if limit is zero. To read the next page,
+// pass the returned page's Next as start.
+func (store UserStore) AuditLog(ctx context.Context, id string, limit int, start string) (page AuditPage, err error) {
+	page, err = queryAuditLog(ctx, store.Client, store.TableName, newUserAuditRecordHashKey(id), limit, start)
 	if err != nil {
-		return fmt.Errorf("userStore.Invite: failed to convert organisationMemberRecord: %w", err)
+		err = fmt.Errorf("userStore.AuditLog: %w", err)
 	}
-	userOrganisationRecord := newUserOrganisationRecord(u, org, now, nil)
-	userOrganisationItem, err := dynamodbattribute.ConvertToMap(userOrganisationRecord)
+	return
+}
+
+// UpdateProfile updates a User, and the copies of the User's name and phone number held by each Organisation
+// that the User is a member of, or has been invited to. The User's Version must match the stored version,
+// otherwise ErrVersionConflict is returned.
+//
+// When the User belongs to few enough Organisations, the update is a single transaction. Otherwise, the User
+// is updated first, and the changes are then copied to each Organisation in turn. If copying fails, a
+// *ProfileSyncError is returned, and SyncProfile can be used to complete the update.
+func (store UserStore) UpdateProfile(ctx context.Context, user User) error {
+	organisationIDs, err := store.getOrganisationIDs(ctx, user.ID)
 	if err != nil {
-		return fmt.Errorf("userStore.Invite: failed to convert userOrganisationRecord: %w", err)
+		return fmt.Errorf("userStore.UpdateProfile: %w", err)
 	}
-	_, err = store.Client.BatchWriteItem(&dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]*dynamodb.WriteRequest{
-			*store.TableName: {
-				&dynamodb.WriteRequest{
-					PutRequest: &dynamodb.PutRequest{
-						Item: organisationGroupMemberItem,
-					},
-				},
-				&dynamodb.WriteRequest{
-					PutRequest: &dynamodb.PutRequest{
-						Item: userOrganisationItem,
-					},
-				},
+	audit, err := store.newProfileAuditPuts(ctx, user)
+	if err != nil {
+		return fmt.Errorf("userStore.UpdateProfile: %w", err)
+	}
+	if len(organisationIDs)+1+len(audit) <= maxTransactionItems {
+		err = store.updateProfileTransaction(ctx, user, organisationIDs, audit)
+		if !errors.Is(err, errMembershipNotFound) {
+			return err
+		}
+		// One of the Organisations no longer has a membership record to update, so the transaction
+		// can't succeed. Fall back to updating each Organisation in turn.
+	}
+	err = store.updateProfileTransaction(ctx, user, nil, audit)
+	if err != nil {
+		return err
+	}
+	return store.syncProfile(ctx, user, organisationIDs)
+}
+
+// newProfileAuditPuts creates the puts of the audit record of a profile update, which names the fields that
+// changed.
+func (store UserStore) newProfileAuditPuts(ctx context.Context, user User) ([]*dynamodb.TransactWriteItem, error) {
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            idAndRng(newUserRecordHashKey(user.ID), newUserRecordRangeKey()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	var before userRecord
+	err = dynamodbattribute.UnmarshalMap(gio.Item, &before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert userRecord: %w", err)
+	}
+	ar, err := newAuditRecord(ctx, store.Now(), AuditActionUpdateProfile, "", user.ID, nil, newAuditFields(before.userRecordFields, newUserRecord(user).userRecordFields))
+	if err != nil {
+		return nil, err
+	}
+	ar.Actor = actorFromContext(ctx, user.ID)
+	return newAuditPuts(store.TableName, ar)
+}
+
+// errMembershipNotFound is returned when one side of a User's membership of an Organisation is missing.
+var errMembershipNotFound = errors.New("membership not found")
+
+func (store UserStore) updateProfileTransaction(ctx context.Context, user User, organisationIDs []string, audit []*dynamodb.TransactWriteItem) error {
+	item, err := dynamodbattribute.MarshalMap(newUserRecord(user))
+	if err != nil {
+		return fmt.Errorf("userStore.UpdateProfile: failed to convert userRecord: %w", err)
+	}
+	expr, err := expression.NewBuilder().WithCondition(versionCondition(user.Version)).Build()
+	if err != nil {
+		return fmt.Errorf("userStore.UpdateProfile: failed to build condition: %v", err)
+	}
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Put: &dynamodb.Put{
+				TableName:                 store.TableName,
+				Item:                      item,
+				ConditionExpression:       expr.Condition(),
+				ExpressionAttributeNames:  expr.Names(),
+				ExpressionAttributeValues: expr.Values(),
 			},
 		},
+	}
+	for _, organisationID := range organisationIDs {
+		update, err := store.newMemberProfileUpdate(user, organisationID)
+		if err != nil {
+			return fmt.Errorf("userStore.UpdateProfile: %w", err)
+		}
+		items = append(items, &dynamodb.TransactWriteItem{Update: update})
+	}
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append(items, audit...),
 	})
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return fmt.Errorf("userStore.UpdateProfile: %w", ErrVersionConflict)
+	}
+	if len(failedTransactionConditions(err)) > 0 {
+		return errMembershipNotFound
+	}
 	return err
 }
 
-// AcceptInvite accepts an invitation to join an Organisation.
-func (store UserStore) AcceptInvite(u User, org Organisation) error {
-	update := expression.Set(expression.Name("acceptedAt"), expression.Value(store.Now()))
+// SyncProfile copies a User's name and phone number to each Organisation that the User is a member of, or has
+// been invited to. It completes an UpdateProfile that returned a *ProfileSyncError.
+func (store UserStore) SyncProfile(ctx context.Context, id string) error {
+	user, err := store.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("userStore.SyncProfile: %w", err)
+	}
+	organisationIDs, err := store.getOrganisationIDs(ctx, id)
+	if err != nil {
+		return fmt.Errorf("userStore.SyncProfile: %w", err)
+	}
+	return store.syncProfile(ctx, user, organisationIDs)
+}
+
+func (store UserStore) syncProfile(ctx context.Context, user User, organisationIDs []string) error {
+	syncErr := &ProfileSyncError{
+		UserID: user.ID,
+	}
+	for _, organisationID := range organisationIDs {
+		update, err := store.newMemberProfileUpdate(user, organisationID)
+		if err != nil {
+			return fmt.Errorf("userStore.syncProfile: %w", err)
+		}
+		_, err = store.Client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 update.TableName,
+			Key:                       update.Key,
+			UpdateExpression:          update.UpdateExpression,
+			ConditionExpression:       update.ConditionExpression,
+			ExpressionAttributeNames:  update.ExpressionAttributeNames,
+			ExpressionAttributeValues: update.ExpressionAttributeValues,
+		})
+		if isConditionalCheckFailed(err) {
+			// The User is no longer a member of the Organisation.
+			continue
+		}
+		if err != nil {
+			syncErr.OrganisationIDs = append(syncErr.OrganisationIDs, organisationID)
+			if syncErr.Err == nil {
+				syncErr.Err = err
+			}
+		}
+	}
+	if len(syncErr.OrganisationIDs) > 0 {
+		return syncErr
+	}
+	return nil
+}
+
+// newMemberProfileUpdate creates an update that copies the User's details to their membership of an Organisation,
+// if they are a member.
+func (store UserStore) newMemberProfileUpdate(user User, organisationID string) (update *dynamodb.Update, err error) {
+	set := incrementVersion(expression.
+		Set(expression.Name("firstName"), expression.Value(user.FirstName)).
+		Set(expression.Name("lastName"), expression.Value(user.LastName)).
+		Set(expression.Name("phone"), expression.Value(user.Phone)))
+	isMember := expression.AttributeExists(expression.Name("id"))
 	expr, err := expression.NewBuilder().
-		WithUpdate(update).
+		WithUpdate(set).
+		WithCondition(isMember).
 		Build()
 	if err != nil {
-		return fmt.Errorf("userStore.AcceptInvite: failed to build query: %v", err)
+		err = fmt.Errorf("failed to build membership update: %v", err)
+		return
 	}
-
-	_, err = store.Client.UpdateItem(&dynamodb.UpdateItemInput{
+	update = &dynamodb.Update{
 		TableName:                 store.TableName,
-		Key:                       idAndRng(newUserOrganisationRecordHashKey(u.ID), newUserOrganisationRecordRangeKey(org.ID)),
+		Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(user.ID)),
 		UpdateExpression:          expr.Update(),
-		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
 		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}
+	return
+}
+
+// getOrganisationIDs gets the IDs of all Organisations that the User is a member of, or has been invited to.
+func (store UserStore) getOrganisationIDs(ctx context.Context, id string) (organisationIDs []string, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newUserOrganisationRecordHashKey(id), userOrgnisationRecordName+"/")
+	if err != nil {
+		err = fmt.Errorf("failed to query organisations: %v", err)
+		return
+	}
+	return newOrganisationIDsFromUserOrganisationRecords(items)
+}
+
+func newOrganisationIDsFromUserOrganisationRecords(items []map[string]*dynamodb.AttributeValue) (organisationIDs []string, err error) {
+	for _, item := range items {
+		var uor userOrganisationRecord
+		err = dynamodbattribute.UnmarshalMap(item, &uor)
+		if err != nil {
+			err = fmt.Errorf("failed to convert userOrganisationRecord: %w", err)
+			return
+		}
+		organisationIDs = append(organisationIDs, uor.OrganisationID)
+	}
+	return
+}
+
+// Delete erases a User. The User record, the User's record of each Organisation they belong to, and their
+// membership and grants of each of those Organisations are deleted, removing the User's personal data from the table.
+// The User's audit log is deleted, and their ID is replaced with a pseudonym in the other audit records that refer
+// to them, the invitations they sent, and the events waiting to be published. If the User doesn't exist,
+// ErrUserNotFound is returned. If the User is the last owner of an Organisation, a *LastOwnerError is returned,
+// and nothing is deleted.
+//
+// Memberships are found through the User's records, so memberships written by earlier versions must be migrated
+// with MigrateMemberships first. The User record is deleted last, so if Delete fails part way through, it can be
+// called again to complete the erasure.
+func (store UserStore) Delete(ctx context.Context, id string) (report ErasureReport, err error) {
+	items, err := queryPartition(ctx, store.Client, store.TableName, newUserRecordHashKey(id), "")
+	if err != nil {
+		err = fmt.Errorf("userStore.Delete: failed to query records: %w", err)
+		return
+	}
+	if len(items) == 0 {
+		err = fmt.Errorf("userStore.Delete: %w", ErrUserNotFound)
+		return
+	}
+	userKeys, organisationIDs, err := newUserDeleteKeys(items)
+	if err != nil {
+		err = fmt.Errorf("userStore.Delete: %w", err)
+		return
+	}
+	report.UserID = id
+	var memberOf []string
+	var grantKeys []RecordKey
+	lastOwnerErr := &LastOwnerError{UserID: id}
+	for _, organisationID := range organisationIDs {
+		err = ensureOwners(ctx, store.Client, store.TableName, organisationID)
+		if err != nil {
+			err = fmt.Errorf("userStore.Delete: %w", err)
+			return
+		}
+		var orgItems []map[string]*dynamodb.AttributeValue
+		orgItems, err = queryPartition(ctx, store.Client, store.TableName, newOrganisationRecordHashKey(organisationID), "")
+		if err != nil {
+			err = fmt.Errorf("userStore.Delete: failed to query organisation %q: %w", organisationID, err)
+			return
+		}
+		var isMember, isLastOwner bool
+		isMember, isLastOwner, err = newMembershipFromOrganisationRecords(id, orgItems)
+		if err != nil {
+			err = fmt.Errorf("userStore.Delete: %w", err)
+			return
+		}
+		if isLastOwner {
+			lastOwnerErr.OrganisationIDs = append(lastOwnerErr.OrganisationIDs, organisationID)
+		}
+		if isMember {
+			memberOf = append(memberOf, organisationID)
+		}
+		var keys []RecordKey
+		keys, err = newGrantKeys(id, orgItems, nil)
+		if err != nil {
+			err = fmt.Errorf("userStore.Delete: %w", err)
+			return
+		}
+		grantKeys = append(grantKeys, keys...)
+		report.OrganisationIDs = append(report.OrganisationIDs, organisationID)
+	}
+	if len(lastOwnerErr.OrganisationIDs) > 0 {
+		err = lastOwnerErr
+		report = ErasureReport{}
+		return
+	}
+	// The events waiting to be published are erased before the memberships are deleted, so that the events of the
+	// erasure keep the User's ID, for subscribers to erase their own copies of the User's data.
+	erasedID := newErasedUserID()
+	err = eraseOutbox(ctx, store.Client, store.TableName, id, erasedID)
+	if err != nil {
+		err = fmt.Errorf("userStore.Delete: %w", err)
+		return
+	}
+	// Delete the memberships before the User's records, so that they can still be found if a retry is needed.
+	for _, organisationID := range memberOf {
+		err = store.deleteMembership(ctx, organisationID, id)
+		if err != nil {
+			err = fmt.Errorf("userStore.Delete: %w", err)
+			return
+		}
+		report.Deleted = append(report.Deleted, RecordKey{ID: newOrganisationMemberRecordHashKey(organisationID), Range: newOrganisationMemberRecordRangeKey(id)})
+	}
+	auditKeys, auditedOrganisationIDs, err := eraseAuditLog(ctx, store.Client, store.TableName, id, erasedID, report.OrganisationIDs)
+	if err != nil {
+		err = fmt.Errorf("userStore.Delete: %w", err)
+		return
+	}
+	for _, organisationID := range auditedOrganisationIDs {
+		err = eraseInvitedBy(ctx, store.Client, store.TableName, organisationID, id, erasedID)
+		if err != nil {
+			err = fmt.Errorf("userStore.Delete: %w", err)
+			return
+		}
+	}
+	userKeys = append(append(grantKeys, auditKeys...), userKeys...)
+	err = batchDelete(ctx, store.Client, store.TableName, newKeys(userKeys), nil)
+	if err != nil {
+		err = fmt.Errorf("userStore.Delete: failed to delete records: %w", err)
+		return
+	}
+	report.Deleted = append(report.Deleted, userKeys...)
+	return
+}
+
+// deleteMembership deletes a User's membership of an Organisation, and removes them from its owners. If the User
+// has become the last owner since they were checked, a *LastOwnerError is returned.
+func (store UserStore) deleteMembership(ctx context.Context, organisationID, userID string) error {
+	removeOwner, err := newRemoveOwnerUpdate(store.TableName, organisationID, userID)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < maxAuditAttempts; i++ {
+		before, version, err := getMembership(ctx, store.Client, store.TableName, organisationID, userID)
+		if err != nil {
+			return err
+		}
+		ar, err := newAuditRecord(ctx, store.Now(), AuditActionEraseUser, organisationID, userID, before, nil)
+		if err != nil {
+			return err
+		}
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return err
+		}
+		removed := MemberRemoved{EventMetadata: newEventMetadata(organisationID, ar.At), UserID: userID}
+		outbox, err := newOutboxPuts(store.TableName, store.Publisher, removed)
+		if err != nil {
+			return err
+		}
+		expr, err := expression.NewBuilder().WithCondition(versionCondition(version)).Build()
+		if err != nil {
+			return fmt.Errorf("failed to build condition: %v", err)
+		}
+		_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]*dynamodb.TransactWriteItem{
+				{
+					Delete: &dynamodb.Delete{
+						TableName:                 store.TableName,
+						Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(userID)),
+						ConditionExpression:       expr.Condition(),
+						ExpressionAttributeNames:  expr.Names(),
+						ExpressionAttributeValues: expr.Values(),
+					},
+				},
+				{Update: removeOwner},
+			}, append(audit, outbox...)...),
+		})
+		if failed, _ := failedTransactionCondition(err, 0); failed {
+			// The membership changed since it was read for the audit log.
+			continue
+		}
+		if failed, _ := failedTransactionCondition(err, 1); failed {
+			return &LastOwnerError{UserID: userID, OrganisationIDs: []string{organisationID}}
+		}
+		if err != nil {
+			return err
+		}
+		publishEvents(ctx, store.Client, store.TableName, store.Publisher, removed)
+		return nil
+	}
+	return ErrVersionConflict
+}
+
+// newUserDeleteKeys returns the keys of the records in a User's partition, with the User record last, and the
+// IDs of the Organisations that the User belongs to.
+func newUserDeleteKeys(items []map[string]*dynamodb.AttributeValue) (keys []RecordKey, organisationIDs []string, err error) {
+	var userKey *RecordKey
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		key := RecordKey{ID: r.ID, Range: r.Range}
+		switch r.RecordType {
+		case userRecordName:
+			userKey = &key
+			continue
+		case userOrgnisationRecordName:
+			var uor userOrganisationRecord
+			err = dynamodbattribute.UnmarshalMap(item, &uor)
+			if err != nil {
+				err = fmt.Errorf("failed to convert userOrganisationRecord: %w", err)
+				return
+			}
+			organisationIDs = append(organisationIDs, uor.OrganisationID)
+		}
+		keys = append(keys, key)
+	}
+	if userKey != nil {
+		keys = append(keys, *userKey)
+	}
+	return
+}
+
+// newMembershipFromOrganisationRecords returns whether the User is a member of the Organisation, and whether they
+// are its only owner, given the items in the Organisation's partition.
+func newMembershipFromOrganisationRecords(userID string, items []map[string]*dynamodb.AttributeValue) (isMember, isLastOwner bool, err error) {
+	for _, item := range items {
+		var r record
+		err = dynamodbattribute.UnmarshalMap(item, &r)
+		if err != nil {
+			err = fmt.Errorf("failed to convert record: %w", err)
+			return
+		}
+		switch r.RecordType {
+		case organisationMemberRecordName:
+			var omr organisationMemberRecord
+			err = dynamodbattribute.UnmarshalMap(item, &omr)
+			if err != nil {
+				err = fmt.Errorf("failed to convert organisationMemberRecord: %w", err)
+				return
+			}
+			isMember = isMember || omr.Email == userID
+		case organisationOwnersRecordName:
+			var oor organisationOwnersRecord
+			err = dynamodbattribute.UnmarshalMap(item, &oor)
+			if err != nil {
+				err = fmt.Errorf("failed to convert organisationOwnersRecord: %w", err)
+				return
+			}
+			isLastOwner = len(oor.Owners) == 1 && oor.Owners[0] == userID
+		}
+	}
+	return
+}
+
+// newKeys converts record keys to DynamoDB keys.
+func newKeys(keys []RecordKey) (dynamoKeys []map[string]*dynamodb.AttributeValue) {
+	dynamoKeys = make([]map[string]*dynamodb.AttributeValue, len(keys))
+	for i, k := range keys {
+		dynamoKeys[i] = idAndRng(k.ID, k.Range)
+	}
+	return
+}
+
+// Invite a User to an Organisation, optionally inviting to Organisation and Service groups. The returned token
+// must be passed to AcceptInvite, and is only valid until the InvitationTTL has passed. The Organisation must
+// exist, otherwise ErrOrganisationNotFound is returned, and each group must be defined by one of its roles,
+// otherwise ErrRoleNotFound is returned. Inviting a User again replaces the pending invitation, but if the User
+// has already accepted an invitation, or was added to the Organisation's groups directly,
+// ErrInvitationAlreadyAccepted is returned.
+func (store UserStore) Invite(ctx context.Context, invitedBy string, u User, org Organisation, groups []string, serviceGroups map[string][]string) (token string, err error) {
+	err = checkGroups(ctx, store.Client, store.TableName, org.ID, groups, serviceGroups)
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: %w", err)
+		return
+	}
+	now := store.Now()
+	token, tokenHash, err := newInvitationToken()
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: %w", err)
+		return
+	}
+	expiresAt := now.Add(store.InvitationTTL)
+	checkOrganisationExists, err := newOrganisationExistsCheck(store.TableName, org.ID)
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: %w", err)
+		return
+	}
+
+	organisationMemberRecord := newOrganisationMemberRecord(org, groups, serviceGroups, u)
+	organisationMemberRecord.invitationRecordFields = newInvitationRecordFields(invitedBy, now, expiresAt, tokenHash)
+	organisationGroupMemberItem, err := dynamodbattribute.MarshalMap(organisationMemberRecord)
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: failed to convert organisationMemberRecord: %w", err)
+		return
+	}
+	notMemberExpr, err := expression.NewBuilder().WithCondition(expression.Not(isAcceptedMember())).Build()
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: failed to build member condition: %w", err)
+		return
+	}
+	putOrganisationGroupMember := &dynamodb.Put{
+		TableName:                store.TableName,
+		Item:                     organisationGroupMemberItem,
+		ConditionExpression:      notMemberExpr.Condition(),
+		ExpressionAttributeNames: notMemberExpr.Names(),
+	}
+
+	userOrganisationRecord := newUserOrganisationRecord(u, org, now, nil)
+	userOrganisationRecord.InvitedBy = invitedBy
+	userOrganisationRecord.ExpiresAt = expiresAt.Unix()
+	userOrganisationItem, err := dynamodbattribute.MarshalMap(userOrganisationRecord)
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: failed to convert userOrganisationRecord: %w", err)
+		return
+	}
+	notAcceptedExpr, err := expression.NewBuilder().WithCondition(invitationNotAccepted()).Build()
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: failed to build invitation condition: %w", err)
+		return
+	}
+	putUserOrganisation := &dynamodb.Put{
+		TableName:                 store.TableName,
+		Item:                      userOrganisationItem,
+		ConditionExpression:       notAcceptedExpr.Condition(),
+		ExpressionAttributeNames:  notAcceptedExpr.Names(),
+		ExpressionAttributeValues: notAcceptedExpr.Values(),
+	}
+
+	invited := newAuditMembership(groups, serviceGroups)
+	invited.Pending = true
+	ar, err := newAuditRecord(ctx, now, AuditActionInvite, org.ID, u.ID, nil, invited)
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: %w", err)
+		return
+	}
+	ar.Actor = actorFromContext(ctx, invitedBy)
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: %w", err)
+		return
+	}
+	invitation := MemberInvited{EventMetadata: newEventMetadata(org.ID, now), UserID: u.ID, InvitedBy: invitedBy, Groups: groups, ServiceGroups: serviceGroups}
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, invitation)
+	if err != nil {
+		err = fmt.Errorf("userStore.Invite: %w", err)
+		return
+	}
+	items := append([]*dynamodb.TransactWriteItem{
+		{ConditionCheck: checkOrganisationExists},
+		{Put: putOrganisationGroupMember},
+		{Put: putUserOrganisation},
+	}, audit...)
+
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append(items, outbox...),
 	})
-	return err
+	if failed, _ := failedTransactionCondition(err, 0); failed {
+		return "", fmt.Errorf("userStore.Invite: %w", ErrOrganisationNotFound)
+	}
+	isMember, _ := failedTransactionCondition(err, 1)
+	if accepted, _ := failedTransactionCondition(err, 2); isMember || accepted {
+		return "", fmt.Errorf("userStore.Invite: %w", ErrInvitationAlreadyAccepted)
+	}
+	if err != nil {
+		return "", err
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, invitation)
+	return
 }
 
-// RejectInvite rejects an invitation to join an Organisation.
-func (store UserStore) RejectInvite(u User, org Organisation) error {
+// AcceptInvite accepts an invitation to join an Organisation, using the token returned when the User was
+// invited. The token can only be used once, and the acceptance time is recorded against both the User and the
+// Organisation. If the invitation can't be accepted, ErrInvitationNotFound,
+// ErrInvitationAlreadyAccepted, ErrInvitationRevoked, ErrInvitationExpired or ErrInvalidInvitationToken is
+// returned. If the invitation is changed while it's being accepted, ErrVersionConflict is returned. Users invited
+// to the owner group become owners when they accept.
+func (store UserStore) AcceptInvite(ctx context.Context, u User, org Organisation, token string) error {
+	now := store.Now()
+	tokenHash := hashInvitationToken(token)
+	memberKey := idAndRng(newOrganisationMemberRecordHashKey(org.ID), newOrganisationMemberRecordRangeKey(u.ID))
+	gio, err := store.Client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      store.TableName,
+		ConsistentRead: aws.Bool(true),
+		Key:            memberKey,
+	})
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: %w", err)
+	}
+	var omr organisationMemberRecord
+	err = dynamodbattribute.UnmarshalMap(gio.Item, &omr)
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: failed to convert organisationMemberRecord: %w", err)
+	}
+	err = checkInvitation(omr.invitationRecordFields, tokenHash, now)
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: %w", err)
+	}
+
+	consumeToken := incrementVersion(expression.Remove(expression.Name("tokenHash")).
+		Set(expression.Name("acceptedAt"), expression.Value(now)))
+	// The version condition ensures that the groups being accepted are the ones that were read.
+	valid := expression.And(
+		versionCondition(omr.Version),
+		expression.Name("tokenHash").Equal(expression.Value(tokenHash)),
+		expression.Name("expiresAt").GreaterThan(expression.Value(now.Unix())),
+		expression.AttributeNotExists(expression.Name("revokedAt")))
+	memberExpr, err := expression.NewBuilder().
+		WithUpdate(consumeToken).
+		WithCondition(valid).
+		Build()
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: failed to build member update: %v", err)
+	}
+	accept := incrementVersion(expression.Set(expression.Name("acceptedAt"), expression.Value(now)))
+	pending := expression.And(expression.AttributeExists(expression.Name("id")), invitationNotAccepted())
+	expr, err := expression.NewBuilder().
+		WithUpdate(accept).
+		WithCondition(pending).
+		Build()
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: failed to build query: %v", err)
+	}
+	items := []*dynamodb.TransactWriteItem{
+		{
+			Update: &dynamodb.Update{
+				TableName:                           store.TableName,
+				Key:                                 memberKey,
+				UpdateExpression:                    memberExpr.Update(),
+				ConditionExpression:                 memberExpr.Condition(),
+				ExpressionAttributeNames:            memberExpr.Names(),
+				ExpressionAttributeValues:           memberExpr.Values(),
+				ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+			},
+		},
+		{
+			Update: &dynamodb.Update{
+				TableName:                           store.TableName,
+				Key:                                 idAndRng(newUserOrganisationRecordHashKey(u.ID), newUserOrganisationRecordRangeKey(org.ID)),
+				UpdateExpression:                    expr.Update(),
+				ConditionExpression:                 expr.Condition(),
+				ExpressionAttributeValues:           expr.Values(),
+				ExpressionAttributeNames:            expr.Names(),
+				ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
+			},
+		},
+	}
+	if omr.Groups != nil && containsString(omr.Groups.OrganisationGroups(), GroupOwner) {
+		// Invited owners only count towards the Organisation's owners once they've accepted.
+		err = ensureOwners(ctx, store.Client, store.TableName, org.ID)
+		if err != nil {
+			return fmt.Errorf("userStore.AcceptInvite: %w", err)
+		}
+		addOwner, err := newAddOwnerUpdate(store.TableName, org.ID, u.ID)
+		if err != nil {
+			return fmt.Errorf("userStore.AcceptInvite: %w", err)
+		}
+		items = append(items, &dynamodb.TransactWriteItem{Update: addOwner})
+	}
+	before, _, err := newAuditMembershipFromRecord(gio.Item)
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: %w", err)
+	}
+	after := *before
+	after.Pending = false
+	ar, err := newAuditRecord(ctx, now, AuditActionAcceptInvite, org.ID, u.ID, before, &after)
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: %w", err)
+	}
+	ar.Actor = actorFromContext(ctx, u.ID)
+	audit, err := newAuditPuts(store.TableName, ar)
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: %w", err)
+	}
+	items = append(items, audit...)
+	accepted := InvitationAccepted{EventMetadata: newEventMetadata(org.ID, now), UserID: u.ID}
+	outbox, err := newOutboxPuts(store.TableName, store.Publisher, accepted)
+	if err != nil {
+		return fmt.Errorf("userStore.AcceptInvite: %w", err)
+	}
+	items = append(items, outbox...)
+
+	_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	if failed, item := failedTransactionCondition(err, 0); failed {
+		if err := newInvitationError(item, tokenHash, now); err != nil {
+			return fmt.Errorf("userStore.AcceptInvite: %w", err)
+		}
+		return fmt.Errorf("userStore.AcceptInvite: %w", ErrVersionConflict)
+	}
+	if failed, item := failedTransactionCondition(err, 1); failed {
+		if len(item) == 0 {
+			return fmt.Errorf("userStore.AcceptInvite: %w", ErrInvitationNotFound)
+		}
+		return fmt.Errorf("userStore.AcceptInvite: %w", ErrInvitationAlreadyAccepted)
+	}
+	if err != nil {
+		return err
+	}
+	publishEvents(ctx, store.Client, store.TableName, store.Publisher, accepted)
+	return nil
+}
+
+// RejectInvite rejects an invitation to join an Organisation. If there is no invitation, ErrInvitationNotFound
+// is returned, and if the invitation has already been accepted, ErrInvitationAlreadyAccepted is returned.
+func (store UserStore) RejectInvite(ctx context.Context, u User, org Organisation) error {
 	organisationGroupMemberKey := idAndRng(newOrganisationMemberRecordHashKey(org.ID),
 		newOrganisationMemberRecordRangeKey(u.ID))
 	userOrganisationRecordKey := idAndRng(newUserOrganisationRecordHashKey(u.ID),
 		newUserOrganisationRecordRangeKey(org.ID))
-	_, err := store.Client.BatchWriteItem(&dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]*dynamodb.WriteRequest{
-			*store.TableName: {
-				&dynamodb.WriteRequest{
-					DeleteRequest: &dynamodb.DeleteRequest{
-						Key: organisationGroupMemberKey,
+	pending := expression.And(expression.AttributeExists(expression.Name("id")), invitationNotAccepted())
+	pendingExpr, err := expression.NewBuilder().WithCondition(pending).Build()
+	if err != nil {
+		return fmt.Errorf("userStore.RejectInvite: failed to build condition: %v", err)
+	}
+	for i := 0; i < maxAuditAttempts; i++ {
+		before, version, err := getMembership(ctx, store.Client, store.TableName, org.ID, u.ID)
+		if err != nil {
+			return fmt.Errorf("userStore.RejectInvite: %w", err)
+		}
+		ar, err := newAuditRecord(ctx, store.Now(), AuditActionRejectInvite, org.ID, u.ID, before, nil)
+		if err != nil {
+			return fmt.Errorf("userStore.RejectInvite: %w", err)
+		}
+		ar.Actor = actorFromContext(ctx, u.ID)
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return fmt.Errorf("userStore.RejectInvite: %w", err)
+		}
+		rejected := InvitationRejected{EventMetadata: newEventMetadata(org.ID, ar.At), UserID: u.ID}
+		outbox, err := newOutboxPuts(store.TableName, store.Publisher, rejected)
+		if err != nil {
+			return fmt.Errorf("userStore.RejectInvite: %w", err)
+		}
+		unchanged, err := expression.NewBuilder().WithCondition(versionCondition(version)).Build()
+		if err != nil {
+			return fmt.Errorf("userStore.RejectInvite: failed to build condition: %v", err)
+		}
+		_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append([]*dynamodb.TransactWriteItem{
+				{
+					Delete: &dynamodb.Delete{
+						TableName:                 store.TableName,
+						Key:                       organisationGroupMemberKey,
+						ConditionExpression:       unchanged.Condition(),
+						ExpressionAttributeNames:  unchanged.Names(),
+						ExpressionAttributeValues: unchanged.Values(),
 					},
 				},
-				&dynamodb.WriteRequest{
-					DeleteRequest: &dynamodb.DeleteRequest{
-						Key: userOrganisationRecordKey,
+				{
+					Delete: &dynamodb.Delete{
+						TableName:                           store.TableName,
+						Key:                                 userOrganisationRecordKey,
+						ConditionExpression:                 pendingExpr.Condition(),
+						ExpressionAttributeNames:            pendingExpr.Names(),
+						ExpressionAttributeValues:           pendingExpr.Values(),
+						ReturnValuesOnConditionCheckFailure: aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld),
 					},
 				},
+			}, append(audit, outbox...)...),
+		})
+		if failed, item := failedTransactionCondition(err, 1); failed {
+			if len(item) == 0 {
+				return fmt.Errorf("userStore.RejectInvite: %w", ErrInvitationNotFound)
+			}
+			return fmt.Errorf("userStore.RejectInvite: %w", ErrInvitationAlreadyAccepted)
+		}
+		if failed, _ := failedTransactionCondition(err, 0); failed {
+			// The invitation changed since it was read for the audit log.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		publishEvents(ctx, store.Client, store.TableName, store.Publisher, rejected)
+		return nil
+	}
+	return fmt.Errorf("userStore.RejectInvite: %w", ErrVersionConflict)
+}
+
+// LeaveOrganisation removes a User from an Organisation. The User's record of belonging to the Organisation,
+// their membership and their grants are deleted in a single transaction with the audit record. If the User doesn't
+// belong to the Organisation, ErrNotMember is returned, and if the User is the Organisation's last owner, a
+// *LastOwnerError is returned.
+func (store UserStore) LeaveOrganisation(ctx context.Context, id, organisationID string) error {
+	belongs := expression.AttributeExists(expression.Name("id"))
+	belongsExpr, err := expression.NewBuilder().WithCondition(belongs).Build()
+	if err != nil {
+		return fmt.Errorf("userStore.LeaveOrganisation: failed to build condition: %v", err)
+	}
+	err = ensureOwners(ctx, store.Client, store.TableName, organisationID)
+	if err != nil {
+		return fmt.Errorf("userStore.LeaveOrganisation: %w", err)
+	}
+	removeOwner, err := newRemoveOwnerUpdate(store.TableName, organisationID, id)
+	if err != nil {
+		return fmt.Errorf("userStore.LeaveOrganisation: %w", err)
+	}
+	deleteGrants, err := newDeleteGrants(ctx, store.Client, store.TableName, organisationID, id, nil)
+	if err != nil {
+		return fmt.Errorf("userStore.LeaveOrganisation: %w", err)
+	}
+	for i := 0; i < maxAuditAttempts; i++ {
+		before, version, err := getMembership(ctx, store.Client, store.TableName, organisationID, id)
+		if err != nil {
+			return fmt.Errorf("userStore.LeaveOrganisation: %w", err)
+		}
+		ar, err := newAuditRecord(ctx, store.Now(), AuditActionLeave, organisationID, id, before, nil)
+		if err != nil {
+			return fmt.Errorf("userStore.LeaveOrganisation: %w", err)
+		}
+		ar.Actor = actorFromContext(ctx, id)
+		audit, err := newAuditPuts(store.TableName, ar)
+		if err != nil {
+			return fmt.Errorf("userStore.LeaveOrganisation: %w", err)
+		}
+		removed := MemberRemoved{EventMetadata: newEventMetadata(organisationID, ar.At), UserID: id}
+		outbox, err := newOutboxPuts(store.TableName, store.Publisher, removed)
+		if err != nil {
+			return fmt.Errorf("userStore.LeaveOrganisation: %w", err)
+		}
+		unchanged, err := expression.NewBuilder().WithCondition(versionCondition(version)).Build()
+		if err != nil {
+			return fmt.Errorf("userStore.LeaveOrganisation: failed to build condition: %v", err)
+		}
+		items := append([]*dynamodb.TransactWriteItem{
+			{
+				Delete: &dynamodb.Delete{
+					TableName:                store.TableName,
+					Key:                      idAndRng(newUserOrganisationRecordHashKey(id), newUserOrganisationRecordRangeKey(organisationID)),
+					ConditionExpression:      belongsExpr.Condition(),
+					ExpressionAttributeNames: belongsExpr.Names(),
+				},
 			},
-		},
-	})
-	return err
+			{
+				Delete: &dynamodb.Delete{
+					TableName:                 store.TableName,
+					Key:                       idAndRng(newOrganisationMemberRecordHashKey(organisationID), newOrganisationMemberRecordRangeKey(id)),
+					ConditionExpression:       unchanged.Condition(),
+					ExpressionAttributeNames:  unchanged.Names(),
+					ExpressionAttributeValues: unchanged.Values(),
+				},
+			},
+			{Update: removeOwner},
+		}, audit...)
+		items = append(items, outbox...)
+		_, err = store.Client.TransactWriteItemsWithContext(ctx, &dynamodb.TransactWriteItemsInput{
+			TransactItems: append(items, deleteGrants...),
+		})
+		if failed, _ := failedTransactionCondition(err, 0); failed {
+			return fmt.Errorf("userStore.LeaveOrganisation: %w", ErrNotMember)
+		}
+		if failed, _ := failedTransactionCondition(err, 1); failed {
+			// The membership changed since it was read for the audit log.
+			continue
+		}
+		if failed, _ := failedTransactionCondition(err, 2); failed {
+			return fmt.Errorf("userStore.LeaveOrganisation: %w", &LastOwnerError{UserID: id, OrganisationIDs: []string{organisationID}})
+		}
+		if err != nil {
+			return err
+		}
+		publishEvents(ctx, store.Client, store.TableName, store.Publisher, removed)
+		return nil
+	}
+	return fmt.Errorf("userStore.LeaveOrganisation: %w", ErrVersionConflict)
+}
+
+// invitationNotAccepted is met if a userOrganisation record doesn't exist, or hasn't been accepted. Pending
+// invitations store acceptedAt as NULL.
+func invitationNotAccepted() expression.ConditionBuilder {
+	return expression.Or(expression.AttributeNotExists(expression.Name("acceptedAt")),
+		expression.AttributeType(expression.Name("acceptedAt"), expression.Null))
 }
 
 // user record.
@@ -217,7 +1023,7 @@ func newUserRecord(user User) userRecord {
 	var ur userRecord
 	ur.ID = newUserRecordHashKey(user.ID)
 	ur.Range = newUserRecordRangeKey()
-	ur.Version = 0
+	ur.Version = user.Version + 1
 	ur.RecordType = userRecordName
 	ur.Email = user.ID
 	ur.FirstName = user.FirstName
@@ -264,7 +1070,7 @@ func newUserOrganisationRecord(u User, org Organisation, invitedAt time.Time, ac
 	record.ID = newUserOrganisationRecordHashKey(u.ID)
 	record.Range = newUserOrganisationRecordRangeKey(org.ID)
 	record.RecordType = userOrgnisationRecordName
-	record.Version = 0
+	record.Version = 1
 	record.Email = u.ID
 	record.OrganisationID = org.ID
 	record.OrganisationName = org.Name
@@ -279,4 +1085,8 @@ type userOrganisationRecord struct {
 	organisationRecordFields
 	InvitedAt  time.Time  `json:"invitedAt"`
 	AcceptedAt *time.Time `json:"acceptedAt"`
+	InvitedBy  string     `json:"invitedBy,omitempty"`
+	// ExpiresAt is stored as Unix seconds, matching the organisationGroupMember record.
+	ExpiresAt int64      `json:"expiresAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
 }
@@ -1,49 +1,70 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
-func TestUserPutIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewUserStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
+// createOrganisation creates an Organisation owned by another user, for users to be invited to.
+func createOrganisation(t *testing.T, r repositories, name string) Organisation {
+	owner := newUser("owner@example.com", "Organisation", "Owner", "447901234567",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	id, err := r.organisations.Create(context.Background(), owner, name)
 	if err != nil {
-		t.Errorf("failed to create store: %v", err)
+		t.Fatalf("failed to create organisation %q: %v", name, err)
+	}
+	putRoles(t, r, id, RoleScopeOrganisation, "otherGroup", "testGroup")
+	return newOrganisation(id, name)
+}
+
+// putRoles defines roles for each of the group names, and returns them in the order that they're listed in
+// OrganisationDetails, if the names are sorted.
+func putRoles(t *testing.T, r repositories, organisationID string, scope RoleScope, names ...string) (roles []Role) {
+	for _, name := range names {
+		role := Role{Name: GroupName(name), Description: name, Scope: scope}
+		err := r.organisations.PutRole(context.Background(), organisationID, role)
+		if err != nil {
+			t.Fatalf("failed to create role %q: %v", name, err)
+		}
+		roles = append(roles, role)
 	}
+	return
+}
+
+func testUserPut(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
 	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
 		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
-	err = s.Put(u)
+	err := s.Put(ctx, u)
 	if err != nil {
 		t.Errorf("failed to create user: %v", err)
 	}
 }
 
-func TestUserGetIntegration(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewUserStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testUserGet(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
 	expected := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
 		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
-	err = s.Put(expected)
+	err := s.Put(ctx, expected)
 	if err != nil {
 		t.Errorf("failed to put user: %v", err)
 	}
-	actual, err := s.Get("test@example.com")
+	expected.Version = 1
+	actual, err := s.Get(ctx, "test@example.com")
 	if err != nil {
 		t.Errorf("failed to get user: %v", err)
 	}
@@ -52,37 +73,29 @@ func TestUserGetIntegration(t *testing.T) {
 	}
 }
 
-func TestUserInviteIgnore(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewUserStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testUserInviteIgnore(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
 	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
 		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
-	err = s.Put(u)
+	err := s.Put(ctx, u)
 	if err != nil {
 		t.Errorf("failed to create user: %v", err)
 	}
 
-	orgA := newOrganisation("orgA", "A")
-	err = s.Invite(u, orgA, []string{"testGroup"}, nil)
+	orgA := createOrganisation(t, r, "A")
+	_, err = s.Invite(ctx, "owner@example.com", u, orgA, []string{"testGroup"}, nil)
 	if err != nil {
 		t.Errorf("failed to invite user to group A: %v", err)
 	}
 
 	// Get the details and ensure that this is reflected.
-	userDetails, err := s.GetDetails("test@example.com")
+	userDetails, err := s.GetDetails(ctx, "test@example.com")
 	if err != nil {
 		t.Errorf("failed to get user details: %v", err)
 	}
 
-	if diff := cmp.Diff(u, userDetails.User); diff != "" {
+	if diff := cmp.Diff(u, userDetails.User, ignoreVersions); diff != "" {
 		t.Errorf("failed to match user:\n%v", diff)
 	}
 	if len(userDetails.Organisations) != 0 {
@@ -91,7 +104,7 @@ func TestUserInviteIgnore(t *testing.T) {
 	if len(userDetails.Invitations) != 1 {
 		t.Errorf("expected 1 invitation, got %d", len(userDetails.Invitations))
 	}
-	if userDetails.Invitations[0].Organisation.ID != "orgA" {
+	if userDetails.Invitations[0].Organisation.ID != orgA.ID {
 		t.Errorf("the invite from orgA has not been accepted or rejected, but got %q", userDetails.Invitations[0].Organisation.ID)
 	}
 	if diff := cmp.Diff(orgA, userDetails.Invitations[0].Organisation); diff != "" {
@@ -99,49 +112,41 @@ func TestUserInviteIgnore(t *testing.T) {
 	}
 }
 
-func TestUserInviteAccept(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewUserStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testUserInviteAccept(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
 	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
 		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
-	err = s.Put(u)
+	err := s.Put(ctx, u)
 	if err != nil {
 		t.Errorf("failed to create user: %v", err)
 	}
 
 	// Invite user to three groups (A, B and C). Ignore A, Accept B, and Reject C.
-	orgA := newOrganisation("orgA", "A")
-	err = s.Invite(u, orgA, []string{"testGroup"}, nil)
+	orgA := createOrganisation(t, r, "A")
+	token, err := s.Invite(ctx, "owner@example.com", u, orgA, []string{"testGroup"}, nil)
 	if err != nil {
 		t.Errorf("failed to invite user to group A: %v", err)
 	}
 
-	err = s.AcceptInvite(u, orgA)
+	err = s.AcceptInvite(ctx, u, orgA, token)
 	if err != nil {
 		t.Errorf("failed to accept invite to orgB: %v", err)
 	}
 
 	// Get the details and ensure that this is reflected.
-	userDetails, err := s.GetDetails("test@example.com")
+	userDetails, err := s.GetDetails(ctx, "test@example.com")
 	if err != nil {
 		t.Errorf("failed to get user details: %v", err)
 	}
 
-	if diff := cmp.Diff(u, userDetails.User); diff != "" {
+	if diff := cmp.Diff(u, userDetails.User, ignoreVersions); diff != "" {
 		t.Errorf("failed to match user:\n%v", diff)
 	}
 	if len(userDetails.Organisations) != 1 {
 		t.Errorf("expected 1 organisation, got %d", len(userDetails.Organisations))
 	}
-	if userDetails.Organisations[0].ID != "orgA" {
+	if userDetails.Organisations[0].ID != orgA.ID {
 		t.Errorf("accepted orgA, but it's showing as %q", userDetails.Organisations[0].ID)
 	}
 	if diff := cmp.Diff(orgA, userDetails.Organisations[0]); diff != "" {
@@ -152,40 +157,32 @@ func TestUserInviteAccept(t *testing.T) {
 	}
 }
 
-func TestUserInviteReject(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test")
-	}
-	name := createLocalTable(t)
-	defer deleteLocalTable(t, name)
-	s, err := NewUserStore(region, name)
-	s.Client.Endpoint = "http://localhost:8000"
-	if err != nil {
-		t.Errorf("failed to create store: %v", err)
-	}
+func testUserInviteReject(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
 	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
 		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
-	err = s.Put(u)
+	err := s.Put(ctx, u)
 	if err != nil {
 		t.Errorf("failed to create user: %v", err)
 	}
 
-	orgA := newOrganisation("orgA", "A")
-	err = s.Invite(u, orgA, []string{"testGroup"}, nil)
+	orgA := createOrganisation(t, r, "A")
+	_, err = s.Invite(ctx, "owner@example.com", u, orgA, []string{"testGroup"}, nil)
 	if err != nil {
 		t.Errorf("failed to invite user to group A: %v", err)
 	}
-	err = s.RejectInvite(u, orgA)
+	err = s.RejectInvite(ctx, u, orgA)
 	if err != nil {
 		t.Errorf("failed to reject invite to orgC: %v", err)
 	}
 
-	userDetails, err := s.GetDetails("test@example.com")
+	userDetails, err := s.GetDetails(ctx, "test@example.com")
 	if err != nil {
 		t.Errorf("failed to get user details: %v", err)
 	}
 
-	if diff := cmp.Diff(u, userDetails.User); diff != "" {
+	if diff := cmp.Diff(u, userDetails.User, ignoreVersions); diff != "" {
 		t.Errorf("failed to match user:\n%v", diff)
 	}
 	if len(userDetails.Organisations) != 0 {
@@ -195,3 +192,866 @@ func TestUserInviteReject(t *testing.T) {
 		t.Errorf("expected no invitations, got %d", len(userDetails.Invitations))
 	}
 }
+
+func testUserContextCancelled(t *testing.T, r repositories) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err == nil {
+		t.Error("expected an error when the context is cancelled, but got nil")
+	}
+	// Check that the user was not stored.
+	_, err = s.Get(context.Background(), u.ID)
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected the user not to be stored, but got error %v", err)
+	}
+}
+
+func testUserGetNotFound(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	_, err := s.Get(ctx, "missing@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	_, err = s.GetDetails(ctx, "missing@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound from GetDetails, got %v", err)
+	}
+}
+
+func testUserAcceptInviteNotFound(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	err = s.AcceptInvite(ctx, u, createOrganisation(t, r, "A"), "token")
+	if !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("expected ErrInvitationNotFound, got %v", err)
+	}
+	userDetails, err := s.GetDetails(ctx, u.ID)
+	if err != nil {
+		t.Errorf("failed to get user details: %v", err)
+	}
+	if len(userDetails.Organisations) != 0 {
+		t.Errorf("expected accepting a missing invitation not to create an organisation, got %d", len(userDetails.Organisations))
+	}
+}
+
+func testUserPutVersionConflict(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+
+	// Creating the user again must fail, because it already exists.
+	err = s.Put(ctx, u)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict when recreating the user, got %v", err)
+	}
+
+	// Two concurrent edits of the same version.
+	a, err := s.Get(ctx, u.ID)
+	if err != nil {
+		t.Errorf("failed to get user: %v", err)
+	}
+	b := a
+	a.FirstName = "A"
+	b.FirstName = "B"
+	err = s.Put(ctx, a)
+	if err != nil {
+		t.Errorf("failed to update user: %v", err)
+	}
+	err = s.Put(ctx, b)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for the stale update, got %v", err)
+	}
+
+	// Only the first edit is stored.
+	actual, err := s.Get(ctx, u.ID)
+	if err != nil {
+		t.Errorf("failed to get user: %v", err)
+	}
+	if actual.FirstName != "A" {
+		t.Errorf("expected first name %q, got %q", "A", actual.FirstName)
+	}
+	if actual.Version != 2 {
+		t.Errorf("expected version 2, got %d", actual.Version)
+	}
+}
+
+func testUserInviteOrganisationNotFound(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	_, err = s.Invite(ctx, "owner@example.com", u, newOrganisation("missing", "Missing"), []string{GroupMember}, nil)
+	if !errors.Is(err, ErrOrganisationNotFound) {
+		t.Errorf("expected ErrOrganisationNotFound, got %v", err)
+	}
+	userDetails, err := s.GetDetails(ctx, u.ID)
+	if err != nil {
+		t.Errorf("failed to get user details: %v", err)
+	}
+	if len(userDetails.Invitations) != 0 {
+		t.Errorf("expected no invitations, got %d", len(userDetails.Invitations))
+	}
+}
+
+func testUserInviteAlreadyAccepted(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	orgA := createOrganisation(t, r, "A")
+	_, err = s.Invite(ctx, "owner@example.com", u, orgA, []string{"testGroup"}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	// Inviting again replaces the pending invitation.
+	token, err := s.Invite(ctx, "owner@example.com", u, orgA, []string{"otherGroup"}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user again: %v", err)
+	}
+	err = s.AcceptInvite(ctx, u, orgA, token)
+	if err != nil {
+		t.Errorf("failed to accept invite: %v", err)
+	}
+	_, err = s.Invite(ctx, "owner@example.com", u, orgA, []string{"testGroup"}, nil)
+	if !errors.Is(err, ErrInvitationAlreadyAccepted) {
+		t.Errorf("expected ErrInvitationAlreadyAccepted when inviting a member, got %v", err)
+	}
+	err = s.RejectInvite(ctx, u, orgA)
+	if !errors.Is(err, ErrInvitationAlreadyAccepted) {
+		t.Errorf("expected ErrInvitationAlreadyAccepted when rejecting an accepted invite, got %v", err)
+	}
+
+	// The membership is unchanged.
+	userDetails, err := s.GetDetails(ctx, u.ID)
+	if err != nil {
+		t.Errorf("failed to get user details: %v", err)
+	}
+	if len(userDetails.Organisations) != 1 {
+		t.Errorf("expected 1 organisation, got %d", len(userDetails.Organisations))
+	}
+	orgDetails, err := r.organisations.GetDetails(ctx, orgA.ID)
+	if err != nil {
+		t.Errorf("failed to get organisation details: %v", err)
+	}
+	if len(orgDetails.Groups["otherGroup"]) != 1 {
+		t.Errorf("expected the user to remain in otherGroup, got %v", orgDetails.Groups)
+	}
+
+	// Users added to groups directly can't be invited either.
+	direct := newUser("direct@example.com", "John", "Connor", "4476123456780",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err = r.organisations.AddUserToOrganisationGroups(ctx, orgA.ID, direct, "testGroup")
+	if err != nil {
+		t.Errorf("failed to add user to group: %v", err)
+	}
+	_, err = s.Invite(ctx, "owner@example.com", direct, orgA, []string{"otherGroup"}, nil)
+	if !errors.Is(err, ErrInvitationAlreadyAccepted) {
+		t.Errorf("expected ErrInvitationAlreadyAccepted when inviting a direct member, got %v", err)
+	}
+	orgDetails, err = r.organisations.GetDetails(ctx, orgA.ID)
+	if err != nil {
+		t.Errorf("failed to get organisation details: %v", err)
+	}
+	if len(orgDetails.Groups["testGroup"]) != 1 || len(orgDetails.Groups["otherGroup"]) != 1 {
+		t.Errorf("expected the direct member to remain in testGroup only, got %v", orgDetails.Groups)
+	}
+}
+
+func testUserRejectInviteNotFound(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	err = s.RejectInvite(ctx, u, createOrganisation(t, r, "A"))
+	if !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("expected ErrInvitationNotFound, got %v", err)
+	}
+}
+
+func testUserUpdateProfile(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	u.Version = 1
+
+	// The user owns one organisation and is invited to another.
+	ownedID, err := r.organisations.Create(ctx, u, "Owned")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	invitedTo := createOrganisation(t, r, "Invited")
+	_, err = s.Invite(ctx, "owner@example.com", u, invitedTo, []string{"testGroup"}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+
+	u.FirstName = "Kyle"
+	u.LastName = "Reese"
+	u.Phone = "447000000000"
+	err = s.UpdateProfile(ctx, u)
+	if err != nil {
+		t.Errorf("failed to update profile: %v", err)
+	}
+	err = s.UpdateProfile(ctx, u)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict for a stale profile update, got %v", err)
+	}
+
+	actual, err := s.Get(ctx, u.ID)
+	if err != nil {
+		t.Errorf("failed to get user: %v", err)
+	}
+	if actual.FirstName != "Kyle" || actual.Version != 2 {
+		t.Errorf("expected user to be updated to version 2, got %+v", actual)
+	}
+	for _, org := range []struct {
+		id    string
+		group GroupName
+	}{
+		{id: ownedID, group: GroupOwner},
+		{id: invitedTo.ID, group: "testGroup"},
+	} {
+		details, err := r.organisations.GetDetails(ctx, org.id, IncludePending())
+		if err != nil {
+			t.Errorf("failed to get organisation details: %v", err)
+		}
+		members := details.Groups[org.group]
+		if len(members) != 1 {
+			t.Fatalf("expected 1 member of %q, got %d", org.group, len(members))
+		}
+		if diff := cmp.Diff(u, members[0], ignoreVersions); diff != "" {
+			t.Errorf("organisation %q has not been updated:\n%v", org.id, diff)
+		}
+	}
+
+	// The user's audit log names the fields that changed, but not their values.
+	page, err := s.AuditLog(ctx, u.ID, 0, "")
+	if err != nil {
+		t.Fatalf("failed to read audit log: %v", err)
+	}
+	var updates []AuditRecord
+	for _, ar := range page.Records {
+		if ar.Action == AuditActionUpdateProfile {
+			updates = append(updates, ar)
+		}
+	}
+	expected := []AuditRecord{
+		{UserID: u.ID, Actor: u.ID, Action: AuditActionUpdateProfile, After: json.RawMessage(`{"fields":["firstName","lastName","phone"]}`)},
+	}
+	if diff := cmp.Diff(expected, updates, cmpopts.IgnoreFields(AuditRecord{}, "ID", "At")); diff != "" {
+		t.Errorf("unexpected profile audit records:\n%v", diff)
+	}
+	page, err = r.organisations.AuditLog(ctx, ownedID, 0, "")
+	if err != nil {
+		t.Fatalf("failed to read organisation audit log: %v", err)
+	}
+	for _, ar := range page.Records {
+		if ar.Action == AuditActionUpdateProfile {
+			t.Errorf("expected the profile update to be kept out of the organisation's audit log, got %+v", ar)
+		}
+	}
+}
+
+func testUserUpdateProfileManyOrganisations(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	u.Version = 1
+
+	// More organisations than fit into a single transaction.
+	var organisationIDs []string
+	for i := 0; i < maxTransactionItems+5; i++ {
+		id, err := r.organisations.Create(ctx, u, "Organisation")
+		if err != nil {
+			t.Fatalf("failed to create organisation: %v", err)
+		}
+		organisationIDs = append(organisationIDs, id)
+	}
+
+	u.FirstName = "Kyle"
+	err = s.UpdateProfile(ctx, u)
+	if err != nil {
+		t.Errorf("failed to update profile: %v", err)
+	}
+	for _, id := range organisationIDs {
+		details, err := r.organisations.GetDetails(ctx, id)
+		if err != nil {
+			t.Errorf("failed to get organisation details: %v", err)
+		}
+		if owner := details.Groups[GroupOwner][0]; owner.FirstName != "Kyle" {
+			t.Errorf("organisation %q has not been updated, got first name %q", id, owner.FirstName)
+		}
+	}
+}
+
+func testUserUpdateProfileRemovedMember(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	u.Version = 1
+	orgA := createOrganisation(t, r, "A")
+	_, err = s.Invite(ctx, "owner@example.com", u, orgA, []string{"testGroup"}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user: %v", err)
+	}
+	err = r.organisations.RemoveUser(ctx, orgA.ID, u.ID)
+	if err != nil {
+		t.Errorf("failed to remove user: %v", err)
+	}
+
+	// The update must not recreate the removed membership.
+	u.FirstName = "Kyle"
+	err = s.UpdateProfile(ctx, u)
+	if err != nil {
+		t.Errorf("failed to update profile: %v", err)
+	}
+	details, err := r.organisations.GetDetails(ctx, orgA.ID)
+	if err != nil {
+		t.Errorf("failed to get organisation details: %v", err)
+	}
+	if len(details.Groups["testGroup"]) != 0 {
+		t.Errorf("expected the removed member not to be recreated, got %v", details.Groups)
+	}
+}
+
+func testUserSyncProfile(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	organisationID, err := r.organisations.Create(ctx, u, "Organisation")
+	if err != nil {
+		t.Errorf("failed to create organisation: %v", err)
+	}
+	err = r.organisations.UpdateUserDetails(ctx, organisationID, u.ID, "Out", "Of", "Date", 1)
+	if err != nil {
+		t.Errorf("failed to update user details: %v", err)
+	}
+
+	err = s.SyncProfile(ctx, u.ID)
+	if err != nil {
+		t.Errorf("failed to sync profile: %v", err)
+	}
+	details, err := r.organisations.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation details: %v", err)
+	}
+	if diff := cmp.Diff(u, details.Groups[GroupOwner][0], ignoreVersions); diff != "" {
+		t.Errorf("organisation has not been updated:\n%v", diff)
+	}
+
+	err = s.SyncProfile(ctx, "missing@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func testUserDelete(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	orgA := createOrganisation(t, r, "A")
+	token, err := s.Invite(ctx, "owner@example.com", u, orgA, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user to orgA: %v", err)
+	}
+	err = s.AcceptInvite(ctx, u, orgA, token)
+	if err != nil {
+		t.Errorf("failed to accept invite to orgA: %v", err)
+	}
+	orgB := createOrganisation(t, r, "B")
+	_, err = s.Invite(ctx, "owner@example.com", u, orgB, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user to orgB: %v", err)
+	}
+	// The user's changes are also in the audit logs of the Organisation and the User they changed.
+	// The event of the user's invitation can't be published yet, so it waits in the outbox.
+	var recorder eventRecorder
+	r.publisher.Subscribe(recorder.handle)
+	recorder.setFail(EventTypeMemberInvited)
+	other := newUser("other@example.com", "Other", "User", "4476123456789", u.CreatedAt)
+	_, err = s.Invite(WithActor(ctx, u.ID), u.ID, other, orgA, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite other user to orgA: %v", err)
+	}
+	recorder.setFail()
+	// The lists of owners recorded by ownership transfers include the user.
+	owner := newUser("owner@example.com", "Organisation", "Owner", "447901234567", u.CreatedAt)
+	err = r.organisations.TransferOwnership(ctx, orgA.ID, owner.ID, u)
+	if err != nil {
+		t.Errorf("failed to transfer ownership to user: %v", err)
+	}
+	err = r.organisations.TransferOwnership(ctx, orgA.ID, u.ID, owner)
+	if err != nil {
+		t.Errorf("failed to transfer ownership back to owner: %v", err)
+	}
+
+	report, err := s.Delete(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	// The user's audit log is deleted.
+	var auditKeys int
+	for i := 0; i < len(report.Deleted); i++ {
+		if report.Deleted[i].ID == newUserAuditRecordHashKey(u.ID) {
+			report.Deleted = append(report.Deleted[:i], report.Deleted[i+1:]...)
+			auditKeys++
+			i--
+		}
+	}
+	if auditKeys == 0 {
+		t.Error("expected the user's audit log to be deleted")
+	}
+	expected := ErasureReport{
+		UserID:          u.ID,
+		OrganisationIDs: []string{orgA.ID, orgB.ID},
+		Deleted: []RecordKey{
+			{ID: newOrganisationMemberRecordHashKey(orgA.ID), Range: newOrganisationMemberRecordRangeKey(u.ID)},
+			{ID: newOrganisationMemberRecordHashKey(orgB.ID), Range: newOrganisationMemberRecordRangeKey(u.ID)},
+			{ID: newUserOrganisationRecordHashKey(u.ID), Range: newUserOrganisationRecordRangeKey(orgA.ID)},
+			{ID: newUserOrganisationRecordHashKey(u.ID), Range: newUserOrganisationRecordRangeKey(orgB.ID)},
+			{ID: newUserRecordHashKey(u.ID), Range: newUserRecordRangeKey()},
+		},
+	}
+	sortStrings := cmpopts.SortSlices(func(a, b string) bool { return a < b })
+	sortKeys := cmpopts.SortSlices(func(a, b RecordKey) bool { return a.ID+a.Range < b.ID+b.Range })
+	if diff := cmp.Diff(expected, report, sortStrings, sortKeys); diff != "" {
+		t.Error(diff)
+	}
+	if last := report.Deleted[len(report.Deleted)-1]; last.Range != newUserRecordRangeKey() {
+		t.Errorf("expected the user record to be deleted last, got %+v", last)
+	}
+
+	_, err = s.GetDetails(ctx, u.ID)
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+	for _, org := range []Organisation{orgA, orgB} {
+		details, err := r.organisations.GetDetails(ctx, org.ID)
+		if err != nil {
+			t.Fatalf("failed to get organisation: %v", err)
+		}
+		for group, users := range details.Groups {
+			for _, member := range users {
+				if member.ID == u.ID {
+					t.Errorf("expected the user to be removed from group %q of %q", group, org.Name)
+				}
+			}
+		}
+	}
+
+	// The events that were waiting are published without the user's ID.
+	recorder.take()
+	_, err = r.organisations.PublishOutbox(ctx)
+	if err != nil {
+		t.Fatalf("failed to publish outbox: %v", err)
+	}
+	var invitedBy []string
+	for _, e := range recorder.take() {
+		if invited, ok := e.(MemberInvited); ok && invited.UserID == other.ID {
+			invitedBy = append(invitedBy, invited.InvitedBy)
+		}
+	}
+	if len(invitedBy) != 1 || !strings.HasPrefix(invitedBy[0], "erased/") {
+		t.Errorf("expected the other user's invitation event to record a pseudonym, got %v", invitedBy)
+	}
+
+	// No record contains the user's ID, including the audit records' states and the invitations they sent.
+	items, err := r.records()
+	if err != nil {
+		t.Fatalf("failed to read records: %v", err)
+	}
+	for _, item := range items {
+		var fields map[string]interface{}
+		err = dynamodbattribute.UnmarshalMap(item, &fields)
+		if err != nil {
+			t.Fatalf("failed to convert record: %v", err)
+		}
+		for name, value := range fields {
+			if strings.Contains(fmt.Sprint(value), u.ID) {
+				t.Errorf("expected the user's ID to be erased, but found it in %q of %v/%v", name, fields["id"], fields["rng"])
+			}
+		}
+	}
+	orgDetails, err := r.organisations.GetDetails(ctx, orgA.ID)
+	if err != nil || len(orgDetails.Invitees) != 1 || !strings.HasPrefix(orgDetails.Invitees[0].InvitedBy, "erased/") {
+		t.Errorf("expected the other user's invitation to record a pseudonym, got %+v, %v", orgDetails.Invitees, err)
+	}
+	otherDetails, err := s.GetDetails(ctx, other.ID)
+	if err != nil || len(otherDetails.Invitations) != 1 || otherDetails.Invitations[0].InvitedBy != orgDetails.Invitees[0].InvitedBy {
+		t.Errorf("expected the other user's record of the invitation to record the same pseudonym, got %+v, %v", otherDetails.Invitations, err)
+	}
+}
+
+func testUserDeleteLastOwner(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	org := createOrganisation(t, r, "A")
+	owner := newUser("owner@example.com", "Organisation", "Owner", "447901234567",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+
+	_, err := s.Delete(ctx, owner.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Fatalf("expected ErrLastOwner, got %v", err)
+	}
+	var lastOwnerErr *LastOwnerError
+	if !errors.As(err, &lastOwnerErr) || len(lastOwnerErr.OrganisationIDs) != 1 || lastOwnerErr.OrganisationIDs[0] != org.ID {
+		t.Errorf("expected the error to list the organisation, got %v", err)
+	}
+	details, err := s.GetDetails(ctx, owner.ID)
+	if err != nil || len(details.Organisations) != 1 {
+		t.Errorf("expected nothing to be deleted, got %+v, %v", details, err)
+	}
+
+	// Once there's another owner, the user can be erased.
+	other := newUser("other@example.com", "Other", "Owner", "447901234567", time.Time{})
+	err = r.organisations.AddUserToOrganisationGroups(ctx, org.ID, other, GroupOwner)
+	if err != nil {
+		t.Errorf("failed to add owner: %v", err)
+	}
+	_, err = s.Delete(ctx, owner.ID)
+	if err != nil {
+		t.Errorf("failed to delete user: %v", err)
+	}
+
+	// Users added to groups directly are found too, so the new owner can't be erased, but other members can.
+	_, err = s.Delete(ctx, other.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner for a directly added owner, got %v", err)
+	}
+	member := newUser("member@example.com", "Direct", "Member", "447901234567", time.Time{})
+	err = s.Put(ctx, member)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	err = r.organisations.AddUserToOrganisationGroups(ctx, org.ID, member, GroupMember)
+	if err != nil {
+		t.Errorf("failed to add member: %v", err)
+	}
+	report, err := s.Delete(ctx, member.ID)
+	if err != nil {
+		t.Errorf("failed to delete user: %v", err)
+	}
+	if diff := cmp.Diff([]string{org.ID}, report.OrganisationIDs); diff != "" {
+		t.Errorf("expected the membership to be erased:\n%v", diff)
+	}
+	orgDetails, err := r.organisations.GetDetails(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("failed to get organisation details: %v", err)
+	}
+	if len(orgDetails.Groups[GroupMember]) != 0 {
+		t.Errorf("expected the member's details to be erased, got %+v", orgDetails.Groups[GroupMember])
+	}
+}
+
+func testUserDeleteNotFound(t *testing.T, r repositories) {
+	_, err := r.users.Delete(context.Background(), "missing@example.com")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func testUserLeaveOrganisation(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err := s.Put(ctx, u)
+	if err != nil {
+		t.Errorf("failed to create user: %v", err)
+	}
+	orgA := createOrganisation(t, r, "A")
+	token, err := s.Invite(ctx, "owner@example.com", u, orgA, []string{GroupMember}, nil)
+	if err != nil {
+		t.Errorf("failed to invite user to orgA: %v", err)
+	}
+	err = s.AcceptInvite(ctx, u, orgA, token)
+	if err != nil {
+		t.Errorf("failed to accept invite to orgA: %v", err)
+	}
+
+	err = s.LeaveOrganisation(ctx, u.ID, orgA.ID)
+	if err != nil {
+		t.Fatalf("failed to leave organisation: %v", err)
+	}
+	userDetails, err := s.GetDetails(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("failed to get user details: %v", err)
+	}
+	if len(userDetails.Organisations) != 0 {
+		t.Errorf("expected 0 organisations, got %d", len(userDetails.Organisations))
+	}
+	orgDetails, err := r.organisations.GetDetails(ctx, orgA.ID)
+	if err != nil {
+		t.Fatalf("failed to get organisation details: %v", err)
+	}
+	if members := orgDetails.Groups[GroupMember]; len(members) != 0 {
+		t.Errorf("expected the membership to be deleted, got %v", members)
+	}
+
+	err = s.LeaveOrganisation(ctx, u.ID, orgA.ID)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected ErrNotMember when leaving again, got %v", err)
+	}
+	err = s.LeaveOrganisation(ctx, "owner@example.com", orgA.ID)
+	if !errors.Is(err, ErrLastOwner) {
+		t.Errorf("expected ErrLastOwner when the last owner leaves, got %v", err)
+	}
+}
+
+func testUserAcceptInviteToken(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	orgA := createOrganisation(t, r, "A")
+	token, err := s.Invite(ctx, "owner@example.com", u, orgA, []string{GroupMember}, nil)
+	if err != nil {
+		t.Fatalf("failed to invite user: %v", err)
+	}
+	if token == "" {
+		t.Fatal("expected a token")
+	}
+
+	details, err := s.GetDetails(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("failed to get user details: %v", err)
+	}
+	invitation := details.Invitations[0]
+	if invitation.InvitedBy != "owner@example.com" || invitation.UserID != u.ID {
+		t.Errorf("expected the invitation to record who invited whom, got %+v", invitation)
+	}
+	if !invitation.ExpiresAt.After(invitation.InvitedAt) {
+		t.Errorf("expected the invitation to expire after it was sent, got %+v", invitation)
+	}
+
+	err = s.AcceptInvite(ctx, u, orgA, "wrong")
+	if !errors.Is(err, ErrInvalidInvitationToken) {
+		t.Errorf("expected ErrInvalidInvitationToken, got %v", err)
+	}
+	err = s.AcceptInvite(ctx, u, orgA, token)
+	if err != nil {
+		t.Errorf("failed to accept invite: %v", err)
+	}
+	details, err = s.GetDetails(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("failed to get user details: %v", err)
+	}
+	if len(details.Organisations) != 1 || len(details.Invitations) != 0 {
+		t.Fatalf("expected the invitation to be accepted, got %+v", details)
+	}
+	err = s.AcceptInvite(ctx, u, orgA, token)
+	if !errors.Is(err, ErrInvitationAlreadyAccepted) {
+		t.Errorf("expected the token to be single use, got %v", err)
+	}
+}
+
+// putEarlierMembership puts the records of a membership in the shape written by earlier versions, which recorded
+// invitations only against the User, and didn't record direct members against the User at all.
+func putEarlierMembership(t *testing.T, r repositories, u User, org Organisation, invitedAt time.Time, acceptedAt *time.Time, direct bool) {
+	records := []interface{}{newOrganisationMemberRecord(org, nil, nil, u)}
+	if !direct {
+		records = append(records, newUserOrganisationRecord(u, org, invitedAt, acceptedAt))
+	}
+	for _, record := range records {
+		item, err := dynamodbattribute.ConvertToMap(record)
+		if err != nil {
+			t.Fatalf("failed to convert record: %v", err)
+		}
+		err = r.putRecord(item)
+		if err != nil {
+			t.Fatalf("failed to put record: %v", err)
+		}
+	}
+}
+
+func testUserMigrateMemberships(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.users
+	org := createOrganisation(t, r, "A")
+	invitedAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	acceptedAt := invitedAt.Add(time.Hour)
+	pending := newUser("pending@example.com", "Pending", "Invitee", "4476123456789", invitedAt)
+	accepted := newUser("accepted@example.com", "Accepted", "Invitee", "4476123456789", invitedAt)
+	direct := newUser("direct@example.com", "Direct", "Member", "4476123456789", invitedAt)
+	putEarlierMembership(t, r, pending, org, invitedAt, nil, false)
+	putEarlierMembership(t, r, accepted, org, invitedAt, &acceptedAt, false)
+	putEarlierMembership(t, r, direct, org, invitedAt, nil, true)
+
+	migrated, err := s.MigrateMemberships(ctx)
+	if err != nil {
+		t.Fatalf("failed to migrate memberships: %v", err)
+	}
+	if migrated < 3 {
+		t.Errorf("expected at least 3 memberships to be migrated, got %d", migrated)
+	}
+	migrated, err = s.MigrateMemberships(ctx)
+	if err != nil || migrated != 0 {
+		t.Errorf("expected migrating again to change nothing, got %d, %v", migrated, err)
+	}
+
+	// The pending invitation is still pending, but must be resent before it can be accepted.
+	_, _, err = r.organisations.GetUserGroups(ctx, org.ID, pending.ID)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected the invitee not to be a member, got %v", err)
+	}
+	invitations, err := r.organisations.ListInvitations(ctx, org.ID)
+	if err != nil {
+		t.Fatalf("failed to list invitations: %v", err)
+	}
+	if len(invitations) != 1 || invitations[0].UserID != pending.ID || !invitations[0].InvitedAt.Equal(invitedAt) {
+		t.Errorf("expected the pending invitation to be listed, got %+v", invitations)
+	}
+	err = s.AcceptInvite(ctx, pending, org, "token")
+	if !errors.Is(err, ErrInvitationExpired) {
+		t.Errorf("expected ErrInvitationExpired for a migrated invitation, got %v", err)
+	}
+	token, err := r.organisations.ResendInvite(ctx, org.ID, pending.ID)
+	if err != nil {
+		t.Fatalf("failed to resend invitation: %v", err)
+	}
+	err = s.AcceptInvite(ctx, pending, org, token)
+	if err != nil {
+		t.Errorf("failed to accept resent invitation: %v", err)
+	}
+
+	// Accepted invitations and direct members are still members.
+	for _, u := range []User{accepted, direct} {
+		_, _, err = r.organisations.GetUserGroups(ctx, org.ID, u.ID)
+		if err != nil {
+			t.Errorf("expected %q to be a member, got %v", u.ID, err)
+		}
+	}
+	err = s.AcceptInvite(ctx, accepted, org, "token")
+	if !errors.Is(err, ErrInvitationAlreadyAccepted) {
+		t.Errorf("expected ErrInvitationAlreadyAccepted, got %v", err)
+	}
+
+	// Direct members are found when they're erased.
+	report, err := s.Delete(ctx, direct.ID)
+	if err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+	if diff := cmp.Diff([]string{org.ID}, report.OrganisationIDs); diff != "" {
+		t.Errorf("expected the direct membership to be erased:\n%v", diff)
+	}
+	_, _, err = r.organisations.GetUserGroups(ctx, org.ID, direct.ID)
+	if !errors.Is(err, ErrNotMember) {
+		t.Errorf("expected the direct member to be removed, got %v", err)
+	}
+}
+
+// syncFailureClient returns many organisations, and fails to update the membership of one of them.
+type syncFailureClient struct {
+	dynamodbiface.DynamoDBAPI
+	organisationIDs []string
+	failID          string
+	updated         []string
+}
+
+func (c *syncFailureClient) QueryPagesWithContext(ctx context.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error {
+	var page dynamodb.QueryOutput
+	for _, id := range c.organisationIDs {
+		item, err := dynamodbattribute.MarshalMap(newUserOrganisationRecord(User{ID: "test@example.com"}, newOrganisation(id, id), time.Time{}, nil))
+		if err != nil {
+			return err
+		}
+		page.Items = append(page.Items, item)
+	}
+	fn(&page, true)
+	return nil
+}
+
+func (c *syncFailureClient) GetItemWithContext(ctx context.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (c *syncFailureClient) TransactWriteItemsWithContext(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func (c *syncFailureClient) UpdateItemWithContext(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	id := strings.TrimPrefix(*input.Key["id"].S, "organisation/")
+	if id == c.failID {
+		return nil, errors.New("throttled")
+	}
+	c.updated = append(c.updated, id)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestUserStoreUpdateProfileSyncError(t *testing.T) {
+	client := &syncFailureClient{failID: "org3"}
+	for i := 0; i < maxTransactionItems; i++ {
+		client.organisationIDs = append(client.organisationIDs, fmt.Sprintf("org%d", i))
+	}
+	s, err := NewUserStore(region, "table", WithClient(client))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	u := newUser("test@example.com", "Sarah", "Connor", "4476123456789",
+		time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC))
+	err = s.UpdateProfile(context.Background(), u)
+
+	var syncErr *ProfileSyncError
+	if !errors.As(err, &syncErr) {
+		t.Fatalf("expected a *ProfileSyncError, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"org3"}, syncErr.OrganisationIDs); diff != "" {
+		t.Error(diff)
+	}
+	if len(client.updated) != maxTransactionItems-1 {
+		t.Errorf("expected the other organisations to be updated, got %v", client.updated)
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// webhookDeliveryRetention is how long delivery attempts are kept for, if the table's time to live is enabled on
+// the ttl attribute.
+const webhookDeliveryRetention = 30 * 24 * time.Hour
+
+// validateWebhook returns ErrInvalidWebhook if the Webhook can't be delivered to.
+func validateWebhook(w Webhook) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http or https URL: %w", w.URL, ErrInvalidWebhook)
+	}
+	if w.Secret == "" {
+		return fmt.Errorf("secret must be non-empty: %w", ErrInvalidWebhook)
+	}
+	for _, t := range w.Events {
+		if !isEventType(t) {
+			return fmt.Errorf("unknown event type %q: %w", t, ErrInvalidWebhook)
+		}
+	}
+	return nil
+}
+
+func newWebhooksFromRecords(items []map[string]*dynamodb.AttributeValue) (webhooks []Webhook, err error) {
+	for _, item := range items {
+		var owr organisationWebhookRecord
+		err = dynamodbattribute.UnmarshalMap(item, &owr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert organisationWebhookRecord: %w", err)
+			return
+		}
+		if owr.RecordType != organisationWebhookRecordName {
+			continue
+		}
+		webhooks = append(webhooks, newWebhookFromRecord(owr))
+	}
+	return
+}
+
+func newWebhookFromRecord(owr organisationWebhookRecord) Webhook {
+	w := Webhook{
+		ID:      owr.WebhookID,
+		URL:     owr.URL,
+		Secret:  owr.Secret,
+		Version: owr.Version,
+	}
+	for _, t := range owr.Events {
+		w.Events = append(w.Events, EventType(t))
+	}
+	return w
+}
+
+func newWebhookDeliveriesFromRecords(items []map[string]*dynamodb.AttributeValue) (deliveries []WebhookDelivery, err error) {
+	for _, item := range items {
+		var wdr webhookDeliveryRecord
+		err = dynamodbattribute.UnmarshalMap(item, &wdr)
+		if err != nil {
+			err = fmt.Errorf("failed to convert webhookDeliveryRecord: %w", err)
+			return
+		}
+		deliveries = append(deliveries, WebhookDelivery{
+			WebhookID:  wdr.WebhookID,
+			EventID:    wdr.EventID,
+			EventType:  EventType(wdr.EventType),
+			Attempt:    wdr.Attempt,
+			At:         wdr.At,
+			StatusCode: wdr.StatusCode,
+			Error:      wdr.Error,
+			Delivered:  wdr.Delivered,
+		})
+	}
+	return
+}
+
+// organisation webhook record.
+const organisationWebhookRecordName = "organisationWebhook"
+
+func newOrganisationWebhookRecordHashKey(organisationID string) string {
+	return newOrganisationRecordHashKey(organisationID)
+}
+
+func newOrganisationWebhookRecordRangeKey(webhookID string) string {
+	return organisationWebhookRecordName + "/" + webhookID
+}
+
+func newOrganisationWebhookRecord(organisationID string, w Webhook) organisationWebhookRecord {
+	var record organisationWebhookRecord
+	record.ID = newOrganisationWebhookRecordHashKey(organisationID)
+	record.Range = newOrganisationWebhookRecordRangeKey(w.ID)
+	record.RecordType = organisationWebhookRecordName
+	record.Version = 1
+	record.OrganisationID = organisationID
+	record.WebhookID = w.ID
+	record.URL = w.URL
+	record.Secret = w.Secret
+	for _, t := range w.Events {
+		record.Events = append(record.Events, string(t))
+	}
+	return record
+}
+
+type organisationWebhookRecord struct {
+	record
+	OrganisationID string   `json:"organisationId"`
+	WebhookID      string   `json:"webhookId"`
+	URL            string   `json:"url"`
+	Secret         string   `json:"secret"`
+	Events         []string `json:"events,omitempty"`
+}
+
+// webhook delivery record. Deliveries are kept in their own partition for each Webhook, so that they don't need to
+// be read with the Organisation.
+const webhookDeliveryRecordName = "webhookDelivery"
+
+func newWebhookDeliveryRecordHashKey(organisationID, webhookID string) string {
+	return webhookDeliveryRecordName + "/" + organisationID + "/" + webhookID
+}
+
+func newWebhookDeliveryRecordRangeKey(d WebhookDelivery) string {
+	return webhookDeliveryRecordName + "/" + sortableTime(d.At) + "/" + d.EventID + "/" + fmt.Sprintf("%03d", d.Attempt)
+}
+
+func newWebhookDeliveryRecord(organisationID string, d WebhookDelivery) webhookDeliveryRecord {
+	var record webhookDeliveryRecord
+	record.ID = newWebhookDeliveryRecordHashKey(organisationID, d.WebhookID)
+	record.Range = newWebhookDeliveryRecordRangeKey(d)
+	record.RecordType = webhookDeliveryRecordName
+	record.Version = 1
+	record.OrganisationID = organisationID
+	record.WebhookID = d.WebhookID
+	record.EventID = d.EventID
+	record.EventType = string(d.EventType)
+	record.Attempt = d.Attempt
+	record.At = d.At
+	record.StatusCode = d.StatusCode
+	record.Error = d.Error
+	record.Delivered = d.Delivered
+	record.TTL = d.At.Add(webhookDeliveryRetention).Unix()
+	return record
+}
+
+type webhookDeliveryRecord struct {
+	record
+	OrganisationID string    `json:"organisationId"`
+	WebhookID      string    `json:"webhookId"`
+	EventID        string    `json:"eventId"`
+	EventType      string    `json:"eventType"`
+	Attempt        int       `json:"attempt"`
+	At             time.Time `json:"at"`
+	StatusCode     int       `json:"statusCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Delivered      bool      `json:"delivered"`
+	// TTL is the Unix time after which the delivery can be deleted by the table's time to live.
+	TTL int64 `json:"ttl"`
+}
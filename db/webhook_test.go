@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func testOrganisationWebhooks(t *testing.T, r repositories) {
+	ctx := context.Background()
+	s := r.organisations
+	createdAt := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	owner := newUser("test@example.com", "First", "Last", "447901234567", createdAt)
+	organisationID, err := s.Create(ctx, owner, "Organisation Name")
+	if err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+	membership := Webhook{
+		URL:    "https://example.com/membership",
+		Secret: "secret",
+		Events: []EventType{EventTypeInvitationAccepted, EventTypeMemberRemoved},
+	}
+	membership.ID, err = s.CreateWebhook(ctx, organisationID, membership)
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	membership.Version = 1
+	err = s.PutWebhook(ctx, organisationID, Webhook{ID: membership.ID, URL: "https://example.com", Secret: "secret"})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict when creating the webhook again, got %v", err)
+	}
+	membership.URL = "https://example.com/members"
+	err = s.PutWebhook(ctx, organisationID, membership)
+	if err != nil {
+		t.Fatalf("failed to update webhook: %v", err)
+	}
+	membership.Version = 2
+	all := Webhook{
+		URL:    "http://localhost:8080",
+		Secret: "another secret",
+	}
+	all.ID, err = s.CreateWebhook(ctx, organisationID, all)
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	all.Version = 1
+	for _, invalid := range []Webhook{
+		{URL: "example.com", Secret: "secret"},
+		{URL: "ftp://example.com", Secret: "secret"},
+		{URL: "https://example.com"},
+		{URL: "https://example.com", Secret: "secret", Events: []EventType{"UserCreated"}},
+	} {
+		_, err = s.CreateWebhook(ctx, organisationID, invalid)
+		if !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("expected ErrInvalidWebhook for webhook %+v, got %v", invalid, err)
+		}
+	}
+
+	webhooks, err := s.ListWebhooks(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to list webhooks: %v", err)
+	}
+	expected := map[string]Webhook{membership.ID: membership, all.ID: all}
+	actual := make(map[string]Webhook)
+	for _, w := range webhooks {
+		actual[w.ID] = w
+	}
+	if diff := cmp.Diff(expected, actual); diff != "" {
+		t.Errorf("unexpected webhooks:\n%v", diff)
+	}
+	if membership.Accepts(EventTypeGroupsChanged) || !membership.Accepts(EventTypeMemberRemoved) || !all.Accepts(EventTypeGroupsChanged) {
+		t.Errorf("webhooks didn't filter events")
+	}
+
+	// Webhooks don't affect the Organisation's details.
+	_, err = s.GetDetails(ctx, organisationID)
+	if err != nil {
+		t.Errorf("failed to get organisation details: %v", err)
+	}
+
+	// Deliveries are listed in the order they were attempted.
+	deliveries := []WebhookDelivery{
+		{WebhookID: membership.ID, EventID: "b", EventType: EventTypeMemberRemoved, Attempt: 1, At: createdAt, StatusCode: 500, Error: "500 Internal Server Error"},
+		{WebhookID: membership.ID, EventID: "b", EventType: EventTypeMemberRemoved, Attempt: 2, At: createdAt.Add(time.Second), StatusCode: 200, Delivered: true},
+		{WebhookID: membership.ID, EventID: "a", EventType: EventTypeInvitationAccepted, Attempt: 1, At: createdAt.Add(time.Minute), Error: "connection refused"},
+	}
+	for _, d := range deliveries {
+		err = s.PutWebhookDelivery(ctx, organisationID, d)
+		if err != nil {
+			t.Fatalf("failed to record delivery: %v", err)
+		}
+	}
+	actualDeliveries, err := s.ListWebhookDeliveries(ctx, organisationID, membership.ID)
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	if diff := cmp.Diff(deliveries, actualDeliveries); diff != "" {
+		t.Errorf("unexpected deliveries:\n%v", diff)
+	}
+	actualDeliveries, err = s.ListWebhookDeliveries(ctx, organisationID, all.ID)
+	if err != nil || len(actualDeliveries) != 0 {
+		t.Errorf("expected no deliveries, got %v, %v", actualDeliveries, err)
+	}
+
+	err = s.DeleteWebhook(ctx, organisationID, membership.ID)
+	if err != nil {
+		t.Fatalf("failed to delete webhook: %v", err)
+	}
+	err = s.DeleteWebhook(ctx, organisationID, membership.ID)
+	if !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("expected ErrWebhookNotFound deleting the webhook again, got %v", err)
+	}
+	webhooks, err = s.ListWebhooks(ctx, organisationID)
+	if err != nil {
+		t.Fatalf("failed to list webhooks: %v", err)
+	}
+	if diff := cmp.Diff([]Webhook{all}, webhooks); diff != "" {
+		t.Errorf("unexpected webhooks after delete:\n%v", diff)
+	}
+}
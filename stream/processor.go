@@ -0,0 +1,70 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/a-h/organisation/db"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// NewProcessor creates a Processor that publishes the Events of the records read from the source.
+func NewProcessor(source Source, publisher db.Publisher) Processor {
+	return Processor{
+		Source:    source,
+		Publisher: publisher,
+	}
+}
+
+// Processor decodes stream records into db.Events, and publishes them.
+type Processor struct {
+	Source    Source
+	Publisher db.Publisher
+}
+
+// Run processes records until the Source has no more records, or an error occurs. If the Source is a Committer,
+// each batch of records is committed once its Events have been published. If the Events of a batch of records
+// can't be published, the error is returned without committing the batch, and the Source is rewound, so that the
+// batch is read again when Run is called again, or reading resumes.
+func (p Processor) Run(ctx context.Context) error {
+	for {
+		records, err := p.Source.Read(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("processor.Run: failed to read records: %w", err)
+		}
+		if err = p.Process(ctx, records...); err != nil {
+			if c, ok := p.Source.(Committer); ok {
+				c.Rewind()
+			}
+			return err
+		}
+		if c, ok := p.Source.(Committer); ok {
+			if err = c.Commit(ctx, records); err != nil {
+				return fmt.Errorf("processor.Run: failed to commit records: %w", err)
+			}
+		}
+	}
+}
+
+// Process decodes the records, and publishes their Events, e.g. when the records are passed to a Lambda function.
+func (p Processor) Process(ctx context.Context, records ...*dynamodbstreams.Record) error {
+	var events []db.Event
+	for _, r := range records {
+		re, err := db.NewEventsFromStreamRecord(r)
+		if err != nil {
+			return fmt.Errorf("processor.Process: %w", err)
+		}
+		events = append(events, re...)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	if err := p.Publisher.Publish(ctx, events...); err != nil {
+		return fmt.Errorf("processor.Process: failed to publish events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/a-h/organisation/db"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/google/go-cmp/cmp"
+)
+
+var at = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func newRecord(eventID, eventName string, old, new map[string]*dynamodb.AttributeValue) *dynamodbstreams.Record {
+	return &dynamodbstreams.Record{
+		EventID:   aws.String(eventID),
+		EventName: aws.String(eventName),
+		Dynamodb: &dynamodbstreams.StreamRecord{
+			ApproximateCreationDateTime: aws.Time(at),
+			SequenceNumber:              aws.String(eventID),
+			OldImage:                    old,
+			NewImage:                    new,
+		},
+	}
+}
+
+func newMemberImage(groups ...string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"id":             {S: aws.String("organisation/org")},
+		"rng":            {S: aws.String("organisationGroupMember/user@example.com")},
+		"typ":            {S: aws.String("organisationGroupMember")},
+		"v":              {N: aws.String("1")},
+		"organisationId": {S: aws.String("org")},
+		"email":          {S: aws.String("user@example.com")},
+		"groups":         {SS: aws.StringSlice(groups)},
+	}
+}
+
+var records = []*dynamodbstreams.Record{
+	newRecord("1", dynamodbstreams.OperationTypeInsert, nil, newMemberImage("organisationGroup/member")),
+	// Changes that don't affect the groups don't result in events.
+	newRecord("2", dynamodbstreams.OperationTypeModify, newMemberImage("organisationGroup/member"), newMemberImage("organisationGroup/member")),
+	newRecord("3", dynamodbstreams.OperationTypeModify, newMemberImage("organisationGroup/member"),
+		newMemberImage("organisationGroup/member", "serviceGroup/service/deployer")),
+	newRecord("4", dynamodbstreams.OperationTypeRemove, newMemberImage("organisationGroup/member", "serviceGroup/service/deployer"), nil),
+}
+
+func newMetadata(id string) db.EventMetadata {
+	return db.EventMetadata{ID: id, OrganisationID: "org", At: at}
+}
+
+var expected = []db.Event{
+	db.GroupsChanged{EventMetadata: newMetadata("1/0"), UserID: "user@example.com", AddedGroups: []string{db.GroupMember}},
+	db.GroupsChanged{EventMetadata: newMetadata("3/0"), UserID: "user@example.com",
+		AddedServiceGroups: map[string][]string{"service": {db.ServiceGroupDeployer}}},
+	db.MemberRemoved{EventMetadata: newMetadata("4/0"), UserID: "user@example.com"},
+}
+
+func TestProcessorRun(t *testing.T) {
+	var file bytes.Buffer
+	if err := NewFileWriter(&file).Write(records...); err != nil {
+		t.Fatalf("failed to write records: %v", err)
+	}
+	source := NewFileSource(&file)
+	source.BatchSize = 3
+
+	var events []db.Event
+	var batches int
+	publisher := db.PublisherFunc(func(ctx context.Context, e ...db.Event) error {
+		events = append(events, e...)
+		batches++
+		return nil
+	})
+	err := NewProcessor(source, publisher).Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Error(diff)
+	}
+	if batches != 2 {
+		t.Errorf("expected events to be published in 2 batches, got %d", batches)
+	}
+}
+
+func TestProcessorRunPublishError(t *testing.T) {
+	var file bytes.Buffer
+	if err := NewFileWriter(&file).Write(records...); err != nil {
+		t.Fatalf("failed to write records: %v", err)
+	}
+	errUnavailable := errors.New("unavailable")
+	publisher := db.PublisherFunc(func(ctx context.Context, e ...db.Event) error {
+		return errUnavailable
+	})
+	err := NewProcessor(NewFileSource(&file), publisher).Run(context.Background())
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("expected the publisher's error, got %v", err)
+	}
+}
+
+func TestProcessorProcessWithoutImages(t *testing.T) {
+	publisher := db.PublisherFunc(func(ctx context.Context, e ...db.Event) error {
+		t.Errorf("unexpected events: %v", e)
+		return nil
+	})
+	keysOnly := newRecord("1", dynamodbstreams.OperationTypeInsert, nil, nil)
+	err := NewProcessor(nil, publisher).Process(context.Background(), keysOnly)
+	if !errors.Is(err, db.ErrStreamImagesMissing) {
+		t.Errorf("expected ErrStreamImagesMissing, got %v", err)
+	}
+}
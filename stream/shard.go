@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+)
+
+// defaultPollInterval is how long a ShardSource waits before reading again when there were no new records.
+const defaultPollInterval = time.Second
+
+// NewShardSource creates a ShardSource that reads the shard of the stream from the oldest record.
+func NewShardSource(client dynamodbstreamsiface.DynamoDBStreamsAPI, streamARN, shardID string) *ShardSource {
+	return &ShardSource{
+		Client:       client,
+		StreamARN:    streamARN,
+		ShardID:      shardID,
+		PollInterval: defaultPollInterval,
+	}
+}
+
+// ShardSource reads the records of a single shard of a DynamoDB stream. It returns io.EOF once the shard has been
+// closed and all of its records have been read.
+type ShardSource struct {
+	Client    dynamodbstreamsiface.DynamoDBStreamsAPI
+	StreamARN string
+	ShardID   string
+	// After is the sequence number of the last record that was processed. If set, reading starts from the record
+	// after it, otherwise from the oldest record in the shard. It's updated when records are committed, so it can
+	// be stored to resume reading later, without skipping records that were read but not processed.
+	After string
+	// PollInterval is how long to wait before reading again when there were no new records.
+	PollInterval time.Duration
+
+	iterator *string
+	started  bool
+	wait     bool
+}
+
+// Read returns the next records in the shard.
+func (s *ShardSource) Read(ctx context.Context) (records []*dynamodbstreams.Record, err error) {
+	if !s.started {
+		if err = s.getShardIterator(ctx); err != nil {
+			return
+		}
+		s.started = true
+	}
+	if s.iterator == nil {
+		return nil, io.EOF
+	}
+	if s.wait {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(s.PollInterval):
+		}
+	}
+	gro, err := s.Client.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+		ShardIterator: s.iterator,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("shardSource.Read: failed to get records: %w", err)
+	}
+	s.iterator = gro.NextShardIterator
+	s.wait = len(gro.Records) == 0
+	if s.iterator == nil && len(gro.Records) == 0 {
+		return nil, io.EOF
+	}
+	return gro.Records, nil
+}
+
+// Commit moves After to the last of the records, once they've been processed.
+func (s *ShardSource) Commit(ctx context.Context, records []*dynamodbstreams.Record) error {
+	if len(records) > 0 && records[len(records)-1].Dynamodb != nil {
+		s.After = aws.StringValue(records[len(records)-1].Dynamodb.SequenceNumber)
+	}
+	return nil
+}
+
+// Rewind restarts reading after After, so that records that were read but not committed are read again.
+func (s *ShardSource) Rewind() {
+	s.started = false
+	s.wait = false
+}
+
+func (s *ShardSource) getShardIterator(ctx context.Context) error {
+	gsii := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(s.StreamARN),
+		ShardId:           aws.String(s.ShardID),
+		ShardIteratorType: aws.String(dynamodbstreams.ShardIteratorTypeTrimHorizon),
+	}
+	if s.After != "" {
+		gsii.ShardIteratorType = aws.String(dynamodbstreams.ShardIteratorTypeAfterSequenceNumber)
+		gsii.SequenceNumber = aws.String(s.After)
+	}
+	gsio, err := s.Client.GetShardIteratorWithContext(ctx, gsii)
+	if err != nil {
+		return fmt.Errorf("shardSource.Read: failed to get shard iterator: %w", err)
+	}
+	s.iterator = gsio.ShardIterator
+	return nil
+}
@@ -0,0 +1,176 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/a-h/organisation/db"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams/dynamodbstreamsiface"
+	"github.com/google/go-cmp/cmp"
+)
+
+// shardClient serves pages of records from a closed shard. The iterator of each page is its index, and the
+// sequence numbers of the records are integers.
+type shardClient struct {
+	dynamodbstreamsiface.DynamoDBStreamsAPI
+	pages         [][]*dynamodbstreams.Record
+	iteratorInput *dynamodbstreams.GetShardIteratorInput
+}
+
+func (c *shardClient) GetShardIteratorWithContext(ctx aws.Context, input *dynamodbstreams.GetShardIteratorInput, opts ...request.Option) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	c.iteratorInput = input
+	after, _ := strconv.Atoi(aws.StringValue(input.SequenceNumber))
+	// Start from the first page with a record after the sequence number.
+	for i, page := range c.pages {
+		for _, r := range page {
+			if sn, _ := strconv.Atoi(aws.StringValue(r.Dynamodb.SequenceNumber)); sn > after {
+				return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(strconv.Itoa(i))}, nil
+			}
+		}
+	}
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: aws.String(strconv.Itoa(len(c.pages) - 1))}, nil
+}
+
+func (c *shardClient) GetRecordsWithContext(ctx aws.Context, input *dynamodbstreams.GetRecordsInput, opts ...request.Option) (*dynamodbstreams.GetRecordsOutput, error) {
+	page, err := strconv.Atoi(aws.StringValue(input.ShardIterator))
+	if err != nil {
+		return nil, err
+	}
+	out := &dynamodbstreams.GetRecordsOutput{Records: c.pages[page]}
+	if page < len(c.pages)-1 {
+		out.NextShardIterator = aws.String(strconv.Itoa(page + 1))
+	}
+	return out, nil
+}
+
+func TestShardSource(t *testing.T) {
+	client := &shardClient{
+		pages: [][]*dynamodbstreams.Record{
+			records[:2],
+			// An open shard returns no records when there are no new changes.
+			nil,
+			records[2:],
+			nil,
+		},
+	}
+	s := NewShardSource(client, "arn", "shard")
+	s.After = "0"
+	s.PollInterval = time.Millisecond
+
+	var read []*dynamodbstreams.Record
+	for {
+		r, err := s.Read(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		read = append(read, r...)
+		if s.After != "0" {
+			t.Fatalf("expected the sequence number not to move until the records are committed, got %q", s.After)
+		}
+	}
+	if diff := cmp.Diff(records, read); diff != "" {
+		t.Error(diff)
+	}
+	if aws.StringValue(client.iteratorInput.ShardIteratorType) != dynamodbstreams.ShardIteratorTypeAfterSequenceNumber {
+		t.Errorf("expected to read after the sequence number, got %q", aws.StringValue(client.iteratorInput.ShardIteratorType))
+	}
+	if err := s.Commit(context.Background(), read); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.After != "4" {
+		t.Errorf("expected the last sequence number to be 4, got %q", s.After)
+	}
+}
+
+func TestShardSourceResumesAfterPublishError(t *testing.T) {
+	client := &shardClient{
+		pages: [][]*dynamodbstreams.Record{records[:2], records[2:], nil},
+	}
+	s := NewShardSource(client, "arn", "shard")
+	s.PollInterval = time.Millisecond
+
+	// The first batch is published, but the second can't be.
+	errUnavailable := errors.New("unavailable")
+	var events []db.Event
+	publisher := db.PublisherFunc(func(ctx context.Context, e ...db.Event) error {
+		if len(events) > 0 {
+			return errUnavailable
+		}
+		events = append(events, e...)
+		return nil
+	})
+	err := NewProcessor(s, publisher).Run(context.Background())
+	if !errors.Is(err, errUnavailable) {
+		t.Fatalf("expected the publisher's error, got %v", err)
+	}
+	if s.After != "2" {
+		t.Errorf("expected the first batch to be committed, got %q", s.After)
+	}
+
+	// Resuming from the committed sequence number reads the second batch again.
+	resumed := NewShardSource(client, "arn", "shard")
+	resumed.After = s.After
+	resumed.PollInterval = time.Millisecond
+	publisher = db.PublisherFunc(func(ctx context.Context, e ...db.Event) error {
+		events = append(events, e...)
+		return nil
+	})
+	err = NewProcessor(resumed, publisher).Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Error(diff)
+	}
+	if resumed.After != "4" {
+		t.Errorf("expected the last sequence number to be 4, got %q", resumed.After)
+	}
+}
+
+func TestShardSourceRerunAfterPublishError(t *testing.T) {
+	client := &shardClient{
+		pages: [][]*dynamodbstreams.Record{records[:2], records[2:], nil},
+	}
+	s := NewShardSource(client, "arn", "shard")
+	s.PollInterval = time.Millisecond
+
+	// The second batch can't be published the first time.
+	errUnavailable := errors.New("unavailable")
+	var events []db.Event
+	var failed bool
+	publisher := db.PublisherFunc(func(ctx context.Context, e ...db.Event) error {
+		if len(events) > 0 && !failed {
+			failed = true
+			return errUnavailable
+		}
+		events = append(events, e...)
+		return nil
+	})
+	p := NewProcessor(s, publisher)
+	err := p.Run(context.Background())
+	if !errors.Is(err, errUnavailable) {
+		t.Fatalf("expected the publisher's error, got %v", err)
+	}
+
+	// Running again with the same source reads the second batch again, rather than skipping it.
+	err = p.Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(expected, events); diff != "" {
+		t.Error(diff)
+	}
+	if s.After != "4" {
+		t.Errorf("expected the last sequence number to be 4, got %q", s.After)
+	}
+}
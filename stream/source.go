@@ -0,0 +1,92 @@
+// Package stream processes the DynamoDB stream of the table used by the db package, decoding each change into
+// the db.Events that it represents.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// A Source reads stream records in the order that the changes were made.
+type Source interface {
+	// Read returns the next records. It returns io.EOF when there are no more records. A Source that's waiting
+	// for new records may return none.
+	Read(ctx context.Context) ([]*dynamodbstreams.Record, error)
+}
+
+// A Committer is a Source that keeps track of the records that have been processed, so that reading can resume
+// after them. The Processor commits each batch of records once their Events have been published.
+type Committer interface {
+	// Commit marks the records, and those read before them, as processed.
+	Commit(ctx context.Context, records []*dynamodbstreams.Record) error
+	// Rewind returns to the records after the last commit, so that the records that were read but not processed
+	// are read again.
+	Rewind()
+}
+
+// defaultBatchSize is the number of records that a FileSource returns from each Read.
+const defaultBatchSize = 100
+
+// NewFileSource creates a FileSource that reads the records from r.
+func NewFileSource(r io.Reader) *FileSource {
+	return &FileSource{
+		BatchSize: defaultBatchSize,
+		decoder:   json.NewDecoder(r),
+	}
+}
+
+// FileSource is a stand-in for a DynamoDB stream, so that stream processing can be tested offline. It reads
+// records from a file of JSON records, one per line, as written by a FileWriter.
+type FileSource struct {
+	// BatchSize is the maximum number of records returned by each Read.
+	BatchSize int
+	decoder   *json.Decoder
+}
+
+// Read returns the next records in the file.
+func (fs *FileSource) Read(ctx context.Context) (records []*dynamodbstreams.Record, err error) {
+	for len(records) < fs.BatchSize {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		var r dynamodbstreams.Record
+		err = fs.decoder.Decode(&r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("fileSource.Read: failed to decode record %d: %w", len(records), err)
+		}
+		records = append(records, &r)
+	}
+	if len(records) == 0 {
+		return nil, io.EOF
+	}
+	return records, nil
+}
+
+// NewFileWriter creates a FileWriter that writes records to w.
+func NewFileWriter(w io.Writer) *FileWriter {
+	return &FileWriter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// FileWriter writes the records that a FileSource reads, e.g. to record part of a stream to replay offline.
+type FileWriter struct {
+	encoder *json.Encoder
+}
+
+// Write writes the records to the file.
+func (fw *FileWriter) Write(records ...*dynamodbstreams.Record) error {
+	for _, r := range records {
+		if err := fw.encoder.Encode(r); err != nil {
+			return fmt.Errorf("fileWriter.Write: %w", err)
+		}
+	}
+	return nil
+}
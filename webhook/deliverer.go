@@ -0,0 +1,217 @@
+// Package webhook delivers an Organisation's db.Events to the URLs of its db.Webhooks.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/a-h/organisation/db"
+)
+
+const (
+	// SignatureHeader is the header that contains the signature of each delivery's timestamp and body.
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader is the header that contains the Unix time that the delivery was signed.
+	TimestampHeader = "X-Webhook-Timestamp"
+	// EventTypeHeader is the header that contains the type of the delivered Event.
+	EventTypeHeader = "X-Webhook-Event"
+	// EventIDHeader is the header that contains the ID of the delivered Event. An Event may be delivered more than
+	// once, so receivers can use the ID to ignore Events they've already handled.
+	EventIDHeader = "X-Webhook-ID"
+)
+
+// Tolerance is how far the timestamp of a delivery can be from the receiver's clock for Verify to accept it. It
+// limits how long a delivery that's been intercepted can be replayed for.
+const Tolerance = 5 * time.Minute
+
+// ErrDeliveryFailed is returned when delivery to a Webhook fails on every attempt.
+var ErrDeliveryFailed = errors.New("webhook delivery failed")
+
+const (
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultTimeout     = 10 * time.Second
+)
+
+// Store reads an Organisation's Webhooks, and records the attempts to deliver to them.
+type Store interface {
+	ListWebhooks(ctx context.Context, id string) (webhooks []db.Webhook, err error)
+	PutWebhookDelivery(ctx context.Context, id string, delivery db.WebhookDelivery) error
+}
+
+// NewDeliverer creates a Deliverer that reads Webhooks from, and records deliveries to, the store.
+func NewDeliverer(store Store) Deliverer {
+	return Deliverer{
+		Store:       store,
+		Client:      &http.Client{Timeout: defaultTimeout},
+		MaxAttempts: defaultMaxAttempts,
+		Backoff:     defaultBackoff,
+		Now:         time.Now,
+	}
+}
+
+// Deliverer is a db.Publisher that delivers each Event to the Webhooks of the Event's Organisation that accept
+// it. Each delivery is a POST of the JSON payload, signed with the Webhook's secret and the time of the attempt.
+//
+// Failed attempts are retried with exponential backoff, so delivery can take some time. Rather than setting it as
+// the Publisher of the stores, which would hold up each change until its Events were delivered, use it as the
+// Publisher of a stream.Processor.
+type Deliverer struct {
+	Store  Store
+	Client *http.Client
+	// MaxAttempts is the number of times that delivery of an Event to a Webhook is attempted before giving up.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles after each attempt.
+	Backoff time.Duration
+	Now     func() time.Time
+}
+
+// Publish delivers the events. Every attempt is recorded in the Store. If delivery to a Webhook fails on every
+// attempt, the events are still delivered to the other Webhooks, so that one Webhook can't hold up the others,
+// and then an error wrapping ErrDeliveryFailed is returned, so that the events are published again later.
+// Webhooks that have already received an event may receive it again. Deliveries that are rejected with a client
+// error other than 408 or 429 won't succeed if they're retried, so they're abandoned without an error. An error is
+// also returned if the Webhooks can't be read, or the attempts recorded.
+func (d Deliverer) Publish(ctx context.Context, events ...db.Event) (err error) {
+	for _, e := range events {
+		webhooks, listErr := d.Store.ListWebhooks(ctx, e.Metadata().OrganisationID)
+		if listErr != nil {
+			return fmt.Errorf("deliverer.Publish: failed to list webhooks: %w", listErr)
+		}
+		for _, w := range webhooks {
+			if !w.Accepts(e.Type()) {
+				continue
+			}
+			deliverErr := d.deliver(ctx, w, e)
+			if deliverErr == nil {
+				continue
+			}
+			if !errors.Is(deliverErr, ErrDeliveryFailed) {
+				return fmt.Errorf("deliverer.Publish: %w", deliverErr)
+			}
+			if err == nil {
+				err = fmt.Errorf("deliverer.Publish: %w", deliverErr)
+			}
+		}
+	}
+	return
+}
+
+// HandleEvent delivers the event, so that the Deliverer can subscribe to a db.InProcessPublisher.
+func (d Deliverer) HandleEvent(ctx context.Context, e db.Event) error {
+	return d.Publish(ctx, e)
+}
+
+// payload is the body of each delivery.
+type payload struct {
+	ID             string       `json:"id"`
+	Type           db.EventType `json:"type"`
+	OrganisationID string       `json:"organisationId"`
+	At             time.Time    `json:"at"`
+	Event          db.Event     `json:"event"`
+}
+
+func (d Deliverer) deliver(ctx context.Context, w db.Webhook, e db.Event) error {
+	m := e.Metadata()
+	body, err := json.Marshal(payload{
+		ID:             m.ID,
+		Type:           e.Type(),
+		OrganisationID: m.OrganisationID,
+		At:             m.At,
+		Event:          e,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to convert %s event: %w", e.Type(), err)
+	}
+	backoff := d.Backoff
+	for attempt := 1; ; attempt++ {
+		delivery := db.WebhookDelivery{
+			WebhookID: w.ID,
+			EventID:   m.ID,
+			EventType: e.Type(),
+			Attempt:   attempt,
+			At:        d.Now(),
+		}
+		retry := d.post(ctx, w, e, body, &delivery)
+		if err = d.Store.PutWebhookDelivery(ctx, m.OrganisationID, delivery); err != nil {
+			return fmt.Errorf("failed to record delivery: %w", err)
+		}
+		if delivery.Delivered || !retry {
+			return nil
+		}
+		if attempt >= d.MaxAttempts {
+			return fmt.Errorf("%s event %q to webhook %q after %d attempts: %w", e.Type(), m.ID, w.ID, attempt, ErrDeliveryFailed)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes an attempt to deliver the body, and records the outcome in the delivery. It returns whether a failed
+// attempt should be retried.
+func (d Deliverer) post(ctx context.Context, w db.Webhook, e db.Event, body []byte, delivery *db.WebhookDelivery) (retry bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return false
+	}
+	timestamp := strconv.FormatInt(delivery.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(w.Secret, timestamp, body))
+	req.Header.Set(EventTypeHeader, string(e.Type()))
+	req.Header.Set(EventIDHeader, e.Metadata().ID)
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return ctx.Err() == nil
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	delivery.StatusCode = resp.StatusCode
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		delivery.Delivered = true
+		return false
+	}
+	delivery.Error = resp.Status
+	// Other client errors won't succeed if they're retried.
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// Sign returns the signature of a delivery's timestamp and body, as sent in the SignatureHeader. The timestamp is
+// the value of the TimestampHeader. The signed message is the timestamp, a full stop, and the body.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if the signature is the signature of the timestamp and body, and the timestamp is within
+// Tolerance of now, so that receivers can check that deliveries were signed with their Webhook's secret, and
+// reject deliveries that are replayed later.
+func Verify(secret string, body []byte, timestamp, signature string, now time.Time) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > Tolerance || age < -Tolerance {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,216 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-h/organisation/db"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// receiver records the deliveries it receives, responding to each with the next of its status codes.
+type receiver struct {
+	t        *testing.T
+	secret   string
+	m        sync.Mutex
+	statuses []int
+	received []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("failed to read body: %v", err)
+	}
+	if !Verify(rc.secret, body, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), time.Now()) {
+		rc.t.Errorf("invalid signature %q of timestamp %q", r.Header.Get(SignatureHeader), r.Header.Get(TimestampHeader))
+	}
+	var p struct {
+		ID   string       `json:"id"`
+		Type db.EventType `json:"type"`
+	}
+	if err = json.Unmarshal(body, &p); err != nil {
+		rc.t.Errorf("failed to decode body: %v", err)
+	}
+	if p.ID != r.Header.Get(EventIDHeader) || string(p.Type) != r.Header.Get(EventTypeHeader) {
+		rc.t.Errorf("headers don't match payload %+v: %v", p, r.Header)
+	}
+	rc.received = append(rc.received, p.ID)
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestDeliverer(store Store) Deliverer {
+	d := NewDeliverer(store)
+	d.Backoff = time.Millisecond
+	d.MaxAttempts = 3
+	return d
+}
+
+// newTestStore creates a store holding the "org" Organisation, which the tests' Webhooks belong to.
+func newTestStore(t *testing.T) db.OrganisationStore {
+	store := db.NewMemoryOrganisationStore(db.NewMemoryTable())
+	if err := store.Put(context.Background(), db.Organisation{ID: "org", Name: "Org"}); err != nil {
+		t.Fatalf("failed to create organisation: %v", err)
+	}
+	return store
+}
+
+var ignoreDeliveryTimes = cmpopts.IgnoreFields(db.WebhookDelivery{}, "At")
+
+func TestDelivererRetries(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	rc := &receiver{t: t, secret: "secret", statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	webhookID, err := store.CreateWebhook(ctx, "org", db.Webhook{URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	removed := db.MemberRemoved{EventMetadata: db.EventMetadata{ID: "removed", OrganisationID: "org"}, UserID: "user@example.com"}
+	err = newTestDeliverer(store).Publish(ctx, removed)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"removed", "removed", "removed"}, rc.received); diff != "" {
+		t.Errorf("unexpected deliveries received:\n%v", diff)
+	}
+	deliveries, err := store.ListWebhookDeliveries(ctx, "org", webhookID)
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	expected := []db.WebhookDelivery{
+		{WebhookID: webhookID, EventID: "removed", EventType: db.EventTypeMemberRemoved, Attempt: 1, StatusCode: 503, Error: "503 Service Unavailable"},
+		{WebhookID: webhookID, EventID: "removed", EventType: db.EventTypeMemberRemoved, Attempt: 2, StatusCode: 500, Error: "500 Internal Server Error"},
+		{WebhookID: webhookID, EventID: "removed", EventType: db.EventTypeMemberRemoved, Attempt: 3, StatusCode: 200, Delivered: true},
+	}
+	if diff := cmp.Diff(expected, deliveries, ignoreDeliveryTimes); diff != "" {
+		t.Errorf("unexpected deliveries recorded:\n%v", diff)
+	}
+}
+
+func TestDelivererGivesUp(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	rc := &receiver{t: t, secret: "secret", statuses: []int{500, 500, 500, 410}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+	webhookID, err := store.CreateWebhook(ctx, "org", db.Webhook{URL: server.URL, Secret: "secret"})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	// Server errors are retried until the attempts run out, and then an error is returned so that the event can
+	// be published again. Other client errors aren't retried, and the delivery is abandoned. A failed delivery
+	// doesn't stop the other events from being delivered.
+	first := db.InvitationAccepted{EventMetadata: db.EventMetadata{ID: "first", OrganisationID: "org"}, UserID: "user@example.com"}
+	second := db.InvitationAccepted{EventMetadata: db.EventMetadata{ID: "second", OrganisationID: "org"}, UserID: "user@example.com"}
+	err = newTestDeliverer(store).Publish(ctx, first, second)
+	if !errors.Is(err, ErrDeliveryFailed) {
+		t.Fatalf("expected ErrDeliveryFailed, got %v", err)
+	}
+	if diff := cmp.Diff([]string{"first", "first", "first", "second"}, rc.received); diff != "" {
+		t.Errorf("unexpected deliveries received:\n%v", diff)
+	}
+	deliveries, err := store.ListWebhookDeliveries(ctx, "org", webhookID)
+	if err != nil {
+		t.Fatalf("failed to list deliveries: %v", err)
+	}
+	var attempts []int
+	for _, d := range deliveries {
+		if d.Delivered {
+			t.Errorf("unexpected successful delivery: %+v", d)
+		}
+		attempts = append(attempts, d.StatusCode)
+	}
+	if diff := cmp.Diff([]int{500, 500, 500, 410}, attempts); diff != "" {
+		t.Errorf("unexpected attempts:\n%v", diff)
+	}
+}
+
+func TestDelivererFiltersEvents(t *testing.T) {
+	ctx := context.Background()
+	store := newTestStore(t)
+	membership := &receiver{t: t, secret: "membership secret"}
+	membershipServer := httptest.NewServer(membership)
+	defer membershipServer.Close()
+	all := &receiver{t: t, secret: "all secret"}
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+	_, err := store.CreateWebhook(ctx, "org", db.Webhook{
+		URL:    membershipServer.URL,
+		Secret: "membership secret",
+		Events: []db.EventType{db.EventTypeInvitationAccepted, db.EventTypeMemberRemoved},
+	})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+	_, err = store.CreateWebhook(ctx, "org", db.Webhook{URL: allServer.URL, Secret: "all secret"})
+	if err != nil {
+		t.Fatalf("failed to create webhook: %v", err)
+	}
+
+	// The Deliverer can also subscribe to an InProcessPublisher.
+	p := db.NewInProcessPublisher()
+	p.Subscribe(newTestDeliverer(store).HandleEvent)
+	err = p.Publish(ctx,
+		db.ServiceCreated{EventMetadata: db.EventMetadata{ID: "created", OrganisationID: "org"}, ServiceID: "service"},
+		db.InvitationAccepted{EventMetadata: db.EventMetadata{ID: "joined", OrganisationID: "org"}, UserID: "user@example.com"},
+		db.MemberRemoved{EventMetadata: db.EventMetadata{ID: "left", OrganisationID: "org"}, UserID: "user@example.com"},
+		db.MemberRemoved{EventMetadata: db.EventMetadata{ID: "other", OrganisationID: "other"}, UserID: "user@example.com"},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"joined", "left"}, membership.received); diff != "" {
+		t.Errorf("unexpected membership deliveries:\n%v", diff)
+	}
+	if diff := cmp.Diff([]string{"created", "joined", "left"}, all.received); diff != "" {
+		t.Errorf("unexpected deliveries:\n%v", diff)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"id":"event"}`)
+	signature := Sign("secret", timestamp, body)
+	if !Verify("secret", body, timestamp, signature, now.Add(Tolerance)) {
+		t.Errorf("expected the signature to be verified")
+	}
+	if Verify("other secret", body, timestamp, signature, now) {
+		t.Errorf("expected the signature of another secret to fail")
+	}
+	if Verify("secret", []byte(`{"id":"other"}`), timestamp, signature, now) {
+		t.Errorf("expected the signature of another body to fail")
+	}
+	later := strconv.FormatInt(now.Add(time.Second).Unix(), 10)
+	if Verify("secret", body, later, signature, now) {
+		t.Errorf("expected the signature of another timestamp to fail")
+	}
+	if Verify("secret", body, timestamp, signature, now.Add(Tolerance+time.Second)) {
+		t.Errorf("expected a replayed delivery to fail")
+	}
+	if Verify("secret", body, timestamp, signature, now.Add(-Tolerance-time.Second)) {
+		t.Errorf("expected a delivery from the future to fail")
+	}
+	if Verify("secret", body, "", signature, now) {
+		t.Errorf("expected a delivery without a timestamp to fail")
+	}
+}